@@ -11,13 +11,17 @@ require (
 	github.com/free5gc/ngap v1.0.6
 	github.com/free5gc/openapi v1.0.5
 	github.com/free5gc/pfcp v1.0.6-0.20221213042804-fe871d6967c2
+	github.com/free5gc/tlv v1.0.2-0.20221213035259-4f03751fadbe
 	github.com/free5gc/util v1.0.3
 	github.com/gin-gonic/gin v1.7.7
+	github.com/golang-jwt/jwt v3.2.1+incompatible
 	github.com/google/uuid v1.3.0
 	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli v1.22.5
+	gopkg.in/h2non/gock.v1 v1.1.2
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,208 @@
+package context
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/radius"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// 3GPP vendor-specific attributes, TS 29.061 16.4.7.2
+const (
+	radiusVendor3GPP   = 10415
+	radiusAttr3GPPIMSI = 1
+)
+
+// accounting requests waiting to be sent for a PDU session
+const accountingQueueLength = 16
+
+// AccountingServer is the RADIUS accounting server of the PDU sessions of a DNN, TS 29.561 16.5
+type AccountingServer struct {
+	Client        *radius.Client
+	NasIdentifier string
+	// period of the Interim-Updates, the UPF reports the usage of the session at this period
+	InterimInterval time.Duration
+}
+
+func newAccountingServer(accounting *factory.Accounting) *AccountingServer {
+	server := newDNAAAServer(accounting.Radius)
+	return &AccountingServer{
+		Client:          server.Client,
+		NasIdentifier:   server.NasIdentifier,
+		InterimInterval: accounting.InterimInterval,
+	}
+}
+
+// Accounting is the RADIUS accounting state of a PDU session. The usage reports of the UPFs may
+// be handled while the SM context is not locked, the state has its own lock.
+type Accounting struct {
+	mu        sync.Mutex
+	SessionID string
+	StartTime time.Time
+	// volumes reported by the UPFs since the session started
+	InputOctets  uint64
+	OutputOctets uint64
+	// requests are sent in order by a goroutine, the queue is closed by the Stop
+	requests chan *radius.Packet
+}
+
+// StartAccounting sends the Accounting-Request Start of the PDU session if the DNN is accounted
+func (smContext *SMContext) StartAccounting() {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.Accounting == nil || smContext.Accounting != nil {
+		return
+	}
+
+	acct := &Accounting{
+		SessionID: smContext.Ref,
+		StartTime: time.Now(),
+		requests:  make(chan *radius.Packet, accountingQueueLength),
+	}
+	smContext.Accounting = acct
+	go acct.run(smContext.DNNInfo.Accounting.Client, acct.requests)
+
+	logger.CtxLog.Infof("UE[%s] PDUSessionID[%d] accounting session[%s] started",
+		smContext.Supi, smContext.PDUSessionID, acct.SessionID)
+	smContext.sendAccountingRequest(radius.AcctStatusStart)
+}
+
+// resumeAccounting resumes the accounting session of a restored PDU session, whose Start was
+// sent before the SMF restarted
+func (smContext *SMContext) resumeAccounting(record *AccountingRecord) {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.Accounting == nil {
+		return
+	}
+
+	acct := &Accounting{
+		SessionID:    record.SessionID,
+		StartTime:    record.StartTime,
+		InputOctets:  record.InputOctets,
+		OutputOctets: record.OutputOctets,
+		requests:     make(chan *radius.Packet, accountingQueueLength),
+	}
+	smContext.Accounting = acct
+	go acct.run(smContext.DNNInfo.Accounting.Client, acct.requests)
+}
+
+// AccountUsage adds the volumes of a usage report of a UPF to the session
+func (smContext *SMContext) AccountUsage(volume *pfcpType.VolumeMeasurement) {
+	acct := smContext.Accounting
+	if acct == nil || volume == nil {
+		return
+	}
+
+	acct.mu.Lock()
+	defer acct.mu.Unlock()
+	if volume.Ulvol {
+		acct.InputOctets += volume.UplinkVolume
+	}
+	if volume.Dlvol {
+		acct.OutputOctets += volume.DownlinkVolume
+	}
+}
+
+// SendInterimAccounting sends the Accounting-Request Interim-Update of the PDU session
+func (smContext *SMContext) SendInterimAccounting() {
+	if smContext.Accounting != nil {
+		smContext.sendAccountingRequest(radius.AcctStatusInterimUpdate)
+	}
+}
+
+// StopAccounting sends the Accounting-Request Stop of the PDU session
+func (smContext *SMContext) StopAccounting() {
+	acct := smContext.Accounting
+	if acct == nil {
+		return
+	}
+
+	smContext.sendAccountingRequest(radius.AcctStatusStop)
+	acct.mu.Lock()
+	if acct.requests != nil {
+		close(acct.requests)
+		acct.requests = nil
+	}
+	acct.mu.Unlock()
+	logger.CtxLog.Infof("UE[%s] PDUSessionID[%d] accounting session[%s] stopped",
+		smContext.Supi, smContext.PDUSessionID, acct.SessionID)
+}
+
+// sendAccountingRequest queues the Accounting-Request of the status, TS 29.561 16.5
+func (smContext *SMContext) sendAccountingRequest(status uint32) {
+	acct := smContext.Accounting
+	server := smContext.DNNInfo.Accounting
+
+	request, err := radius.NewRequest(radius.CodeAccountingRequest)
+	if err != nil {
+		logger.CtxLog.Errorf("UE[%s] PDUSessionID[%d] new Accounting-Request failed: %v",
+			smContext.Supi, smContext.PDUSessionID, err)
+		return
+	}
+	request.AddUint32(radius.AttrAcctStatusType, status)
+	request.AddString(radius.AttrAcctSessionID, acct.SessionID)
+
+	userName := strings.TrimPrefix(smContext.Supi, "imsi-")
+	if auth := smContext.SecondaryAuth; auth != nil && auth.Authenticated && auth.UserName != "" {
+		userName = auth.UserName
+	}
+	request.AddString(radius.AttrUserName, userName)
+	if strings.HasPrefix(smContext.Supi, "imsi-") {
+		request.AddVendorSpecific(radiusVendor3GPP, radiusAttr3GPPIMSI,
+			[]byte(strings.TrimPrefix(smContext.Supi, "imsi-")))
+	}
+	if strings.HasPrefix(smContext.Gpsi, "msisdn-") {
+		request.AddString(radius.AttrCallingStationID, strings.TrimPrefix(smContext.Gpsi, "msisdn-"))
+	}
+	request.AddIPv4(radius.AttrFramedIPAddress, smContext.PDUAddress)
+	request.AddString(radius.AttrCalledStationID, smContext.Dnn)
+	// no RADIUS attribute carries the S-NSSAI, it is sent as the port of the session in the
+	// hexadecimal form SST followed by SD
+	if snssai := smContext.Snssai; snssai != nil {
+		request.AddString(radius.AttrNASPortID, fmt.Sprintf("%02x%s", snssai.Sst, strings.ToLower(snssai.Sd)))
+	}
+	request.AddString(radius.AttrNASIdentifier, server.NasIdentifier)
+	if smfContext.CPNodeID.NodeIdType == pfcpType.NodeIdTypeIpv4Address {
+		request.AddIPv4(radius.AttrNASIPAddress, smfContext.CPNodeID.IP)
+	}
+	request.AddUint32(radius.AttrServiceType, radiusServiceTypeFramed)
+	request.AddUint32(radius.AttrFramedProtocol, radiusFramedProtocolGPRSPDP)
+	if auth := smContext.SecondaryAuth; auth != nil && auth.RadiusClass != nil {
+		request.Add(radius.AttrClass, auth.RadiusClass)
+	}
+
+	acct.mu.Lock()
+	defer acct.mu.Unlock()
+	if status != radius.AcctStatusStart {
+		request.AddUint32(radius.AttrAcctSessionTime, uint32(time.Since(acct.StartTime)/time.Second))
+		request.AddUint32(radius.AttrAcctInputOctets, uint32(acct.InputOctets))
+		request.AddUint32(radius.AttrAcctInputGigawords, uint32(acct.InputOctets>>32))
+		request.AddUint32(radius.AttrAcctOutputOctets, uint32(acct.OutputOctets))
+		request.AddUint32(radius.AttrAcctOutputGigawords, uint32(acct.OutputOctets>>32))
+	}
+	if acct.requests == nil {
+		return
+	}
+	select {
+	case acct.requests <- request:
+	default:
+		logger.CtxLog.Warnf("UE[%s] PDUSessionID[%d] accounting queue full, Accounting-Request dropped",
+			smContext.Supi, smContext.PDUSessionID)
+	}
+}
+
+func (acct *Accounting) run(client *radius.Client, requests <-chan *radius.Packet) {
+	for request := range requests {
+		response, err := client.Exchange(request)
+		if err != nil {
+			logger.CtxLog.Warnf("Accounting session[%s] Accounting-Request failed: %v", acct.SessionID, err)
+			continue
+		}
+		if response.Code != radius.CodeAccountingResponse {
+			logger.CtxLog.Warnf("Accounting session[%s] unexpected response code %d",
+				acct.SessionID, response.Code)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package context
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/radius"
+)
+
+func TestAccounting(t *testing.T) {
+	secret := []byte("testing123")
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// accounting server stand-in
+	requests := make(chan *radius.Packet, 3)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			request, err := radius.Decode(buf[:n])
+			if err != nil {
+				continue
+			}
+			requests <- request
+			raw, err := radius.NewResponse(request, radius.CodeAccountingResponse).Encode(secret)
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteToUDP(raw, addr)
+		}
+	}()
+
+	smContext := &SMContext{
+		Ref:          "urn:uuid:0d6f1c9e-3c55-4c2f-8f0e-6b2b8a3b9d41",
+		Supi:         "imsi-208930000000001",
+		Gpsi:         "msisdn-0900000001",
+		PDUSessionID: 1,
+		Dnn:          "internet",
+		Snssai:       &models.Snssai{Sst: 1, Sd: "010203"},
+		PDUAddress:   net.ParseIP("10.60.0.1").To4(),
+		DNNInfo: &SnssaiSmfDnnInfo{
+			Accounting: &AccountingServer{
+				Client:        &radius.Client{Addr: conn.LocalAddr().String(), Secret: secret},
+				NasIdentifier: "smf",
+			},
+		},
+	}
+
+	smContext.StartAccounting()
+	smContext.AccountUsage(&pfcpType.VolumeMeasurement{
+		Ulvol:          true,
+		Dlvol:          true,
+		UplinkVolume:   1000,
+		DownlinkVolume: 5<<32 + 2000,
+	})
+	smContext.SendInterimAccounting()
+	smContext.StopAccounting()
+
+	status := []uint32{radius.AcctStatusStart, radius.AcctStatusInterimUpdate, radius.AcctStatusStop}
+	for i := range status {
+		var request *radius.Packet
+		select {
+		case request = <-requests:
+		case <-time.After(time.Second):
+			t.Fatal("no Accounting-Request")
+		}
+		require.Equal(t, radius.CodeAccountingRequest, request.Code)
+		value, _ := request.GetUint32(radius.AttrAcctStatusType)
+		require.Equal(t, status[i], value)
+		require.Equal(t, []byte(smContext.Ref), request.Get(radius.AttrAcctSessionID))
+		require.Equal(t, []byte("208930000000001"), request.Get(radius.AttrUserName))
+		require.Equal(t, []byte("0900000001"), request.Get(radius.AttrCallingStationID))
+		require.Equal(t, net.IPv4(10, 60, 0, 1).To4(), request.GetIPv4(radius.AttrFramedIPAddress))
+		require.Equal(t, []byte("01010203"), request.Get(radius.AttrNASPortID))
+
+		vsa := request.Get(radius.AttrVendorSpecific)
+		require.Equal(t, uint32(radiusVendor3GPP), binary.BigEndian.Uint32(vsa))
+		require.Equal(t, append([]byte{radiusAttr3GPPIMSI, 17}, "208930000000001"...), vsa[4:])
+
+		if status[i] == radius.AcctStatusStart {
+			require.Nil(t, request.Get(radius.AttrAcctInputOctets))
+			continue
+		}
+		value, _ = request.GetUint32(radius.AttrAcctInputOctets)
+		require.Equal(t, uint32(1000), value)
+		value, _ = request.GetUint32(radius.AttrAcctOutputOctets)
+		require.Equal(t, uint32(2000), value)
+		value, _ = request.GetUint32(radius.AttrAcctOutputGigawords)
+		require.Equal(t, uint32(5), value)
+	}
+
+	// no request once stopped
+	smContext.SendInterimAccounting()
+	select {
+	case <-requests:
+		t.Fatal("Accounting-Request after the Stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,148 @@
+package context
+
+import (
+	"sync"
+	"time"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/logger"
+)
+
+const (
+	GtpuPort                   = 2152
+	DefaultBufferingMaxPackets = 64
+	DefaultDDNBackoffTimer     = 30 * time.Second
+	// unit of the Downlink Data Notification Delay IE, TS 29.244 8.2.28
+	ddnDelayUnit = 50 * time.Millisecond
+	maxPPI       = 7
+)
+
+var bufferingTEIDSMContextMap sync.Map
+
+func GetSMContextByBufferingTEID(teid uint32) *SMContext {
+	if value, ok := bufferingTEIDSMContextMap.Load(teid); ok {
+		return value.(*SMContext)
+	}
+	return nil
+}
+
+// BufferDownlinkFAR changes the downlink FAR of the AN UPF for an idle UE, TS 23.501 5.8.3.
+// When the UPF buffers, the FAR is set to BUFF+NOCP and a BAR is attached to it; the BAR is
+// returned so that it can be created with the FAR. When the SMF buffers, the FAR keeps
+// forwarding, but to the GTP-U endpoint of the SMF, and no BAR is needed.
+func (smContext *SMContext) BufferDownlinkFAR(upf *UPF, far *FAR) (*BAR, error) {
+	far.State = RULE_UPDATE
+
+	if SMF_Self().SMFBuffering {
+		if smContext.BufferingTEID == 0 {
+			smContext.BufferingTEID = AllocateBufferingTEID()
+			bufferingTEIDSMContextMap.Store(smContext.BufferingTEID, smContext)
+		}
+
+		far.ApplyAction = pfcpType.ApplyAction{Forw: true}
+		far.ForwardingParameters = &ForwardingParameters{
+			DestinationInterface: pfcpType.DestinationInterface{
+				InterfaceValue: pfcpType.DestinationInterfaceCpFunction,
+			},
+			OuterHeaderCreation: &pfcpType.OuterHeaderCreation{
+				OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
+				Teid:                           smContext.BufferingTEID,
+				Ipv4Address:                    SMF_Self().BufferingGtpuAddr.IP.To4(),
+			},
+		}
+		return nil, nil
+	}
+
+	far.ApplyAction = pfcpType.ApplyAction{Buff: true, Nocp: true}
+	if far.BAR == nil {
+		bar, err := upf.AddBAR()
+		if err != nil {
+			return nil, err
+		}
+		bar.SuggestedBufferingPacketsCount.PacketCountValue = SMF_Self().SuggestedBufferingPacketsCount
+		far.BAR = bar
+	} else if far.BAR.DownlinkDataNotificationDelay.DelayValue != 0 ||
+		far.BAR.SuggestedBufferingPacketsCount.PacketCountValue != SMF_Self().SuggestedBufferingPacketsCount {
+		// lift the DDN throttling of the previous idle period
+		far.BAR.DownlinkDataNotificationDelay.DelayValue = 0
+		far.BAR.SuggestedBufferingPacketsCount.PacketCountValue = SMF_Self().SuggestedBufferingPacketsCount
+		far.BAR.State = RULE_UPDATE
+	}
+	return far.BAR, nil
+}
+
+// ThrottleDownlinkDataNotification delays the DDNs of the FAR and limits the packets
+// buffered for it, using the BAR attached by BufferDownlinkFAR. The DDNs are delayed by
+// retryAfter, the time after which the AMF asked to page the UE again, or else by the
+// configured delay.
+func (smContext *SMContext) ThrottleDownlinkDataNotification(far *FAR, retryAfter time.Duration) *BAR {
+	bar := far.BAR
+	if bar == nil {
+		return nil
+	}
+
+	delay := SMF_Self().DDNThrottlingDelay
+	if retryAfter > 0 {
+		delay = retryAfter
+	}
+	delay /= ddnDelayUnit
+	if delay > 255 {
+		delay = 255
+	}
+	bar.DownlinkDataNotificationDelay.DelayValue = uint8(delay)
+	bar.SuggestedBufferingPacketsCount.PacketCountValue = SMF_Self().DDNThrottlingPacketCount
+	bar.State = RULE_UPDATE
+	return bar
+}
+
+// PagingPolicy maps the Downlink Data Service Information reported by the UPF to the
+// Paging Policy Indicator, ARP and 5QI of the N1N2MessageTransfer, TS 23.501 5.4.3
+func (smContext *SMContext) PagingPolicy(info *pfcpType.DownlinkDataServiceInformation) (
+	ppi int32, arp *models.Arp, var5qi int32,
+) {
+	if info == nil {
+		return 0, nil, 0
+	}
+
+	if info.Ppi {
+		if info.PagingPolicyIndicationValue <= maxPPI {
+			ppi = int32(info.PagingPolicyIndicationValue)
+		} else {
+			logger.CtxLog.Warnf("Invalid Paging Policy Indication[%d]", info.PagingPolicyIndicationValue)
+		}
+	}
+
+	if !info.Qfii {
+		return ppi, nil, 0
+	}
+	sessionRule := smContext.SelectedSessionRule()
+	if sessionRule == nil || sessionRule.AuthDefQos == nil {
+		return ppi, nil, 0
+	}
+	// the QFI of the default QoS flow is the default 5QI, the PCC rules with QoS data have their own
+	authDefQos := sessionRule.AuthDefQos
+	if uint8(authDefQos.Var5qi) == info.Qfi {
+		return ppi, authDefQos.Arp, authDefQos.Var5qi
+	}
+	for _, rule := range smContext.PCCRules {
+		if rule.Enforced() && rule.QosData != nil && rule.QFI == info.Qfi {
+			arp := rule.QosData.Arp
+			if arp == nil {
+				arp = authDefQos.Arp
+			}
+			return ppi, arp, rule.QosData.Var5qi
+		}
+	}
+	logger.CtxLog.Warnf("QFI[%d] is not a QoS flow of UE[%s] PDUSessionID[%d], use the default QoS flow",
+		info.Qfi, smContext.Supi, smContext.PDUSessionID)
+	return ppi, authDefQos.Arp, authDefQos.Var5qi
+}
+
+// StopDDNBackoff cancels the back-off started after a paging failure
+func (smContext *SMContext) StopDDNBackoff() {
+	if smContext.DDNBackoffTimer != nil {
+		smContext.DDNBackoffTimer.Stop()
+		smContext.DDNBackoffTimer = nil
+	}
+}
@@ -0,0 +1,205 @@
+package context
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+)
+
+func TestBufferDownlinkFAR(t *testing.T) {
+	smfBuffering, gtpuAddr := smfContext.SMFBuffering, smfContext.BufferingGtpuAddr
+	packetCount := smfContext.SuggestedBufferingPacketsCount
+	defer func() {
+		smfContext.SMFBuffering, smfContext.BufferingGtpuAddr = smfBuffering, gtpuAddr
+		smfContext.SuggestedBufferingPacketsCount = packetCount
+	}()
+	smfContext.SuggestedBufferingPacketsCount = 10
+
+	nodeID := pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: net.ParseIP("10.4.0.22").To4()}
+	upf := NewUPF(&nodeID, nil)
+	upf.UPFStatus = AssociatedSetUpSuccess
+	defer RemoveUPFNodeByNodeID(nodeID)
+
+	t.Run("UPF buffering", func(t *testing.T) {
+		smfContext.SMFBuffering = false
+		smContext := NewSMContext("imsi-208930000000042", 1)
+		defer RemoveSMContext(smContext.Ref)
+		far, err := upf.AddFAR()
+		require.NoError(t, err)
+
+		bar, err := smContext.BufferDownlinkFAR(upf, far)
+		require.NoError(t, err)
+		require.NotNil(t, bar)
+		require.Same(t, bar, far.BAR)
+		require.Equal(t, pfcpType.ApplyAction{Buff: true, Nocp: true}, far.ApplyAction)
+		require.Equal(t, RULE_UPDATE, far.State)
+		require.Equal(t, RULE_INITIAL, bar.State)
+		require.Equal(t, uint8(10), bar.SuggestedBufferingPacketsCount.PacketCountValue)
+		require.Zero(t, smContext.BufferingTEID)
+
+		// the throttling of the previous idle period is lifted on the same BAR
+		bar.State = RULE_CREATE
+		bar.DownlinkDataNotificationDelay.DelayValue = 20
+		bar.SuggestedBufferingPacketsCount.PacketCountValue = 1
+		again, err := smContext.BufferDownlinkFAR(upf, far)
+		require.NoError(t, err)
+		require.Same(t, bar, again)
+		require.Equal(t, RULE_UPDATE, bar.State)
+		require.Zero(t, bar.DownlinkDataNotificationDelay.DelayValue)
+		require.Equal(t, uint8(10), bar.SuggestedBufferingPacketsCount.PacketCountValue)
+
+		// nothing to update on the BAR without throttling
+		bar.State = RULE_CREATE
+		_, err = smContext.BufferDownlinkFAR(upf, far)
+		require.NoError(t, err)
+		require.Equal(t, RULE_CREATE, bar.State)
+	})
+
+	t.Run("SMF buffering", func(t *testing.T) {
+		smfContext.SMFBuffering = true
+		smfContext.BufferingGtpuAddr = &net.UDPAddr{IP: net.ParseIP("10.4.0.1"), Port: GtpuPort}
+		smContext := NewSMContext("imsi-208930000000043", 1)
+		far, err := upf.AddFAR()
+		require.NoError(t, err)
+
+		bar, err := smContext.BufferDownlinkFAR(upf, far)
+		require.NoError(t, err)
+		require.Nil(t, bar)
+		require.Nil(t, far.BAR)
+		require.Equal(t, pfcpType.ApplyAction{Forw: true}, far.ApplyAction)
+		teid := smContext.BufferingTEID
+		require.NotZero(t, teid)
+		require.Equal(t, pfcpType.DestinationInterfaceCpFunction,
+			far.ForwardingParameters.DestinationInterface.InterfaceValue)
+		require.Equal(t, &pfcpType.OuterHeaderCreation{
+			OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
+			Teid:                           teid,
+			Ipv4Address:                    net.ParseIP("10.4.0.1").To4(),
+		}, far.ForwardingParameters.OuterHeaderCreation)
+		require.Same(t, smContext, GetSMContextByBufferingTEID(teid))
+
+		// the session keeps its TEID for the next idle periods, until it is removed
+		_, err = smContext.BufferDownlinkFAR(upf, far)
+		require.NoError(t, err)
+		require.Equal(t, teid, smContext.BufferingTEID)
+		RemoveSMContext(smContext.Ref)
+		require.Nil(t, GetSMContextByBufferingTEID(teid))
+	})
+}
+
+func TestThrottleDownlinkDataNotification(t *testing.T) {
+	delay, packetCount := smfContext.DDNThrottlingDelay, smfContext.DDNThrottlingPacketCount
+	defer func() { smfContext.DDNThrottlingDelay, smfContext.DDNThrottlingPacketCount = delay, packetCount }()
+	smfContext.DDNThrottlingDelay = time.Second
+	smfContext.DDNThrottlingPacketCount = 2
+
+	testCases := []struct {
+		name       string
+		retryAfter time.Duration
+		delay      uint8
+	}{
+		{name: "configured delay", delay: 20},
+		{name: "retry after", retryAfter: 5 * time.Second, delay: 100},
+		{name: "retry after over the BAR range", retryAfter: time.Minute, delay: 255},
+	}
+
+	smContext := &SMContext{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			far := &FAR{BAR: &BAR{BARID: 1, State: RULE_CREATE}}
+			bar := smContext.ThrottleDownlinkDataNotification(far, tc.retryAfter)
+			require.Same(t, far.BAR, bar)
+			require.Equal(t, tc.delay, bar.DownlinkDataNotificationDelay.DelayValue)
+			require.Equal(t, uint8(2), bar.SuggestedBufferingPacketsCount.PacketCountValue)
+			require.Equal(t, RULE_UPDATE, bar.State)
+		})
+	}
+
+	// the SMF buffers, nothing to throttle in the UPF
+	require.Nil(t, smContext.ThrottleDownlinkDataNotification(&FAR{}, time.Second))
+}
+
+func TestPagingPolicy(t *testing.T) {
+	defaultArp := &models.Arp{PriorityLevel: 8, PreemptCap: models.PreemptionCapability_NOT_PREEMPT}
+	voiceArp := &models.Arp{PriorityLevel: 2, PreemptCap: models.PreemptionCapability_MAY_PREEMPT}
+	smContext := NewSMContext("imsi-208930000000044", 1)
+	defer RemoveSMContext(smContext.Ref)
+	sessionRule := NewSessionRuleFromModel(&models.SessionRule{
+		SessRuleId: "default",
+		AuthDefQos: &models.AuthorizedDefaultQos{Var5qi: 9, Arp: defaultArp},
+	})
+	SetSessionRuleActivateState(sessionRule, true)
+	smContext.SessionRules[sessionRule.SessionRuleID] = sessionRule
+	smContext.PCCRules["voice"] = &PCCRule{
+		PCCRuleID: "voice",
+		QosData:   &models.QosData{QosId: "voice", Var5qi: 1, Arp: voiceArp},
+		QFI:       1,
+		QoSRuleID: 2,
+	}
+	smContext.PCCRules["video"] = &PCCRule{
+		PCCRuleID: "video",
+		QosData:   &models.QosData{QosId: "video", Var5qi: 7},
+		QFI:       2,
+		QoSRuleID: 3,
+	}
+
+	testCases := []struct {
+		name   string
+		info   *pfcpType.DownlinkDataServiceInformation
+		ppi    int32
+		arp    *models.Arp
+		var5qi int32
+	}{
+		{
+			name: "no service information",
+		},
+		{
+			name: "PPI",
+			info: &pfcpType.DownlinkDataServiceInformation{Ppi: true, PagingPolicyIndicationValue: 5},
+			ppi:  5,
+		},
+		{
+			name: "invalid PPI",
+			info: &pfcpType.DownlinkDataServiceInformation{Ppi: true, PagingPolicyIndicationValue: 9},
+		},
+		{
+			name:   "default QoS flow",
+			info:   &pfcpType.DownlinkDataServiceInformation{Ppi: true, PagingPolicyIndicationValue: 1, Qfii: true, Qfi: 9},
+			ppi:    1,
+			arp:    defaultArp,
+			var5qi: 9,
+		},
+		{
+			name:   "QoS flow of a PCC rule",
+			info:   &pfcpType.DownlinkDataServiceInformation{Qfii: true, Qfi: 1},
+			arp:    voiceArp,
+			var5qi: 1,
+		},
+		{
+			name:   "QoS flow of a PCC rule without ARP",
+			info:   &pfcpType.DownlinkDataServiceInformation{Qfii: true, Qfi: 2},
+			arp:    defaultArp,
+			var5qi: 7,
+		},
+		{
+			name:   "unknown QoS flow",
+			info:   &pfcpType.DownlinkDataServiceInformation{Qfii: true, Qfi: 5},
+			arp:    defaultArp,
+			var5qi: 9,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ppi, arp, var5qi := smContext.PagingPolicy(tc.info)
+			require.Equal(t, tc.ppi, ppi)
+			require.Equal(t, tc.arp, arp)
+			require.Equal(t, tc.var5qi, var5qi)
+		})
+	}
+}
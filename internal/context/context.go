@@ -2,17 +2,21 @@ package context
 
 import (
 	"context"
+	"crypto/rsa"
 	"fmt"
 	"net"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/free5gc/openapi/Nnrf_AccessToken"
 	"github.com/free5gc/openapi/Nnrf_NFDiscovery"
 	"github.com/free5gc/openapi/Nnrf_NFManagement"
 	"github.com/free5gc/openapi/Nudm_SubscriberDataManagement"
+	"github.com/free5gc/openapi/Nudm_UEContextManagement"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp/pfcpType"
 	"github.com/free5gc/pfcp/pfcpUdp"
@@ -35,7 +39,10 @@ type SMFContext struct {
 	RegisterIPv4 string
 	SBIPort      int
 	CPNodeID     pfcpType.NodeID
+	// address the Prometheus metrics are served on, empty when they are not
+	MetricsAddr string
 
+	// selected UDM, read and changed with UDM and SetUDM
 	UDMProfile models.NfProfile
 
 	UPNodeIDs []pfcpType.NodeID
@@ -45,10 +52,44 @@ type SMFContext struct {
 
 	SnssaiInfos []SnssaiSmfInfo
 
+	// the SBI requests carry access tokens issued by the NRF, verified with its public key
+	OAuth2Required    bool
+	NrfPublicKey      *rsa.PublicKey
+	AccessTokenClient *Nnrf_AccessToken.APIClient
+
+	// the SBI requests are sent through the SCP, which also selects the NFs with delegated discovery
+	ScpUri                string
+	ScpDelegatedDiscovery bool
+
+	// shared secret of the active and standby SMFs, required to read the replicated sessions
+	ReplicationSecret string
+
+	// timeouts, retries and circuit breakers of the requests to the other NFs
+	SBITimeout            time.Duration
+	SBIPeerTimeouts       map[models.NfType]time.Duration
+	SBIMaxRetries         int
+	SBIBreakerFailures    int
+	SBIBreakerOpenTime    time.Duration
+	SBIRejectBackoffTimer time.Duration
+
+	// interval of the retries of the SM policy associations of the sessions on a local policy
+	SBIPolicyRetryInterval time.Duration
+
+	// heartbeat period asked by the NRF, and the load last reported to it
+	NrfHeartBeatTimer time.Duration
+	NrfReportedLoad   int32
+	// NF status subscriptions to the NRF by the type of the NFs subscribed to, which the heartbeat
+	// renews and the termination removes
+	NfStatusSubscriptions     map[models.NfType]models.NrfSubscriptionData
+	NfStatusSubscriptionsLock sync.Mutex
+
 	NrfUri                              string
 	NFManagementClient                  *Nnrf_NFManagement.APIClient
 	NFDiscoveryClient                   *Nnrf_NFDiscovery.APIClient
 	SubscriberDataManagementClient      *Nudm_SubscriberDataManagement.APIClient
+	UEContextManagementClient           *Nudm_UEContextManagement.APIClient
+	subscriberDataManagementConf        *Nudm_SubscriberDataManagement.Configuration
+	udmLock                             sync.RWMutex
 	Locality                            string
 	AssociationSetupFailedAlertInterval time.Duration
 	AssociationSetupFailedRetryInterval time.Duration
@@ -57,6 +98,18 @@ type SMFContext struct {
 	Ctx                   context.Context
 	PFCPCancelFunc        context.CancelFunc
 	PfcpHeartbeatInterval time.Duration
+	// wait for the user plane path recovery before releasing sessions
+	PathFailureReleaseDelay time.Duration
+	// PDU sessions released per second from a UPF in maintenance mode
+	MaintenanceReleaseRate int
+	// features supported by the SMF, pfcpType.CpFunctionFeatures*, advertised to the UPFs
+	cpFunctionFeatures     uint8
+	cpFunctionFeaturesLock sync.Mutex
+	// usage of a UE IP pool, in percent, above which an alarm is raised
+	UEIPPoolWarningThreshold  int
+	UEIPPoolCriticalThreshold int
+	// framed routes of the UEs by SUPI
+	FramedRoutes map[string][]factory.FramedRoutes
 
 	// Now only "IPv4" supported
 	// TODO: support "IPv6", "IPv4v6", "Ethernet"
@@ -68,6 +121,16 @@ type SMFContext struct {
 	UEPreConfigPathPool map[string]*UEPreConfigPaths
 	UEDefaultPathPool   map[string]*UEDefaultPaths
 	LocalSEIDCount      uint64
+
+	// Downlink data buffering for idle UEs
+	SMFBuffering                   bool
+	BufferingGtpuAddr              *net.UDPAddr
+	SuggestedBufferingPacketsCount uint8
+	BufferingMaxPackets            int
+	DDNBackoffTimer                time.Duration
+	DDNThrottlingDelay             time.Duration
+	DDNThrottlingPacketCount       uint8
+	BufferingTEIDCount             uint32
 }
 
 // RetrieveDnnInformation gets the corresponding dnn info from S-NSSAI and DNN
@@ -84,6 +147,10 @@ func AllocateLocalSEID() uint64 {
 	return atomic.AddUint64(&smfContext.LocalSEIDCount, 1)
 }
 
+func AllocateBufferingTEID() uint32 {
+	return atomic.AddUint32(&smfContext.BufferingTEIDCount, 1)
+}
+
 func InitSmfContext(config *factory.Config) {
 	if config == nil {
 		logger.CtxLog.Error("Config is nil")
@@ -116,6 +183,14 @@ func InitSmfContext(config *factory.Config) {
 			smfContext.PEM = tls.Pem
 		}
 
+		if sbi.OAuth2 {
+			// the SMF must not serve its services without checking the access tokens
+			if err := smfContext.loadNrfPublicKey(sbi.NrfCertPem); err != nil {
+				logger.InitLog.Fatalf("Load NRF certificate failed: %v", err)
+			}
+			smfContext.OAuth2Required = true
+		}
+
 		smfContext.BindingIPv4 = os.Getenv(sbi.BindingIPv4)
 		if smfContext.BindingIPv4 != "" {
 			logger.CtxLog.Info("Parsing ServerIPv4 address from ENV Variable.")
@@ -128,12 +203,36 @@ func InitSmfContext(config *factory.Config) {
 		}
 	}
 
+	// the metrics are served by default, on the SBI binding address
+	metrics := configuration.Metrics
+	if metrics == nil {
+		metrics = &factory.Metrics{}
+	}
+	smfContext.MetricsAddr = ""
+	if !metrics.Disable {
+		bindingIPv4 := metrics.BindingIPv4
+		if bindingIPv4 == "" {
+			bindingIPv4 = smfContext.BindingIPv4
+		}
+		smfContext.MetricsAddr = fmt.Sprintf("%s:%d", bindingIPv4, metrics.ListenPort())
+	}
+
 	if configuration.NrfUri != "" {
 		smfContext.NrfUri = configuration.NrfUri
 	} else {
 		logger.CtxLog.Warn("NRF Uri is empty! Using localhost as NRF IPv4 address.")
 		smfContext.NrfUri = fmt.Sprintf("%s://%s:%d", smfContext.URIScheme, "127.0.0.1", 29510)
 	}
+	smfContext.NfStatusSubscriptions = make(map[models.NfType]models.NrfSubscriptionData)
+	var resilience *factory.SbiResilience
+	if sbi := configuration.Sbi; sbi != nil {
+		resilience = sbi.Resilience
+	}
+	smfContext.setSBIResilience(resilience)
+	if scp := configuration.Scp; scp != nil {
+		smfContext.ScpUri = scp.Uri
+		smfContext.ScpDelegatedDiscovery = scp.DelegatedDiscovery
+	}
 
 	if pfcp := configuration.PFCP; pfcp != nil {
 		if pfcp.Port == 0 {
@@ -163,6 +262,9 @@ func InitSmfContext(config *factory.Config) {
 		}
 
 		smfContext.PfcpHeartbeatInterval = pfcp.Heartbeat.Interval
+		smfContext.PathFailureReleaseDelay = pfcp.PathFailureReleaseDelay
+		smfContext.MaintenanceReleaseRate = pfcp.MaintenanceReleaseRate
+		smfContext.SetCPFunctionFeatures(CPFunctionFeaturesOf(pfcp.CPFunctionFeatures))
 
 		if pfcp.AlertInterval == 0 {
 			smfContext.AssociationSetupFailedAlertInterval = 5 * time.Minute
@@ -176,6 +278,60 @@ func InitSmfContext(config *factory.Config) {
 		}
 	}
 
+	smfContext.BufferingMaxPackets = DefaultBufferingMaxPackets
+	smfContext.DDNBackoffTimer = DefaultDDNBackoffTimer
+	if dlBuffering := configuration.DLBuffering; dlBuffering != nil {
+		smfContext.SuggestedBufferingPacketsCount = dlBuffering.SuggestedPacketCount
+		if dlBuffering.MaxPackets > 0 {
+			smfContext.BufferingMaxPackets = dlBuffering.MaxPackets
+		}
+		if dlBuffering.DDNBackoffTimer > 0 {
+			smfContext.DDNBackoffTimer = dlBuffering.DDNBackoffTimer
+		}
+		if ddnThrottling := dlBuffering.DDNThrottling; ddnThrottling != nil {
+			smfContext.DDNThrottlingDelay = ddnThrottling.Delay
+			smfContext.DDNThrottlingPacketCount = ddnThrottling.SuggestedPacketCount
+		}
+		if dlBuffering.Mode == factory.DLBufferingModeSMF {
+			addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", dlBuffering.GtpuAddr, GtpuPort))
+			if err != nil {
+				logger.CtxLog.Warnf("DL buffering GTP-U Parse Addr Fail: %v", err)
+			} else {
+				smfContext.SMFBuffering = true
+				smfContext.BufferingGtpuAddr = addr
+			}
+		}
+	}
+
+	smfContext.UEIPPoolWarningThreshold = DefaultUEIPPoolWarningThreshold
+	smfContext.UEIPPoolCriticalThreshold = DefaultUEIPPoolCriticalThreshold
+	if ueIPPoolAlarm := configuration.UEIPPoolAlarm; ueIPPoolAlarm != nil {
+		if ueIPPoolAlarm.WarningThreshold > 0 {
+			smfContext.UEIPPoolWarningThreshold = ueIPPoolAlarm.WarningThreshold
+		}
+		if ueIPPoolAlarm.CriticalThreshold > 0 {
+			smfContext.UEIPPoolCriticalThreshold = ueIPPoolAlarm.CriticalThreshold
+		}
+	}
+
+	smfContext.FramedRoutes = make(map[string][]factory.FramedRoutes)
+	for _, framedRoutes := range configuration.FramedRoutes {
+		smfContext.FramedRoutes[framedRoutes.Supi] = append(smfContext.FramedRoutes[framedRoutes.Supi], framedRoutes)
+	}
+
+	if storeConfig := configuration.SessionStore; storeConfig != nil {
+		if store, err := NewFileSessionStore(storeConfig.Path); err != nil {
+			logger.CtxLog.Errorf("Open session store %s failed: %v", storeConfig.Path, err)
+		} else if configuration.Redundancy != nil {
+			SetSessionStore(NewReplicatedSessionStore(store))
+		} else {
+			SetSessionStore(store)
+		}
+	}
+	if redundancy := configuration.Redundancy; redundancy != nil {
+		smfContext.ReplicationSecret = redundancy.Secret
+	}
+
 	smfContext.SnssaiInfos = make([]SnssaiSmfInfo, 0, len(configuration.SNssaiInfo))
 
 	for _, snssaiInfoConfig := range configuration.SNssaiInfo {
@@ -196,6 +352,22 @@ func InitSmfContext(config *factory.Config) {
 			if dnnInfoConfig.PCSCF != nil {
 				dnnInfo.PCSCF.IPv4Addr = net.ParseIP(dnnInfoConfig.PCSCF.IPv4Addr).To4()
 			}
+			dnnInfo.UPFRestoration = factory.UPFRestorationRelease
+			if dnnInfoConfig.UPFRestoration != "" {
+				dnnInfo.UPFRestoration = dnnInfoConfig.UPFRestoration
+			}
+			if secondaryAuth := dnnInfoConfig.SecondaryAuth; secondaryAuth != nil {
+				dnnInfo.SecondaryAuth = newDNAAAServer(secondaryAuth.Radius)
+			}
+			if accounting := dnnInfoConfig.Accounting; accounting != nil {
+				dnnInfo.Accounting = newAccountingServer(accounting)
+			}
+			if localPolicy := dnnInfoConfig.LocalPolicy; localPolicy != nil {
+				dnnInfo.LocalPolicy = NewLocalPolicy(localPolicy)
+			}
+			if defaultSubscription := dnnInfoConfig.DefaultSubscription; defaultSubscription != nil {
+				dnnInfo.DefaultSubscription = newDefaultSubscription(defaultSubscription)
+			}
 			snssaiInfo.DnnInfos[dnnInfoConfig.Dnn] = &dnnInfo
 		}
 		smfContext.SnssaiInfos = append(smfContext.SnssaiInfos, snssaiInfo)
@@ -203,13 +375,17 @@ func InitSmfContext(config *factory.Config) {
 
 	// Set client and set url
 	ManagementConfig := Nnrf_NFManagement.NewConfiguration()
-	ManagementConfig.SetBasePath(SMF_Self().NrfUri)
+	SetSBIApiRoot(ManagementConfig, SMF_Self().NrfUri)
 	smfContext.NFManagementClient = Nnrf_NFManagement.NewAPIClient(ManagementConfig)
 
 	NFDiscovryConfig := Nnrf_NFDiscovery.NewConfiguration()
-	NFDiscovryConfig.SetBasePath(SMF_Self().NrfUri)
+	SetSBIApiRoot(NFDiscovryConfig, SMF_Self().NrfUri)
 	smfContext.NFDiscoveryClient = Nnrf_NFDiscovery.NewAPIClient(NFDiscovryConfig)
 
+	AccessTokenConfig := Nnrf_AccessToken.NewConfiguration()
+	SetSBIApiRoot(AccessTokenConfig, SMF_Self().NrfUri)
+	smfContext.AccessTokenClient = Nnrf_AccessToken.NewAPIClient(AccessTokenConfig)
+
 	smfContext.ULCLSupport = configuration.ULCL
 
 	smfContext.SupportedPDUSessionType = "IPv4"
@@ -268,3 +444,54 @@ func GetUserPlaneInformation() *UserPlaneInformation {
 func GetUEDefaultPathPool(groupName string) *UEDefaultPaths {
 	return smfContext.UEDefaultPathPool[groupName]
 }
+
+// FramedRoutesOf returns the framed routes of the UE for its PDU sessions of the DNN
+func (c *SMFContext) FramedRoutesOf(supi, dnn string) []string {
+	var routes []string
+	for _, framedRoutes := range c.FramedRoutes[supi] {
+		if framedRoutes.Dnn == "" || framedRoutes.Dnn == dnn {
+			routes = append(routes, framedRoutes.Routes...)
+		}
+	}
+	return routes
+}
+
+// TakeOverAddress makes the SMF serve the SBI and PFCP on the IPv4 address of the active SMF it
+// takes over, and register it at the NRF
+func (c *SMFContext) TakeOverAddress(ip net.IP) {
+	c.BindingIPv4 = ip.String()
+	c.RegisterIPv4 = ip.String()
+	c.CPNodeID = pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: ip.To4()}
+}
+
+// CPFunctionFeatures returns the features supported by the SMF, advertised to the UPFs
+func (c *SMFContext) CPFunctionFeatures() uint8 {
+	c.cpFunctionFeaturesLock.Lock()
+	defer c.cpFunctionFeaturesLock.Unlock()
+	return c.cpFunctionFeatures
+}
+
+// SetCPFunctionFeatures changes the features supported by the SMF, and reports whether they changed
+func (c *SMFContext) SetCPFunctionFeatures(features uint8) bool {
+	c.cpFunctionFeaturesLock.Lock()
+	defer c.cpFunctionFeaturesLock.Unlock()
+	if c.cpFunctionFeatures == features {
+		return false
+	}
+	c.cpFunctionFeatures = features
+	return true
+}
+
+// CPFunctionFeaturesOf maps the configured CP function features to pfcpType.CpFunctionFeatures*
+func CPFunctionFeaturesOf(features []string) uint8 {
+	var supportedFeatures uint8
+	for _, feature := range features {
+		switch feature {
+		case factory.CPFunctionFeatureLoad:
+			supportedFeatures |= pfcpType.CpFunctionFeaturesLoad
+		case factory.CPFunctionFeatureOvrl:
+			supportedFeatures |= pfcpType.CpFunctionFeaturesOvrl
+		}
+	}
+	return supportedFeatures
+}
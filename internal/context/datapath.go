@@ -190,6 +190,11 @@ func (node *DataPathNode) DeactivateUpLinkTunnel(smContext *SMContext) {
 				}
 			}
 		}
+		if urr := pdr.URR; urr != nil {
+			if err = node.UPF.RemoveURR(urr); err != nil {
+				logger.CtxLog.Warnln("Deactivated UpLinkTunnel", err)
+			}
+		}
 	}
 
 	teid := node.UpLinkTunnel.TEID
@@ -229,6 +234,11 @@ func (node *DataPathNode) DeactivateDownLinkTunnel(smContext *SMContext) {
 				}
 			}
 		}
+		if urr := pdr.URR; urr != nil {
+			if err = node.UPF.RemoveURR(urr); err != nil {
+				logger.CtxLog.Warnln("Deactivated DownLinkTunnel", err)
+			}
+		}
 	}
 
 	teid := node.DownLinkTunnel.TEID
@@ -346,27 +356,33 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 
 	// Activate PDR
 	for curDataPathNode := firstDPNode; curDataPathNode != nil; curDataPathNode = curDataPathNode.Next() {
-		var flowQER *QER
+		// each data path of the session has its own session AMBR QER on the UPF, shared by its
+		// uplink and downlink PDRs
+		flowQER, err := curDataPathNode.UPF.AddQER()
+		if err != nil {
+			logger.PduSessLog.Errorln("new QER failed")
+			return
+		}
+		flowQER.SessionAMBR = true
+		flowQER.QFI.QFI = uint8(AuthDefQos.Var5qi)
+		flowQER.GateStatus = &pfcpType.GateStatus{
+			ULGate: pfcpType.GateOpen,
+			DLGate: pfcpType.GateOpen,
+		}
+		flowQER.MBR = &pfcpType.MBR{
+			ULMBR: util.BitRateTokbps(sessionRule.AuthSessAmbr.Uplink),
+			DLMBR: util.BitRateTokbps(sessionRule.AuthSessAmbr.Downlink),
+		}
 
-		// if has the sess QoS (QER == 1), this value **SHOULD BE** uint32
-		if oldQER, ok := curDataPathNode.UPF.qerPool.Load(uint32(1)); ok {
-			flowQER = oldQER.(*QER)
-		} else {
-			if newQER, err := curDataPathNode.UPF.AddQER(); err != nil {
-				logger.PduSessLog.Errorln("new QER failed")
+		// the PSA reports the usage of the session for its accounting
+		var accountingURR *URR
+		if curDataPathNode.IsAnchorUPF() && smContext.DNNInfo != nil && smContext.DNNInfo.Accounting != nil {
+			if newURR, err := curDataPathNode.UPF.AddURR(); err != nil {
+				logger.PduSessLog.Errorln("new URR failed")
 				return
 			} else {
-				newQER.QFI.QFI = uint8(AuthDefQos.Var5qi)
-				newQER.GateStatus = &pfcpType.GateStatus{
-					ULGate: pfcpType.GateOpen,
-					DLGate: pfcpType.GateOpen,
-				}
-				newQER.MBR = &pfcpType.MBR{
-					ULMBR: util.BitRateTokbps(sessionRule.AuthSessAmbr.Uplink),
-					DLMBR: util.BitRateTokbps(sessionRule.AuthSessAmbr.Downlink),
-				}
-
-				flowQER = newQER
+				newURR.MeasurementPeriod = smContext.DNNInfo.Accounting.InterimInterval
+				accountingURR = newURR
 			}
 		}
 
@@ -379,6 +395,7 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 			ULPDR := curULTunnel.PDR
 			ULDestUPF := curULTunnel.DestEndPoint.UPF
 			ULPDR.QER = append(ULPDR.QER, flowQER)
+			ULPDR.URR = accountingURR
 
 			ULPDR.Precedence = precedence
 
@@ -455,6 +472,7 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 			DLPDR := curDLTunnel.PDR
 			DLDestUPF := curDLTunnel.DestEndPoint.UPF
 			DLPDR.QER = append(DLPDR.QER, flowQER)
+			DLPDR.URR = accountingURR
 
 			DLPDR.Precedence = precedence
 
@@ -469,6 +487,7 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 						Ipv4Address: smContext.PDUAddress.To4(),
 					},
 				}
+				DLPDR.PDI.FramedRoutes = append([]string(nil), smContext.FramedRoutes...)
 			} else {
 				DLPDR.OuterHeaderRemoval = &pfcpType.OuterHeaderRemoval{
 					OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
@@ -0,0 +1,83 @@
+package context
+
+import (
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// DefaultSubscription is the SM subscription data of the PDU sessions of a DNN the UDM has none for
+type DefaultSubscription struct {
+	// the sessions without subscription data are rejected rather than given the defaults
+	Reject bool
+
+	dnnConfiguration models.DnnConfiguration
+}
+
+func newDefaultSubscription(config *factory.DefaultSubscription) *DefaultSubscription {
+	defaults := &DefaultSubscription{
+		Reject: config.Mode == factory.DefaultSubscriptionReject,
+		dnnConfiguration: models.DnnConfiguration{
+			SessionAmbr: config.SessionAmbr,
+			UpSecurity:  config.UpSecurity,
+		},
+	}
+	if types := config.PduSessionTypes; types != nil {
+		// the SMF only allows the session types listed as allowed
+		pduSessionTypes := *types
+		if len(pduSessionTypes.AllowedSessionTypes) == 0 {
+			pduSessionTypes.AllowedSessionTypes = []models.PduSessionType{pduSessionTypes.DefaultSessionType}
+		}
+		defaults.dnnConfiguration.PduSessionTypes = &pduSessionTypes
+	}
+	defaults.dnnConfiguration.SscModes = &models.SscModes{
+		DefaultSscMode:  models.SscMode__1,
+		AllowedSscModes: []models.SscMode{models.SscMode__1},
+	}
+	if config.SscModes != nil {
+		defaults.dnnConfiguration.SscModes = config.SscModes
+	}
+	if config.Var5qi != 0 {
+		defaults.dnnConfiguration.Var5gQosProfile = &models.SubscribedDefaultQos{
+			Var5qi: config.Var5qi,
+			Arp:    config.Arp,
+		}
+	}
+	return defaults
+}
+
+// Merge sets the SM subscription data the UDM did not send to the defaults of the DNN, and
+// reports whether any was missing
+func (d *DefaultSubscription) Merge(dnnConfiguration *models.DnnConfiguration) bool {
+	merged := false
+	defaults := d.dnnConfiguration
+	if dnnConfiguration.PduSessionTypes == nil && defaults.PduSessionTypes != nil {
+		pduSessionTypes := *defaults.PduSessionTypes
+		dnnConfiguration.PduSessionTypes = &pduSessionTypes
+		merged = true
+	}
+	if dnnConfiguration.SscModes == nil && defaults.SscModes != nil {
+		sscModes := *defaults.SscModes
+		dnnConfiguration.SscModes = &sscModes
+		merged = true
+	}
+	if dnnConfiguration.SessionAmbr == nil && defaults.SessionAmbr != nil {
+		sessionAmbr := *defaults.SessionAmbr
+		dnnConfiguration.SessionAmbr = &sessionAmbr
+		merged = true
+	}
+	if dnnConfiguration.Var5gQosProfile == nil && defaults.Var5gQosProfile != nil {
+		qosProfile := *defaults.Var5gQosProfile
+		if qosProfile.Arp != nil {
+			arp := *qosProfile.Arp
+			qosProfile.Arp = &arp
+		}
+		dnnConfiguration.Var5gQosProfile = &qosProfile
+		merged = true
+	}
+	if dnnConfiguration.UpSecurity == nil && defaults.UpSecurity != nil {
+		upSecurity := *defaults.UpSecurity
+		dnnConfiguration.UpSecurity = &upSecurity
+		merged = true
+	}
+	return merged
+}
@@ -0,0 +1,53 @@
+package context
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestDefaultSubscriptionMerge(t *testing.T) {
+	defaults := newDefaultSubscription(&factory.DefaultSubscription{
+		PduSessionTypes: &models.PduSessionTypes{DefaultSessionType: models.PduSessionType_IPV4},
+		SessionAmbr:     &models.Ambr{Uplink: "100 Mbps", Downlink: "200 Mbps"},
+		Var5qi:          9,
+		Arp: &models.Arp{
+			PriorityLevel: 8,
+			PreemptCap:    models.PreemptionCapability_NOT_PREEMPT,
+			PreemptVuln:   models.PreemptionVulnerability_PREEMPTABLE,
+		},
+	})
+	require.False(t, defaults.Reject)
+
+	// no subscription data
+	var dnnConfiguration models.DnnConfiguration
+	require.True(t, defaults.Merge(&dnnConfiguration))
+	require.Equal(t, []models.PduSessionType{models.PduSessionType_IPV4},
+		dnnConfiguration.PduSessionTypes.AllowedSessionTypes)
+	require.Equal(t, models.SscMode__1, dnnConfiguration.SscModes.DefaultSscMode)
+	require.Equal(t, "200 Mbps", dnnConfiguration.SessionAmbr.Downlink)
+	require.Equal(t, int32(9), dnnConfiguration.Var5gQosProfile.Var5qi)
+	require.Nil(t, dnnConfiguration.UpSecurity)
+
+	// the subscription data of the UDM is kept
+	dnnConfiguration = models.DnnConfiguration{
+		SessionAmbr: &models.Ambr{Uplink: "1 Gbps", Downlink: "1 Gbps"},
+	}
+	require.True(t, defaults.Merge(&dnnConfiguration))
+	require.Equal(t, "1 Gbps", dnnConfiguration.SessionAmbr.Uplink)
+	require.NotNil(t, dnnConfiguration.PduSessionTypes)
+	require.False(t, defaults.Merge(&dnnConfiguration))
+
+	// each session has its own copy of the defaults
+	dnnConfiguration.Var5gQosProfile.Arp.PriorityLevel = 1
+	var other models.DnnConfiguration
+	defaults.Merge(&other)
+	require.Equal(t, int32(8), other.Var5gQosProfile.Arp.PriorityLevel)
+
+	require.True(t, newDefaultSubscription(&factory.DefaultSubscription{
+		Mode: factory.DefaultSubscriptionReject,
+	}).Reject)
+}
@@ -0,0 +1,154 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/free5gc/smf/internal/logger"
+)
+
+const (
+	sessionRecordSuffix   = ".json"
+	quarantineSuffix      = ".corrupt"
+	recoveryTimeStampFile = "recovery-timestamp"
+)
+
+// FileSessionStore is a SessionStore keeping each record in a JSON file of a directory
+type FileSessionStore struct {
+	dir string
+}
+
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+func (s *FileSessionStore) recordPath(ref string) string {
+	// the Ref is a URN, "urn:uuid:<uuid>"
+	return filepath.Join(s.dir, strings.ReplaceAll(ref, ":", "_")+sessionRecordSuffix)
+}
+
+func (s *FileSessionStore) Put(record *SessionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(s.recordPath(record.Ref), data)
+}
+
+func (s *FileSessionStore) Delete(ref string) error {
+	err := os.Remove(s.recordPath(ref))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// a released session must not be restored after a crash
+	return s.syncDir()
+}
+
+func (s *FileSessionStore) LoadAll() ([]*SessionRecord, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*SessionRecord, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), sessionRecordSuffix) {
+			continue
+		}
+		path := filepath.Join(s.dir, file.Name())
+		record, err := readRecord(path)
+		if err != nil {
+			// one bad record must not lose the other sessions
+			logger.CtxLog.Errorf("Session record %s quarantined: %v", file.Name(), err)
+			if err = os.Rename(path, path+quarantineSuffix); err != nil {
+				logger.CtxLog.Errorf("Quarantine session record %s failed: %v", file.Name(), err)
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func readRecord(path string) (*SessionRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	record := new(SessionRecord)
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("invalid session record: %v", err)
+	}
+	return record, nil
+}
+
+func (s *FileSessionStore) PutRecoveryTimeStamp(timeStamp time.Time) error {
+	data, err := timeStamp.MarshalText()
+	if err != nil {
+		return err
+	}
+	return s.writeFile(filepath.Join(s.dir, recoveryTimeStampFile), data)
+}
+
+func (s *FileSessionStore) LoadRecoveryTimeStamp() (time.Time, error) {
+	var timeStamp time.Time
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, recoveryTimeStampFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return timeStamp, nil
+		}
+		return timeStamp, err
+	}
+	err = timeStamp.UnmarshalText(data)
+	return timeStamp, err
+}
+
+// writeFile replaces the file at once, so that a crash never leaves a partial record: the data
+// is synced to a temporary file before it is renamed, and the rename is synced with the directory
+func (s *FileSessionStore) writeFile(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if removeErr := os.Remove(tmpPath); removeErr != nil {
+			logger.CtxLog.Warnf("Remove %s failed: %v", tmpPath, removeErr)
+		}
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return s.syncDir()
+}
+
+func (s *FileSessionStore) syncDir() error {
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package context
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+)
+
+func TestFileSessionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smf-session-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileSessionStore(dir)
+	require.NoError(t, err)
+
+	// IPv4 addresses are in 16 bytes, as decoded from JSON
+
+	record := &SessionRecord{
+		Ref:          "urn:uuid:6a8e4d5c-5e3f-4a6b-9a4e-2b1f5c1d7e90",
+		State:        Active,
+		Supi:         "imsi-208930000000001",
+		Identifier:   "imsi-208930000000001",
+		PDUSessionID: 1,
+		Dnn:          "internet",
+		Snssai:       &models.Snssai{Sst: 1, Sd: "010203"},
+		PDUAddress:   net.ParseIP("10.60.0.1"),
+		LocalSEID:    1,
+		PFCPSessions: []*PFCPSessionRecord{
+			{
+				NodeID: pfcpType.NodeID{
+					NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+					IP:         net.ParseIP("10.4.0.11"),
+				},
+				LocalSEID:  1,
+				RemoteSEID: 2,
+			},
+		},
+		DataPaths: []*DataPathRecord{
+			{
+				PathID:        1,
+				Activated:     true,
+				IsDefaultPath: true,
+				Nodes: []*DataPathNodeRecord{
+					{
+						UPF:        "UPF",
+						UpLinkTEID: 1,
+						UpLinkPDR: &PDR{
+							PDRID: 1,
+							FAR:   &FAR{FARID: 1},
+							QER:   []*QER{{QERID: 1}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	require.NoError(t, store.Put(record))
+	records, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Equal(t, []*SessionRecord{record}, records)
+
+	require.NoError(t, store.Delete(record.Ref))
+	records, err = store.LoadAll()
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	timeStamp, err := store.LoadRecoveryTimeStamp()
+	require.NoError(t, err)
+	require.True(t, timeStamp.IsZero())
+
+	now := time.Now().Truncate(time.Second)
+	require.NoError(t, store.PutRecoveryTimeStamp(now))
+	timeStamp, err = store.LoadRecoveryTimeStamp()
+	require.NoError(t, err)
+	require.True(t, now.Equal(timeStamp))
+}
+
+func TestFileSessionStoreQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSessionStore(dir)
+	require.NoError(t, err)
+
+	record := &SessionRecord{
+		Ref:          "urn:uuid:0f0e8c3a-1d2b-4c5d-8e9f-a0b1c2d3e4f5",
+		State:        Active,
+		Supi:         "imsi-208930000000002",
+		PDUSessionID: 1,
+	}
+	require.NoError(t, store.Put(record))
+	corrupt := filepath.Join(dir, "urn_uuid_corrupt.json")
+	require.NoError(t, ioutil.WriteFile(corrupt, []byte(`{"ref":`), 0o600))
+	// left by a crash before the rename
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "urn_uuid_partial.json.tmp"), []byte(`{`), 0o600))
+
+	// the corrupt record is set aside, the other sessions are restored
+	records, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Equal(t, []*SessionRecord{record}, records)
+	_, err = os.Stat(corrupt)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(corrupt + quarantineSuffix)
+	require.NoError(t, err)
+
+	records, err = store.LoadAll()
+	require.NoError(t, err)
+	require.Equal(t, []*SessionRecord{record}, records)
+
+	// no temporary file is left by the writes
+	files, err := filepath.Glob(filepath.Join(dir, "urn_uuid_0f0e8c3a*"))
+	require.NoError(t, err)
+	require.Equal(t, []string{store.recordPath(record.Ref)}, files)
+}
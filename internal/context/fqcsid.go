@@ -0,0 +1,173 @@
+package context
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/free5gc/pfcp/pfcpType"
+)
+
+// Node-ID Type of the FQ-CSID IE, TS 29.244 8.2.46
+const (
+	FQCSIDNodeIDTypeIpv4 uint8 = iota
+	FQCSIDNodeIDTypeIpv6
+	FQCSIDNodeIDTypeMccMnc
+)
+
+const maxCSIDs = 15
+
+var csidCount uint32
+
+// FQCSID is the FQ-CSID IE, TS 29.244 8.2.46. A CSID identifies a set of PFCP sessions of
+// a node, so that the peers can delete them at once after a partial failure, TS 23.007.
+type FQCSID struct {
+	NodeIDType  uint8
+	NodeAddress net.IP
+	// MCC/MNC based Node-ID, used when NodeIDType is FQCSIDNodeIDTypeMccMnc
+	NodeID uint32
+	CSIDs  []uint16
+}
+
+func (f *FQCSID) MarshalBinary() (data []byte, err error) {
+	if len(f.CSIDs) == 0 || len(f.CSIDs) > maxCSIDs {
+		return nil, fmt.Errorf("invalid number of CSIDs: %d", len(f.CSIDs))
+	}
+
+	// Octet 5
+	data = append(data, f.NodeIDType<<4|uint8(len(f.CSIDs)))
+
+	// Octet 6 to m
+	switch f.NodeIDType {
+	case FQCSIDNodeIDTypeIpv4:
+		ipv4 := f.NodeAddress.To4()
+		if ipv4 == nil {
+			return nil, fmt.Errorf("invalid IPv4 node address: %s", f.NodeAddress)
+		}
+		data = append(data, ipv4...)
+	case FQCSIDNodeIDTypeIpv6:
+		ipv6 := f.NodeAddress.To16()
+		if ipv6 == nil {
+			return nil, fmt.Errorf("invalid IPv6 node address: %s", f.NodeAddress)
+		}
+		data = append(data, ipv6...)
+	case FQCSIDNodeIDTypeMccMnc:
+		data = append(data, make([]byte, 4)...)
+		binary.BigEndian.PutUint32(data[1:], f.NodeID)
+	default:
+		return nil, fmt.Errorf("unknown Node-ID type: %d", f.NodeIDType)
+	}
+
+	// Octet (m+1) to p
+	for _, csid := range f.CSIDs {
+		data = append(data, uint8(csid>>8), uint8(csid))
+	}
+
+	return data, nil
+}
+
+func (f *FQCSID) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("Inadequate TLV length: %d", len(data))
+	}
+
+	// Octet 5
+	f.NodeIDType = data[0] >> 4
+	numberOfCSIDs := int(data[0] & 0x0f)
+	idx := 1
+
+	// Octet 6 to m
+	var addrLen int
+	switch f.NodeIDType {
+	case FQCSIDNodeIDTypeIpv4:
+		addrLen = net.IPv4len
+	case FQCSIDNodeIDTypeIpv6:
+		addrLen = net.IPv6len
+	case FQCSIDNodeIDTypeMccMnc:
+		addrLen = 4
+	default:
+		return fmt.Errorf("unknown Node-ID type: %d", f.NodeIDType)
+	}
+	if len(data) != idx+addrLen+2*numberOfCSIDs {
+		return fmt.Errorf("Inadequate TLV length: %d", len(data))
+	}
+	if f.NodeIDType == FQCSIDNodeIDTypeMccMnc {
+		f.NodeID = binary.BigEndian.Uint32(data[idx : idx+addrLen])
+	} else {
+		f.NodeAddress = net.IP(data[idx : idx+addrLen])
+	}
+	idx += addrLen
+
+	// Octet (m+1) to p
+	f.CSIDs = make([]uint16, 0, numberOfCSIDs)
+	for i := 0; i < numberOfCSIDs; i++ {
+		f.CSIDs = append(f.CSIDs, binary.BigEndian.Uint16(data[idx:idx+2]))
+		idx += 2
+	}
+
+	return nil
+}
+
+// NewFQCSID returns the FQ-CSID of the node with the address
+func NewFQCSID(nodeAddress net.IP, csids ...uint16) *FQCSID {
+	fqcsid := &FQCSID{
+		NodeIDType:  FQCSIDNodeIDTypeIpv4,
+		NodeAddress: nodeAddress.To4(),
+		CSIDs:       csids,
+	}
+	if fqcsid.NodeAddress == nil {
+		fqcsid.NodeIDType = FQCSIDNodeIDTypeIpv6
+		fqcsid.NodeAddress = nodeAddress.To16()
+	}
+	return fqcsid
+}
+
+// ParseFQCSID decodes the FQ-CSID IE kept as raw bytes by the pfcp library
+func ParseFQCSID(ie *pfcpType.FQCSID) (*FQCSID, error) {
+	fqcsid := new(FQCSID)
+	if err := fqcsid.UnmarshalBinary(ie.FQCSIDdata); err != nil {
+		return nil, err
+	}
+	return fqcsid, nil
+}
+
+func allocateCSID() uint16 {
+	return uint16(atomic.AddUint32(&csidCount, 1))
+}
+
+// SMFFQCSID returns the FQ-CSID of the SMF for the PFCP sessions on the UPF
+func (upf *UPF) SMFFQCSID() *FQCSID {
+	return NewFQCSID(SMF_Self().CPNodeID.ResolveNodeIdToIp(), upf.SMFCSID)
+}
+
+// RenewSMFCSID assigns a new CSID of the SMF to the PFCP sessions on the UPF, and returns
+// the former one so that the sessions the SMF no longer knows can be deleted as a set
+func (upf *UPF) RenewSMFCSID() uint16 {
+	staleCSID := upf.SMFCSID
+	upf.SMFCSID = allocateCSID()
+	return staleCSID
+}
+
+// Match reports whether the FQ-CSIDs are of the same node and share a CSID
+func (f *FQCSID) Match(other *FQCSID) bool {
+	if f == nil || other == nil || f.NodeIDType != other.NodeIDType {
+		return false
+	}
+	if f.NodeIDType == FQCSIDNodeIDTypeMccMnc {
+		if f.NodeID != other.NodeID {
+			return false
+		}
+	} else if !f.NodeAddress.Equal(other.NodeAddress) {
+		return false
+	}
+
+	for _, csid := range f.CSIDs {
+		for _, otherCSID := range other.CSIDs {
+			if csid == otherCSID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp/pfcpType"
+)
+
+func TestSMContextsOfFQCSIDs(t *testing.T) {
+	upfIP := net.ParseIP("10.4.0.21").To4()
+	upf := &UPF{
+		NodeID:  pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: upfIP},
+		SMFCSID: 1,
+	}
+	newSession := func(pduSessionID int32, upfFQCSID *FQCSID) *SMContext {
+		smContext := NewSMContext("imsi-208930000000021", pduSessionID)
+		t.Cleanup(func() { RemoveSMContext(smContext.Ref) })
+		smContext.PFCPContext[upfIP.String()] = &PFCPSessionContext{NodeID: upf.NodeID, UPFFQCSID: upfFQCSID}
+		return smContext
+	}
+	inSet := newSession(1, NewFQCSID(upfIP, 7))
+	newSession(2, NewFQCSID(upfIP, 8))
+	newSession(3, nil)
+
+	// the CSID of the SMF is shared by all the sessions on the UPF, and is not a set of the UPF
+	require.Empty(t, upf.SMContextsOfFQCSIDs([]*FQCSID{upf.SMFFQCSID()}))
+	require.Empty(t, upf.SMContextsOfFQCSIDs([]*FQCSID{NewFQCSID(net.ParseIP("10.4.0.22"), 7)}))
+	require.Equal(t, []*SMContext{inSet}, upf.SMContextsOfFQCSIDs([]*FQCSID{NewFQCSID(upfIP, 7, 9)}))
+}
@@ -2,6 +2,7 @@ package context
 
 import (
 	"encoding/hex"
+	"time"
 
 	"github.com/free5gc/nas"
 	"github.com/free5gc/nas/nasConvert"
@@ -45,13 +46,16 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 			QFI:           uint8(authDefQos.Var5qi),
 			PacketFilterList: []PacketFilter{
 				{
-					Identifier:    0x01,
-					Direction:     PacketFilterDirectionBidirectional,
-					ComponentType: PacketFilterComponentTypeMatchAll,
+					Identifier: 0x01,
+					Direction:  PacketFilterDirectionBidirectional,
+					Components: []PacketFilterComponent{{ComponentType: PacketFilterComponentTypeMatchAll}},
 				},
 			},
 		},
 	}
+	// the PCC rules enforced on the UPFs
+	pccRules := smContext.enforcedPCCRules()
+	qoSRules = append(qoSRules, pccRules.qosRules()...)
 
 	qosRulesBytes, err := qoSRules.MarshalBinary()
 	if err != nil {
@@ -70,10 +74,22 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 		pDUSessionEstablishmentAccept.PDUAddress.SetPDUAddressInformation(addr)
 	}
 
+	qosFlowDescriptions := QoSFlowDescriptions{
+		{
+			QFI:           uint8(authDefQos.Var5qi),
+			OperationCode: OperationCodeCreateNewQoSFlowDescription,
+			Var5qi:        uint8(authDefQos.Var5qi),
+		},
+	}
+	qosFlowDescriptions = append(qosFlowDescriptions, pccRules.qosFlowDescriptions()...)
+	qosFlowDescriptionsBytes, err := qosFlowDescriptions.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
 	pDUSessionEstablishmentAccept.AuthorizedQosFlowDescriptions = nasType.NewAuthorizedQosFlowDescriptions(
 		nasMessage.PDUSessionEstablishmentAcceptAuthorizedQosFlowDescriptionsType)
-	pDUSessionEstablishmentAccept.AuthorizedQosFlowDescriptions.SetLen(6)
-	pDUSessionEstablishmentAccept.SetQoSFlowDescriptions([]uint8{uint8(authDefQos.Var5qi), 0x20, 0x41, 0x01, 0x01, 0x09})
+	pDUSessionEstablishmentAccept.AuthorizedQosFlowDescriptions.SetLen(uint16(len(qosFlowDescriptionsBytes)))
+	pDUSessionEstablishmentAccept.SetQoSFlowDescriptions(qosFlowDescriptionsBytes)
 
 	var sd [3]uint8
 
@@ -142,10 +158,25 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 			ExtendedProtocolConfigurationOptions.
 			SetExtendedProtocolConfigurationOptionsContents(pcoContents)
 	}
+
+	if auth := smContext.SecondaryAuth; auth != nil && auth.EAPResult != nil {
+		pDUSessionEstablishmentAccept.EAPMessage = nasType.NewEAPMessage(
+			nasMessage.PDUSessionEstablishmentAcceptEAPMessageType)
+		pDUSessionEstablishmentAccept.EAPMessage.SetLen(uint16(len(auth.EAPResult)))
+		pDUSessionEstablishmentAccept.EAPMessage.SetEAPMessage(auth.EAPResult)
+	}
 	return m.PlainNasEncode()
 }
 
 func BuildGSMPDUSessionEstablishmentReject(smContext *SMContext, cause uint8) ([]byte, error) {
+	return BuildGSMPDUSessionEstablishmentRejectWithBackoffTimer(smContext, cause, 0)
+}
+
+// BuildGSMPDUSessionEstablishmentRejectWithBackoffTimer builds a PDU Session Establishment Reject
+// with the Back-off timer value IE when backoffTimer is positive, TS 24.501 8.3.3.2
+func BuildGSMPDUSessionEstablishmentRejectWithBackoffTimer(smContext *SMContext, cause uint8,
+	backoffTimer time.Duration,
+) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GsmMessage = nas.NewGsmMessage()
 	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionEstablishmentReject)
@@ -157,6 +188,39 @@ func BuildGSMPDUSessionEstablishmentReject(smContext *SMContext, cause uint8) ([
 	pDUSessionEstablishmentReject.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
 	pDUSessionEstablishmentReject.SetPDUSessionID(uint8(smContext.PDUSessionID))
 	pDUSessionEstablishmentReject.SetCauseValue(cause)
+	if backoffTimer > 0 {
+		pDUSessionEstablishmentReject.BackoffTimerValue = nasType.NewBackoffTimerValue(
+			nasMessage.PDUSessionEstablishmentRejectBackoffTimerValueType)
+		pDUSessionEstablishmentReject.BackoffTimerValue.SetLen(1)
+		pDUSessionEstablishmentReject.BackoffTimerValue.Octet = nasConvert.GPRSTimer3ToNas(int(backoffTimer.Seconds()))
+	}
+
+	if auth := smContext.SecondaryAuth; auth != nil && auth.EAPResult != nil {
+		pDUSessionEstablishmentReject.EAPMessage = nasType.NewEAPMessage(
+			nasMessage.PDUSessionEstablishmentRejectEAPMessageType)
+		pDUSessionEstablishmentReject.EAPMessage.SetLen(uint16(len(auth.EAPResult)))
+		pDUSessionEstablishmentReject.EAPMessage.SetEAPMessage(auth.EAPResult)
+	}
+
+	return m.PlainNasEncode()
+}
+
+// BuildGSMPDUSessionAuthenticationCommand makes the PDU Session Authentication Command carrying
+// the EAP request of the DN-AAA server, TS 24.501 8.3.4
+func BuildGSMPDUSessionAuthenticationCommand(smContext *SMContext, eap []byte) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionAuthenticationCommand)
+	m.GsmHeader.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	m.PDUSessionAuthenticationCommand = nasMessage.NewPDUSessionAuthenticationCommand(0x0)
+	pDUSessionAuthenticationCommand := m.PDUSessionAuthenticationCommand
+
+	pDUSessionAuthenticationCommand.SetMessageType(nas.MsgTypePDUSessionAuthenticationCommand)
+	pDUSessionAuthenticationCommand.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pDUSessionAuthenticationCommand.SetPDUSessionID(uint8(smContext.PDUSessionID))
+	pDUSessionAuthenticationCommand.SetPTI(smContext.Pti)
+	pDUSessionAuthenticationCommand.EAPMessage.SetLen(uint16(len(eap)))
+	pDUSessionAuthenticationCommand.EAPMessage.SetEAPMessage(eap)
 
 	return m.PlainNasEncode()
 }
@@ -167,6 +231,14 @@ func BuildGSMPDUSessionEstablishmentReject(smContext *SMContext, cause uint8) ([
 // the value of smContext.Pti which is received from UE, otherwise it is 0.
 // ref. 6.3.3.2 Network-requested PDU session release procedure initiation in TS24.501.
 func BuildGSMPDUSessionReleaseCommand(smContext *SMContext, cause uint8, isTriggeredByUE bool) ([]byte, error) {
+	return BuildGSMPDUSessionReleaseCommandWithBackoffTimer(smContext, cause, isTriggeredByUE, 0)
+}
+
+// BuildGSMPDUSessionReleaseCommandWithBackoffTimer builds a PDU Session Release Command
+// with the Back-off timer value IE when backoffTimer is positive, TS 24.501 8.3.14.3
+func BuildGSMPDUSessionReleaseCommandWithBackoffTimer(smContext *SMContext, cause uint8, isTriggeredByUE bool,
+	backoffTimer time.Duration,
+) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GsmMessage = nas.NewGsmMessage()
 	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionReleaseCommand)
@@ -184,11 +256,19 @@ func BuildGSMPDUSessionReleaseCommand(smContext *SMContext, cause uint8, isTrigg
 		pDUSessionReleaseCommand.SetPTI(0x00)
 	}
 	pDUSessionReleaseCommand.SetCauseValue(cause)
+	if backoffTimer > 0 {
+		pDUSessionReleaseCommand.BackoffTimerValue = nasType.NewBackoffTimerValue(
+			nasMessage.PDUSessionReleaseCommandBackoffTimerValueType)
+		pDUSessionReleaseCommand.BackoffTimerValue.SetLen(1)
+		pDUSessionReleaseCommand.BackoffTimerValue.Octet = nasConvert.GPRSTimer3ToNas(int(backoffTimer.Seconds()))
+	}
 
 	return m.PlainNasEncode()
 }
 
-func BuildGSMPDUSessionModificationCommand(smContext *SMContext) ([]byte, error) {
+// BuildGSMPDUSessionModificationCommand carries the session AMBR and the QoS rules and QoS flows
+// of the changed PCC rules, if any
+func BuildGSMPDUSessionModificationCommand(smContext *SMContext, changes *PCCRuleChanges) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GsmMessage = nas.NewGsmMessage()
 	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionModificationCommand)
@@ -200,13 +280,32 @@ func BuildGSMPDUSessionModificationCommand(smContext *SMContext) ([]byte, error)
 	pDUSessionModificationCommand.SetPDUSessionID(uint8(smContext.PDUSessionID))
 	pDUSessionModificationCommand.SetPTI(smContext.Pti)
 	pDUSessionModificationCommand.SetMessageType(nas.MsgTypePDUSessionModificationCommand)
-	// pDUSessionModificationCommand.SetQosRule()
-	// pDUSessionModificationCommand.AuthorizedQosRules.SetLen()
-	// pDUSessionModificationCommand.SessionAMBR.SetSessionAMBRForDownlink([2]uint8{0x11, 0x11})
-	// pDUSessionModificationCommand.SessionAMBR.SetSessionAMBRForUplink([2]uint8{0x11, 0x11})
-	// pDUSessionModificationCommand.SessionAMBR.SetUnitForSessionAMBRForDownlink(10)
-	// pDUSessionModificationCommand.SessionAMBR.SetUnitForSessionAMBRForUplink(10)
-	// pDUSessionModificationCommand.SessionAMBR.SetLen(uint8(len(pDUSessionModificationCommand.SessionAMBR.Octet)))
+	if qosRules := changes.qosRules(); len(qosRules) > 0 {
+		qosRulesBytes, err := qosRules.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		pDUSessionModificationCommand.AuthorizedQosRules = nasType.NewAuthorizedQosRules(
+			nasMessage.PDUSessionModificationCommandAuthorizedQosRulesType)
+		pDUSessionModificationCommand.AuthorizedQosRules.SetLen(uint16(len(qosRulesBytes)))
+		pDUSessionModificationCommand.AuthorizedQosRules.SetQosRule(qosRulesBytes)
+	}
+	if descriptions := changes.qosFlowDescriptions(); len(descriptions) > 0 {
+		descriptionsBytes, err := descriptions.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		pDUSessionModificationCommand.AuthorizedQosFlowDescriptions = nasType.NewAuthorizedQosFlowDescriptions(
+			nasMessage.PDUSessionModificationCommandAuthorizedQosFlowDescriptionsType)
+		pDUSessionModificationCommand.AuthorizedQosFlowDescriptions.SetLen(uint16(len(descriptionsBytes)))
+		pDUSessionModificationCommand.AuthorizedQosFlowDescriptions.SetQoSFlowDescriptions(descriptionsBytes)
+	}
+	if sessRule := smContext.SelectedSessionRule(); sessRule != nil && sessRule.AuthSessAmbr != nil {
+		sessionAMBR := nasConvert.ModelsToSessionAMBR(sessRule.AuthSessAmbr)
+		sessionAMBR.SetIei(nasMessage.PDUSessionModificationCommandSessionAMBRType)
+		sessionAMBR.SetLen(uint8(len(sessionAMBR.Octet)))
+		pDUSessionModificationCommand.SessionAMBR = &sessionAMBR
+	}
 
 	return m.PlainNasEncode()
 }
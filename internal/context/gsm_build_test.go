@@ -0,0 +1,40 @@
+package context
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+)
+
+func TestBuildGSMPDUSessionReleaseCommandWithBackoffTimer(t *testing.T) {
+	smContext := NewSMContext("imsi-208930000000031", 5)
+	t.Cleanup(func() { RemoveSMContext(smContext.Ref) })
+
+	decode := func(buf []byte) *nasMessage.PDUSessionReleaseCommand {
+		m := nas.NewMessage()
+		require.NoError(t, m.GsmMessageDecode(&buf))
+		require.Equal(t, nas.MsgTypePDUSessionReleaseCommand, m.GsmHeader.GetMessageType())
+		return m.PDUSessionReleaseCommand
+	}
+
+	buf, err := BuildGSMPDUSessionReleaseCommandWithBackoffTimer(smContext,
+		nasMessage.Cause5GSMInsufficientResources, false, time.Hour)
+	require.NoError(t, err)
+	command := decode(buf)
+	require.Equal(t, uint8(5), command.GetPDUSessionID())
+	require.Equal(t, nasMessage.Cause5GSMInsufficientResources, command.GetCauseValue())
+	require.NotNil(t, command.BackoffTimerValue)
+	// 6 multiples of 10 minutes, TS 24.008 10.5.7.4a
+	require.Equal(t, uint8(nasMessage.GPRSTimer3UnitMultiplesOf10Minutes<<5|6), command.BackoffTimerValue.Octet)
+
+	// without a back-off timer, the UE may re-establish the PDU session at once
+	buf, err = BuildGSMPDUSessionReleaseCommand(smContext, nasMessage.Cause5GSMRegularDeactivation, false)
+	require.NoError(t, err)
+	command = decode(buf)
+	require.Equal(t, nasMessage.Cause5GSMRegularDeactivation, command.GetCauseValue())
+	require.Nil(t, command.BackoffTimerValue)
+}
@@ -0,0 +1,72 @@
+package context
+
+import (
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// LocalSessionRuleID is the ID of the session rule of the local policies, removed from the
+// session when the PCF takes over its policy
+const LocalSessionRuleID = "LocalPolicy"
+
+// LocalPolicy is the SM policy decision of the PDU sessions of a DNN while the SM policy
+// association with the PCF cannot be created
+type LocalPolicy struct {
+	config *factory.LocalPolicy
+}
+
+// NewLocalPolicy returns the local policy of the configuration
+func NewLocalPolicy(config *factory.LocalPolicy) *LocalPolicy {
+	return &LocalPolicy{config: config}
+}
+
+// SmPolicyDecision returns a new SM policy decision of the local policy, with the session rule of
+// the default QoS and session AMBR and the predefined PCC rules, TS 29.512 5.6.2.4
+func (p *LocalPolicy) SmPolicyDecision() *models.SmPolicyDecision {
+	arp := *p.config.Arp
+	ambr := *p.config.SessionAmbr
+	decision := &models.SmPolicyDecision{
+		SessRules: map[string]*models.SessionRule{
+			LocalSessionRuleID: {
+				SessRuleId:   LocalSessionRuleID,
+				AuthSessAmbr: &ambr,
+				AuthDefQos: &models.AuthorizedDefaultQos{
+					Var5qi: p.config.Var5qi,
+					Arp:    &arp,
+				},
+			},
+		},
+		PccRules: make(map[string]*models.PccRule),
+		QosDecs:  make(map[string]*models.QosData),
+	}
+
+	for _, rule := range p.config.PccRules {
+		pccRule := &models.PccRule{
+			PccRuleId:  rule.PccRuleId,
+			Precedence: rule.Precedence,
+		}
+		for _, flowDescription := range rule.FlowDescriptions {
+			pccRule.FlowInfos = append(pccRule.FlowInfos, models.FlowInformation{
+				FlowDescription: flowDescription,
+				FlowDirection:   models.FlowDirectionRm_BIDIRECTIONAL,
+			})
+		}
+		if rule.Var5qi != 0 || rule.MaxbrUl != "" || rule.MaxbrDl != "" {
+			qosArp := arp
+			qosData := &models.QosData{
+				QosId:   rule.PccRuleId,
+				Var5qi:  rule.Var5qi,
+				MaxbrUl: rule.MaxbrUl,
+				MaxbrDl: rule.MaxbrDl,
+				Arp:     &qosArp,
+			}
+			if qosData.Var5qi == 0 {
+				qosData.Var5qi = p.config.Var5qi
+			}
+			decision.QosDecs[qosData.QosId] = qosData
+			pccRule.RefQosData = []string{qosData.QosId}
+		}
+		decision.PccRules[pccRule.PccRuleId] = pccRule
+	}
+	return decision
+}
@@ -0,0 +1,55 @@
+package context
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestLocalPolicySmPolicyDecision(t *testing.T) {
+	arp := &models.Arp{
+		PriorityLevel: 8,
+		PreemptCap:    models.PreemptionCapability_NOT_PREEMPT,
+		PreemptVuln:   models.PreemptionVulnerability_PREEMPTABLE,
+	}
+	policy := NewLocalPolicy(&factory.LocalPolicy{
+		Var5qi:      9,
+		Arp:         arp,
+		SessionAmbr: &models.Ambr{Uplink: "100 Mbps", Downlink: "200 Mbps"},
+		PccRules: []factory.LocalPccRule{
+			{
+				PccRuleId:        "voice",
+				Precedence:       10,
+				FlowDescriptions: []string{"permit out 17 from 10.10.0.0/16 5060 to assigned"},
+				Var5qi:           5,
+				MaxbrUl:          "1 Mbps",
+				MaxbrDl:          "1 Mbps",
+			},
+			{
+				PccRuleId:        "web",
+				Precedence:       20,
+				FlowDescriptions: []string{"permit out 6 from any 443 to assigned"},
+			},
+		},
+	})
+
+	decision := policy.SmPolicyDecision()
+	require.Equal(t, &models.SessionRule{
+		SessRuleId:   LocalSessionRuleID,
+		AuthSessAmbr: &models.Ambr{Uplink: "100 Mbps", Downlink: "200 Mbps"},
+		AuthDefQos:   &models.AuthorizedDefaultQos{Var5qi: 9, Arp: arp},
+	}, decision.SessRules[LocalSessionRuleID])
+	require.Len(t, decision.PccRules, 2)
+	require.Equal(t, []string{"voice"}, decision.PccRules["voice"].RefQosData)
+	require.Equal(t, int32(5), decision.QosDecs["voice"].Var5qi)
+	require.Equal(t, "1 Mbps", decision.QosDecs["voice"].MaxbrUl)
+	require.Nil(t, decision.PccRules["web"].RefQosData)
+	require.Equal(t, models.FlowDirectionRm_BIDIRECTIONAL, decision.PccRules["web"].FlowInfos[0].FlowDirection)
+
+	// each decision is a copy the session may change
+	decision.SessRules[LocalSessionRuleID].AuthSessAmbr.Uplink = "1 Kbps"
+	require.Equal(t, "100 Mbps", policy.SmPolicyDecision().SessRules[LocalSessionRuleID].AuthSessAmbr.Uplink)
+}
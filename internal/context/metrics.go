@@ -0,0 +1,106 @@
+package context
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	smContextsDesc = prometheus.NewDesc("smf_sm_contexts",
+		"Number of SM contexts by state, S-NSSAI and DNN.",
+		[]string{"state", "snssai", "dnn"}, nil)
+	upfAssociationDesc = prometheus.NewDesc("smf_upf_association_status",
+		"PFCP association status of the UPF: 0 not associated, 1 setting up, 2 associated.",
+		[]string{"upf"}, nil)
+	ueIPPoolTotalDesc = prometheus.NewDesc("smf_ue_ip_pool_addresses",
+		"Number of addresses of the UE IP pool.",
+		[]string{"upf", "snssai", "dnn", "pool"}, nil)
+	ueIPPoolAvailableDesc = prometheus.NewDesc("smf_ue_ip_pool_available_addresses",
+		"Number of unallocated addresses of the UE IP pool.",
+		[]string{"upf", "snssai", "dnn", "pool"}, nil)
+	ueIPPoolAlarmDesc = prometheus.NewDesc("smf_ue_ip_pool_usage_alarm",
+		"Usage alarm of the UE IP pool: 0 cleared, 1 warning, 2 critical.",
+		[]string{"upf", "snssai", "dnn", "pool"}, nil)
+)
+
+// Collector reads the SM contexts, the UPF associations and the UE IP pools when scraped
+type Collector struct{}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- smContextsDesc
+	ch <- upfAssociationDesc
+	ch <- ueIPPoolTotalDesc
+	ch <- ueIPPoolAvailableDesc
+	ch <- ueIPPoolAlarmDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	type smContextKey struct {
+		state  string
+		snssai string
+		dnn    string
+	}
+	smContexts := make(map[smContextKey]int)
+	smContextPool.Range(func(key, value interface{}) bool {
+		var k smContextKey
+		k.state, k.snssai, k.dnn = value.(*SMContext).metricLabels()
+		smContexts[k]++
+		return true
+	})
+	for k, n := range smContexts {
+		ch <- prometheus.MustNewConstMetric(smContextsDesc, prometheus.GaugeValue, float64(n),
+			k.state, k.snssai, k.dnn)
+	}
+
+	upi := GetUserPlaneInformation()
+	if upi == nil {
+		return
+	}
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+	ueIPPoolsLock.RLock()
+	defer ueIPPoolsLock.RUnlock()
+	for name, upNode := range upi.UPFs {
+		upf := upNode.UPF
+		ch <- prometheus.MustNewConstMetric(upfAssociationDesc, prometheus.GaugeValue,
+			float64(upf.UPFStatus), name)
+		for _, snssaiInfo := range upf.SNssaiInfos {
+			snssai := snssaiLabel(snssaiInfo.SNssai.Sst, snssaiInfo.SNssai.Sd)
+			for _, dnnInfo := range snssaiInfo.DnnList {
+				pools := append(append([]*UeIPPool{}, dnnInfo.UeIPPools...), dnnInfo.StaticUeIPPools...)
+				for _, pool := range pools {
+					subnet := pool.ueSubNet.String()
+					ch <- prometheus.MustNewConstMetric(ueIPPoolTotalDesc, prometheus.GaugeValue,
+						float64(pool.pool.Total()), name, snssai, dnnInfo.Dnn, subnet)
+					ch <- prometheus.MustNewConstMetric(ueIPPoolAvailableDesc, prometheus.GaugeValue,
+						float64(pool.pool.Remain()), name, snssai, dnnInfo.Dnn, subnet)
+					ch <- prometheus.MustNewConstMetric(ueIPPoolAlarmDesc, prometheus.GaugeValue,
+						float64(pool.AlarmLevel()), name, snssai, dnnInfo.Dnn, subnet)
+				}
+			}
+		}
+	}
+}
+
+// metricLabels reads the state, S-NSSAI and DNN of the SM context under its lock, as the
+// procedures change them
+func (smContext *SMContext) metricLabels() (state, snssai, dnn string) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	if smContext.Snssai != nil {
+		snssai = snssaiLabel(smContext.Snssai.Sst, smContext.Snssai.Sd)
+	}
+	return smContext.SMContextState.String(), snssai, smContext.Dnn
+}
+
+func snssaiLabel(sst int32, sd string) string {
+	if sd == "" {
+		return fmt.Sprintf("%d", sst)
+	}
+	return fmt.Sprintf("%d-%s", sst, sd)
+}
@@ -0,0 +1,66 @@
+package context
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+)
+
+func TestCollectorSMContexts(t *testing.T) {
+	smContext := NewSMContext("imsi-208930000000071", 1)
+	defer RemoveSMContext(smContext.Ref)
+	smContext.Dnn = "collector"
+	smContext.Snssai = &models.Snssai{Sst: 1, Sd: "0a0b0c"}
+	smContext.SMContextState = Active
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(NewCollector())
+
+	// a scrape waits for the procedure changing the SM context
+	smContext.SMLock.Lock()
+	gathered := make(chan []map[string]string)
+	go func() {
+		gathered <- gatherSMContexts(registry, "collector")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, gathered)
+	smContext.SMContextState = ModificationPending
+	smContext.SMLock.Unlock()
+
+	select {
+	case labels := <-gathered:
+		require.Equal(t, []map[string]string{
+			{"state": ModificationPending.String(), "snssai": "1-0a0b0c", "dnn": "collector", "value": "1"},
+		}, labels)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "metrics not gathered")
+	}
+}
+
+// gatherSMContexts returns the labels and the value of the smf_sm_contexts metrics of the DNN
+func gatherSMContexts(registry *prometheus.Registry, dnn string) []map[string]string {
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		return nil
+	}
+	var gathered []map[string]string
+	for _, metricFamily := range metricFamilies {
+		if metricFamily.GetName() != "smf_sm_contexts" {
+			continue
+		}
+		for _, metric := range metricFamily.GetMetric() {
+			labels := map[string]string{"value": fmt.Sprint(metric.GetGauge().GetValue())}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["dnn"] == dnn {
+				gathered = append(gathered, labels)
+			}
+		}
+	}
+	return gathered
+}
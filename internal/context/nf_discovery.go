@@ -0,0 +1,242 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/free5gc/openapi/Nnrf_NFDiscovery"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
+)
+
+// NFFailureBackoff is how long an NF instance which did not answer is tried after the others
+const NFFailureBackoff = 30 * time.Second
+
+// ErrNFUnavailable is returned for the requests to an NF instance whose circuit breaker is open
+var ErrNFUnavailable = errors.New("NF instance unavailable")
+
+type nfDiscoveryEntry struct {
+	instances []models.NfProfile
+	expiry    time.Time
+}
+
+// nfFailure is the circuit breaker of an NF instance which did not answer: after
+// SBIBreakerFailures consecutive failures, its requests fail at once until openUntil
+type nfFailure struct {
+	failedAt  time.Time
+	failures  int
+	openUntil time.Time
+}
+
+// nfDiscovery caches the results of the NF discoveries for their validity period, by the
+// target NF type and the query parameters, and keeps the NF instances which did not answer
+var nfDiscovery = struct {
+	sync.Mutex
+	cache  map[string]nfDiscoveryEntry
+	failed map[string]*nfFailure
+}{
+	cache:  make(map[string]nfDiscoveryEntry),
+	failed: make(map[string]*nfFailure),
+}
+
+// DiscoverNFInstances returns the NF instances of the target type matching the query, in the
+// order they should be tried. The result of the NRF is used again during its validity period.
+func DiscoverNFInstances(targetNfType models.NfType,
+	localVarOptionals *Nnrf_NFDiscovery.SearchNFInstancesParamOpts,
+) ([]models.NfProfile, error) {
+	key := fmt.Sprintf("%s%+v", targetNfType, *localVarOptionals)
+
+	nfDiscovery.Lock()
+	entry, ok := nfDiscovery.cache[key]
+	nfDiscovery.Unlock()
+	if !ok || time.Now().After(entry.expiry) {
+		ctx, cancel, err := SMF_Self().GetSBICtx(models.ServiceName_NNRF_DISC, models.NfType_NRF)
+		if err != nil {
+			return nil, err
+		}
+		defer cancel()
+		start := time.Now()
+		result, res, err := SMF_Self().
+			NFDiscoveryClient.
+			NFInstancesStoreApi.
+			SearchNFInstances(ctx, targetNfType, models.NfType_SMF, localVarOptionals)
+		metrics.ObserveSBIClient(metrics.ServiceNnrfDisc, start, res)
+		if res != nil {
+			defer func() {
+				if resCloseErr := res.Body.Close(); resCloseErr != nil {
+					logger.CtxLog.Errorf("SearchNFInstances response body cannot close: %+v", resCloseErr)
+				}
+			}()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry = nfDiscoveryEntry{
+			instances: result.NfInstances,
+			expiry:    time.Now().Add(time.Duration(result.ValidityPeriod) * time.Second),
+		}
+		if result.ValidityPeriod > 0 {
+			nfDiscovery.Lock()
+			nfDiscovery.cache[key] = entry
+			nfDiscovery.Unlock()
+		}
+	}
+
+	return orderNFInstances(entry.instances, SMF_Self().Locality), nil
+}
+
+// NFInstanceFailed makes the NF instance tried after the others for the NFFailureBackoff, and
+// opens its circuit breaker after SBIBreakerFailures consecutive failures
+func NFInstanceFailed(nfInstanceID string) {
+	nfDiscovery.Lock()
+	defer nfDiscovery.Unlock()
+	failure, ok := nfDiscovery.failed[nfInstanceID]
+	if !ok {
+		failure = &nfFailure{}
+		nfDiscovery.failed[nfInstanceID] = failure
+	}
+	failure.failedAt = time.Now()
+	failure.failures++
+	if failure.failures >= SMF_Self().sbiBreakerFailures() {
+		if !failure.openUntil.After(failure.failedAt) {
+			logger.ConsumerLog.Warnf("NF[%s] failed %d times, circuit breaker open for %s",
+				nfInstanceID, failure.failures, SMF_Self().sbiBreakerOpenTime())
+		}
+		failure.openUntil = failure.failedAt.Add(SMF_Self().sbiBreakerOpenTime())
+	}
+}
+
+// NFInstanceAnswered closes the circuit breaker of the NF instance
+func NFInstanceAnswered(nfInstanceID string) {
+	nfDiscovery.Lock()
+	defer nfDiscovery.Unlock()
+	if failure, ok := nfDiscovery.failed[nfInstanceID]; ok {
+		if failure.failures >= SMF_Self().sbiBreakerFailures() {
+			logger.ConsumerLog.Infof("NF[%s] answered, circuit breaker closed", nfInstanceID)
+		}
+		delete(nfDiscovery.failed, nfInstanceID)
+	}
+}
+
+// CheckNFInstance returns ErrNFUnavailable while the circuit breaker of the NF instance is
+// open. Once its open time is over, a single request tries the NF instance again.
+func CheckNFInstance(nfInstanceID string) error {
+	nfDiscovery.Lock()
+	defer nfDiscovery.Unlock()
+	failure, ok := nfDiscovery.failed[nfInstanceID]
+	if !ok || failure.failures < SMF_Self().sbiBreakerFailures() {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(failure.openUntil) {
+		return fmt.Errorf("NF[%s]: %w", nfInstanceID, ErrNFUnavailable)
+	}
+	failure.openUntil = now.Add(SMF_Self().sbiBreakerOpenTime())
+	return nil
+}
+
+// RemoveNFInstanceFromDiscovery removes the deregistered NF instance from the cached discoveries
+func RemoveNFInstanceFromDiscovery(nfInstanceID string) {
+	nfDiscovery.Lock()
+	defer nfDiscovery.Unlock()
+	for key, entry := range nfDiscovery.cache {
+		instances := make([]models.NfProfile, 0, len(entry.instances))
+		for _, instance := range entry.instances {
+			if instance.NfInstanceId != nfInstanceID {
+				instances = append(instances, instance)
+			}
+		}
+		entry.instances = instances
+		nfDiscovery.cache[key] = entry
+	}
+	delete(nfDiscovery.failed, nfInstanceID)
+}
+
+// orderNFInstances orders the NF instances by recent failure, locality and priority, TS 29.510
+// 6.1.6.2.2. The instances of the same rank are shuffled in proportion to their capacity.
+func orderNFInstances(instances []models.NfProfile, locality string) []models.NfProfile {
+	nfDiscovery.Lock()
+	failed := make(map[string]bool)
+	for _, instance := range instances {
+		if failure, ok := nfDiscovery.failed[instance.NfInstanceId]; ok {
+			if time.Since(failure.failedAt) < NFFailureBackoff || time.Now().Before(failure.openUntil) {
+				failed[instance.NfInstanceId] = true
+			} else if failure.failures < SMF_Self().sbiBreakerFailures() {
+				delete(nfDiscovery.failed, instance.NfInstanceId)
+			}
+		}
+	}
+	nfDiscovery.Unlock()
+
+	rank := func(instance models.NfProfile) [3]int32 {
+		var r [3]int32
+		if failed[instance.NfInstanceId] {
+			r[0] = 1
+		}
+		if locality != "" && instance.Locality != locality {
+			r[1] = 1
+		}
+		r[2] = instance.Priority
+		return r
+	}
+	less := func(a, b [3]int32) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return a[i] < b[i]
+			}
+		}
+		return false
+	}
+
+	ordered := make([]models.NfProfile, 0, len(instances))
+	remaining := append([]models.NfProfile{}, instances...)
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return less(rank(remaining[i]), rank(remaining[j]))
+	})
+	for len(remaining) > 0 {
+		// the instances of the best rank
+		n := 1
+		for n < len(remaining) && rank(remaining[n]) == rank(remaining[0]) {
+			n++
+		}
+		group := remaining[:n]
+		for len(group) > 0 {
+			i := pickByCapacity(group)
+			ordered = append(ordered, group[i])
+			group = append(group[:i], group[i+1:]...)
+		}
+		remaining = remaining[n:]
+	}
+	return ordered
+}
+
+// pickByCapacity picks an instance with a probability in proportion to its capacity
+func pickByCapacity(instances []models.NfProfile) int {
+	var total int64
+	for _, instance := range instances {
+		total += capacityOf(instance)
+	}
+	pick := rand.Int63n(total)
+	for i, instance := range instances {
+		if pick < capacityOf(instance) {
+			return i
+		}
+		pick -= capacityOf(instance)
+	}
+	return len(instances) - 1
+}
+
+// capacityOf returns the capacity of the instance, the instances without one weigh as little
+// as possible
+func capacityOf(instance models.NfProfile) int64 {
+	if instance.Capacity <= 0 {
+		return 1
+	}
+	return int64(instance.Capacity)
+}
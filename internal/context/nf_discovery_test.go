@@ -0,0 +1,83 @@
+package context
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+)
+
+func TestOrderNFInstances(t *testing.T) {
+	instances := []models.NfProfile{
+		{NfInstanceId: "pcf-1", Priority: 2, Locality: "area1"},
+		{NfInstanceId: "pcf-2", Priority: 1, Locality: "area2"},
+		{NfInstanceId: "pcf-3", Priority: 1, Locality: "area1"},
+		{NfInstanceId: "pcf-4", Priority: 0, Locality: "area1"},
+	}
+	ids := func(instances []models.NfProfile) []string {
+		ids := make([]string, 0, len(instances))
+		for _, instance := range instances {
+			ids = append(ids, instance.NfInstanceId)
+		}
+		return ids
+	}
+
+	// priority only, the instances of the same priority in any order
+	ordered := ids(orderNFInstances(instances, ""))
+	require.Equal(t, "pcf-4", ordered[0])
+	require.ElementsMatch(t, []string{"pcf-2", "pcf-3"}, ordered[1:3])
+	require.Equal(t, "pcf-1", ordered[3])
+
+	// the preferred locality first
+	require.Equal(t, []string{"pcf-4", "pcf-3", "pcf-1", "pcf-2"}, ids(orderNFInstances(instances, "area1")))
+
+	// the instances which did not answer last
+	NFInstanceFailed("pcf-4")
+	require.Equal(t, []string{"pcf-3", "pcf-1", "pcf-2", "pcf-4"}, ids(orderNFInstances(instances, "area1")))
+	nfDiscovery.Lock()
+	nfDiscovery.failed["pcf-4"].failedAt = time.Now().Add(-NFFailureBackoff)
+	nfDiscovery.Unlock()
+	require.Equal(t, "pcf-4", ids(orderNFInstances(instances, "area1"))[0])
+
+	// a deregistered instance is removed from the cached discoveries
+	nfDiscovery.Lock()
+	nfDiscovery.cache["PCF"] = nfDiscoveryEntry{instances: instances, expiry: time.Now().Add(time.Minute)}
+	nfDiscovery.Unlock()
+	RemoveNFInstanceFromDiscovery("pcf-3")
+	require.Equal(t, []string{"pcf-1", "pcf-2", "pcf-4"}, ids(nfDiscovery.cache["PCF"].instances))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	defer RemoveNFInstanceFromDiscovery("udm-1")
+
+	for i := 1; i < DefaultSBIBreakerFailures; i++ {
+		NFInstanceFailed("udm-1")
+		require.NoError(t, CheckNFInstance("udm-1"))
+	}
+	NFInstanceFailed("udm-1")
+	require.ErrorIs(t, CheckNFInstance("udm-1"), ErrNFUnavailable)
+
+	// a single request tries the instance again once the breaker open time is over
+	nfDiscovery.Lock()
+	nfDiscovery.failed["udm-1"].openUntil = time.Now()
+	nfDiscovery.Unlock()
+	require.NoError(t, CheckNFInstance("udm-1"))
+	require.ErrorIs(t, CheckNFInstance("udm-1"), ErrNFUnavailable)
+
+	NFInstanceAnswered("udm-1")
+	require.NoError(t, CheckNFInstance("udm-1"))
+}
+
+func TestPickByCapacity(t *testing.T) {
+	instances := []models.NfProfile{
+		{NfInstanceId: "udm-1", Capacity: 100},
+		{NfInstanceId: "udm-2"},
+	}
+	picks := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		picks[pickByCapacity(instances)]++
+	}
+	require.Greater(t, picks[0], picks[1])
+}
@@ -2,6 +2,7 @@ package context
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/free5gc/openapi/models"
@@ -74,6 +75,9 @@ func SNssaiSmfInfo() *[]models.SnssaiSmfInfoItem {
 			})
 		}
 
+		sort.Slice(dnnModelList, func(i, j int) bool {
+			return dnnModelList[i].Dnn < dnnModelList[j].Dnn
+		})
 		snssaiInfoModel.DnnSmfInfoList = &dnnModelList
 
 		snssaiInfo = append(snssaiInfo, snssaiInfoModel)
@@ -81,3 +85,24 @@ func SNssaiSmfInfo() *[]models.SnssaiSmfInfoItem {
 
 	return &snssaiInfo
 }
+
+// NFLoad returns the load of the SMF advertised to the NRF, the usage in percent of the addresses
+// of its dynamic UE IP pools
+func NFLoad() int32 {
+	upi := GetUserPlaneInformation()
+	if upi == nil {
+		return 0
+	}
+	total, used := 0, 0
+	for _, entry := range upi.UEIPPools() {
+		if entry.Static {
+			continue
+		}
+		total += entry.Pool.Total()
+		used += entry.Pool.Total() - entry.Pool.Free()
+	}
+	if total == 0 {
+		return 0
+	}
+	return int32(used * 100 / total)
+}
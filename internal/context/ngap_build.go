@@ -120,6 +120,16 @@ func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error)
 			},
 		},
 	}
+	// the QoS flows of the PCC rules enforced on the UPFs
+	for _, rule := range ctx.enforcedPCCRules().Installed {
+		if rule.QosData != nil {
+			ie.Value.QosFlowSetupRequestList.List = append(ie.Value.QosFlowSetupRequestList.List,
+				ngapType.QosFlowSetupRequestItem{
+					QosFlowIdentifier:         ngapType.QosFlowIdentifier{Value: int64(rule.QFI)},
+					QosFlowLevelQosParameters: *qosFlowLevelQosParameters(rule.QosData),
+				})
+		}
+	}
 	resourceSetupRequestTransfer.ProtocolIEs.List = append(resourceSetupRequestTransfer.ProtocolIEs.List, ie)
 
 	// Security Indication to NG-RAN (optional) TS 38.413 9.3.1.27
@@ -315,6 +325,113 @@ func BuildPDUSessionResourceReleaseCommandTransfer(ctx *SMContext) (buf []byte,
 	return
 }
 
+// BuildPDUSessionResourceModifyRequestTransfer carries the PDU Session AMBR authorized to the
+// session and the QoS flows of the changed PCC rules, TS 38.413 9.3.4.3
+func BuildPDUSessionResourceModifyRequestTransfer(ctx *SMContext, changes *PCCRuleChanges) ([]byte, error) {
+	resourceModifyRequestTransfer := ngapType.PDUSessionResourceModifyRequestTransfer{}
+
+	ie := ngapType.PDUSessionResourceModifyRequestTransferIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDPDUSessionAggregateMaximumBitRate
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	sessRule := ctx.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthSessAmbr == nil {
+		return nil, fmt.Errorf("No PDU Session AMBR")
+	}
+	ie.Value = ngapType.PDUSessionResourceModifyRequestTransferIEsValue{
+		Present: ngapType.PDUSessionResourceModifyRequestTransferIEsPresentPDUSessionAggregateMaximumBitRate,
+		PDUSessionAggregateMaximumBitRate: &ngapType.PDUSessionAggregateMaximumBitRate{
+			PDUSessionAggregateMaximumBitRateDL: ngapType.BitRate{
+				Value: ngapConvert.UEAmbrToInt64(sessRule.AuthSessAmbr.Downlink),
+			},
+			PDUSessionAggregateMaximumBitRateUL: ngapType.BitRate{
+				Value: ngapConvert.UEAmbrToInt64(sessRule.AuthSessAmbr.Uplink),
+			},
+		},
+	}
+	resourceModifyRequestTransfer.ProtocolIEs.List = append(resourceModifyRequestTransfer.ProtocolIEs.List, ie)
+
+	addOrModifyList := new(ngapType.QosFlowAddOrModifyRequestList)
+	releaseList := new(ngapType.QosFlowListWithCause)
+	if changes != nil {
+		for _, rule := range changes.Installed {
+			if rule.QosData != nil {
+				addOrModifyList.List = append(addOrModifyList.List, ngapType.QosFlowAddOrModifyRequestItem{
+					QosFlowIdentifier:         ngapType.QosFlowIdentifier{Value: int64(rule.QFI)},
+					QosFlowLevelQosParameters: qosFlowLevelQosParameters(rule.QosData),
+				})
+			}
+		}
+		for _, rule := range changes.Removed {
+			if rule.QosData != nil {
+				releaseList.List = append(releaseList.List, ngapType.QosFlowWithCauseItem{
+					QosFlowIdentifier: ngapType.QosFlowIdentifier{Value: int64(rule.QFI)},
+					Cause: ngapType.Cause{
+						Present: ngapType.CausePresentNas,
+						Nas:     &ngapType.CauseNas{Value: ngapType.CauseNasPresentNormalRelease},
+					},
+				})
+			}
+		}
+	}
+	if len(addOrModifyList.List) > 0 {
+		ie = ngapType.PDUSessionResourceModifyRequestTransferIEs{}
+		ie.Id.Value = ngapType.ProtocolIEIDQosFlowAddOrModifyRequestList
+		ie.Criticality.Value = ngapType.CriticalityPresentReject
+		ie.Value.Present = ngapType.PDUSessionResourceModifyRequestTransferIEsPresentQosFlowAddOrModifyRequestList
+		ie.Value.QosFlowAddOrModifyRequestList = addOrModifyList
+		resourceModifyRequestTransfer.ProtocolIEs.List = append(resourceModifyRequestTransfer.ProtocolIEs.List, ie)
+	}
+	if len(releaseList.List) > 0 {
+		ie = ngapType.PDUSessionResourceModifyRequestTransferIEs{}
+		ie.Id.Value = ngapType.ProtocolIEIDQosFlowToReleaseList
+		ie.Criticality.Value = ngapType.CriticalityPresentReject
+		ie.Value = ngapType.PDUSessionResourceModifyRequestTransferIEsValue{
+			Present:              ngapType.PDUSessionResourceModifyRequestTransferIEsPresentQosFlowToReleaseList,
+			QosFlowToReleaseList: releaseList,
+		}
+		resourceModifyRequestTransfer.ProtocolIEs.List = append(resourceModifyRequestTransfer.ProtocolIEs.List, ie)
+	}
+
+	if buf, err := aper.MarshalWithParams(resourceModifyRequestTransfer, "valueExt"); err != nil {
+		return nil, fmt.Errorf("encode resourceModifyRequestTransfer failed: %s", err)
+	} else {
+		return buf, nil
+	}
+}
+
+// qosFlowLevelQosParameters returns the QoS parameters of the QoS flow of the QoS data, of a
+// standardized non-GBR 5QI
+func qosFlowLevelQosParameters(qosData *models.QosData) *ngapType.QosFlowLevelQosParameters {
+	parameters := &ngapType.QosFlowLevelQosParameters{
+		QosCharacteristics: ngapType.QosCharacteristics{
+			Present: ngapType.QosCharacteristicsPresentNonDynamic5QI,
+			NonDynamic5QI: &ngapType.NonDynamic5QIDescriptor{
+				FiveQI: ngapType.FiveQI{Value: int64(qosData.Var5qi)},
+			},
+		},
+		AllocationAndRetentionPriority: ngapType.AllocationAndRetentionPriority{
+			PriorityLevelARP: ngapType.PriorityLevelARP{Value: 15},
+			PreEmptionCapability: ngapType.PreEmptionCapability{
+				Value: ngapType.PreEmptionCapabilityPresentShallNotTriggerPreEmption,
+			},
+			PreEmptionVulnerability: ngapType.PreEmptionVulnerability{
+				Value: ngapType.PreEmptionVulnerabilityPresentNotPreEmptable,
+			},
+		},
+	}
+	if arp := qosData.Arp; arp != nil {
+		arpParameters := &parameters.AllocationAndRetentionPriority
+		arpParameters.PriorityLevelARP.Value = int64(arp.PriorityLevel)
+		if arp.PreemptCap == models.PreemptionCapability_MAY_PREEMPT {
+			arpParameters.PreEmptionCapability.Value = ngapType.PreEmptionCapabilityPresentMayTriggerPreEmption
+		}
+		if arp.PreemptVuln == models.PreemptionVulnerability_PREEMPTABLE {
+			arpParameters.PreEmptionVulnerability.Value = ngapType.PreEmptionVulnerabilityPresentPreEmptable
+		}
+	}
+	return parameters
+}
+
 func BuildHandoverCommandTransfer(ctx *SMContext) ([]byte, error) {
 	ANUPF := ctx.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode
 	UpNode := ANUPF.UPF
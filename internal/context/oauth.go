@@ -0,0 +1,179 @@
+package context
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antihax/optional"
+	"github.com/golang-jwt/jwt"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/Nnrf_AccessToken"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/util"
+)
+
+// accessTokenRenewMargin is how long before its expiry an access token is requested again
+const accessTokenRenewMargin = 10 * time.Second
+
+// ErrInvalidAccessToken is returned when the access token of a request is missing or not valid
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+type accessToken struct {
+	token  string
+	expiry time.Time
+}
+
+// accessTokens caches the access tokens granted by the NRF, by scope and target NF type
+var accessTokens = struct {
+	sync.Mutex
+	tokens map[string]accessToken
+}{
+	tokens: make(map[string]accessToken),
+}
+
+// loadNrfPublicKey reads the public key of the NRF, which signs the access tokens, from its
+// certificate or public key in PEM
+func (c *SMFContext) loadNrfPublicKey(pemPath string) error {
+	content, err := os.ReadFile(pemPath)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return fmt.Errorf("no PEM data in %s", pemPath)
+	}
+
+	var publicKey interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		publicKey = cert.PublicKey
+	default:
+		if publicKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return err
+		}
+	}
+
+	rsaPublicKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("the NRF key in %s is not an RSA key", pemPath)
+	}
+	c.NrfPublicKey = rsaPublicKey
+	return nil
+}
+
+// GetTokenCtx returns the context of a request to the service of the target NF type, carrying
+// the access token granted by the NRF when OAuth2 is required, TS 29.510 5.4.2.2
+func (c *SMFContext) GetTokenCtx(serviceName models.ServiceName, targetNF models.NfType) (context.Context, error) {
+	if !c.OAuth2Required {
+		return context.Background(), nil
+	}
+
+	key := string(serviceName) + "/" + string(targetNF)
+	accessTokens.Lock()
+	cached, ok := accessTokens.tokens[key]
+	accessTokens.Unlock()
+	if ok && time.Now().Before(cached.expiry) {
+		return context.WithValue(context.Background(), openapi.ContextAccessToken, cached.token), nil
+	}
+
+	tokenCtx, cancel := context.WithTimeout(context.Background(), c.SBITimeoutOf(models.NfType_NRF))
+	defer cancel()
+	rsp, res, err := c.AccessTokenClient.AccessTokenRequestApi.AccessTokenRequest(tokenCtx,
+		"client_credentials", c.NfInstanceID, string(serviceName), &Nnrf_AccessToken.AccessTokenRequestParamOpts{
+			NfType:       optional.NewInterface(models.NfType_SMF),
+			TargetNfType: optional.NewInterface(targetNF),
+		})
+	if res != nil {
+		defer func() {
+			if resCloseErr := res.Body.Close(); resCloseErr != nil {
+				logger.CtxLog.Errorf("AccessTokenRequest response body cannot close: %+v", resCloseErr)
+			}
+		}()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("access token request for %s failed: %w", serviceName, err)
+	}
+	if rsp.AccessToken == "" {
+		return nil, fmt.Errorf("no access token granted for %s", serviceName)
+	}
+
+	expiry := time.Now().Add(time.Duration(rsp.ExpiresIn)*time.Second - accessTokenRenewMargin)
+	accessTokens.Lock()
+	accessTokens.tokens[key] = accessToken{token: rsp.AccessToken, expiry: expiry}
+	accessTokens.Unlock()
+	logger.CtxLog.Debugf("Access token granted for %s, expires in %d s", serviceName, rsp.ExpiresIn)
+
+	return context.WithValue(context.Background(), openapi.ContextAccessToken, rsp.AccessToken), nil
+}
+
+// AuthorizationCheck verifies the access token of a request to the service of the SMF: it must
+// be signed by the NRF, not expired, for the SMF, and grant the service unless serviceName is
+// empty, TS 33.501 13.4.1.2
+func (c *SMFContext) AuthorizationCheck(token string, serviceName models.ServiceName) error {
+	if !c.OAuth2Required {
+		return nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return c.NrfPublicKey, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAccessToken, err)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return fmt.Errorf("%w: no expiry", ErrInvalidAccessToken)
+	}
+	if !c.tokenAudienceAllowed(claims["aud"]) {
+		return fmt.Errorf("%w: audience %v", ErrInvalidAccessToken, claims["aud"])
+	}
+
+	// the notifications to the callbacks of the SMF are not requests to a service of the SMF, so
+	// their access tokens grant no service of it
+	if serviceName == "" {
+		return nil
+	}
+	scope, _ := claims["scope"].(string)
+	for _, granted := range strings.Fields(scope) {
+		if granted == string(serviceName) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s not in %q", util.ErrInsufficientScope, serviceName, scope)
+}
+
+// tokenAudienceAllowed reports whether the audience of an access token, the NF instance ID or
+// the NF type of the producer, is the SMF
+func (c *SMFContext) tokenAudienceAllowed(aud interface{}) bool {
+	allowed := func(audience interface{}) bool {
+		return audience == c.NfInstanceID || audience == string(models.NfType_SMF)
+	}
+	switch aud := aud.(type) {
+	case string:
+		return allowed(aud)
+	case []interface{}:
+		for _, audience := range aud {
+			if allowed(audience) {
+				return true
+			}
+		}
+	}
+	return false
+}
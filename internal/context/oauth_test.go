@@ -0,0 +1,109 @@
+package context
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/util"
+)
+
+func TestAuthorizationCheck(t *testing.T) {
+	nrfKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nrf"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &nrfKey.PublicKey, nrfKey)
+	require.NoError(t, err)
+	certPath := filepath.Join(t.TempDir(), "nrf.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	smfContext := &SMFContext{NfInstanceID: "smf-1", OAuth2Required: true}
+	require.NoError(t, smfContext.loadNrfPublicKey(certPath))
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	sign := func(key *rsa.PrivateKey, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+
+	testCases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{
+			name:  "valid",
+			token: sign(nrfKey, jwt.MapClaims{"aud": "smf-1", "exp": exp, "scope": "nsmf-pdusession nsmf-oam"}),
+		},
+		{
+			name:  "audience is the NF type",
+			token: sign(nrfKey, jwt.MapClaims{"aud": []string{"SMF"}, "exp": exp, "scope": "nsmf-pdusession"}),
+		},
+		{
+			name:  "wrong scope",
+			token: sign(nrfKey, jwt.MapClaims{"aud": "smf-1", "exp": exp, "scope": "nsmf-event-exposure"}),
+			err:   util.ErrInsufficientScope,
+		},
+		{
+			name:  "expired",
+			token: sign(nrfKey, jwt.MapClaims{"aud": "smf-1", "exp": exp - 120, "scope": "nsmf-pdusession"}),
+			err:   ErrInvalidAccessToken,
+		},
+		{
+			name:  "no expiry",
+			token: sign(nrfKey, jwt.MapClaims{"aud": "smf-1", "scope": "nsmf-pdusession"}),
+			err:   ErrInvalidAccessToken,
+		},
+		{
+			name:  "other audience",
+			token: sign(nrfKey, jwt.MapClaims{"aud": "smf-2", "exp": exp, "scope": "nsmf-pdusession"}),
+			err:   ErrInvalidAccessToken,
+		},
+		{
+			name:  "not signed by the NRF",
+			token: sign(otherKey, jwt.MapClaims{"aud": "smf-1", "exp": exp, "scope": "nsmf-pdusession"}),
+			err:   ErrInvalidAccessToken,
+		},
+		{
+			name:  "no token",
+			token: "",
+			err:   ErrInvalidAccessToken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := smfContext.AuthorizationCheck(tc.token, models.ServiceName_NSMF_PDUSESSION)
+			if tc.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.True(t, errors.Is(err, tc.err), "%v", err)
+			}
+		})
+	}
+
+	// the notifications to the callbacks need a valid access token for the SMF, with no scope
+	require.NoError(t, smfContext.AuthorizationCheck(sign(nrfKey, jwt.MapClaims{"aud": "smf-1", "exp": exp}), ""))
+	err = smfContext.AuthorizationCheck(sign(otherKey, jwt.MapClaims{"aud": "smf-1", "exp": exp}), "")
+	require.True(t, errors.Is(err, ErrInvalidAccessToken), "%v", err)
+}
@@ -0,0 +1,59 @@
+package context
+
+import (
+	"net"
+	"time"
+)
+
+// PathFailed records a failure of the user plane path between the UPF and the remote GTP-U
+// peer, reported by the UPF in a Node Report, TS 29.244 5.9. release is called once the path
+// has not recovered within the PathFailureReleaseDelay.
+func (upf *UPF) PathFailed(peer net.IP, release func(peer net.IP)) {
+	delay := SMF_Self().PathFailureReleaseDelay
+	if delay == 0 {
+		go release(peer)
+		return
+	}
+
+	upf.pathFailureLock.Lock()
+	defer upf.pathFailureLock.Unlock()
+
+	if upf.pathFailureTimers == nil {
+		upf.pathFailureTimers = make(map[string]*time.Timer)
+	}
+	key := peer.String()
+	if _, exist := upf.pathFailureTimers[key]; exist {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		upf.pathFailureLock.Lock()
+		if upf.pathFailureTimers[key] != timer {
+			// the path has recovered in the meantime
+			upf.pathFailureLock.Unlock()
+			return
+		}
+		delete(upf.pathFailureTimers, key)
+		upf.pathFailureLock.Unlock()
+
+		release(peer)
+	})
+	upf.pathFailureTimers[key] = timer
+}
+
+// PathRecovered cancels the release pending for the failed user plane path between the UPF
+// and the remote GTP-U peer, and reports whether there was one
+func (upf *UPF) PathRecovered(peer net.IP) bool {
+	upf.pathFailureLock.Lock()
+	defer upf.pathFailureLock.Unlock()
+
+	key := peer.String()
+	timer, exist := upf.pathFailureTimers[key]
+	if !exist {
+		return false
+	}
+	timer.Stop()
+	delete(upf.pathFailureTimers, key)
+	return true
+}
@@ -1,7 +1,15 @@
 package context
 
 import (
+	"fmt"
+	"net"
+	"sort"
+
 	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/util"
+	"github.com/free5gc/util/flowdesc"
 )
 
 // PCCRule - Policy and Charging Rule
@@ -19,6 +27,33 @@ type PCCRule struct {
 
 	// related Data
 	Datapath *DataPath
+
+	// QoS of the traffic of the rule, the default QoS flow of the session if nil
+	QosData *models.QosData
+	// QoS flow and QoS rule of the rule signalled to the UE, allocated while the rule is enforced
+	QFI       uint8
+	QoSRuleID uint8
+	// rules enforcing the rule on the UPFs of the default path
+	UPFs []*PCCRuleUPF
+}
+
+// PCCRuleUPF are the PDRs detecting the traffic of a PCC rule on a UPF, and the QER of its QoS
+type PCCRuleUPF struct {
+	UPF  *UPF
+	PDRs []*PDR
+	// nil if the traffic of the rule has the default QoS of the session
+	QER *QER
+}
+
+// PCCRuleChanges are the PCC rules enforced and withdrawn by UpdatePCCRules
+type PCCRuleChanges struct {
+	Installed []*PCCRule
+	Removed   []*PCCRule
+}
+
+// Empty reports whether no PCC rule changed
+func (c *PCCRuleChanges) Empty() bool {
+	return c == nil || len(c.Installed) == 0 && len(c.Removed) == 0
 }
 
 // NewPCCRuleFromModel - create PCC rule from OpenAPI models
@@ -49,3 +84,433 @@ func (r *PCCRule) SetRefTrafficControlData(tcID string) {
 func (r *PCCRule) RefTrafficControlData() string {
 	return r.refTrafficControlData
 }
+
+// Enforced reports whether the PDRs of the rule are installed on the UPFs
+func (r *PCCRule) Enforced() bool {
+	return r.QoSRuleID != 0
+}
+
+// InstallPCCRule replaces the PCC rule of the same ID, the rule being enforced on the next
+// UpdatePCCRules
+func (smContext *SMContext) InstallPCCRule(rule *PCCRule) {
+	smContext.RemovePCCRule(rule.PCCRuleID)
+	smContext.PCCRules[rule.PCCRuleID] = rule
+}
+
+// RemovePCCRule removes the PCC rule of the ID, the rule being withdrawn on the next UpdatePCCRules
+func (smContext *SMContext) RemovePCCRule(id string) {
+	if rule, exist := smContext.PCCRules[id]; exist {
+		delete(smContext.PCCRules, id)
+		if rule.Enforced() {
+			smContext.pccRulesToRemove = append(smContext.pccRulesToRemove, rule)
+		}
+	}
+}
+
+// UpdatePCCRules installs the PDRs and QERs of the PCC rules not enforced yet on the UPFs of the
+// activated default path of the session, and marks the rules of the removed PCC rules to be
+// removed. The rules are sent to the UPFs and the changes are signalled to the UE by the caller.
+func (smContext *SMContext) UpdatePCCRules() *PCCRuleChanges {
+	changes := new(PCCRuleChanges)
+	if smContext.Tunnel == nil {
+		return changes
+	}
+	defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	if defaultPath == nil || !defaultPath.Activated {
+		return changes
+	}
+
+	// the removed rules keep their QoS flows and QoS rules until the UE is told, so the installed
+	// rules are given other ones
+	for _, rule := range smContext.sortedPCCRules() {
+		if rule.Enforced() {
+			continue
+		}
+		if err := smContext.activatePCCRule(defaultPath, rule); err != nil {
+			logger.PduSessLog.Errorf("UE[%s] PDUSessionID[%d] enforce PccRule[%s] failed: %v",
+				smContext.Supi, smContext.PDUSessionID, rule.PCCRuleID, err)
+			smContext.deactivatePCCRule(rule)
+			rule.QFI, rule.QoSRuleID, rule.UPFs = 0, 0, nil
+			continue
+		}
+		changes.Installed = append(changes.Installed, rule)
+	}
+	for _, rule := range smContext.pccRulesToRemove {
+		smContext.deactivatePCCRule(rule)
+		changes.Removed = append(changes.Removed, rule)
+	}
+	smContext.pccRulesToRemove = nil
+	return changes
+}
+
+// DeactivatePCCRules frees the rules of the PCC rules of the session on its UPFs, whose PFCP
+// sessions are deleted
+func (smContext *SMContext) DeactivatePCCRules() {
+	for _, rule := range smContext.pccRulesToRemove {
+		smContext.deactivatePCCRule(rule)
+	}
+	smContext.pccRulesToRemove = nil
+	for _, rule := range smContext.PCCRules {
+		smContext.deactivatePCCRule(rule)
+		rule.QFI, rule.QoSRuleID, rule.UPFs = 0, 0, nil
+	}
+}
+
+func (smContext *SMContext) activatePCCRule(defaultPath *DataPath, rule *PCCRule) error {
+	if len(rule.FlowInfos) == 0 {
+		return fmt.Errorf("no flow information")
+	}
+	if err := smContext.allocatePCCRuleIDs(rule); err != nil {
+		return err
+	}
+
+	for node := defaultPath.FirstDPNode; node != nil; node = node.Next() {
+		ruleUPF := &PCCRuleUPF{UPF: node.UPF}
+		rule.UPFs = append(rule.UPFs, ruleUPF)
+
+		if rule.QosData != nil {
+			qer, err := node.UPF.AddQER()
+			if err != nil {
+				return err
+			}
+			qer.QFI.QFI = rule.QFI
+			qer.GateStatus = &pfcpType.GateStatus{
+				ULGate: pfcpType.GateOpen,
+				DLGate: pfcpType.GateOpen,
+			}
+			if rule.QosData.MaxbrUl != "" || rule.QosData.MaxbrDl != "" {
+				qer.MBR = &pfcpType.MBR{
+					ULMBR: util.BitRateTokbps(rule.QosData.MaxbrUl),
+					DLMBR: util.BitRateTokbps(rule.QosData.MaxbrDl),
+				}
+			}
+			ruleUPF.QER = qer
+		}
+
+		for _, flowInfo := range rule.FlowInfos {
+			sdfFilter, err := smContext.sdfFilter(flowInfo.FlowDescription)
+			if err != nil {
+				return err
+			}
+			var tunnels []*GTPTunnel
+			switch flowInfo.FlowDirection {
+			case models.FlowDirectionRm_UPLINK:
+				tunnels = []*GTPTunnel{node.UpLinkTunnel}
+			case models.FlowDirectionRm_DOWNLINK:
+				tunnels = []*GTPTunnel{node.DownLinkTunnel}
+			default:
+				tunnels = []*GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel}
+			}
+			for _, tunnel := range tunnels {
+				if tunnel == nil || tunnel.PDR == nil {
+					continue
+				}
+				pdr, err := smContext.addPCCRulePDR(node.UPF, tunnel.PDR, rule, ruleUPF.QER, sdfFilter)
+				if err != nil {
+					return err
+				}
+				ruleUPF.PDRs = append(ruleUPF.PDRs, pdr)
+			}
+		}
+	}
+	return nil
+}
+
+// addPCCRulePDR adds a PDR detecting the traffic of the rule among the traffic of the PDR of the
+// default path, forwarded by its FAR
+func (smContext *SMContext) addPCCRulePDR(upf *UPF, defaultPDR *PDR, rule *PCCRule, qer *QER,
+	sdfFilter *pfcpType.SDFFilter,
+) (*PDR, error) {
+	pdr, err := upf.AddPDR()
+	if err != nil {
+		return nil, err
+	}
+	// the FAR of the default PDR follows the changes of the user plane of the session
+	if err = upf.RemoveFAR(pdr.FAR); err != nil {
+		return nil, err
+	}
+	pdr.FAR = defaultPDR.FAR
+	pdr.URR = defaultPDR.URR
+	pdr.Precedence = uint32(rule.Precedence)
+	pdr.PDI = defaultPDR.PDI
+	pdr.PDI.SDFFilter = sdfFilter
+	pdr.OuterHeaderRemoval = defaultPDR.OuterHeaderRemoval
+	if qer != nil {
+		pdr.QER = append(pdr.QER, qer)
+	}
+	// the traffic of the rule counts in the session AMBR
+	for _, defaultQER := range defaultPDR.QER {
+		if defaultQER.SessionAMBR {
+			pdr.QER = append(pdr.QER, defaultQER)
+		}
+	}
+	if err = smContext.PutPDRtoPFCPSession(upf.NodeID, pdr); err != nil {
+		return nil, err
+	}
+	return pdr, nil
+}
+
+// deactivatePCCRule marks the PDRs and the QERs of the rule to be removed from the UPFs and frees
+// their IDs
+func (smContext *SMContext) deactivatePCCRule(rule *PCCRule) {
+	for _, ruleUPF := range rule.UPFs {
+		for _, pdr := range ruleUPF.PDRs {
+			pdr.State = RULE_REMOVE
+			smContext.RemovePDRfromPFCPSession(ruleUPF.UPF.NodeID, pdr)
+			if err := ruleUPF.UPF.RemovePDR(pdr); err != nil {
+				logger.CtxLog.Warnln("Deactivated PccRule", err)
+			}
+		}
+		if qer := ruleUPF.QER; qer != nil {
+			qer.State = RULE_REMOVE
+			if err := ruleUPF.UPF.RemoveQER(qer); err != nil {
+				logger.CtxLog.Warnln("Deactivated PccRule", err)
+			}
+		}
+	}
+}
+
+// allocatePCCRuleIDs gives the rule a QoS rule, and its own QoS flow if it has QoS data. The
+// QoS rule 1 and the QoS flow of the default 5QI are the default QoS rule and QoS flow.
+func (smContext *SMContext) allocatePCCRuleIDs(rule *PCCRule) error {
+	defaultQFI := uint8(0)
+	if sessionRule := smContext.SelectedSessionRule(); sessionRule != nil && sessionRule.AuthDefQos != nil {
+		defaultQFI = uint8(sessionRule.AuthDefQos.Var5qi)
+	}
+	usedQFIs := map[uint8]bool{defaultQFI: true}
+	usedQoSRuleIDs := map[uint8]bool{1: true}
+	for _, rules := range [][]*PCCRule{smContext.sortedPCCRules(), smContext.pccRulesToRemove} {
+		for _, r := range rules {
+			usedQFIs[r.QFI] = true
+			usedQoSRuleIDs[r.QoSRuleID] = true
+		}
+	}
+
+	rule.QoSRuleID = 0
+	for id := 2; id <= 255; id++ {
+		if !usedQoSRuleIDs[uint8(id)] {
+			rule.QoSRuleID = uint8(id)
+			break
+		}
+	}
+	if rule.QoSRuleID == 0 {
+		return fmt.Errorf("no QoS rule identifier left")
+	}
+
+	rule.QFI = defaultQFI
+	if rule.QosData != nil {
+		rule.QFI = 0
+		// QFI is 6 bits, TS 24.501 9.11.4.12
+		for qfi := 1; qfi <= 63; qfi++ {
+			if !usedQFIs[uint8(qfi)] {
+				rule.QFI = uint8(qfi)
+				break
+			}
+		}
+		if rule.QFI == 0 {
+			return fmt.Errorf("no QoS flow identifier left")
+		}
+	}
+	return nil
+}
+
+// sortedPCCRules returns the PCC rules of the session in the order of their IDs
+func (smContext *SMContext) sortedPCCRules() []*PCCRule {
+	ids := make([]string, 0, len(smContext.PCCRules))
+	for id := range smContext.PCCRules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	rules := make([]*PCCRule, 0, len(ids))
+	for _, id := range ids {
+		rules = append(rules, smContext.PCCRules[id])
+	}
+	return rules
+}
+
+// sdfFilter returns the SDF filter of the flow description for the UPF, the UE address replacing
+// "assigned", TS 29.244 8.2.5
+func (smContext *SMContext) sdfFilter(flowDescription string) (*pfcpType.SDFFilter, error) {
+	ipFilterRule, err := decodeFlowDescription(flowDescription)
+	if err != nil {
+		return nil, err
+	}
+	if ipFilterRule.GetSourceIP() == "assigned" {
+		err = ipFilterRule.SetSourceIP(smContext.PDUAddress.String())
+	}
+	if err == nil && ipFilterRule.GetDestinationIP() == "assigned" {
+		err = ipFilterRule.SetDestinationIP(smContext.PDUAddress.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	fd, err := flowdesc.Encode(ipFilterRule)
+	if err != nil {
+		return nil, err
+	}
+	return &pfcpType.SDFFilter{
+		Fd:                      true,
+		LengthOfFlowDescription: uint16(len(fd)),
+		FlowDescription:         []byte(fd),
+	}, nil
+}
+
+// decodeFlowDescription decodes the IPFilterRule of a flow description, TS 29.214 5.3.8
+func decodeFlowDescription(flowDescription string) (ipFilterRule *flowdesc.IPFilterRule, err error) {
+	// flowdesc.Decode reads the fields of the rule without checking their number
+	defer func() {
+		if recover() != nil {
+			ipFilterRule, err = nil, fmt.Errorf("malformed flow description: %s", flowDescription)
+		}
+	}()
+	return flowdesc.Decode(flowDescription)
+}
+
+// enforcedPCCRules returns the PCC rules of the session enforced on the UPFs
+func (smContext *SMContext) enforcedPCCRules() *PCCRuleChanges {
+	enforced := new(PCCRuleChanges)
+	for _, rule := range smContext.sortedPCCRules() {
+		if rule.Enforced() {
+			enforced.Installed = append(enforced.Installed, rule)
+		}
+	}
+	return enforced
+}
+
+// qosRules returns the QoS rules of the installed and removed PCC rules for the UE
+func (c *PCCRuleChanges) qosRules() QoSRules {
+	var qosRules QoSRules
+	if c == nil {
+		return qosRules
+	}
+	for _, rule := range c.Installed {
+		// the default QoS rule has the lowest precedence, 255
+		precedence := uint8(254)
+		if rule.Precedence >= 0 && rule.Precedence < 254 {
+			precedence = uint8(rule.Precedence)
+		}
+		qosRules = append(qosRules, QoSRule{
+			Identifier:       rule.QoSRuleID,
+			OperationCode:    OperationCodeCreateNewQoSRule,
+			Precedence:       precedence,
+			QFI:              rule.QFI,
+			PacketFilterList: rule.packetFilters(),
+		})
+	}
+	for _, rule := range c.Removed {
+		qosRules = append(qosRules, QoSRule{
+			Identifier:    rule.QoSRuleID,
+			OperationCode: OperationCodeDeleteExistingQoSRule,
+		})
+	}
+	return qosRules
+}
+
+// qosFlowDescriptions returns the QoS flows of the installed and removed PCC rules with QoS data
+// for the UE
+func (c *PCCRuleChanges) qosFlowDescriptions() QoSFlowDescriptions {
+	var descriptions QoSFlowDescriptions
+	if c == nil {
+		return descriptions
+	}
+	for _, rule := range c.Installed {
+		if rule.QosData != nil {
+			descriptions = append(descriptions, QoSFlowDescription{
+				QFI:           rule.QFI,
+				OperationCode: OperationCodeCreateNewQoSFlowDescription,
+				Var5qi:        uint8(rule.QosData.Var5qi),
+			})
+		}
+	}
+	for _, rule := range c.Removed {
+		if rule.QosData != nil {
+			descriptions = append(descriptions, QoSFlowDescription{
+				QFI:           rule.QFI,
+				OperationCode: OperationCodeDeleteExistingQoSFlowDescription,
+			})
+		}
+	}
+	return descriptions
+}
+
+// packetFilters returns the packet filters of the flows of the rule for the UE, TS 24.501
+// 9.11.4.13. The UE side matches the protocol, the remote address and the single ports and port
+// ranges of the flows, the UPF matching the full flow descriptions.
+func (r *PCCRule) packetFilters() []PacketFilter {
+	var packetFilters []PacketFilter
+	for i, flowInfo := range r.FlowInfos {
+		packetFilter := PacketFilter{
+			// the identifier is 4 bits
+			Identifier: uint8(i%15 + 1),
+			Direction:  PacketFilterDirectionBidirectional,
+		}
+		switch flowInfo.FlowDirection {
+		case models.FlowDirectionRm_UPLINK:
+			packetFilter.Direction = PacketFilterDirectionUplink
+		case models.FlowDirectionRm_DOWNLINK:
+			packetFilter.Direction = PacketFilterDirectionDownlink
+		}
+
+		if ipFilterRule, err := decodeFlowDescription(flowInfo.FlowDescription); err == nil {
+			// "permit out" rules are from the remote side to the UE
+			remoteIP, remotePorts := ipFilterRule.GetSourceIP(), ipFilterRule.GetSourcePorts()
+			localPorts := ipFilterRule.GetDestinationPorts()
+			if remoteIP == "assigned" {
+				remoteIP, remotePorts = ipFilterRule.GetDestinationIP(), ipFilterRule.GetDestinationPorts()
+				localPorts = ipFilterRule.GetSourcePorts()
+			}
+
+			if protocol := ipFilterRule.GetProtocol(); protocol != flowdesc.ProtocolNumberAny {
+				packetFilter.Components = append(packetFilter.Components, PacketFilterComponent{
+					ComponentType:  PacketFilterComponentTypeProtocolIdentifierOrNextHeader,
+					ComponentValue: []byte{protocol},
+				})
+			}
+			if _, ipNet, err := net.ParseCIDR(remoteIP); err == nil && ipNet.IP.To4() != nil {
+				packetFilter.Components = append(packetFilter.Components, PacketFilterComponent{
+					ComponentType:  PacketFilterComponentTypeIPv4RemoteAddress,
+					ComponentValue: append(append([]byte{}, ipNet.IP.To4()...), ipNet.Mask...),
+				})
+			} else if ip := net.ParseIP(remoteIP).To4(); ip != nil {
+				packetFilter.Components = append(packetFilter.Components, PacketFilterComponent{
+					ComponentType:  PacketFilterComponentTypeIPv4RemoteAddress,
+					ComponentValue: append(append([]byte{}, ip...), net.CIDRMask(32, 32)...),
+				})
+			}
+			if component, ok := portComponent(remotePorts, PacketFilterComponentTypeSingleRemotePort,
+				PacketFilterComponentTypeRemotePortRange); ok {
+				packetFilter.Components = append(packetFilter.Components, component)
+			}
+			if component, ok := portComponent(localPorts, PacketFilterComponentTypeSingleLocalPort,
+				PacketFilterComponentTypeLocalPortRange); ok {
+				packetFilter.Components = append(packetFilter.Components, component)
+			}
+		}
+
+		if len(packetFilter.Components) == 0 {
+			packetFilter.Components = []PacketFilterComponent{{ComponentType: PacketFilterComponentTypeMatchAll}}
+		}
+		packetFilters = append(packetFilters, packetFilter)
+	}
+	return packetFilters
+}
+
+// portComponent returns the packet filter component of a single port or a port range, lists of
+// ports having no component
+func portComponent(ports string, singleType, rangeType uint8) (PacketFilterComponent, bool) {
+	var low, high uint16
+	if n, err := fmt.Sscanf(ports, "%d-%d", &low, &high); err == nil && n == 2 &&
+		fmt.Sprintf("%d-%d", low, high) == ports {
+		return PacketFilterComponent{
+			ComponentType:  rangeType,
+			ComponentValue: []byte{byte(low >> 8), byte(low), byte(high >> 8), byte(high)},
+		}, true
+	}
+	if n, err := fmt.Sscanf(ports, "%d", &low); err == nil && n == 1 && fmt.Sprintf("%d", low) == ports {
+		return PacketFilterComponent{
+			ComponentType:  singleType,
+			ComponentValue: []byte{byte(low >> 8), byte(low)},
+		}, true
+	}
+	return PacketFilterComponent{}, false
+}
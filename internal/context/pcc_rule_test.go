@@ -0,0 +1,180 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/nas"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+)
+
+// newPCCRuleSMContext returns a session with an activated default path through a UPF, and its
+// default PDRs
+func newPCCRuleSMContext(t *testing.T) (*SMContext, *PDR, *PDR) {
+	nodeID := pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: net.ParseIP("10.4.0.21").To4()}
+	upf := NewUPF(&nodeID, nil)
+	upf.UPFStatus = AssociatedSetUpSuccess
+	t.Cleanup(func() { RemoveUPFNodeByNodeID(nodeID) })
+
+	smContext := NewSMContext("imsi-208930000000041", 1)
+	t.Cleanup(func() { RemoveSMContext(smContext.Ref) })
+	smContext.PDUAddress = net.ParseIP("10.60.0.1").To4()
+	sessionRule := NewSessionRuleFromModel(&models.SessionRule{
+		SessRuleId:   "default",
+		AuthSessAmbr: &models.Ambr{Uplink: "100 Mbps", Downlink: "200 Mbps"},
+		AuthDefQos:   &models.AuthorizedDefaultQos{Var5qi: 9},
+	})
+	SetSessionRuleActivateState(sessionRule, true)
+	smContext.SessionRules[sessionRule.SessionRuleID] = sessionRule
+
+	node := NewDataPathNode()
+	node.UPF = upf
+	defaultPath := NewDataPath()
+	defaultPath.IsDefaultPath = true
+	defaultPath.FirstDPNode = node
+	smContext.Tunnel = NewUPTunnel()
+	smContext.Tunnel.AddDataPath(defaultPath)
+	smContext.AllocateLocalSEIDForDataPath(defaultPath)
+
+	ambrQER, err := upf.AddQER()
+	require.NoError(t, err)
+	ambrQER.SessionAMBR = true
+	var pdrs []*PDR
+	for _, tunnel := range []*GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+		pdr, err := upf.AddPDR()
+		require.NoError(t, err)
+		pdr.Precedence = 255
+		pdr.QER = []*QER{ambrQER}
+		require.NoError(t, smContext.PutPDRtoPFCPSession(nodeID, pdr))
+		tunnel.PDR = pdr
+		pdrs = append(pdrs, pdr)
+	}
+	defaultPath.Activated = true
+	return smContext, pdrs[0], pdrs[1]
+}
+
+func TestUpdatePCCRules(t *testing.T) {
+	smContext, upLinkPDR, downLinkPDR := newPCCRuleSMContext(t)
+	pfcpSessionContext := smContext.PFCPContext["10.4.0.21"]
+
+	smContext.InstallPCCRule(&PCCRule{
+		PCCRuleID:  "voice",
+		Precedence: 10,
+		FlowInfos: []models.FlowInformation{
+			{
+				FlowDescription: "permit out 17 from 10.10.0.0/16 5060 to assigned",
+				FlowDirection:   models.FlowDirectionRm_BIDIRECTIONAL,
+			},
+		},
+		QosData: &models.QosData{QosId: "voice", Var5qi: 5, MaxbrUl: "1 Mbps", MaxbrDl: "2 Mbps"},
+	})
+	smContext.InstallPCCRule(&PCCRule{
+		PCCRuleID:  "web",
+		Precedence: 20,
+		FlowInfos: []models.FlowInformation{
+			{
+				FlowDescription: "permit out 6 from any 443 to assigned",
+				FlowDirection:   models.FlowDirectionRm_DOWNLINK,
+			},
+		},
+	})
+
+	changes := smContext.UpdatePCCRules()
+	require.Len(t, changes.Installed, 2)
+	require.Empty(t, changes.Removed)
+	voice, web := smContext.PCCRules["voice"], smContext.PCCRules["web"]
+
+	// the voice rule has its own QoS flow, the web traffic goes with the default QoS flow
+	require.Equal(t, uint8(1), voice.QFI)
+	require.Equal(t, uint8(2), voice.QoSRuleID)
+	require.Equal(t, uint8(9), web.QFI)
+	require.Equal(t, uint8(3), web.QoSRuleID)
+
+	require.Len(t, voice.UPFs, 1)
+	voiceQER := voice.UPFs[0].QER
+	require.NotNil(t, voiceQER)
+	require.Equal(t, uint8(1), voiceQER.QFI.QFI)
+	require.Equal(t, &pfcpType.MBR{ULMBR: 1000, DLMBR: 2000}, voiceQER.MBR)
+	require.Len(t, voice.UPFs[0].PDRs, 2)
+	for i, defaultPDR := range []*PDR{upLinkPDR, downLinkPDR} {
+		pdr := voice.UPFs[0].PDRs[i]
+		require.Equal(t, uint32(10), pdr.Precedence)
+		require.Equal(t, "permit out 17 from 10.10.0.0/16 5060 to 10.60.0.1",
+			string(pdr.PDI.SDFFilter.FlowDescription))
+		require.Same(t, defaultPDR.FAR, pdr.FAR)
+		require.Equal(t, []*QER{voiceQER, defaultPDR.QER[0]}, pdr.QER)
+		require.Same(t, pdr, pfcpSessionContext.PDRs[pdr.PDRID])
+	}
+	require.Len(t, web.UPFs[0].PDRs, 1)
+	require.Nil(t, web.UPFs[0].QER)
+	require.Same(t, downLinkPDR.FAR, web.UPFs[0].PDRs[0].FAR)
+	require.Len(t, pfcpSessionContext.PDRs, 5)
+
+	// the UE matches the protocol, the remote address and the remote port of the voice traffic
+	qosRules, err := changes.qosRules().MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		2, 0, 19, OperationCodeCreateNewQoSRule<<5 | 1,
+		PacketFilterDirectionBidirectional<<4 | 1, 14,
+		PacketFilterComponentTypeProtocolIdentifierOrNextHeader, 17,
+		PacketFilterComponentTypeIPv4RemoteAddress, 10, 10, 0, 0, 255, 255, 0, 0,
+		PacketFilterComponentTypeSingleRemotePort, 0x13, 0xc4,
+		10, 1,
+	}, qosRules[:22])
+	qosFlowDescriptions, err := changes.qosFlowDescriptions().MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, OperationCodeCreateNewQoSFlowDescription << 5, 0x41, 0x01, 1, 5}, qosFlowDescriptions)
+
+	// nothing left to enforce
+	require.True(t, smContext.UpdatePCCRules().Empty())
+
+	smContext.RemovePCCRule("voice")
+	changes = smContext.UpdatePCCRules()
+	require.Empty(t, changes.Installed)
+	require.Equal(t, []*PCCRule{voice}, changes.Removed)
+	for _, pdr := range voice.UPFs[0].PDRs {
+		require.Equal(t, RULE_REMOVE, pdr.State)
+	}
+	require.Equal(t, RULE_REMOVE, voiceQER.State)
+	require.Len(t, pfcpSessionContext.PDRs, 3)
+
+	m := nas.NewMessage()
+	buf, err := BuildGSMPDUSessionModificationCommand(smContext, changes)
+	require.NoError(t, err)
+	require.NoError(t, m.GsmMessageDecode(&buf))
+	command := m.PDUSessionModificationCommand
+	require.Equal(t, []byte{2, 0, 1, OperationCodeDeleteExistingQoSRule << 5}, command.AuthorizedQosRules.GetQosRule())
+	require.Equal(t, []byte{1, OperationCodeDeleteExistingQoSFlowDescription << 5, 0},
+		command.AuthorizedQosFlowDescriptions.GetQoSFlowDescriptions())
+
+	// the QoS flow and the QoS rule of the removed rule are given again
+	smContext.InstallPCCRule(&PCCRule{
+		PCCRuleID:  "video",
+		Precedence: 30,
+		FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out ip from 10.20.0.1 to assigned"}},
+		QosData:    &models.QosData{QosId: "video", Var5qi: 7},
+	})
+	changes = smContext.UpdatePCCRules()
+	require.Len(t, changes.Installed, 1)
+	require.Equal(t, uint8(1), changes.Installed[0].QFI)
+	require.Equal(t, uint8(2), changes.Installed[0].QoSRuleID)
+}
+
+func TestPCCRuleMalformedFlowDescription(t *testing.T) {
+	smContext, _, _ := newPCCRuleSMContext(t)
+
+	smContext.InstallPCCRule(&PCCRule{
+		PCCRuleID:  "broken",
+		Precedence: 10,
+		FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out"}},
+		QosData:    &models.QosData{QosId: "broken", Var5qi: 5},
+	})
+	changes := smContext.UpdatePCCRules()
+	require.True(t, changes.Empty())
+	require.False(t, smContext.PCCRules["broken"].Enforced())
+	// the rules allocated before the failure are freed
+	require.Len(t, smContext.PFCPContext["10.4.0.21"].PDRs, 2)
+}
@@ -1,6 +1,8 @@
 package context
 
 import (
+	"time"
+
 	"github.com/free5gc/pfcp/pfcpType"
 )
 
@@ -36,6 +38,8 @@ type PDI struct {
 	UEIPAddress     *pfcpType.UEIPAddress
 	SDFFilter       *pfcpType.SDFFilter
 	ApplicationID   string
+	// IP subnets behind the UE, detected as its UE IP address
+	FramedRoutes []string
 }
 
 // Forwarding Action Rule. 7.5.2.3-1
@@ -78,8 +82,17 @@ type QER struct {
 	MBR        *pfcpType.MBR
 	GBR        *pfcpType.GBR
 
+	// the QER enforces the session AMBR of its session, TS 29.244 5.4.2
+	SessionAMBR bool
+
 	State RuleState
 }
 
-// Usage Report Rule
-type URR struct{}
+// Usage Report Rule. 7.5.2.4
+type URR struct {
+	URRID uint32
+	// period of the usage reports, none if zero
+	MeasurementPeriod time.Duration
+
+	State RuleState
+}
@@ -22,6 +22,8 @@ type PFCPSessionContext struct {
 	NodeID     pfcpType.NodeID
 	LocalSEID  uint64
 	RemoteSEID uint64
+	// FQ-CSID of the UPF for the PFCP session, if the UPF supports partial failure handling
+	UPFFQCSID *FQCSID
 }
 
 func (pfcpSessionContext *PFCPSessionContext) String() string {
@@ -133,6 +133,54 @@ success:
 	return true
 }
 
+// Use allocates the value, which is no longer returned by Allocate until it is freed.
+// It returns false if the value is out of this pool or already allocated.
+func (p *LazyReusePool) Use(value int) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var prev *segment
+	for cur := p.head; cur != nil; prev, cur = cur, cur.next {
+		if cur.relativePosisionOf(value) != withinThisSegment {
+			continue
+		}
+		switch {
+		case cur.first == cur.last:
+			// remove the segment
+			if prev == nil {
+				p.head = cur.next
+			} else {
+				prev.next = cur.next
+			}
+		case value == cur.first:
+			cur.first++
+		case value == cur.last:
+			cur.last--
+		case prev == nil:
+			// split the head, the segments after it are kept sorted
+			front := &segment{cur.first, value - 1, nil}
+			cur.first = value + 1
+			p.insertAfterHead(front)
+		default:
+			// split the segment
+			cur.next = &segment{value + 1, cur.last, cur.next}
+			cur.last = value - 1
+		}
+		p.remain--
+		return true
+	}
+	return false
+}
+
+func (p *LazyReusePool) insertAfterHead(seg *segment) {
+	prev := p.head
+	for prev.next != nil && prev.next.first < seg.first {
+		prev = prev.next
+	}
+	seg.next = prev.next
+	prev.next = seg
+}
+
 func (p *LazyReusePool) Remain() int {
 	return p.remain
 }
@@ -178,6 +226,9 @@ func (p1 *LazyReusePool) IsJoint(p2 *LazyReusePool) bool {
 }
 
 func (p *LazyReusePool) Dump() [][]int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
 	var dumpedSegList [][]int
 	curSeg := p.head
 	for curSeg != nil {
@@ -221,3 +221,33 @@ func TestLazyReusePool_ManyGoroutine(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, 900-numOfThreads-1, p.Remain())
 }
+
+func TestLazyReusePool_Use(t *testing.T) {
+	p, err := NewLazyReusePool(1, 10)
+	assert.NoError(t, err)
+
+	// split the head
+	assert.True(t, p.Use(5))
+	assert.Equal(t, 9, p.Remain())
+	assert.Equal(t, [][]int{{6, 10}, {1, 4}}, p.Dump())
+
+	// already used or out of range
+	assert.False(t, p.Use(5))
+	assert.False(t, p.Use(11))
+
+	// the edges of segments
+	assert.True(t, p.Use(6))
+	assert.True(t, p.Use(4))
+	assert.True(t, p.Use(1))
+	assert.Equal(t, [][]int{{7, 10}, {2, 3}}, p.Dump())
+
+	// split a segment after the head
+	assert.True(t, p.Free(4))
+	assert.True(t, p.Use(3))
+	assert.Equal(t, 6, p.Remain())
+	assert.Equal(t, [][]int{{7, 10}, {2, 2}, {4, 4}}, p.Dump())
+
+	a, ok := p.Allocate()
+	assert.True(t, ok)
+	assert.Equal(t, 7, a)
+}
@@ -44,14 +44,28 @@ const (
 	PacketFilterComponentTypeEthertype                      uint8 = 0x87
 )
 
+type PacketFilterComponent struct {
+	ComponentType  uint8
+	ComponentValue []byte
+}
+
 type PacketFilter struct {
-	Direction     uint8
-	Identifier    uint8
-	ComponentType uint8
-	Component     []byte
+	Direction  uint8
+	Identifier uint8
+	Components []PacketFilterComponent
 }
 
 func (pf *PacketFilter) MarshalBinary() (data []byte, err error) {
+	contentsBuffer := bytes.NewBuffer(nil)
+	for _, component := range pf.Components {
+		if err = contentsBuffer.WriteByte(component.ComponentType); err != nil {
+			return nil, err
+		}
+		if _, err = contentsBuffer.Write(component.ComponentValue); err != nil {
+			return nil, err
+		}
+	}
+
 	packetFilterBuffer := bytes.NewBuffer(nil)
 	header := 0 | pf.Direction<<4 | pf.Identifier
 	// write header
@@ -60,23 +74,15 @@ func (pf *PacketFilter) MarshalBinary() (data []byte, err error) {
 		return nil, err
 	}
 	// write length of packet filter
-	err = packetFilterBuffer.WriteByte(uint8(1 + len(pf.Component)))
+	err = packetFilterBuffer.WriteByte(uint8(contentsBuffer.Len()))
 	if err != nil {
 		return nil, err
 	}
 
-	err = packetFilterBuffer.WriteByte(pf.ComponentType)
-	if err != nil {
+	if _, err = packetFilterBuffer.ReadFrom(contentsBuffer); err != nil {
 		return nil, err
 	}
 
-	if pf.ComponentType == PacketFilterComponentTypeMatchAll || pf.Component == nil {
-		_, err = packetFilterBuffer.Write(pf.Component)
-		if err != nil {
-			return nil, err
-		}
-	}
-
 	return packetFilterBuffer.Bytes(), nil
 }
 
@@ -116,15 +122,18 @@ func (r *QoSRule) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 
-	// write precedence
-	if err := ruleContentBuffer.WriteByte(r.Precedence); err != nil {
-		return nil, err
-	}
+	// a deleted QoS rule has no precedence and QFI
+	if r.OperationCode != OperationCodeDeleteExistingQoSRule {
+		// write precedence
+		if err := ruleContentBuffer.WriteByte(r.Precedence); err != nil {
+			return nil, err
+		}
 
-	// write Segregation and QFI
-	segregationAndQFIByte := r.Segregation<<6 | r.QFI
-	if err := ruleContentBuffer.WriteByte(segregationAndQFIByte); err != nil {
-		return nil, err
+		// write Segregation and QFI
+		segregationAndQFIByte := r.Segregation<<6 | r.QFI
+		if err := ruleContentBuffer.WriteByte(segregationAndQFIByte); err != nil {
+			return nil, err
+		}
 	}
 
 	ruleBuffer := bytes.NewBuffer(nil)
@@ -164,3 +173,61 @@ func (rs QoSRules) MarshalBinary() (data []byte, err error) {
 	}
 	return qosRulesBuffer.Bytes(), nil
 }
+
+// TS 24.501 Table 9.11.4.12.1
+const (
+	OperationCodeCreateNewQoSFlowDescription      uint8 = 1
+	OperationCodeDeleteExistingQoSFlowDescription uint8 = 2
+	OperationCodeModifyExistingQoSFlowDescription uint8 = 3
+)
+
+const QoSFlowDescriptionParameter5QI uint8 = 0x01
+
+type QoSFlowDescription struct {
+	QFI           uint8
+	OperationCode uint8
+	// 5QI of a created QoS flow
+	Var5qi uint8
+}
+
+func (d *QoSFlowDescription) MarshalBinary() ([]byte, error) {
+	descriptionBuffer := bytes.NewBuffer(nil)
+	if err := descriptionBuffer.WriteByte(d.QFI); err != nil {
+		return nil, err
+	}
+	if err := descriptionBuffer.WriteByte(d.OperationCode << 5); err != nil {
+		return nil, err
+	}
+	// a deleted QoS flow description has no parameters
+	if d.OperationCode == OperationCodeDeleteExistingQoSFlowDescription {
+		if err := descriptionBuffer.WriteByte(0); err != nil {
+			return nil, err
+		}
+		return descriptionBuffer.Bytes(), nil
+	}
+
+	// write E bit and number of parameters
+	if err := descriptionBuffer.WriteByte(0x40 | 1); err != nil {
+		return nil, err
+	}
+	if _, err := descriptionBuffer.Write([]byte{QoSFlowDescriptionParameter5QI, 1, d.Var5qi}); err != nil {
+		return nil, err
+	}
+	return descriptionBuffer.Bytes(), nil
+}
+
+type QoSFlowDescriptions []QoSFlowDescription
+
+func (ds QoSFlowDescriptions) MarshalBinary() ([]byte, error) {
+	descriptionsBuffer := bytes.NewBuffer(nil)
+	for _, description := range ds {
+		descriptionBytes, err := description.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := descriptionsBuffer.Write(descriptionBytes); err != nil {
+			return nil, err
+		}
+	}
+	return descriptionsBuffer.Bytes(), nil
+}
@@ -0,0 +1,173 @@
+package context
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// number of changes kept for the standby SMF, which reads a snapshot when it is further behind
+const replicationLogSize = 4096
+
+// ReplicationChange is a change of the session store; Record is nil when it is deleted
+type ReplicationChange struct {
+	Seq    uint64         `json:"seq"`
+	Ref    string         `json:"ref"`
+	Record *SessionRecord `json:"record,omitempty"`
+}
+
+// ReplicationChanges are the changes after a sequence number. Epoch identifies the run of
+// the active SMF, the sequence numbers restart with a new epoch.
+type ReplicationChanges struct {
+	Epoch   string               `json:"epoch"`
+	Changes []*ReplicationChange `json:"changes"`
+}
+
+// ReplicationSnapshot is the content of the session store of the active SMF
+type ReplicationSnapshot struct {
+	Epoch             string           `json:"epoch"`
+	Seq               uint64           `json:"seq"`
+	NfInstanceID      string           `json:"nfInstanceId"`
+	RecoveryTimeStamp time.Time        `json:"recoveryTimeStamp"`
+	Records           []*SessionRecord `json:"records"`
+}
+
+// ReplicatedSessionStore is a SessionStore which keeps the recent changes, to be tailed by
+// a standby SMF
+type ReplicatedSessionStore struct {
+	SessionStore
+
+	epoch string
+	seq   uint64
+	// ring buffer of the recent changes, changes[seq % replicationLogSize]
+	changes []*ReplicationChange
+	lock    sync.Mutex
+}
+
+func NewReplicatedSessionStore(store SessionStore) *ReplicatedSessionStore {
+	return &ReplicatedSessionStore{
+		SessionStore: store,
+		epoch:        uuid.New().String(),
+		changes:      make([]*ReplicationChange, replicationLogSize),
+	}
+}
+
+func (s *ReplicatedSessionStore) Put(record *SessionRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.SessionStore.Put(record); err != nil {
+		return err
+	}
+	s.appendChange(record.Ref, record)
+	return nil
+}
+
+func (s *ReplicatedSessionStore) Delete(ref string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.SessionStore.Delete(ref); err != nil {
+		return err
+	}
+	s.appendChange(ref, nil)
+	return nil
+}
+
+func (s *ReplicatedSessionStore) appendChange(ref string, record *SessionRecord) {
+	s.seq++
+	s.changes[s.seq%replicationLogSize] = &ReplicationChange{
+		Seq:    s.seq,
+		Ref:    ref,
+		Record: record,
+	}
+}
+
+// ChangesAfter returns the changes after the sequence number, or false if they are no
+// longer kept and a snapshot has to be read instead
+func (s *ReplicatedSessionStore) ChangesAfter(seq uint64) (*ReplicationChanges, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if seq > s.seq || s.seq-seq > replicationLogSize {
+		return nil, false
+	}
+	changes := &ReplicationChanges{
+		Epoch:   s.epoch,
+		Changes: make([]*ReplicationChange, 0, s.seq-seq),
+	}
+	for next := seq + 1; next <= s.seq; next++ {
+		changes.Changes = append(changes.Changes, s.changes[next%replicationLogSize])
+	}
+	return changes, true
+}
+
+func (s *ReplicatedSessionStore) Snapshot() (*ReplicationSnapshot, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records, err := s.SessionStore.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+	timeStamp, err := s.SessionStore.LoadRecoveryTimeStamp()
+	if err != nil {
+		return nil, err
+	}
+	return &ReplicationSnapshot{
+		Epoch:             s.epoch,
+		Seq:               s.seq,
+		NfInstanceID:      SMF_Self().NfInstanceID,
+		RecoveryTimeStamp: timeStamp,
+		Records:           records,
+	}, nil
+}
+
+// ApplySnapshot replaces the content of the store with the snapshot of the active SMF
+func (s *ReplicatedSessionStore) ApplySnapshot(snapshot *ReplicationSnapshot) error {
+	records, err := s.SessionStore.LoadAll()
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]bool, len(snapshot.Records))
+	for _, record := range snapshot.Records {
+		kept[record.Ref] = true
+		if err := s.Put(record); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		if !kept[record.Ref] {
+			if err := s.Delete(record.Ref); err != nil {
+				return err
+			}
+		}
+	}
+	if !snapshot.RecoveryTimeStamp.IsZero() {
+		if err := s.PutRecoveryTimeStamp(snapshot.RecoveryTimeStamp); err != nil {
+			return err
+		}
+	}
+	if snapshot.NfInstanceID != "" {
+		// the standby registers to the NRF as the same NF instance when it takes over
+		SMF_Self().NfInstanceID = snapshot.NfInstanceID
+	}
+	return nil
+}
+
+// ApplyChanges applies the changes tailed from the active SMF
+func (s *ReplicatedSessionStore) ApplyChanges(changes []*ReplicationChange) error {
+	for _, change := range changes {
+		var err error
+		if change.Record != nil {
+			err = s.Put(change.Record)
+		} else {
+			err = s.Delete(change.Ref)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
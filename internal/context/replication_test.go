@@ -0,0 +1,52 @@
+package context
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicatedSessionStore(t *testing.T) {
+	fileStore, err := NewFileSessionStore(t.TempDir())
+	require.NoError(t, err)
+	active := NewReplicatedSessionStore(fileStore)
+
+	require.NoError(t, active.Put(&SessionRecord{Ref: "ref-1", Supi: "imsi-208930000000001"}))
+	require.NoError(t, active.Put(&SessionRecord{Ref: "ref-2", Supi: "imsi-208930000000002"}))
+	snapshot, err := active.Snapshot()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), snapshot.Seq)
+	require.Len(t, snapshot.Records, 2)
+
+	standbyStore, err := NewFileSessionStore(t.TempDir())
+	require.NoError(t, err)
+	standby := NewReplicatedSessionStore(standbyStore)
+	require.NoError(t, standby.ApplySnapshot(snapshot))
+
+	// the standby follows the changes after the snapshot
+	require.NoError(t, active.Delete("ref-1"))
+	require.NoError(t, active.Put(&SessionRecord{Ref: "ref-3", Supi: "imsi-208930000000003"}))
+	changes, ok := active.ChangesAfter(snapshot.Seq)
+	require.True(t, ok)
+	require.Equal(t, snapshot.Epoch, changes.Epoch)
+	require.Len(t, changes.Changes, 2)
+	require.Nil(t, changes.Changes[0].Record)
+	require.NoError(t, standby.ApplyChanges(changes.Changes))
+
+	records, err := standby.LoadAll()
+	require.NoError(t, err)
+	refs := make([]string, 0, len(records))
+	for _, record := range records {
+		refs = append(refs, record.Ref)
+	}
+	require.ElementsMatch(t, []string{"ref-2", "ref-3"}, refs)
+
+	// a standby ahead of the active SMF, or too far behind it, reads a snapshot
+	_, ok = active.ChangesAfter(5)
+	require.False(t, ok)
+	for i := 0; i < replicationLogSize; i++ {
+		require.NoError(t, active.Delete("ref-2"))
+	}
+	_, ok = active.ChangesAfter(snapshot.Seq)
+	require.False(t, ok)
+}
@@ -0,0 +1,99 @@
+package context
+
+import (
+	"context"
+	"time"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+const (
+	DefaultSBITimeout             = 3 * time.Second
+	DefaultSBIMaxRetries          = 2
+	DefaultSBIBreakerFailures     = 5
+	DefaultSBIBreakerOpenTime     = 30 * time.Second
+	DefaultSBIRejectBackoffTimer  = 30 * time.Second
+	DefaultSBIPolicyRetryInterval = 30 * time.Second
+)
+
+// setSBIResilience sets the timeouts, retries and circuit breakers of the requests to the other
+// NFs from the configuration, the unset values keeping their defaults
+func (c *SMFContext) setSBIResilience(resilience *factory.SbiResilience) {
+	c.SBITimeout = DefaultSBITimeout
+	c.SBIPeerTimeouts = make(map[models.NfType]time.Duration)
+	c.SBIMaxRetries = DefaultSBIMaxRetries
+	c.SBIBreakerFailures = DefaultSBIBreakerFailures
+	c.SBIBreakerOpenTime = DefaultSBIBreakerOpenTime
+	c.SBIRejectBackoffTimer = DefaultSBIRejectBackoffTimer
+	c.SBIPolicyRetryInterval = DefaultSBIPolicyRetryInterval
+	if resilience == nil {
+		return
+	}
+
+	if resilience.Timeout > 0 {
+		c.SBITimeout = resilience.Timeout
+	}
+	for nfType, timeout := range resilience.PeerTimeouts {
+		if timeout > 0 {
+			c.SBIPeerTimeouts[models.NfType(nfType)] = timeout
+		}
+	}
+	if resilience.MaxRetries != nil {
+		c.SBIMaxRetries = *resilience.MaxRetries
+	}
+	if resilience.BreakerFailures > 0 {
+		c.SBIBreakerFailures = resilience.BreakerFailures
+	}
+	if resilience.BreakerOpenTime > 0 {
+		c.SBIBreakerOpenTime = resilience.BreakerOpenTime
+	}
+	if resilience.RejectBackoffTimer > 0 {
+		c.SBIRejectBackoffTimer = resilience.RejectBackoffTimer
+	}
+	if resilience.PolicyRetryInterval > 0 {
+		c.SBIPolicyRetryInterval = resilience.PolicyRetryInterval
+	}
+}
+
+// SBITimeoutOf returns the timeout of the requests to the NF type
+func (c *SMFContext) SBITimeoutOf(nfType models.NfType) time.Duration {
+	if timeout, ok := c.SBIPeerTimeouts[nfType]; ok {
+		return timeout
+	}
+	if c.SBITimeout > 0 {
+		return c.SBITimeout
+	}
+	return DefaultSBITimeout
+}
+
+// sbiBreakerFailures returns the consecutive failures opening the circuit breaker of an NF
+// instance
+func (c *SMFContext) sbiBreakerFailures() int {
+	if c.SBIBreakerFailures > 0 {
+		return c.SBIBreakerFailures
+	}
+	return DefaultSBIBreakerFailures
+}
+
+// sbiBreakerOpenTime returns how long the circuit breaker of an NF instance stays open
+func (c *SMFContext) sbiBreakerOpenTime() time.Duration {
+	if c.SBIBreakerOpenTime > 0 {
+		return c.SBIBreakerOpenTime
+	}
+	return DefaultSBIBreakerOpenTime
+}
+
+// GetSBICtx returns the context of a request to the service of the target NF type, with the
+// access token of the request and the timeout of the NF type. The cancel function is to be
+// called once the response is handled.
+func (c *SMFContext) GetSBICtx(serviceName models.ServiceName, targetNF models.NfType) (
+	context.Context, context.CancelFunc, error,
+) {
+	ctx, err := c.GetTokenCtx(serviceName, targetNF)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.SBITimeoutOf(targetNF))
+	return ctx, cancel, nil
+}
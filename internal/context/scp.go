@@ -0,0 +1,147 @@
+package context
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"github.com/free5gc/openapi/Namf_Communication"
+	"github.com/free5gc/openapi/Npcf_SMPolicyControl"
+	"github.com/free5gc/openapi/models"
+)
+
+// Headers of the indirect communication through an SCP, TS 29.500 5.2.3.2
+const (
+	HeaderTargetApiRoot               = "3gpp-Sbi-Target-apiRoot"
+	HeaderDiscoveryTargetNfType       = "3gpp-Sbi-Discovery-target-nf-type"
+	HeaderDiscoveryRequesterNfType    = "3gpp-Sbi-Discovery-requester-nf-type"
+	HeaderDiscoveryServiceNames       = "3gpp-Sbi-Discovery-service-names"
+	HeaderDiscoveryTargetNfInstanceId = "3gpp-Sbi-Discovery-target-nf-instance-id"
+	HeaderDiscoverySnssais            = "3gpp-Sbi-Discovery-snssais"
+	HeaderDiscoveryDnn                = "3gpp-Sbi-Discovery-dnn"
+)
+
+// sbiClientConfiguration is the configuration of the openapi clients
+type sbiClientConfiguration interface {
+	SetBasePath(apiRoot string)
+	AddDefaultHeader(key string, value string)
+}
+
+// SetSBIApiRoot sets the apiRoot of the NF the client sends its requests to. Through an SCP, the
+// requests are sent to the SCP with the apiRoot of the NF in a header, TS 29.500 6.10.2.
+func SetSBIApiRoot(cfg sbiClientConfiguration, apiRoot string) {
+	scpUri := SMF_Self().ScpUri
+	if scpUri == "" {
+		cfg.SetBasePath(apiRoot)
+		return
+	}
+	cfg.SetBasePath(scpUri)
+	cfg.AddDefaultHeader(HeaderTargetApiRoot, apiRoot)
+}
+
+// SBICallbackURI returns the URI to send a notification to the callback URI of an NF, the same
+// URI on the SCP when the requests are sent through it
+func SBICallbackURI(cfg sbiClientConfiguration, uri string) string {
+	scpUri := SMF_Self().ScpUri
+	if scpUri == "" {
+		return uri
+	}
+	callback, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	cfg.AddDefaultHeader(HeaderTargetApiRoot, callback.Scheme+"://"+callback.Host)
+	return scpUri + callback.RequestURI()
+}
+
+// DelegatedDiscovery reports whether the SCP discovers and selects the PCF, UDM and serving AMF
+// of the requests rather than the SMF, model D
+func DelegatedDiscovery() bool {
+	return SMF_Self().ScpUri != "" && SMF_Self().ScpDelegatedDiscovery
+}
+
+// SetSBIDelegatedDiscovery sends the requests of the client to the SCP, with the parameters of
+// the discovery of the NF in headers, TS 29.500 6.10.3.3. The parameters are encoded as the
+// query parameters of the NF discovery, TS 29.510 6.2.3.2.3.1.
+func SetSBIDelegatedDiscovery(cfg sbiClientConfiguration, targetNfType models.NfType,
+	serviceName models.ServiceName, params map[string]string,
+) {
+	cfg.SetBasePath(SMF_Self().ScpUri)
+	cfg.AddDefaultHeader(HeaderDiscoveryTargetNfType, string(targetNfType))
+	cfg.AddDefaultHeader(HeaderDiscoveryRequesterNfType, string(models.NfType_SMF))
+	cfg.AddDefaultHeader(HeaderDiscoveryServiceNames, string(serviceName))
+	for header, value := range params {
+		cfg.AddDefaultHeader(header, value)
+	}
+}
+
+// setSMPolicyClient creates the client to the npcf-smpolicycontrol service of the selected PCF,
+// or to the PCF the SCP selects with delegated discovery until the SM policy association is bound
+// to the PCF the SCP selected
+func (smContext *SMContext) setSMPolicyClient() {
+	smContext.SetSMPolicyConf(nil)
+	if services := smContext.SelectedPCFProfile.NfServices; services != nil {
+		for _, service := range *services {
+			if service.ServiceName == models.ServiceName_NPCF_SMPOLICYCONTROL {
+				SmPolicyControlConf := Npcf_SMPolicyControl.NewConfiguration()
+				SetSBIApiRoot(SmPolicyControlConf, service.ApiPrefix)
+				smContext.SetSMPolicyConf(SmPolicyControlConf)
+			}
+		}
+	}
+	if smContext.SMPolicyClient == nil && DelegatedDiscovery() {
+		params := map[string]string{HeaderDiscoveryDnn: smContext.Dnn}
+		if smContext.Snssai != nil {
+			if snssais, err := json.Marshal([]models.Snssai{*smContext.Snssai}); err == nil {
+				params[HeaderDiscoverySnssais] = string(snssais)
+			}
+		}
+		SmPolicyControlConf := Npcf_SMPolicyControl.NewConfiguration()
+		SetSBIDelegatedDiscovery(SmPolicyControlConf, models.NfType_PCF, models.ServiceName_NPCF_SMPOLICYCONTROL,
+			params)
+		smContext.SetSMPolicyConf(SmPolicyControlConf)
+	}
+}
+
+// SetSMPolicyConf creates the client to the npcf-smpolicycontrol service of the configuration,
+// which is kept for the requests the client cannot send. A nil configuration drops the client.
+func (smContext *SMContext) SetSMPolicyConf(cfg *Npcf_SMPolicyControl.Configuration) {
+	smContext.SMPolicyConf = cfg
+	smContext.SMPolicyClient = nil
+	if cfg != nil {
+		smContext.SMPolicyClient = Npcf_SMPolicyControl.NewAPIClient(cfg)
+	}
+}
+
+// BindSMPolicyAssociation sends the later requests of the SM policy association created through
+// the SCP with delegated discovery to the PCF which created it, at the apiRoot of the Location of
+// the association, TS 29.500 6.10.3.3. The PCF is kept in the selected PCF profile of the session.
+func (smContext *SMContext) BindSMPolicyAssociation(apiRoot string) {
+	smContext.SelectedPCFProfile.NfServices = &[]models.NfService{{
+		ServiceName: models.ServiceName_NPCF_SMPOLICYCONTROL,
+		ApiPrefix:   apiRoot,
+	}}
+	smContext.setSMPolicyClient()
+}
+
+// SetCommunicationClient creates the client to the namf-comm service of the serving AMF, or to
+// the serving AMF the SCP selects by its NF instance ID with delegated discovery
+func (smContext *SMContext) SetCommunicationClient() {
+	if DelegatedDiscovery() {
+		communicationConf := Namf_Communication.NewConfiguration()
+		SetSBIDelegatedDiscovery(communicationConf, models.NfType_AMF, models.ServiceName_NAMF_COMM,
+			map[string]string{
+				HeaderDiscoveryTargetNfInstanceId: smContext.ServingNfId,
+			})
+		smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
+		return
+	}
+	if services := smContext.AMFProfile.NfServices; services != nil {
+		for _, service := range *services {
+			if service.ServiceName == models.ServiceName_NAMF_COMM {
+				communicationConf := Namf_Communication.NewConfiguration()
+				SetSBIApiRoot(communicationConf, service.ApiPrefix)
+				smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package context
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+)
+
+type testClientConfiguration struct {
+	basePath string
+	headers  map[string]string
+}
+
+func (c *testClientConfiguration) SetBasePath(apiRoot string) {
+	c.basePath = apiRoot
+}
+
+func (c *testClientConfiguration) AddDefaultHeader(key string, value string) {
+	c.headers[key] = value
+}
+
+func TestSCP(t *testing.T) {
+	defer func(scpUri string, delegatedDiscovery bool) {
+		SMF_Self().ScpUri, SMF_Self().ScpDelegatedDiscovery = scpUri, delegatedDiscovery
+	}(SMF_Self().ScpUri, SMF_Self().ScpDelegatedDiscovery)
+
+	SMF_Self().ScpUri, SMF_Self().ScpDelegatedDiscovery = "", true
+	cfg := &testClientConfiguration{headers: make(map[string]string)}
+	SetSBIApiRoot(cfg, "http://pcf:8000")
+	require.Equal(t, "http://pcf:8000", cfg.basePath)
+	require.Empty(t, cfg.headers)
+	require.Equal(t, "http://amf:8000/notify/1", SBICallbackURI(cfg, "http://amf:8000/notify/1"))
+	require.False(t, DelegatedDiscovery())
+
+	SMF_Self().ScpUri, SMF_Self().ScpDelegatedDiscovery = "http://scp:8000", false
+	cfg = &testClientConfiguration{headers: make(map[string]string)}
+	SetSBIApiRoot(cfg, "http://pcf:8000")
+	require.Equal(t, "http://scp:8000", cfg.basePath)
+	require.Equal(t, map[string]string{HeaderTargetApiRoot: "http://pcf:8000"}, cfg.headers)
+	require.False(t, DelegatedDiscovery())
+
+	cfg = &testClientConfiguration{headers: make(map[string]string)}
+	require.Equal(t, "http://scp:8000/notify/1?a=b", SBICallbackURI(cfg, "http://amf:8000/notify/1?a=b"))
+	require.Equal(t, map[string]string{HeaderTargetApiRoot: "http://amf:8000"}, cfg.headers)
+
+	SMF_Self().ScpDelegatedDiscovery = true
+	require.True(t, DelegatedDiscovery())
+	cfg = &testClientConfiguration{headers: make(map[string]string)}
+	SetSBIDelegatedDiscovery(cfg, models.NfType_AMF, models.ServiceName_NAMF_COMM,
+		map[string]string{HeaderDiscoveryTargetNfInstanceId: "amf-1"})
+	require.Equal(t, "http://scp:8000", cfg.basePath)
+	require.Equal(t, map[string]string{
+		HeaderDiscoveryTargetNfType:       "AMF",
+		HeaderDiscoveryRequesterNfType:    "SMF",
+		HeaderDiscoveryServiceNames:       "namf-comm",
+		HeaderDiscoveryTargetNfInstanceId: "amf-1",
+	}, cfg.headers)
+}
@@ -0,0 +1,165 @@
+package context
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/radius"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// EAP codes and types, RFC 3748 4 and 5
+const (
+	EAPCodeRequest  uint8 = 1
+	EAPCodeResponse uint8 = 2
+	EAPCodeSuccess  uint8 = 3
+	EAPCodeFailure  uint8 = 4
+	EAPTypeIdentity uint8 = 1
+)
+
+// RADIUS attribute values of TS 29.561 16.4
+const (
+	radiusServiceTypeFramed     = 2
+	radiusFramedProtocolGPRSPDP = 7
+)
+
+// EAPTimeout is how long the SMF waits for the UE to answer an EAP request before it rejects the
+// establishment of the PDU session
+var EAPTimeout = 30 * time.Second
+
+// DNAAAServer is the DN-AAA server authenticating the PDU sessions of a DNN, TS 29.561 16
+type DNAAAServer struct {
+	Client        *radius.Client
+	NasIdentifier string
+}
+
+func newDNAAAServer(server *factory.RadiusServer) *DNAAAServer {
+	dnAAAServer := &DNAAAServer{
+		Client: &radius.Client{
+			Addr:    server.Addr,
+			Secret:  []byte(server.Secret),
+			Timeout: server.Timeout,
+			Retries: server.Retries,
+		},
+		NasIdentifier: server.NasIdentifier,
+	}
+	if dnAAAServer.NasIdentifier == "" {
+		dnAAAServer.NasIdentifier = smfContext.NfInstanceID
+	}
+	return dnAAAServer
+}
+
+// SecondaryAuthentication is the state of the authentication of a PDU session by the DN-AAA
+// server, TS 23.502 4.3.2.3
+type SecondaryAuthentication struct {
+	// the establishment goes on with this request once the UE is authenticated
+	EstablishmentRequest *nasMessage.PDUSessionEstablishmentRequest
+	Authenticated        bool
+	// identifier of the last EAP request sent to the UE
+	EAPIdentifier uint8
+	UserName      string
+	// State attribute of the last Access-Challenge
+	RadiusState []byte
+	// Class attribute of the Access-Accept
+	RadiusClass []byte
+	// EAP-Success or EAP-Failure of the DN-AAA server, sent in the PDU Session Establishment
+	// Accept or Reject
+	EAPResult []byte
+	// guards the answer of the UE to the last EAP request
+	EAPTimer *time.Timer
+
+	// authorized by the DN-AAA server
+	FramedIPAddress net.IP
+	SessionTimeout  time.Duration
+	sessionExpiry   time.Time
+	sessionTimer    *time.Timer
+}
+
+// NewEAPRequestIdentity returns the EAP-Request/Identity starting the authentication
+func NewEAPRequestIdentity(identifier uint8) []byte {
+	return []byte{EAPCodeRequest, identifier, 0, 5, EAPTypeIdentity}
+}
+
+// NewEAPFailure returns the EAP-Failure sent to the UE when the DN-AAA server does not answer
+func NewEAPFailure(identifier uint8) []byte {
+	return []byte{EAPCodeFailure, identifier, 0, 4}
+}
+
+// EAPIdentity returns the identity of an EAP-Response/Identity
+func EAPIdentity(eap []byte) (string, bool) {
+	if len(eap) < 5 || eap[0] != EAPCodeResponse || eap[4] != EAPTypeIdentity {
+		return "", false
+	}
+	length := int(eap[2])<<8 | int(eap[3])
+	if length < 5 || length > len(eap) {
+		return "", false
+	}
+	return string(eap[5:length]), true
+}
+
+// NewAccessRequest returns the Access-Request carrying the EAP packet of the UE to the DN-AAA
+// server, TS 29.561 16.4
+func (smContext *SMContext) NewAccessRequest(eap []byte) (*radius.Packet, error) {
+	auth := smContext.SecondaryAuth
+	if identity, ok := EAPIdentity(eap); ok {
+		auth.UserName = identity
+	}
+
+	request, err := radius.NewRequest(radius.CodeAccessRequest)
+	if err != nil {
+		return nil, err
+	}
+	if auth.UserName != "" {
+		request.AddString(radius.AttrUserName, auth.UserName)
+	}
+	request.AddString(radius.AttrNASIdentifier, smContext.DNNInfo.SecondaryAuth.NasIdentifier)
+	if smfContext.CPNodeID.NodeIdType == pfcpType.NodeIdTypeIpv4Address {
+		request.AddIPv4(radius.AttrNASIPAddress, smfContext.CPNodeID.IP)
+	}
+	request.AddUint32(radius.AttrServiceType, radiusServiceTypeFramed)
+	request.AddUint32(radius.AttrFramedProtocol, radiusFramedProtocolGPRSPDP)
+	request.AddString(radius.AttrCalledStationID, smContext.Dnn)
+	if strings.HasPrefix(smContext.Gpsi, "msisdn-") {
+		request.AddString(radius.AttrCallingStationID, strings.TrimPrefix(smContext.Gpsi, "msisdn-"))
+	}
+	if auth.RadiusState != nil {
+		request.Add(radius.AttrState, auth.RadiusState)
+	}
+	request.SetEAPMessage(eap)
+	return request, nil
+}
+
+// StartSessionTimeout calls expired when the session authorized by the DN-AAA server times out.
+// A restored session times out when it would have before the SMF restarted.
+func (smContext *SMContext) StartSessionTimeout(expired func()) {
+	auth := smContext.SecondaryAuth
+	if auth == nil || auth.sessionTimer != nil {
+		return
+	}
+	if auth.sessionExpiry.IsZero() {
+		if auth.SessionTimeout <= 0 {
+			return
+		}
+		auth.sessionExpiry = time.Now().Add(auth.SessionTimeout)
+	}
+	auth.sessionTimer = time.AfterFunc(time.Until(auth.sessionExpiry), expired)
+}
+
+// StopSessionTimeout cancels the session timeout of the DN-AAA server
+func (smContext *SMContext) StopSessionTimeout() {
+	if auth := smContext.SecondaryAuth; auth != nil && auth.sessionTimer != nil {
+		auth.sessionTimer.Stop()
+		auth.sessionTimer = nil
+	}
+}
+
+// StopEAPTimer cancels the guard timer of the EAP request sent to the UE
+func (smContext *SMContext) StopEAPTimer() {
+	if auth := smContext.SecondaryAuth; auth != nil && auth.EAPTimer != nil {
+		auth.EAPTimer.Stop()
+		auth.EAPTimer = nil
+	}
+}
@@ -0,0 +1,785 @@
+package context
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/util/idgenerator"
+)
+
+// SessionStore persists the state of the PDU sessions, so that a restarted SMF can resume
+// them. FileSessionStore is the embedded backend, other ones are set with SetSessionStore.
+type SessionStore interface {
+	// Put creates or replaces the record with the same Ref
+	Put(record *SessionRecord) error
+	Delete(ref string) error
+	LoadAll() ([]*SessionRecord, error)
+	// the PFCP Recovery Time Stamp of the SMF, kept across restarts so that the UPFs do not
+	// delete the PFCP sessions of the SMF
+	PutRecoveryTimeStamp(timeStamp time.Time) error
+	LoadRecoveryTimeStamp() (time.Time, error)
+}
+
+var sessionStore SessionStore
+
+func SetSessionStore(store SessionStore) {
+	sessionStore = store
+}
+
+func GetSessionStore() SessionStore {
+	return sessionStore
+}
+
+// SessionRecord is the persisted state of a SMContext
+type SessionRecord struct {
+	Ref                 string                  `json:"ref"`
+	State               SMContextState          `json:"state"`
+	UnauthenticatedSupi bool                    `json:"unauthenticatedSupi,omitempty"`
+	Supi                string                  `json:"supi,omitempty"`
+	Pei                 string                  `json:"pei,omitempty"`
+	Identifier          string                  `json:"identifier"`
+	Gpsi                string                  `json:"gpsi,omitempty"`
+	PDUSessionID        int32                   `json:"pduSessionId"`
+	Dnn                 string                  `json:"dnn"`
+	Snssai              *models.Snssai          `json:"snssai"`
+	HplmnSnssai         *models.Snssai          `json:"hplmnSnssai,omitempty"`
+	ServingNetwork      *models.PlmnId          `json:"servingNetwork,omitempty"`
+	ServingNfId         string                  `json:"servingNfId,omitempty"`
+	UpCnxState          models.UpCnxState       `json:"upCnxState,omitempty"`
+	AnType              models.AccessType       `json:"anType,omitempty"`
+	RatType             models.RatType          `json:"ratType,omitempty"`
+	UeLocation          *models.UserLocation    `json:"ueLocation,omitempty"`
+	UeTimeZone          string                  `json:"ueTimeZone,omitempty"`
+	PDUAddress          net.IP                  `json:"pduAddress,omitempty"`
+	FramedRoutes        []string                `json:"framedRoutes,omitempty"`
+	PDUSessionType      uint8                   `json:"pduSessionType"`
+	DnnConfiguration    models.DnnConfiguration `json:"dnnConfiguration"`
+	UpSecurity          *models.UpSecurity      `json:"upSecurity,omitempty"`
+	Pti                 uint8                   `json:"pti,omitempty"`
+
+	// Policy and NF
+	SMPolicyID         string                       `json:"smPolicyId,omitempty"`
+	OnLocalPolicy      bool                         `json:"onLocalPolicy,omitempty"`
+	UECMRegistered     bool                         `json:"uecmRegistered,omitempty"`
+	SdmSubscriptionID  string                       `json:"sdmSubscriptionId,omitempty"`
+	PCFProfile         models.NfProfile             `json:"pcfProfile"`
+	AMFProfile         models.NfProfile             `json:"amfProfile"`
+	SmStatusNotifyUri  string                       `json:"smStatusNotifyUri,omitempty"`
+	SessionRules       []*models.SessionRule        `json:"sessionRules,omitempty"`
+	ActiveSessionRule  string                       `json:"activeSessionRule,omitempty"`
+	PCCRules           []*PCCRuleRecord             `json:"pccRules,omitempty"`
+	TrafficControlData []*models.TrafficControlData `json:"trafficControlData,omitempty"`
+
+	// User plane
+	LocalSEID       uint64                        `json:"localSeid"`
+	SelectedUPF     string                        `json:"selectedUpf,omitempty"`
+	PFCPSessions    []*PFCPSessionRecord          `json:"pfcpSessions,omitempty"`
+	ANInformation   *ANInformationRecord          `json:"anInformation,omitempty"`
+	DataPaths       []*DataPathRecord             `json:"dataPaths,omitempty"`
+	ProtocolOptions *ProtocolConfigurationOptions `json:"protocolConfigurationOptions,omitempty"`
+
+	// DN-AAA server
+	SecondaryAuth *SecondaryAuthRecord `json:"secondaryAuth,omitempty"`
+	Accounting    *AccountingRecord    `json:"accounting,omitempty"`
+}
+
+// SecondaryAuthRecord is the authorization of an authenticated session by the DN-AAA server
+type SecondaryAuthRecord struct {
+	UserName        string    `json:"userName,omitempty"`
+	RadiusClass     []byte    `json:"radiusClass,omitempty"`
+	FramedIPAddress net.IP    `json:"framedIpAddress,omitempty"`
+	SessionExpiry   time.Time `json:"sessionExpiry,omitempty"`
+}
+
+// AccountingRecord is the RADIUS accounting session of a session, resumed without a new Start.
+// The usage reported since the session was last persisted is not counted after a restart.
+type AccountingRecord struct {
+	SessionID    string    `json:"sessionId"`
+	StartTime    time.Time `json:"startTime"`
+	InputOctets  uint64    `json:"inputOctets"`
+	OutputOctets uint64    `json:"outputOctets"`
+}
+
+type PFCPSessionRecord struct {
+	NodeID     pfcpType.NodeID `json:"nodeId"`
+	LocalSEID  uint64          `json:"localSeid"`
+	RemoteSEID uint64          `json:"remoteSeid"`
+	UPFFQCSID  *FQCSID         `json:"upfFqCsid,omitempty"`
+}
+
+type ANInformationRecord struct {
+	IPAddress net.IP `json:"ipAddress"`
+	TEID      uint32 `json:"teid"`
+}
+
+type DataPathRecord struct {
+	PathID        int64                 `json:"pathId"`
+	Activated     bool                  `json:"activated"`
+	IsDefaultPath bool                  `json:"isDefaultPath"`
+	Destination   Destination           `json:"destination"`
+	Nodes         []*DataPathNodeRecord `json:"nodes"`
+}
+
+// DataPathNodeRecord is a UPF on the data path, from the AN to the DN
+type DataPathNodeRecord struct {
+	UPF          string `json:"upf"`
+	UpLinkTEID   uint32 `json:"upLinkTeid,omitempty"`
+	UpLinkPDR    *PDR   `json:"upLinkPdr,omitempty"`
+	DownLinkTEID uint32 `json:"downLinkTeid,omitempty"`
+	DownLinkPDR  *PDR   `json:"downLinkPdr,omitempty"`
+}
+
+// PCCRuleRecord is a PCC rule of the session, with its rules on the UPFs while it is enforced
+type PCCRuleRecord struct {
+	Rule      *models.PccRule     `json:"rule"`
+	QosData   *models.QosData     `json:"qosData,omitempty"`
+	QFI       uint8               `json:"qfi,omitempty"`
+	QoSRuleID uint8               `json:"qosRuleId,omitempty"`
+	UPFs      []*PCCRuleUPFRecord `json:"upfs,omitempty"`
+}
+
+type PCCRuleUPFRecord struct {
+	UPF  string `json:"upf"`
+	PDRs []*PDR `json:"pdrs"`
+	QER  *QER   `json:"qer,omitempty"`
+}
+
+// StoreSMContext persists the state of the SMContext if a session store is set
+func StoreSMContext(smContext *SMContext) {
+	if sessionStore == nil {
+		return
+	}
+	if GetSMContextByRef(smContext.Ref) != smContext {
+		// already removed
+		return
+	}
+	if smContext.BPManager != nil {
+		// the record of the session before the UL CL was inserted is stale
+		logger.CtxLog.Debugf("UE[%s] PDUSessionID[%d] with UL CL is not persisted, it is lost on restart",
+			smContext.Supi, smContext.PDUSessionID)
+		unstoreSMContext(smContext.Ref)
+		return
+	}
+	if err := sessionStore.Put(smContext.toRecord()); err != nil {
+		logger.CtxLog.Errorf("Persist UE[%s] PDUSessionID[%d] failed: %v", smContext.Supi, smContext.PDUSessionID, err)
+	}
+}
+
+func unstoreSMContext(ref string) {
+	if sessionStore == nil {
+		return
+	}
+	if err := sessionStore.Delete(ref); err != nil {
+		logger.CtxLog.Errorf("Delete persisted SMContext[%s] failed: %v", ref, err)
+	}
+}
+
+func (smContext *SMContext) toRecord() *SessionRecord {
+	record := &SessionRecord{
+		Ref:                 smContext.Ref,
+		State:               smContext.SMContextState,
+		UnauthenticatedSupi: smContext.UnauthenticatedSupi,
+		Supi:                smContext.Supi,
+		Pei:                 smContext.Pei,
+		Identifier:          smContext.Identifier,
+		Gpsi:                smContext.Gpsi,
+		PDUSessionID:        smContext.PDUSessionID,
+		Dnn:                 smContext.Dnn,
+		Snssai:              smContext.Snssai,
+		HplmnSnssai:         smContext.HplmnSnssai,
+		ServingNetwork:      smContext.ServingNetwork,
+		ServingNfId:         smContext.ServingNfId,
+		UpCnxState:          smContext.UpCnxState,
+		AnType:              smContext.AnType,
+		RatType:             smContext.RatType,
+		UeLocation:          smContext.UeLocation,
+		UeTimeZone:          smContext.UeTimeZone,
+		PDUAddress:          smContext.PDUAddress,
+		FramedRoutes:        smContext.FramedRoutes,
+		PDUSessionType:      smContext.SelectedPDUSessionType,
+		DnnConfiguration:    smContext.DnnConfiguration,
+		UpSecurity:          smContext.UpSecurity,
+		Pti:                 smContext.Pti,
+		SMPolicyID:          smContext.SMPolicyID,
+		OnLocalPolicy:       smContext.OnLocalPolicy,
+		UECMRegistered:      smContext.UECMRegistered,
+		SdmSubscriptionID:   smContext.SdmSubscriptionID,
+		PCFProfile:          smContext.SelectedPCFProfile,
+		AMFProfile:          smContext.AMFProfile,
+		SmStatusNotifyUri:   smContext.SmStatusNotifyUri,
+		LocalSEID:           smContext.LocalSEID,
+		ProtocolOptions:     smContext.ProtocolConfigurationOptions,
+	}
+
+	for _, rule := range smContext.SessionRules {
+		record.SessionRules = append(record.SessionRules, &models.SessionRule{
+			SessRuleId:   rule.SessionRuleID,
+			AuthSessAmbr: rule.AuthSessAmbr,
+			AuthDefQos:   rule.AuthDefQos,
+		})
+		if rule.isActivate {
+			record.ActiveSessionRule = rule.SessionRuleID
+		}
+	}
+	for _, rule := range smContext.PCCRules {
+		pccRule := &models.PccRule{
+			PccRuleId:  rule.PCCRuleID,
+			Precedence: rule.Precedence,
+			AppId:      rule.AppID,
+			FlowInfos:  rule.FlowInfos,
+		}
+		if tcID := rule.RefTrafficControlData(); tcID != "" {
+			pccRule.RefTcData = []string{tcID}
+		}
+		pccRuleRecord := &PCCRuleRecord{
+			Rule:      pccRule,
+			QosData:   rule.QosData,
+			QFI:       rule.QFI,
+			QoSRuleID: rule.QoSRuleID,
+		}
+		for _, ruleUPF := range rule.UPFs {
+			pccRuleRecord.UPFs = append(pccRuleRecord.UPFs, &PCCRuleUPFRecord{
+				UPF:  GetUserPlaneInformation().GetUPFNameByIp(ruleUPF.UPF.NodeID.ResolveNodeIdToIp().String()),
+				PDRs: ruleUPF.PDRs,
+				QER:  ruleUPF.QER,
+			})
+		}
+		record.PCCRules = append(record.PCCRules, pccRuleRecord)
+	}
+	for _, tc := range smContext.TrafficControlPool {
+		record.TrafficControlData = append(record.TrafficControlData, &models.TrafficControlData{
+			TcId:           tc.TrafficControlID,
+			FlowStatus:     tc.FlowStatus,
+			RouteToLocs:    tc.RouteToLocs,
+			UpPathChgEvent: tc.UpPathChgEvent,
+		})
+	}
+
+	if smContext.SelectedUPF != nil {
+		record.SelectedUPF = GetUserPlaneInformation().GetUPFNameByIp(
+			smContext.SelectedUPF.NodeID.ResolveNodeIdToIp().String())
+	}
+	for _, pfcpSessionContext := range smContext.PFCPContext {
+		record.PFCPSessions = append(record.PFCPSessions, &PFCPSessionRecord{
+			NodeID:     pfcpSessionContext.NodeID,
+			LocalSEID:  pfcpSessionContext.LocalSEID,
+			RemoteSEID: pfcpSessionContext.RemoteSEID,
+			UPFFQCSID:  pfcpSessionContext.UPFFQCSID,
+		})
+	}
+
+	if auth := smContext.SecondaryAuth; auth != nil && auth.Authenticated {
+		record.SecondaryAuth = &SecondaryAuthRecord{
+			UserName:        auth.UserName,
+			RadiusClass:     auth.RadiusClass,
+			FramedIPAddress: auth.FramedIPAddress,
+			SessionExpiry:   auth.sessionExpiry,
+		}
+	}
+	if acct := smContext.Accounting; acct != nil {
+		acct.mu.Lock()
+		record.Accounting = &AccountingRecord{
+			SessionID:    acct.SessionID,
+			StartTime:    acct.StartTime,
+			InputOctets:  acct.InputOctets,
+			OutputOctets: acct.OutputOctets,
+		}
+		acct.mu.Unlock()
+	}
+
+	if tunnel := smContext.Tunnel; tunnel != nil {
+		record.ANInformation = &ANInformationRecord{
+			IPAddress: tunnel.ANInformation.IPAddress,
+			TEID:      tunnel.ANInformation.TEID,
+		}
+		for pathID, dataPath := range tunnel.DataPathPool {
+			record.DataPaths = append(record.DataPaths, dataPath.toRecord(pathID))
+		}
+	}
+
+	return record
+}
+
+func (dataPath *DataPath) toRecord(pathID int64) *DataPathRecord {
+	record := &DataPathRecord{
+		PathID:        pathID,
+		Activated:     dataPath.Activated,
+		IsDefaultPath: dataPath.IsDefaultPath,
+		Destination:   dataPath.Destination,
+	}
+	upi := GetUserPlaneInformation()
+	for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+		nodeRecord := &DataPathNodeRecord{
+			UPF: upi.GetUPFNameByIp(node.UPF.NodeID.ResolveNodeIdToIp().String()),
+		}
+		if node.UpLinkTunnel != nil {
+			nodeRecord.UpLinkTEID = node.UpLinkTunnel.TEID
+			nodeRecord.UpLinkPDR = node.UpLinkTunnel.PDR
+		}
+		if node.DownLinkTunnel != nil {
+			nodeRecord.DownLinkTEID = node.DownLinkTunnel.TEID
+			nodeRecord.DownLinkPDR = node.DownLinkTunnel.PDR
+		}
+		record.Nodes = append(record.Nodes, nodeRecord)
+	}
+	return record
+}
+
+// RestoreSMContexts rebuilds the SMContexts of the PDU sessions persisted before the SMF
+// restarted, with the UE IP addresses, TEIDs, SEIDs and PFCP rule IDs they were allocated.
+// The PFCP sessions are kept by the UPFs, as the SMF keeps its Recovery Time Stamp.
+func RestoreSMContexts() int {
+	if sessionStore == nil {
+		return 0
+	}
+	records, err := sessionStore.LoadAll()
+	if err != nil {
+		logger.CtxLog.Errorf("Load persisted SMContexts failed: %v", err)
+		return 0
+	}
+
+	restored := make([]*SMContext, 0, len(records))
+	for _, record := range records {
+		switch record.State {
+		case Active, ModificationPending, PFCPModification:
+		default:
+			// the session was being established or released
+			logger.CtxLog.Infof("Discard persisted UE[%s] PDUSessionID[%d] in state %s",
+				record.Supi, record.PDUSessionID, record.State)
+			unstoreSMContext(record.Ref)
+			continue
+		}
+		smContext, err := restoreSMContext(record)
+		if err != nil {
+			logger.CtxLog.Warnf("Restore UE[%s] PDUSessionID[%d] failed: %v", record.Supi, record.PDUSessionID, err)
+			unstoreSMContext(record.Ref)
+			continue
+		}
+		restored = append(restored, smContext)
+	}
+
+	if err := reserveRuleIDs(restored); err != nil {
+		logger.CtxLog.Errorf("Reserve the PFCP rule IDs and TEIDs of the restored SMContexts failed: %v", err)
+	}
+	logger.CtxLog.Infof("Restored %d of %d persisted SMContexts", len(restored), len(records))
+	return len(restored)
+}
+
+func restoreSMContext(record *SessionRecord) (*SMContext, error) {
+	upi := GetUserPlaneInformation()
+	record.normalizeIPs()
+
+	smContext := &SMContext{
+		Ref:                          record.Ref,
+		SMContextState:               Active,
+		UnauthenticatedSupi:          record.UnauthenticatedSupi,
+		Supi:                         record.Supi,
+		Pei:                          record.Pei,
+		Identifier:                   record.Identifier,
+		Gpsi:                         record.Gpsi,
+		PDUSessionID:                 record.PDUSessionID,
+		Dnn:                          record.Dnn,
+		Snssai:                       record.Snssai,
+		HplmnSnssai:                  record.HplmnSnssai,
+		ServingNetwork:               record.ServingNetwork,
+		ServingNfId:                  record.ServingNfId,
+		UpCnxState:                   record.UpCnxState,
+		AnType:                       record.AnType,
+		RatType:                      record.RatType,
+		UeLocation:                   record.UeLocation,
+		UeTimeZone:                   record.UeTimeZone,
+		PDUAddress:                   record.PDUAddress,
+		FramedRoutes:                 record.FramedRoutes,
+		SelectedPDUSessionType:       record.PDUSessionType,
+		DnnConfiguration:             record.DnnConfiguration,
+		UpSecurity:                   record.UpSecurity,
+		Pti:                          record.Pti,
+		SMPolicyID:                   record.SMPolicyID,
+		OnLocalPolicy:                record.OnLocalPolicy,
+		UECMRegistered:               record.UECMRegistered,
+		SdmSubscriptionID:            record.SdmSubscriptionID,
+		SelectedPCFProfile:           record.PCFProfile,
+		AMFProfile:                   record.AMFProfile,
+		SmStatusNotifyUri:            record.SmStatusNotifyUri,
+		LocalSEID:                    record.LocalSEID,
+		PFCPContext:                  make(map[string]*PFCPSessionContext),
+		PCCRules:                     make(map[string]*PCCRule),
+		SessionRules:                 make(map[string]*SessionRule),
+		TrafficControlPool:           make(map[string]*TrafficControlData),
+		ProtocolConfigurationOptions: record.ProtocolOptions,
+	}
+	if smContext.ProtocolConfigurationOptions == nil {
+		smContext.ProtocolConfigurationOptions = &ProtocolConfigurationOptions{}
+	}
+	smContext.restoreSBIClients()
+	if smContext.Snssai != nil {
+		smContext.DNNInfo = RetrieveDnnInformation(smContext.Snssai, smContext.Dnn)
+	}
+
+	if auth := record.SecondaryAuth; auth != nil {
+		smContext.SecondaryAuth = &SecondaryAuthentication{
+			Authenticated:   true,
+			UserName:        auth.UserName,
+			RadiusClass:     auth.RadiusClass,
+			FramedIPAddress: ipv4In4Bytes(auth.FramedIPAddress),
+			sessionExpiry:   auth.SessionExpiry,
+		}
+	}
+	if acct := record.Accounting; acct != nil {
+		smContext.resumeAccounting(acct)
+	}
+
+	for _, model := range record.SessionRules {
+		rule := NewSessionRuleFromModel(model)
+		SetSessionRuleActivateState(rule, rule.SessionRuleID == record.ActiveSessionRule)
+		smContext.SessionRules[rule.SessionRuleID] = rule
+	}
+	for _, model := range record.TrafficControlData {
+		smContext.TrafficControlPool[model.TcId] = NewTrafficControlDataFromModel(model)
+	}
+	for _, pccRuleRecord := range record.PCCRules {
+		rule := NewPCCRuleFromModel(pccRuleRecord.Rule)
+		if rule == nil {
+			continue
+		}
+		rule.QosData = pccRuleRecord.QosData
+		smContext.PCCRules[rule.PCCRuleID] = rule
+		if tc, exist := smContext.TrafficControlPool[rule.RefTrafficControlData()]; exist {
+			tc.AddRefedPCCRules(rule.PCCRuleID)
+		}
+	}
+
+	if record.SelectedUPF != "" {
+		upNode, exist := upi.UPNodes[record.SelectedUPF]
+		if !exist || upNode.Type != UPNODE_UPF {
+			return nil, fmt.Errorf("UPF[%s] not found", record.SelectedUPF)
+		}
+		smContext.SelectedUPF = upNode
+		if smContext.PDUAddress != nil && !upi.ReserveUEIP(upNode, smContext.PDUAddress) {
+			return nil, fmt.Errorf("UE IP address %s is not available", smContext.PDUAddress)
+		}
+	}
+
+	for _, pfcpSession := range record.PFCPSessions {
+		smContext.PFCPContext[pfcpSession.NodeID.ResolveNodeIdToIp().String()] = &PFCPSessionContext{
+			PDRs:       make(map[uint16]*PDR),
+			NodeID:     pfcpSession.NodeID,
+			LocalSEID:  pfcpSession.LocalSEID,
+			RemoteSEID: pfcpSession.RemoteSEID,
+			UPFFQCSID:  pfcpSession.UPFFQCSID,
+		}
+	}
+
+	smContext.Tunnel = NewUPTunnel()
+	if record.ANInformation != nil {
+		smContext.Tunnel.UpdateANInformation(record.ANInformation.IPAddress, record.ANInformation.TEID)
+	}
+	pathIDs := make([]int64, 0, len(record.DataPaths))
+	for _, dataPathRecord := range record.DataPaths {
+		dataPath, err := smContext.restoreDataPath(dataPathRecord)
+		if err != nil {
+			if smContext.SelectedUPF != nil && smContext.PDUAddress != nil {
+				upi.ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress)
+			}
+			return nil, err
+		}
+		smContext.Tunnel.DataPathPool[dataPathRecord.PathID] = dataPath
+		pathIDs = append(pathIDs, dataPathRecord.PathID)
+	}
+	if err := reserveIDs(smContext.Tunnel.PathIDGenerator, pathIDs); err != nil {
+		logger.CtxLog.Warnf("Reserve data path IDs failed: %v", err)
+	}
+	for _, pccRuleRecord := range record.PCCRules {
+		if rule, exist := smContext.PCCRules[pccRuleRecord.Rule.PccRuleId]; exist {
+			if err := smContext.restorePCCRuleUPFs(rule, pccRuleRecord); err != nil {
+				if smContext.SelectedUPF != nil && smContext.PDUAddress != nil {
+					upi.ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress)
+				}
+				return nil, err
+			}
+		}
+	}
+
+	smContextPool.Store(smContext.Ref, smContext)
+	canonicalRef.Store(canonicalName(smContext.Identifier, smContext.PDUSessionID), smContext.Ref)
+	for _, pfcpSessionContext := range smContext.PFCPContext {
+		seidSMContextMap.Store(pfcpSessionContext.LocalSEID, smContext)
+		advanceCounter(&smfContext.LocalSEIDCount, pfcpSessionContext.LocalSEID)
+	}
+	advanceCounter(&smContextCount, smContext.LocalSEID)
+
+	return smContext, nil
+}
+
+// normalizeIPs restores the 4-byte form of the IPv4 addresses, which JSON decodes in 16 bytes
+func (record *SessionRecord) normalizeIPs() {
+	record.PDUAddress = ipv4In4Bytes(record.PDUAddress)
+	for _, pfcpSession := range record.PFCPSessions {
+		if pfcpSession.NodeID.NodeIdType == pfcpType.NodeIdTypeIpv4Address {
+			pfcpSession.NodeID.IP = ipv4In4Bytes(pfcpSession.NodeID.IP)
+		}
+	}
+	if record.ANInformation != nil {
+		record.ANInformation.IPAddress = ipv4In4Bytes(record.ANInformation.IPAddress)
+	}
+	var pdrs []*PDR
+	for _, dataPath := range record.DataPaths {
+		for _, node := range dataPath.Nodes {
+			pdrs = append(pdrs, node.UpLinkPDR, node.DownLinkPDR)
+		}
+	}
+	for _, pccRule := range record.PCCRules {
+		for _, ruleUPF := range pccRule.UPFs {
+			pdrs = append(pdrs, ruleUPF.PDRs...)
+		}
+	}
+	for _, pdr := range pdrs {
+		if pdr == nil {
+			continue
+		}
+		if fteid := pdr.PDI.LocalFTeid; fteid != nil {
+			fteid.Ipv4Address = ipv4In4Bytes(fteid.Ipv4Address)
+		}
+		if ueIP := pdr.PDI.UEIPAddress; ueIP != nil {
+			ueIP.Ipv4Address = ipv4In4Bytes(ueIP.Ipv4Address)
+		}
+		if pdr.FAR != nil && pdr.FAR.ForwardingParameters != nil {
+			if ohc := pdr.FAR.ForwardingParameters.OuterHeaderCreation; ohc != nil {
+				ohc.Ipv4Address = ipv4In4Bytes(ohc.Ipv4Address)
+			}
+		}
+	}
+}
+
+func ipv4In4Bytes(ip net.IP) net.IP {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4
+	}
+	return ip
+}
+
+func (smContext *SMContext) restoreDataPath(record *DataPathRecord) (*DataPath, error) {
+	upi := GetUserPlaneInformation()
+
+	dataPath := NewDataPath()
+	dataPath.Activated = record.Activated
+	dataPath.IsDefaultPath = record.IsDefaultPath
+	dataPath.Destination = record.Destination
+
+	var prev *DataPathNode
+	for _, nodeRecord := range record.Nodes {
+		upNode, exist := upi.UPNodes[nodeRecord.UPF]
+		if !exist || upNode.Type != UPNODE_UPF {
+			return nil, fmt.Errorf("UPF[%s] of the data path not found", nodeRecord.UPF)
+		}
+
+		node := NewDataPathNode()
+		node.UPF = upNode.UPF
+		node.UpLinkTunnel.TEID = nodeRecord.UpLinkTEID
+		node.UpLinkTunnel.PDR = nodeRecord.UpLinkPDR
+		node.DownLinkTunnel.TEID = nodeRecord.DownLinkTEID
+		node.DownLinkTunnel.PDR = nodeRecord.DownLinkPDR
+		node.UpLinkTunnel.DestEndPoint = node
+		node.DownLinkTunnel.DestEndPoint = node
+		for _, pdr := range []*PDR{node.UpLinkTunnel.PDR, node.DownLinkTunnel.PDR} {
+			if pdr == nil {
+				continue
+			}
+			if err := smContext.PutPDRtoPFCPSession(node.UPF.NodeID, pdr); err != nil {
+				return nil, err
+			}
+		}
+
+		if prev == nil {
+			dataPath.FirstDPNode = node
+		} else {
+			prev.AddNext(node)
+			node.AddPrev(prev)
+		}
+		prev = node
+	}
+	return dataPath, nil
+}
+
+// restorePCCRuleUPFs puts the PDRs of an enforced PCC rule in the PFCP sessions of their UPFs
+func (smContext *SMContext) restorePCCRuleUPFs(rule *PCCRule, record *PCCRuleRecord) error {
+	upi := GetUserPlaneInformation()
+	for _, ruleUPFRecord := range record.UPFs {
+		upNode, exist := upi.UPNodes[ruleUPFRecord.UPF]
+		if !exist || upNode.Type != UPNODE_UPF {
+			return fmt.Errorf("UPF[%s] of PccRule[%s] not found", ruleUPFRecord.UPF, rule.PCCRuleID)
+		}
+		for _, pdr := range ruleUPFRecord.PDRs {
+			if err := smContext.PutPDRtoPFCPSession(upNode.UPF.NodeID, pdr); err != nil {
+				return err
+			}
+		}
+		rule.UPFs = append(rule.UPFs, &PCCRuleUPF{
+			UPF:  upNode.UPF,
+			PDRs: ruleUPFRecord.PDRs,
+			QER:  ruleUPFRecord.QER,
+		})
+	}
+	rule.QFI = record.QFI
+	rule.QoSRuleID = record.QoSRuleID
+	return nil
+}
+
+// reserveRuleIDs allocates the PDR, FAR, BAR, QER and URR IDs and the TEIDs of the restored
+// SMContexts from the generators of their UPFs, and shares the FARs, QERs and URRs between their
+// PDRs
+func reserveRuleIDs(smContexts []*SMContext) error {
+	type upfIDs struct {
+		pdrIDs, farIDs, barIDs, qerIDs, urrIDs, teids []int64
+	}
+	ids := make(map[*UPF]*upfIDs)
+
+	for _, smContext := range smContexts {
+		for _, dataPath := range smContext.Tunnel.DataPathPool {
+			for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+				upf := node.UPF
+				if ids[upf] == nil {
+					ids[upf] = new(upfIDs)
+				}
+				upfIDs := ids[upf]
+				for _, tunnel := range []*GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+					if tunnel.TEID != 0 {
+						upfIDs.teids = append(upfIDs.teids, int64(tunnel.TEID))
+					}
+				}
+			}
+		}
+
+		for nodeIP, pfcpSessionContext := range smContext.PFCPContext {
+			upf := RetrieveUPFNodeByNodeID(pfcpSessionContext.NodeID)
+			if upf == nil {
+				return fmt.Errorf("UPF[%s] not found", nodeIP)
+			}
+			if ids[upf] == nil {
+				ids[upf] = new(upfIDs)
+			}
+			upfIDs := ids[upf]
+			fars := make(map[uint32]*FAR)
+			qers := make(map[uint32]*QER)
+			urrs := make(map[uint32]*URR)
+			for _, pdr := range pfcpSessionContext.PDRs {
+				upfIDs.pdrIDs = append(upfIDs.pdrIDs, int64(pdr.PDRID))
+				upf.pdrPool.Store(pdr.PDRID, pdr)
+				// the PDRs of the PCC rules share the FARs of the default path
+				if far := pdr.FAR; far != nil {
+					if shared, exist := fars[far.FARID]; exist {
+						pdr.FAR = shared
+					} else {
+						fars[far.FARID] = far
+						upfIDs.farIDs = append(upfIDs.farIDs, int64(far.FARID))
+						upf.farPool.Store(far.FARID, far)
+						if bar := far.BAR; bar != nil {
+							upfIDs.barIDs = append(upfIDs.barIDs, int64(bar.BARID))
+							upf.barPool.Store(bar.BARID, bar)
+						}
+					}
+				}
+				for i, qer := range pdr.QER {
+					if shared, exist := qers[qer.QERID]; exist {
+						pdr.QER[i] = shared
+						continue
+					}
+					qers[qer.QERID] = qer
+					upfIDs.qerIDs = append(upfIDs.qerIDs, int64(qer.QERID))
+					upf.qerPool.Store(qer.QERID, qer)
+				}
+				if urr := pdr.URR; urr != nil {
+					if shared, exist := urrs[urr.URRID]; exist {
+						pdr.URR = shared
+					} else {
+						urrs[urr.URRID] = urr
+						upfIDs.urrIDs = append(upfIDs.urrIDs, int64(urr.URRID))
+						upf.urrPool.Store(urr.URRID, urr)
+					}
+				}
+			}
+			for _, rule := range smContext.PCCRules {
+				for _, ruleUPF := range rule.UPFs {
+					if ruleUPF.UPF != upf || ruleUPF.QER == nil {
+						continue
+					}
+					if shared, exist := qers[ruleUPF.QER.QERID]; exist {
+						ruleUPF.QER = shared
+					}
+				}
+			}
+		}
+	}
+
+	for upf, upfIDs := range ids {
+		for _, reserve := range []struct {
+			generator *idgenerator.IDGenerator
+			ids       []int64
+		}{
+			{upf.pdrIDGenerator, upfIDs.pdrIDs},
+			{upf.farIDGenerator, upfIDs.farIDs},
+			{upf.barIDGenerator, upfIDs.barIDs},
+			{upf.qerIDGenerator, upfIDs.qerIDs},
+			{upf.urrIDGenerator, upfIDs.urrIDs},
+			{upf.teidGenerator, upfIDs.teids},
+		} {
+			if err := reserveIDs(reserve.generator, reserve.ids); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reserveIDs allocates the IDs from a generator which has not allocated any yet
+func reserveIDs(generator *idgenerator.IDGenerator, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	reserved := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		reserved[id] = true
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	maxID := ids[len(ids)-1]
+
+	unused := make([]int64, 0)
+	for {
+		id, err := generator.Allocate()
+		if err != nil {
+			return err
+		}
+		if !reserved[id] {
+			unused = append(unused, id)
+		}
+		if id >= maxID {
+			break
+		}
+	}
+	for _, id := range unused {
+		generator.FreeID(id)
+	}
+	return nil
+}
+
+// advanceCounter makes the counter, incremented to allocate IDs, greater or equal to value
+func advanceCounter(counter *uint64, value uint64) {
+	for {
+		current := atomic.LoadUint64(counter)
+		if current >= value || atomic.CompareAndSwapUint64(counter, current, value) {
+			return
+		}
+	}
+}
+
+// restoreSBIClients creates the clients to the AMF and the PCF of a restored SMContext
+func (smContext *SMContext) restoreSBIClients() {
+	smContext.SetCommunicationClient()
+	smContext.setSMPolicyClient()
+}
@@ -0,0 +1,182 @@
+package context
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestRestoreSMContext(t *testing.T) {
+	upi := smfContext.UserPlaneInformation
+	defer func() { smfContext.UserPlaneInformation = upi }()
+	smfContext.UserPlaneInformation = NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"UPF": {Type: "UPF", NodeID: "10.4.0.11"},
+		},
+	})
+
+	nodeID := pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: net.ParseIP("10.4.0.11")}
+	sessionExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	record := &SessionRecord{
+		Ref:          "urn:uuid:0f3c2b1a-5e3f-4a6b-9a4e-2b1f5c1d7e91",
+		State:        Active,
+		Supi:         "imsi-208930000000001",
+		Identifier:   "imsi-208930000000001",
+		PDUSessionID: 1,
+		Dnn:          "internet",
+		LocalSEID:    1,
+		PFCPSessions: []*PFCPSessionRecord{{NodeID: nodeID, LocalSEID: 1, RemoteSEID: 2}},
+		SecondaryAuth: &SecondaryAuthRecord{
+			UserName:      "alice",
+			RadiusClass:   []byte("class"),
+			SessionExpiry: sessionExpiry,
+		},
+		DataPaths: []*DataPathRecord{
+			{
+				PathID:    1,
+				Activated: true,
+				Nodes: []*DataPathNodeRecord{
+					{
+						UPF:        "UPF",
+						UpLinkTEID: 1,
+						UpLinkPDR: &PDR{
+							PDRID: 1,
+							FAR:   &FAR{FARID: 1},
+							URR:   &URR{URRID: 1},
+							QER:   []*QER{{QERID: 1, SessionAMBR: true}},
+						},
+						DownLinkPDR: &PDR{
+							PDRID: 2,
+							FAR:   &FAR{FARID: 2},
+							URR:   &URR{URRID: 1},
+							QER:   []*QER{{QERID: 1, SessionAMBR: true}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	smContext, err := restoreSMContext(record)
+	require.NoError(t, err)
+	defer RemoveSMContext(smContext.Ref)
+	require.NoError(t, reserveRuleIDs([]*SMContext{smContext}))
+
+	// the authorization of the DN-AAA server is kept, and so is the session timeout
+	require.True(t, smContext.SecondaryAuth.Authenticated)
+	require.Equal(t, "alice", smContext.SecondaryAuth.UserName)
+	require.True(t, sessionExpiry.Equal(smContext.SecondaryAuth.sessionExpiry))
+	require.Equal(t, record.SecondaryAuth, smContext.toRecord().SecondaryAuth)
+
+	// the URR and the QER are shared by the uplink and downlink PDRs, and their IDs reserved
+	node := smContext.Tunnel.DataPathPool[1].FirstDPNode
+	node.UPF.UPFStatus = AssociatedSetUpSuccess
+	upLinkPDR, downLinkPDR := node.UpLinkTunnel.PDR, node.DownLinkTunnel.PDR
+	require.Same(t, upLinkPDR.URR, downLinkPDR.URR)
+	require.Same(t, upLinkPDR.QER[0], downLinkPDR.QER[0])
+	urr, err := node.UPF.AddURR()
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), urr.URRID)
+	qer, err := node.UPF.AddQER()
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), qer.QERID)
+}
+
+func TestRestoreSMContextPCCRules(t *testing.T) {
+	upi := smfContext.UserPlaneInformation
+	defer func() { smfContext.UserPlaneInformation = upi }()
+	smfContext.UserPlaneInformation = NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"UPF": {Type: "UPF", NodeID: "10.4.0.12"},
+		},
+	})
+
+	nodeID := pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: net.ParseIP("10.4.0.12")}
+	ambrQER := &QER{QERID: 1, SessionAMBR: true}
+	record := &SessionRecord{
+		Ref:          "urn:uuid:6b1e0c4d-2f7a-4c3e-8d5b-7a9f1e2c3d4b",
+		State:        Active,
+		Supi:         "imsi-208930000000002",
+		Identifier:   "imsi-208930000000002",
+		PDUSessionID: 1,
+		Dnn:          "internet",
+		LocalSEID:    2,
+		PFCPSessions: []*PFCPSessionRecord{{NodeID: nodeID, LocalSEID: 2, RemoteSEID: 3}},
+		PCCRules: []*PCCRuleRecord{
+			{
+				Rule: &models.PccRule{
+					PccRuleId:  "voice",
+					Precedence: 10,
+					FlowInfos: []models.FlowInformation{
+						{FlowDescription: "permit out 17 from 10.10.0.0/16 5060 to assigned"},
+					},
+					RefQosData: []string{"voice"},
+				},
+				QosData:   &models.QosData{QosId: "voice", Var5qi: 5},
+				QFI:       1,
+				QoSRuleID: 2,
+				UPFs: []*PCCRuleUPFRecord{
+					{
+						UPF: "UPF",
+						PDRs: []*PDR{
+							{
+								PDRID: 3,
+								FAR:   &FAR{FARID: 1},
+								QER:   []*QER{{QERID: 2, QFI: pfcpType.QFI{QFI: 1}}, ambrQER},
+							},
+						},
+						QER: &QER{QERID: 2, QFI: pfcpType.QFI{QFI: 1}},
+					},
+				},
+			},
+		},
+		DataPaths: []*DataPathRecord{
+			{
+				PathID:    1,
+				Activated: true,
+				Nodes: []*DataPathNodeRecord{
+					{
+						UPF:         "UPF",
+						UpLinkTEID:  1,
+						UpLinkPDR:   &PDR{PDRID: 1, FAR: &FAR{FARID: 1}, QER: []*QER{ambrQER}},
+						DownLinkPDR: &PDR{PDRID: 2, FAR: &FAR{FARID: 2}, QER: []*QER{ambrQER}},
+					},
+				},
+			},
+		},
+	}
+
+	smContext, err := restoreSMContext(record)
+	require.NoError(t, err)
+	defer RemoveSMContext(smContext.Ref)
+	require.NoError(t, reserveRuleIDs([]*SMContext{smContext}))
+
+	// the enforced rule keeps its QoS flow, and its PDR the FAR of the default uplink PDR
+	rule := smContext.PCCRules["voice"]
+	require.True(t, rule.Enforced())
+	require.Equal(t, uint8(1), rule.QFI)
+	require.Equal(t, uint8(2), rule.QoSRuleID)
+	require.Equal(t, int32(5), rule.QosData.Var5qi)
+	require.Len(t, rule.UPFs, 1)
+	pdr := rule.UPFs[0].PDRs[0]
+	node := smContext.Tunnel.DataPathPool[1].FirstDPNode
+	require.Same(t, node.UpLinkTunnel.PDR.FAR, pdr.FAR)
+	require.Same(t, rule.UPFs[0].QER, pdr.QER[0])
+	require.Same(t, node.UpLinkTunnel.PDR.QER[0], pdr.QER[1])
+	require.Same(t, pdr, smContext.PFCPContext["10.4.0.12"].PDRs[3])
+
+	// and its IDs stay reserved
+	node.UPF.UPFStatus = AssociatedSetUpSuccess
+	nextPDR, err := node.UPF.AddPDR()
+	require.NoError(t, err)
+	require.Equal(t, uint16(4), nextPDR.PDRID)
+	nextQER, err := node.UPF.AddQER()
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), nextQER.QERID)
+}
@@ -1,19 +1,18 @@
 package context
 
 import (
-	"context"
 	"fmt"
 	"net"
-	"net/http"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/antihax/optional"
 	"github.com/google/uuid"
 
 	"github.com/free5gc/nas/nasConvert"
 	"github.com/free5gc/nas/nasMessage"
-	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/Namf_Communication"
 	"github.com/free5gc/openapi/Nnrf_NFDiscovery"
 	"github.com/free5gc/openapi/Npcf_SMPolicyControl"
@@ -80,10 +79,18 @@ type SMContext struct {
 
 	PDUAddress             net.IP
 	SelectedPDUSessionType uint8
+	// IP subnets behind the UE, routed to it by the PSA
+	FramedRoutes []string
 
 	DnnConfiguration models.DnnConfiguration
 
 	SMPolicyID string
+	// the session follows the local policy of its DNN until the SM policy association is created
+	OnLocalPolicy bool
+	// the UDM knows the SMF serves the session, TS 23.502 4.3.2.2.1 step 4
+	UECMRegistered bool
+	// subscription to the changes of the SM subscription data
+	SdmSubscriptionID string
 
 	// UP Security support TS 29.502 R16 6.1.6.2.39
 	UpSecurity                                                     *models.UpSecurity
@@ -94,6 +101,7 @@ type SMContext struct {
 
 	// Client
 	SMPolicyClient      *Npcf_SMPolicyControl.APIClient
+	SMPolicyConf        *Npcf_SMPolicyControl.Configuration
 	CommunicationClient *Namf_Communication.APIClient
 
 	AMFProfile         models.NfProfile
@@ -109,13 +117,24 @@ type SMContext struct {
 	PFCPContext                         map[string]*PFCPSessionContext
 	PendingUPF                          PendingUPF
 	PDUSessionRelease_DUE_TO_DUP_PDU_ID bool
+	// TEID of the SMF GTP-U endpoint used when the SMF buffers downlink data
+	BufferingTEID uint32
+	// Network triggered service request, TS 23.502 4.2.3.3
+	PagingInProgress bool
+	DDNBackoffTimer  *time.Timer
 
 	DNNInfo *SnssaiSmfDnnInfo
+	// authentication by the DN-AAA server, nil if it is not required
+	SecondaryAuth *SecondaryAuthentication
+	// RADIUS accounting, nil if the session is not accounted
+	Accounting *Accounting
 
 	// SM Policy related
 	PCCRules           map[string]*PCCRule
 	SessionRules       map[string]*SessionRule
 	TrafficControlPool map[string]*TrafficControlData
+	// removed PCC rules whose rules are still installed on the UPFs
+	pccRulesToRemove []*PCCRule
 
 	// NAS
 	Pti                     uint8
@@ -205,8 +224,17 @@ func RemoveSMContext(ref string) {
 		seidSMContextMap.Delete(pfcpSessionContext.LocalSEID)
 	}
 
+	if smContext.BufferingTEID != 0 {
+		bufferingTEIDSMContextMap.Delete(smContext.BufferingTEID)
+	}
+	smContext.StopDDNBackoff()
+	smContext.StopSessionTimeout()
+	smContext.StopEAPTimer()
+	smContext.StopAccounting()
+
 	canonicalRef.Delete(canonicalName(smContext.Supi, smContext.PDUSessionID))
 	smContextPool.Delete(ref)
+	unstoreSMContext(ref)
 }
 
 //*** add unit test ***//
@@ -218,6 +246,22 @@ func GetSMContextBySEID(SEID uint64) *SMContext {
 	return nil
 }
 
+// ListSMContexts returns all the SMContexts, ordered by SUPI and PDU session ID
+func ListSMContexts() []*SMContext {
+	smContexts := make([]*SMContext, 0)
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContexts = append(smContexts, value.(*SMContext))
+		return true
+	})
+	sort.Slice(smContexts, func(i, j int) bool {
+		if smContexts[i].Supi != smContexts[j].Supi {
+			return smContexts[i].Supi < smContexts[j].Supi
+		}
+		return smContexts[i].PDUSessionID < smContexts[j].PDUSessionID
+	})
+	return smContexts
+}
+
 //*** add unit test ***//
 func (smContext *SMContext) SetCreateData(createData *models.SmContextCreateData) {
 	smContext.Gpsi = createData.Gpsi
@@ -258,6 +302,13 @@ func (smContext *SMContext) PDUAddressToNAS() ([12]byte, uint8) {
 
 // PCFSelection will select PCF for this SM Context
 func (smContext *SMContext) PCFSelection() error {
+	// the SCP selects the PCF of the requests with delegated discovery
+	if DelegatedDiscovery() {
+		smContext.SelectedPCFProfile = models.NfProfile{}
+		smContext.setSMPolicyClient()
+		return nil
+	}
+
 	// Send NFDiscovery for find PCF
 	localVarOptionals := Nnrf_NFDiscovery.SearchNFInstancesParamOpts{}
 
@@ -265,40 +316,21 @@ func (smContext *SMContext) PCFSelection() error {
 		localVarOptionals.PreferredLocality = optional.NewString(SMF_Self().Locality)
 	}
 
-	rep, res, err := SMF_Self().
-		NFDiscoveryClient.
-		NFInstancesStoreApi.
-		SearchNFInstances(context.TODO(), models.NfType_PCF, models.NfType_SMF, &localVarOptionals)
+	instances, err := DiscoverNFInstances(models.NfType_PCF, &localVarOptionals)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if rspCloseErr := res.Body.Close(); rspCloseErr != nil {
-			logger.PduSessLog.Errorf("SmfEventExposureNotification response body cannot close: %+v", rspCloseErr)
-		}
-	}()
-
-	if res != nil {
-		if status := res.StatusCode; status != http.StatusOK {
-			apiError := err.(openapi.GenericOpenAPIError)
-			problemDetails := apiError.Model().(models.ProblemDetails)
-
-			logger.CtxLog.Warningf("NFDiscovery PCF return status: %d\n", status)
-			logger.CtxLog.Warningf("Detail: %v\n", problemDetails.Title)
-		}
+	if len(instances) == 0 {
+		return fmt.Errorf("no PCF discovered")
 	}
 
-	// Select PCF from available PCF
-
-	smContext.SelectedPCFProfile = rep.NfInstances[0]
+	// Select PCF from available PCF, the first one in the order of the selection
+	smContext.SelectedPCFProfile = instances[0]
 
 	// Create SMPolicyControl Client for this SM Context
-	for _, service := range *smContext.SelectedPCFProfile.NfServices {
-		if service.ServiceName == models.ServiceName_NPCF_SMPOLICYCONTROL {
-			SmPolicyControlConf := Npcf_SMPolicyControl.NewConfiguration()
-			SmPolicyControlConf.SetBasePath(service.ApiPrefix)
-			smContext.SMPolicyClient = Npcf_SMPolicyControl.NewAPIClient(SmPolicyControlConf)
-		}
+	smContext.setSMPolicyClient()
+	if smContext.SelectedPCFProfile.NfServices == nil {
+		return fmt.Errorf("PCF[%s] has no services", smContext.SelectedPCFProfile.NfInstanceId)
 	}
 
 	return nil
@@ -472,6 +504,21 @@ func (smContext *SMContext) SelectedSessionRule() *SessionRule {
 	return nil
 }
 
+// AddFramedRoute routes the IP subnet to the UE through the session, unless it already is
+func (smContext *SMContext) AddFramedRoute(route string) error {
+	_, ipNet, err := net.ParseCIDR(route)
+	if err != nil {
+		return err
+	}
+	for _, framedRoute := range smContext.FramedRoutes {
+		if framedRoute == ipNet.String() {
+			return nil
+		}
+	}
+	smContext.FramedRoutes = append(smContext.FramedRoutes, ipNet.String())
+	return nil
+}
+
 func (smContextState SMContextState) String() string {
 	switch smContextState {
 	case InActive:
@@ -2,6 +2,7 @@ package context
 
 import (
 	"net"
+	"sync"
 
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/smf/internal/context/pool"
@@ -28,12 +29,19 @@ type DnnUPFInfoItem struct {
 	DnaiList        []string
 	PduSessionTypes []models.PduSessionType
 	UeIPPools       []*UeIPPool
+	// pools of the static UE IP addresses of the subscription data
+	StaticUeIPPools []*UeIPPool
 }
 
 // UeIPPool represent IP address pool for UE
 type UeIPPool struct {
 	ueSubNet *net.IPNet
 	pool     *pool.LazyReusePool
+
+	// no address is allocated from a retired pool, which is removed once all are released
+	retired    bool
+	alarmLevel UEIPPoolAlarmLevel
+	lock       sync.Mutex
 }
 
 // ContainsDNAI return true if the this dnn Info contains the specify DNAI
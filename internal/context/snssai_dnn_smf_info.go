@@ -12,6 +12,16 @@ type SnssaiSmfInfo struct {
 type SnssaiSmfDnnInfo struct {
 	DNS   DNS
 	PCSCF PCSCF
+	// restoration policy of the PDU sessions when their UPF restarts
+	UPFRestoration string
+	// DN-AAA server of the secondary authentication, nil if it is not required
+	SecondaryAuth *DNAAAServer
+	// RADIUS accounting server, nil if the sessions are not accounted
+	Accounting *AccountingServer
+	// SM policy of the sessions while no PCF answers, nil if the sessions need a PCF
+	LocalPolicy *LocalPolicy
+	// SM subscription data of the sessions the UDM has none for, nil to go on without it
+	DefaultSubscription *DefaultSubscription
 }
 
 type DNS struct {
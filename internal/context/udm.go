@@ -0,0 +1,58 @@
+package context
+
+import (
+	"github.com/free5gc/openapi/Nudm_SubscriberDataManagement"
+	"github.com/free5gc/openapi/Nudm_UEContextManagement"
+	"github.com/free5gc/openapi/models"
+)
+
+// UDM returns the selected UDM and the clients of its services, which are nil until a UDM is
+// selected
+func (c *SMFContext) UDM() (
+	models.NfProfile, *Nudm_SubscriberDataManagement.APIClient, *Nudm_UEContextManagement.APIClient,
+) {
+	c.udmLock.RLock()
+	defer c.udmLock.RUnlock()
+	return c.UDMProfile, c.SubscriberDataManagementClient, c.UEContextManagementClient
+}
+
+// SubscriberDataManagementConf returns the configuration of the SDM client of the selected UDM,
+// for the requests the client cannot send
+func (c *SMFContext) SubscriberDataManagementConf() *Nudm_SubscriberDataManagement.Configuration {
+	c.udmLock.RLock()
+	defer c.udmLock.RUnlock()
+	return c.subscriberDataManagementConf
+}
+
+// SetUDM selects the UDM and creates the clients of its services
+func (c *SMFContext) SetUDM(profile models.NfProfile,
+	sdmConf *Nudm_SubscriberDataManagement.Configuration, uecmConf *Nudm_UEContextManagement.Configuration,
+) {
+	c.udmLock.Lock()
+	defer c.udmLock.Unlock()
+	c.UDMProfile = profile
+	c.subscriberDataManagementConf = sdmConf
+	c.SubscriberDataManagementClient = nil
+	if sdmConf != nil {
+		c.SubscriberDataManagementClient = Nudm_SubscriberDataManagement.NewAPIClient(sdmConf)
+	}
+	c.UEContextManagementClient = nil
+	if uecmConf != nil {
+		c.UEContextManagementClient = Nudm_UEContextManagement.NewAPIClient(uecmConf)
+	}
+}
+
+// RemoveUDM drops the selected UDM and its clients if it is the NF instance, so that a UDM is
+// discovered again when one is needed
+func (c *SMFContext) RemoveUDM(nfInstanceID string) bool {
+	c.udmLock.Lock()
+	defer c.udmLock.Unlock()
+	if nfInstanceID == "" || c.UDMProfile.NfInstanceId != nfInstanceID {
+		return false
+	}
+	c.UDMProfile = models.NfProfile{}
+	c.subscriberDataManagementConf = nil
+	c.SubscriberDataManagementClient = nil
+	c.UEContextManagementClient = nil
+	return true
+}
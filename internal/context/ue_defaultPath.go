@@ -191,6 +191,10 @@ func (dfp *UEDefaultPaths) SelectUPFAndAllocUEIPForULCL(
 	for _, upfName := range sortedUPFList {
 		logger.CtxLog.Debugf("check start UPF: %s", upfName)
 		upf := upi.UPFs[upfName]
+		if upf.UPF.Draining() {
+			logger.CtxLog.Infof("UPF[%s] is draining, no new PDU session is placed on it", upfName)
+			continue
+		}
 
 		pools := getUEIPPool(upf, selection)
 		if len(pools) == 0 {
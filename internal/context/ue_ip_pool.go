@@ -0,0 +1,364 @@
+package context
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+const (
+	DefaultUEIPPoolWarningThreshold  = 80
+	DefaultUEIPPoolCriticalThreshold = 95
+)
+
+// UEIPPoolAlarmLevel is raised when the usage of a UE IP pool crosses the thresholds
+type UEIPPoolAlarmLevel int
+
+const (
+	UEIPPoolAlarmCleared UEIPPoolAlarmLevel = iota
+	UEIPPoolAlarmWarning
+	UEIPPoolAlarmCritical
+)
+
+func (l UEIPPoolAlarmLevel) String() string {
+	switch l {
+	case UEIPPoolAlarmWarning:
+		return "WARNING"
+	case UEIPPoolAlarmCritical:
+		return "CRITICAL"
+	default:
+		return "CLEARED"
+	}
+}
+
+var (
+	ErrUPFNotFound        = errors.New("UPF not found")
+	ErrUEIPPoolNotFound   = errors.New("UE IP pool not found")
+	ErrUEIPPoolOverlap    = errors.New("UE IP pool overlaps an existing pool")
+	ErrUEIPPoolInvalid    = errors.New("invalid UE IP pool")
+	ErrSnssaiDnnNotServed = errors.New("S-NSSAI and DNN not served by the UPF")
+	ErrStaticUEIPNotFound = errors.New("static UE IP address not in the static pools")
+	ErrStaticUEIPInUse    = errors.New("static UE IP address already in use")
+)
+
+// ueIPPoolsLock guards the UeIPPools of the DnnUPFInfoItems, which are changed at run time
+var ueIPPoolsLock sync.RWMutex
+
+// UEIPRange is a range of consecutive addresses of a UE IP pool
+type UEIPRange struct {
+	First net.IP
+	Last  net.IP
+}
+
+// UEIPPoolEntry is a UE IP pool with the UPF, S-NSSAI and DNN it serves
+type UEIPPoolEntry struct {
+	UPF    string
+	SNssai SNssai
+	Dnn    string
+	Pool   *UeIPPool
+	// the pool holds static UE IP addresses
+	Static bool
+}
+
+func (ueIPPool *UeIPPool) Subnet() *net.IPNet {
+	return ueIPPool.ueSubNet
+}
+
+func (ueIPPool *UeIPPool) Total() int {
+	return ueIPPool.pool.Total()
+}
+
+func (ueIPPool *UeIPPool) Free() int {
+	return ueIPPool.pool.Remain()
+}
+
+func (ueIPPool *UeIPPool) Retired() bool {
+	ueIPPool.lock.Lock()
+	defer ueIPPool.lock.Unlock()
+	return ueIPPool.retired
+}
+
+func (ueIPPool *UeIPPool) AlarmLevel() UEIPPoolAlarmLevel {
+	ueIPPool.lock.Lock()
+	defer ueIPPool.lock.Unlock()
+	return ueIPPool.alarmLevel
+}
+
+// FreeRanges returns the unallocated addresses, in ascending order
+func (ueIPPool *UeIPPool) FreeRanges() []UEIPRange {
+	segments := ueIPPool.pool.Dump()
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i][0] < segments[j][0]
+	})
+	ranges := make([]UEIPRange, 0, len(segments))
+	for _, segment := range segments {
+		ranges = append(ranges, UEIPRange{
+			First: uint32ToIP(uint32(segment[0])),
+			Last:  uint32ToIP(uint32(segment[1])),
+		})
+	}
+	return ranges
+}
+
+// AllocatedAddresses returns at most limit of the allocated addresses, in ascending order, after
+// the first offset ones
+func (ueIPPool *UeIPPool) AllocatedAddresses(offset, limit int) []net.IP {
+	minAddr, maxAddr, err := calcAddrRange(ueIPPool.ueSubNet)
+	if err != nil {
+		return nil
+	}
+	addrs := make([]net.IP, 0)
+	// appends the allocated addresses from first to last, both included
+	appendRange := func(first, last uint64) {
+		if skipped := last - first + 1; uint64(offset) >= skipped {
+			offset -= int(skipped)
+			return
+		}
+		for addr := first + uint64(offset); addr <= last && len(addrs) < limit; addr++ {
+			addrs = append(addrs, uint32ToIP(uint32(addr)))
+		}
+		offset = 0
+	}
+	next := uint64(minAddr)
+	for _, freeRange := range ueIPPool.FreeRanges() {
+		if len(addrs) >= limit {
+			return addrs
+		}
+		if first := uint64(binary.BigEndian.Uint32(freeRange.First)); next < first {
+			appendRange(next, first-1)
+		}
+		next = uint64(binary.BigEndian.Uint32(freeRange.Last)) + 1
+	}
+	if len(addrs) < limit && next <= uint64(maxAddr) {
+		appendRange(next, uint64(maxAddr))
+	}
+	return addrs
+}
+
+// Fragments returns the number of ranges the unallocated addresses are split into
+func (ueIPPool *UeIPPool) Fragments() int {
+	return len(ueIPPool.pool.Dump())
+}
+
+// checkUsage raises or clears the alarm of the pool when its usage crosses the thresholds
+func (ueIPPool *UeIPPool) checkUsage() {
+	total := ueIPPool.pool.Total()
+	if total == 0 {
+		return
+	}
+	usage := (total - ueIPPool.pool.Remain()) * 100 / total
+
+	warning, critical := smfContext.UEIPPoolWarningThreshold, smfContext.UEIPPoolCriticalThreshold
+	if warning <= 0 {
+		warning = DefaultUEIPPoolWarningThreshold
+	}
+	if critical <= 0 {
+		critical = DefaultUEIPPoolCriticalThreshold
+	}
+	level := UEIPPoolAlarmCleared
+	if usage >= critical {
+		level = UEIPPoolAlarmCritical
+	} else if usage >= warning {
+		level = UEIPPoolAlarmWarning
+	}
+
+	ueIPPool.lock.Lock()
+	previous := ueIPPool.alarmLevel
+	ueIPPool.alarmLevel = level
+	ueIPPool.lock.Unlock()
+	if level == previous {
+		return
+	}
+	switch level {
+	case UEIPPoolAlarmCritical:
+		logger.CtxLog.Errorf("UE IP pool %s usage %d%% reached critical threshold %d%%",
+			ueIPPool.ueSubNet, usage, critical)
+	case UEIPPoolAlarmWarning:
+		logger.CtxLog.Warnf("UE IP pool %s usage %d%% reached warning threshold %d%%",
+			ueIPPool.ueSubNet, usage, warning)
+	default:
+		logger.CtxLog.Infof("UE IP pool %s usage %d%% alarm cleared", ueIPPool.ueSubNet, usage)
+	}
+}
+
+// UEIPPools returns the UE IP pools of all UPFs
+func (upi *UserPlaneInformation) UEIPPools() []UEIPPoolEntry {
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+	ueIPPoolsLock.RLock()
+	defer ueIPPoolsLock.RUnlock()
+
+	entries := make([]UEIPPoolEntry, 0)
+	for name, upNode := range upi.UPFs {
+		for _, snssaiInfo := range upNode.UPF.SNssaiInfos {
+			for _, dnnInfo := range snssaiInfo.DnnList {
+				for _, pool := range dnnInfo.UeIPPools {
+					entries = append(entries, UEIPPoolEntry{
+						UPF:    name,
+						SNssai: snssaiInfo.SNssai,
+						Dnn:    dnnInfo.Dnn,
+						Pool:   pool,
+					})
+				}
+				for _, pool := range dnnInfo.StaticUeIPPools {
+					entries = append(entries, UEIPPoolEntry{
+						UPF:    name,
+						SNssai: snssaiInfo.SNssai,
+						Dnn:    dnnInfo.Dnn,
+						Pool:   pool,
+						Static: true,
+					})
+				}
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].UPF != entries[j].UPF {
+			return entries[i].UPF < entries[j].UPF
+		}
+		return binary.BigEndian.Uint32(entries[i].Pool.ueSubNet.IP.To4()) <
+			binary.BigEndian.Uint32(entries[j].Pool.ueSubNet.IP.To4())
+	})
+	return entries
+}
+
+// AddUEIPPool adds a UE IP pool to the S-NSSAI and DNN served by the UPF
+func (upi *UserPlaneInformation) AddUEIPPool(upfName string, snssai *SNssai, dnn, cidr string) error {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return fmt.Errorf("%w: %v", ErrUEIPPoolInvalid, err)
+	}
+	newPool := NewUEIPPool(&factory.UEIPPool{Cidr: cidr})
+	if newPool == nil {
+		return fmt.Errorf("%w: %s", ErrUEIPPoolInvalid, cidr)
+	}
+
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+	upNode, ok := upi.UPFs[upfName]
+	if !ok {
+		return ErrUPFNotFound
+	}
+
+	ueIPPoolsLock.Lock()
+	defer ueIPPoolsLock.Unlock()
+	for _, node := range upi.UPFs {
+		for _, snssaiInfo := range node.UPF.SNssaiInfos {
+			for _, dnnInfo := range snssaiInfo.DnnList {
+				pools := append([]*UeIPPool{newPool}, dnnInfo.UeIPPools...)
+				if isOverlap(append(pools, dnnInfo.StaticUeIPPools...)) {
+					return ErrUEIPPoolOverlap
+				}
+			}
+		}
+	}
+	for i := range upNode.UPF.SNssaiInfos {
+		snssaiInfo := &upNode.UPF.SNssaiInfos[i]
+		if !snssaiInfo.SNssai.Equal(snssai) {
+			continue
+		}
+		for j := range snssaiInfo.DnnList {
+			dnnInfo := &snssaiInfo.DnnList[j]
+			if dnnInfo.Dnn != dnn {
+				continue
+			}
+			// copy the slice, getUEIPPool may have returned the current one
+			pools := make([]*UeIPPool, 0, len(dnnInfo.UeIPPools)+1)
+			dnnInfo.UeIPPools = append(append(pools, dnnInfo.UeIPPools...), newPool)
+			logger.CtxLog.Infof("Add UE IP pool %s to UPF[%s] S-NSSAI[%v] DNN[%s]", cidr, upfName, *snssai, dnn)
+			return nil
+		}
+	}
+	return ErrSnssaiDnnNotServed
+}
+
+// RetireUEIPPool stops allocating addresses from the UE IP pool of the UPF. The pool is removed
+// as soon as all of its addresses are released, which returns true if it is already the case.
+func (upi *UserPlaneInformation) RetireUEIPPool(upfName, cidr string) (bool, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrUEIPPoolInvalid, err)
+	}
+
+	upi.Mu.RLock()
+	upNode, ok := upi.UPFs[upfName]
+	upi.Mu.RUnlock()
+	if !ok {
+		return false, ErrUPFNotFound
+	}
+
+	var retired *UeIPPool
+	ueIPPoolsLock.RLock()
+	for _, snssaiInfo := range upNode.UPF.SNssaiInfos {
+		for _, dnnInfo := range snssaiInfo.DnnList {
+			for _, pool := range dnnInfo.UeIPPools {
+				if pool.ueSubNet.String() == ipNet.String() {
+					retired = pool
+				}
+			}
+		}
+	}
+	ueIPPoolsLock.RUnlock()
+	if retired == nil {
+		return false, ErrUEIPPoolNotFound
+	}
+
+	retired.lock.Lock()
+	retired.retired = true
+	retired.lock.Unlock()
+	logger.CtxLog.Infof("Retire UE IP pool %s of UPF[%s], %d addresses in use",
+		cidr, upfName, retired.Total()-retired.Free())
+	if retired.Free() == retired.Total() {
+		removeUEIPPool(upNode, retired)
+		return true, nil
+	}
+	return false, nil
+}
+
+func removeUEIPPool(upNode *UPNode, ueIPPool *UeIPPool) {
+	ueIPPoolsLock.Lock()
+	defer ueIPPoolsLock.Unlock()
+
+	for i := range upNode.UPF.SNssaiInfos {
+		snssaiInfo := &upNode.UPF.SNssaiInfos[i]
+		for j := range snssaiInfo.DnnList {
+			dnnInfo := &snssaiInfo.DnnList[j]
+			pools := make([]*UeIPPool, 0, len(dnnInfo.UeIPPools))
+			for _, pool := range dnnInfo.UeIPPools {
+				if pool != ueIPPool {
+					pools = append(pools, pool)
+				}
+			}
+			if len(pools) != len(dnnInfo.UeIPPools) {
+				dnnInfo.UeIPPools = pools
+				logger.CtxLog.Infof("Remove retired UE IP pool %s", ueIPPool.ueSubNet)
+				return
+			}
+		}
+	}
+}
+
+func uint32ToIP(addr uint32) net.IP {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, addr)
+	return buf
+}
+
+// IsAllocated returns true if the address of the pool is allocated
+func (ueIPPool *UeIPPool) IsAllocated(addr net.IP) bool {
+	if !ueIPPool.ueSubNet.Contains(addr) {
+		return false
+	}
+	addrVal := int(binary.BigEndian.Uint32(addr.To4()))
+	for _, segment := range ueIPPool.pool.Dump() {
+		if segment[0] <= addrVal && addrVal <= segment[1] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestUEIPPoolStatus(t *testing.T) {
+	ueIPPool := NewUEIPPool(&factory.UEIPPool{Cidr: "10.60.0.0/29"})
+	require.NotNil(t, ueIPPool)
+	require.Equal(t, 6, ueIPPool.Total())
+
+	for i := 0; i < 4; i++ {
+		require.NotNil(t, ueIPPool.allocate())
+	}
+	require.Equal(t, UEIPPoolAlarmCleared, ueIPPool.AlarmLevel())
+	require.True(t, ueIPPool.reserve(net.ParseIP("10.60.0.6")))
+	require.Equal(t, UEIPPoolAlarmWarning, ueIPPool.AlarmLevel())
+	require.NotNil(t, ueIPPool.allocate())
+	require.Equal(t, UEIPPoolAlarmCritical, ueIPPool.AlarmLevel())
+
+	ueIPPool.release(net.ParseIP("10.60.0.2").To4())
+	ueIPPool.release(net.ParseIP("10.60.0.3").To4())
+	ueIPPool.release(net.ParseIP("10.60.0.6").To4())
+	require.Equal(t, UEIPPoolAlarmCleared, ueIPPool.AlarmLevel())
+	require.Equal(t, 3, ueIPPool.Free())
+	require.Equal(t, 2, ueIPPool.Fragments())
+	require.Equal(t, []UEIPRange{
+		{First: net.IP{10, 60, 0, 2}, Last: net.IP{10, 60, 0, 3}},
+		{First: net.IP{10, 60, 0, 6}, Last: net.IP{10, 60, 0, 6}},
+	}, ueIPPool.FreeRanges())
+	require.Equal(t, []net.IP{{10, 60, 0, 1}, {10, 60, 0, 4}, {10, 60, 0, 5}}, ueIPPool.AllocatedAddresses(0, 10))
+	require.Equal(t, []net.IP{{10, 60, 0, 4}}, ueIPPool.AllocatedAddresses(1, 1))
+	require.Empty(t, ueIPPool.AllocatedAddresses(3, 10))
+	require.True(t, ueIPPool.IsAllocated(net.ParseIP("10.60.0.4")))
+	require.False(t, ueIPPool.IsAllocated(net.ParseIP("10.60.0.6")))
+}
+
+func TestAddAndRetireUEIPPool(t *testing.T) {
+	upi := NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"GNodeB": {
+				Type:   "AN",
+				NodeID: "192.168.179.100",
+			},
+			"UPF": {
+				Type:   "UPF",
+				NodeID: "192.168.179.1",
+				SNssaiInfos: []factory.SnssaiUpfInfoItem{
+					{
+						SNssai: &models.Snssai{Sst: 1, Sd: "010203"},
+						DnnUpfInfoList: []factory.DnnUpfInfoItem{
+							{
+								Dnn:         "internet",
+								Pools:       []factory.UEIPPool{{Cidr: "10.60.0.0/24"}},
+								StaticPools: []factory.UEIPPool{{Cidr: "10.61.0.0/24"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		Links: []factory.UPLink{{A: "GNodeB", B: "UPF"}},
+	})
+	for _, upf := range upi.UPFs {
+		upf.UPF.UPFStatus = AssociatedSetUpSuccess
+	}
+	snssai := &SNssai{Sst: 1, Sd: "010203"}
+	pools := func() []string {
+		var subnets []string
+		for _, entry := range upi.UEIPPools() {
+			if !entry.Static {
+				subnets = append(subnets, entry.Pool.Subnet().String())
+			}
+		}
+		return subnets
+	}
+
+	require.ErrorIs(t, upi.AddUEIPPool("UPF", snssai, "internet", "10.60.0"), ErrUEIPPoolInvalid)
+	require.ErrorIs(t, upi.AddUEIPPool("UPF2", snssai, "internet", "10.62.0.0/24"), ErrUPFNotFound)
+	require.ErrorIs(t, upi.AddUEIPPool("UPF", snssai, "internet", "10.60.0.128/25"), ErrUEIPPoolOverlap)
+	require.ErrorIs(t, upi.AddUEIPPool("UPF", snssai, "internet", "10.61.0.0/25"), ErrUEIPPoolOverlap)
+	require.ErrorIs(t, upi.AddUEIPPool("UPF", snssai, "ims", "10.62.0.0/24"), ErrSnssaiDnnNotServed)
+	require.NoError(t, upi.AddUEIPPool("UPF", snssai, "internet", "10.62.0.0/24"))
+	require.Equal(t, []string{"10.60.0.0/24", "10.62.0.0/24"}, pools())
+
+	// a pool without allocated addresses is removed at once
+	removed, err := upi.RetireUEIPPool("UPF", "10.60.0.0/24")
+	require.NoError(t, err)
+	require.True(t, removed)
+	require.Equal(t, []string{"10.62.0.0/24"}, pools())
+	_, err = upi.RetireUEIPPool("UPF", "10.60.0.0/24")
+	require.ErrorIs(t, err, ErrUEIPPoolNotFound)
+
+	// a retired pool allocates no more addresses and is removed when its last one is released
+	upf, addr := upi.SelectUPFAndAllocUEIP(&UPFSelectionParams{Dnn: "internet", SNssai: snssai})
+	require.Equal(t, net.IP{10, 62, 0, 1}, addr)
+	removed, err = upi.RetireUEIPPool("UPF", "10.62.0.0/24")
+	require.NoError(t, err)
+	require.False(t, removed)
+	_, addr = upi.SelectUPFAndAllocUEIP(&UPFSelectionParams{Dnn: "internet", SNssai: snssai})
+	require.Nil(t, addr)
+	require.Equal(t, []string{"10.62.0.0/24"}, pools())
+	upi.ReleaseUEIP(upf, net.IP{10, 62, 0, 1})
+	require.Empty(t, pools())
+}
@@ -70,6 +70,21 @@ type UPF struct {
 	UPIPInfo          pfcpType.UserPlaneIPResourceInformation
 	UPFStatus         UPFStatus
 	RecoveryTimeStamp time.Time
+	// features supported by the UPF, pfcpType.UpFunctionFeatures*
+	UPFunctionFeatures uint16
+	// CSID of the SMF for the PFCP sessions on the UPF
+	SMFCSID uint16
+
+	// release of the PDU sessions of failed user plane paths, keyed by remote GTP-U peer
+	pathFailureTimers map[string]*time.Timer
+	pathFailureLock   sync.Mutex
+
+	// no new PDU session is placed on a draining UPF
+	draining          bool
+	releaseAfterDrain bool
+	// set by the operator, the UPF is drained and not associated again until it is cleared
+	maintenance bool
+	drainLock   sync.Mutex
 
 	Ctx        context.Context
 	CancelFunc context.CancelFunc
@@ -78,11 +93,11 @@ type UPF struct {
 	N3Interfaces []UPFInterfaceInfo
 	N9Interfaces []UPFInterfaceInfo
 
-	pdrPool sync.Map
-	farPool sync.Map
-	barPool sync.Map
-	qerPool sync.Map
-	// urrPool        sync.Map
+	pdrPool        sync.Map
+	farPool        sync.Map
+	barPool        sync.Map
+	qerPool        sync.Map
+	urrPool        sync.Map
 	pdrIDGenerator *idgenerator.IDGenerator
 	farIDGenerator *idgenerator.IDGenerator
 	barIDGenerator *idgenerator.IDGenerator
@@ -224,6 +239,7 @@ func NewUPF(nodeID *pfcpType.NodeID, ifaces []factory.InterfaceUpfInfoItem) (upf
 	// Initialize context
 	upf.UPFStatus = NotAssociated
 	upf.NodeID = *nodeID
+	upf.SMFCSID = allocateCSID()
 	upf.pdrIDGenerator = idgenerator.NewGenerator(1, math.MaxUint16)
 	upf.farIDGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
 	upf.barIDGenerator = idgenerator.NewGenerator(1, math.MaxUint8)
@@ -431,6 +447,22 @@ func (upf *UPF) qerID() (uint32, error) {
 	return qerID, nil
 }
 
+func (upf *UPF) urrID() (uint32, error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err := fmt.Errorf("this upf not associate with smf")
+		return 0, err
+	}
+
+	var urrID uint32
+	if tmpID, err := upf.urrIDGenerator.Allocate(); err != nil {
+		return 0, err
+	} else {
+		urrID = uint32(tmpID)
+	}
+
+	return urrID, nil
+}
+
 func (upf *UPF) AddPDR() (*PDR, error) {
 	if upf.UPFStatus != AssociatedSetUpSuccess {
 		err := fmt.Errorf("this upf do not associate with smf")
@@ -495,6 +527,7 @@ func (upf *UPF) AddQER() (*QER, error) {
 
 	qer := new(QER)
 	if QERID, err := upf.qerID(); err != nil {
+		return nil, err
 	} else {
 		qer.QERID = QERID
 		upf.qerPool.Store(qer.QERID, qer)
@@ -503,6 +536,23 @@ func (upf *UPF) AddQER() (*QER, error) {
 	return qer, nil
 }
 
+func (upf *UPF) AddURR() (*URR, error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err := fmt.Errorf("this upf do not associate with smf")
+		return nil, err
+	}
+
+	urr := new(URR)
+	if URRID, err := upf.urrID(); err != nil {
+		return nil, err
+	} else {
+		urr.URRID = URRID
+		upf.urrPool.Store(urr.URRID, urr)
+	}
+
+	return urr, nil
+}
+
 // *** add unit test ***//
 func (upf *UPF) RemovePDR(pdr *PDR) (err error) {
 	if upf.UPFStatus != AssociatedSetUpSuccess {
@@ -539,15 +589,31 @@ func (upf *UPF) RemoveBAR(bar *BAR) (err error) {
 	return nil
 }
 
-// *** add unit test ***//
+// RemoveQER frees the QER once, the QER being shared by the uplink and downlink PDRs
 func (upf *UPF) RemoveQER(qer *QER) (err error) {
 	if upf.UPFStatus != AssociatedSetUpSuccess {
 		err = fmt.Errorf("this upf not associate with smf")
 		return err
 	}
 
-	upf.qerIDGenerator.FreeID(int64(qer.QERID))
-	upf.qerPool.Delete(qer.QERID)
+	if value, ok := upf.qerPool.Load(qer.QERID); ok && value.(*QER) == qer {
+		upf.qerIDGenerator.FreeID(int64(qer.QERID))
+		upf.qerPool.Delete(qer.QERID)
+	}
+	return nil
+}
+
+// RemoveURR frees the URR once, the URR being shared by the PDRs of the session
+func (upf *UPF) RemoveURR(urr *URR) (err error) {
+	if upf.UPFStatus != AssociatedSetUpSuccess {
+		err = fmt.Errorf("this upf not associate with smf")
+		return err
+	}
+
+	if value, ok := upf.urrPool.Load(urr.URRID); ok && value.(*URR) == urr {
+		upf.urrIDGenerator.FreeID(int64(urr.URRID))
+		upf.urrPool.Delete(urr.URRID)
+	}
 	return nil
 }
 
@@ -560,6 +626,63 @@ func (upf *UPF) isSupportSnssai(snssai *SNssai) bool {
 	return false
 }
 
+// ProcEachSMContextWithPFCPSession applies procFunc to every SMContext having a PFCP session
+// on the UPF, whether the UPF is the anchor of the session or not
+func (upf *UPF) ProcEachSMContextWithPFCPSession(procFunc func(*SMContext)) {
+	nodeIP := upf.NodeID.ResolveNodeIdToIp().String()
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		if _, exist := smContext.PFCPContext[nodeIP]; exist {
+			procFunc(smContext)
+		}
+		return true
+	})
+}
+
+// SMContextsOfRemoteGTPUPeers returns the SMContexts whose PFCP session on the UPF
+// forwards traffic to one of the remote GTP-U peers
+func (upf *UPF) SMContextsOfRemoteGTPUPeers(peers []net.IP) []*SMContext {
+	nodeIP := upf.NodeID.ResolveNodeIdToIp().String()
+	smContexts := make([]*SMContext, 0)
+	upf.ProcEachSMContextWithPFCPSession(func(smContext *SMContext) {
+		for _, pdr := range smContext.PFCPContext[nodeIP].PDRs {
+			if pdr.FAR == nil || pdr.FAR.ForwardingParameters == nil ||
+				pdr.FAR.ForwardingParameters.OuterHeaderCreation == nil {
+				continue
+			}
+			outerHeaderCreation := pdr.FAR.ForwardingParameters.OuterHeaderCreation
+			for _, peer := range peers {
+				if peer.Equal(outerHeaderCreation.Ipv4Address) || peer.Equal(outerHeaderCreation.Ipv6Address) {
+					smContexts = append(smContexts, smContext)
+					return
+				}
+			}
+		}
+	})
+	return smContexts
+}
+
+// SMContextsOfFQCSIDs returns the SMContexts whose PFCP session on the UPF belongs to one of
+// the sets of PFCP sessions identified by the FQ-CSIDs of the UPF. The sessions the UPF did
+// not assign a CSID to are not in any of the sets.
+func (upf *UPF) SMContextsOfFQCSIDs(fqcsids []*FQCSID) []*SMContext {
+	nodeIP := upf.NodeID.ResolveNodeIdToIp().String()
+	smContexts := make([]*SMContext, 0)
+	upf.ProcEachSMContextWithPFCPSession(func(smContext *SMContext) {
+		upfFQCSID := smContext.PFCPContext[nodeIP].UPFFQCSID
+		if upfFQCSID == nil {
+			return
+		}
+		for _, fqcsid := range fqcsids {
+			if fqcsid.Match(upfFQCSID) {
+				smContexts = append(smContexts, smContext)
+				return
+			}
+		}
+	})
+	return smContexts
+}
+
 func (upf *UPF) ProcEachSMContext(procFunc func(*SMContext)) {
 	smContextPool.Range(func(key, value interface{}) bool {
 		smContext := value.(*SMContext)
@@ -0,0 +1,95 @@
+package context
+
+// StartDrain stops placing new PDU sessions on the UPF, before its existing sessions are
+// moved or released. With releaseAssociation, the PFCP association is to be released once
+// the UPF has been drained. It returns false if the UPF is already draining.
+func (upf *UPF) StartDrain(releaseAssociation bool) bool {
+	upf.drainLock.Lock()
+	defer upf.drainLock.Unlock()
+
+	upf.releaseAfterDrain = upf.releaseAfterDrain || releaseAssociation
+	if upf.draining {
+		return false
+	}
+	upf.draining = true
+	return true
+}
+
+// StopDrain allows new PDU sessions on the UPF again, unless it is in maintenance mode
+func (upf *UPF) StopDrain() {
+	upf.drainLock.Lock()
+	defer upf.drainLock.Unlock()
+
+	if upf.maintenance {
+		return
+	}
+	upf.draining = false
+	upf.releaseAfterDrain = false
+}
+
+// StartMaintenance puts the UPF into maintenance mode: it is drained, then its PFCP
+// association is released. It returns false if the UPF is already in maintenance mode.
+func (upf *UPF) StartMaintenance() bool {
+	upf.drainLock.Lock()
+	defer upf.drainLock.Unlock()
+
+	if upf.maintenance {
+		return false
+	}
+	upf.maintenance = true
+	upf.draining = true
+	upf.releaseAfterDrain = true
+	return true
+}
+
+// StopMaintenance takes the UPF out of maintenance mode, so that it is associated again
+func (upf *UPF) StopMaintenance() {
+	upf.drainLock.Lock()
+	defer upf.drainLock.Unlock()
+
+	upf.maintenance = false
+	upf.draining = false
+	upf.releaseAfterDrain = false
+}
+
+func (upf *UPF) InMaintenance() bool {
+	upf.drainLock.Lock()
+	defer upf.drainLock.Unlock()
+
+	return upf.maintenance
+}
+
+func (upf *UPF) Draining() bool {
+	upf.drainLock.Lock()
+	defer upf.drainLock.Unlock()
+
+	return upf.draining
+}
+
+// ReleaseAssociationAfterDrain reports whether the PFCP association is to be released once
+// the UPF has been drained
+func (upf *UPF) ReleaseAssociationAfterDrain() bool {
+	upf.drainLock.Lock()
+	defer upf.drainLock.Unlock()
+
+	return upf.draining && upf.releaseAfterDrain
+}
+
+// SMContextsToDrain returns the SMContexts with an active PDU session on the UPF, and the
+// number of those being established which are to be drained once active
+func (upf *UPF) SMContextsToDrain() ([]*SMContext, int) {
+	smContexts := make([]*SMContext, 0)
+	pending := 0
+	upf.ProcEachSMContextWithPFCPSession(func(smContext *SMContext) {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+
+		switch smContext.SMContextState {
+		case Active, ModificationPending, PFCPModification:
+			smContexts = append(smContexts, smContext)
+		case ActivePending:
+			pending++
+		}
+	})
+	return smContexts, pending
+}
@@ -0,0 +1,96 @@
+package context
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type UPFRestorationState string
+
+const (
+	UPFRestorationInProgress UPFRestorationState = "IN_PROGRESS"
+	UPFRestorationCompleted  UPFRestorationState = "COMPLETED"
+)
+
+// UPFRestoration records the progress of restoring the PFCP sessions of a restarted UPF
+type UPFRestoration struct {
+	UPF           string              `json:"upf"`
+	State         UPFRestorationState `json:"state"`
+	StartTime     time.Time           `json:"startTime"`
+	EndTime       *time.Time          `json:"endTime,omitempty"`
+	Total         int                 `json:"total"`
+	Reestablished int                 `json:"reestablished"`
+	Released      int                 `json:"released"`
+	Failed        int                 `json:"failed"`
+
+	mu sync.Mutex
+}
+
+// NodeIP to *UPFRestoration, only the latest restoration of each UPF is kept
+var upfRestorations sync.Map
+
+func NewUPFRestoration(upf *UPF, total int) *UPFRestoration {
+	restoration := &UPFRestoration{
+		UPF:       upf.NodeID.ResolveNodeIdToIp().String(),
+		State:     UPFRestorationInProgress,
+		StartTime: time.Now(),
+		Total:     total,
+	}
+	upfRestorations.Store(restoration.UPF, restoration)
+	return restoration
+}
+
+func (r *UPFRestoration) AddReestablished() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reestablished++
+}
+
+func (r *UPFRestoration) AddReleased() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Released++
+}
+
+func (r *UPFRestoration) AddFailed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failed++
+}
+
+func (r *UPFRestoration) Complete() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.EndTime = &now
+	r.State = UPFRestorationCompleted
+}
+
+func (r *UPFRestoration) snapshot() *UPFRestoration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &UPFRestoration{
+		UPF:           r.UPF,
+		State:         r.State,
+		StartTime:     r.StartTime,
+		EndTime:       r.EndTime,
+		Total:         r.Total,
+		Reestablished: r.Reestablished,
+		Released:      r.Released,
+		Failed:        r.Failed,
+	}
+}
+
+// GetUPFRestorations returns a copy of the restoration progress of every restarted UPF
+func GetUPFRestorations() []*UPFRestoration {
+	restorations := make([]*UPFRestoration, 0)
+	upfRestorations.Range(func(key, value interface{}) bool {
+		restorations = append(restorations, value.(*UPFRestoration).snapshot())
+		return true
+	})
+	sort.Slice(restorations, func(i, j int) bool {
+		return restorations[i].UPF < restorations[j].UPF
+	})
+	return restorations
+}
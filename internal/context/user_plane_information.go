@@ -130,11 +130,22 @@ func NewUserPlaneInformation(upTopology *factory.UserPlaneInformation) *UserPlan
 							allUEIPPools = append(allUEIPPools, ueIPPool)
 						}
 					}
+					staticUEIPPools := make([]*UeIPPool, 0)
+					for _, pool := range dnnInfoConfig.StaticPools {
+						ueIPPool := NewUEIPPool(&pool)
+						if ueIPPool == nil {
+							logger.InitLog.Fatalf("invalid staticPools value: %+v", pool)
+						} else {
+							staticUEIPPools = append(staticUEIPPools, ueIPPool)
+							allUEIPPools = append(allUEIPPools, ueIPPool)
+						}
+					}
 					snssaiInfo.DnnList = append(snssaiInfo.DnnList, DnnUPFInfoItem{
 						Dnn:             dnnInfoConfig.Dnn,
 						DnaiList:        dnnInfoConfig.DnaiList,
 						PduSessionTypes: dnnInfoConfig.PduSessionTypes,
 						UeIPPools:       ueIPPools,
+						StaticUeIPPools: staticUEIPPools,
 					})
 				}
 				snssaiInfos = append(snssaiInfos, snssaiInfo)
@@ -185,6 +196,9 @@ func NewUserPlaneInformation(upTopology *factory.UserPlaneInformation) *UserPlan
 }
 
 func (upi *UserPlaneInformation) UpNodesToConfiguration() map[string]factory.UPNode {
+	ueIPPoolsLock.RLock()
+	defer ueIPPoolsLock.RUnlock()
+
 	nodes := make(map[string]factory.UPNode)
 	for name, upNode := range upi.UPNodes {
 		u := new(factory.UPNode)
@@ -213,9 +227,16 @@ func (upi *UserPlaneInformation) UpNodesToConfiguration() map[string]factory.UPN
 								Cidr: pool.ueSubNet.String(),
 							})
 						} // for pool
+						FStaticUEIPPools := make([]factory.UEIPPool, 0)
+						for _, pool := range dnnInfo.StaticUeIPPools {
+							FStaticUEIPPools = append(FStaticUEIPPools, factory.UEIPPool{
+								Cidr: pool.ueSubNet.String(),
+							})
+						} // for static pool
 						FDnnUpfInfoList = append(FDnnUpfInfoList, factory.DnnUpfInfoItem{
-							Dnn:   dnnInfo.Dnn,
-							Pools: FUEIPPools,
+							Dnn:         dnnInfo.Dnn,
+							Pools:       FUEIPPools,
+							StaticPools: FStaticUEIPPools,
 						})
 					} // for dnnInfo
 					Fsnssai := factory.SnssaiUpfInfoItem{
@@ -362,11 +383,21 @@ func (upi *UserPlaneInformation) UpNodesFromConfiguration(upTopology *factory.Us
 							ueIPPools = append(ueIPPools, ueIPPool)
 						}
 					}
+					staticUEIPPools := make([]*UeIPPool, 0)
+					for _, pool := range dnnInfoConfig.StaticPools {
+						ueIPPool := NewUEIPPool(&pool)
+						if ueIPPool == nil {
+							logger.InitLog.Fatalf("invalid staticPools value: %+v", pool)
+						} else {
+							staticUEIPPools = append(staticUEIPPools, ueIPPool)
+						}
+					}
 					snssaiInfo.DnnList = append(snssaiInfo.DnnList, DnnUPFInfoItem{
 						Dnn:             dnnInfoConfig.Dnn,
 						DnaiList:        dnnInfoConfig.DnaiList,
 						PduSessionTypes: dnnInfoConfig.PduSessionTypes,
 						UeIPPools:       ueIPPools,
+						StaticUeIPPools: staticUEIPPools,
 					})
 				}
 				snssaiInfos = append(snssaiInfos, snssaiInfo)
@@ -399,6 +430,7 @@ func (upi *UserPlaneInformation) UpNodesFromConfiguration(upTopology *factory.Us
 		for _, snssaiInfo := range upf.UPF.SNssaiInfos {
 			for _, dnn := range snssaiInfo.DnnList {
 				allUEIPPools = append(allUEIPPools, dnn.UeIPPools...)
+				allUEIPPools = append(allUEIPPools, dnn.StaticUeIPPools...)
 			}
 		}
 	}
@@ -867,6 +899,11 @@ func (upi *UserPlaneInformation) SelectUPFAndAllocUEIP(selection *UPFSelectionPa
 				upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
 			continue
 		}
+		if upf.UPF.Draining() {
+			logger.CtxLog.Infof("UPF[%s] is draining, no new PDU session is placed on it",
+				upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+			continue
+		}
 		pools := getUEIPPool(upf, selection)
 		if len(pools) == 0 {
 			continue
@@ -892,6 +929,34 @@ func (upi *UserPlaneInformation) SelectUPFAndAllocUEIP(selection *UPFSelectionPa
 	return nil, nil
 }
 
+// SelectUPFAndReserveStaticUEIP selects the anchor UPF whose static UE IP pools contain the
+// address of the subscription data, and reserves the address
+func (upi *UserPlaneInformation) SelectUPFAndReserveStaticUEIP(selection *UPFSelectionParams,
+	addr net.IP,
+) (*UPNode, error) {
+	source, err := upi.selectUPPathSource()
+	if err != nil {
+		return nil, err
+	}
+	for _, upf := range upi.selectAnchorUPF(source, selection) {
+		pool := getStaticUEIPPool(upf, selection, addr)
+		if pool == nil {
+			continue
+		}
+		upfName := upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String())
+		// no other UPF can serve the address, even if this one is draining
+		if upf.UPF.UPFStatus != AssociatedSetUpSuccess {
+			return nil, fmt.Errorf("UPF[%s] of static UE IP address %s is not associated", upfName, addr)
+		}
+		if !pool.reserve(addr) {
+			return nil, ErrStaticUEIPInUse
+		}
+		logger.CtxLog.Infof("Selected UPF: %s for static UE IP address %s", upfName, addr)
+		return upf, nil
+	}
+	return nil, ErrStaticUEIPNotFound
+}
+
 func createUPFListForSelection(inputList []*UPNode) (outputList []*UPNode) {
 	offset := rand.Intn(len(inputList))
 	return append(inputList[offset:], inputList[:offset]...)
@@ -903,6 +968,9 @@ func createPoolListForSelection(inputList []*UeIPPool) (outputList []*UeIPPool)
 }
 
 func getUEIPPool(upNode *UPNode, selection *UPFSelectionParams) []*UeIPPool {
+	ueIPPoolsLock.RLock()
+	defer ueIPPoolsLock.RUnlock()
+
 	for _, snssaiInfo := range upNode.UPF.SNssaiInfos {
 		currentSnssai := &snssaiInfo.SNssai
 		targetSnssai := selection.SNssai
@@ -918,8 +986,34 @@ func getUEIPPool(upNode *UPNode, selection *UPFSelectionParams) []*UeIPPool {
 	return nil
 }
 
+func getStaticUEIPPool(upNode *UPNode, selection *UPFSelectionParams, addr net.IP) *UeIPPool {
+	ueIPPoolsLock.RLock()
+	defer ueIPPoolsLock.RUnlock()
+
+	for _, snssaiInfo := range upNode.UPF.SNssaiInfos {
+		if !snssaiInfo.SNssai.Equal(selection.SNssai) {
+			continue
+		}
+		for _, dnnInfo := range snssaiInfo.DnnList {
+			if dnnInfo.Dnn != selection.Dnn {
+				continue
+			}
+			for _, pool := range dnnInfo.StaticUeIPPools {
+				if pool.ueSubNet.Contains(addr) {
+					return pool
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (ueIPPool *UeIPPool) allocate() net.IP {
+	if ueIPPool.Retired() {
+		return nil
+	}
 	allocVal, res := ueIPPool.pool.Allocate()
+	ueIPPool.checkUsage()
 	if !res {
 		logger.CtxLog.Warnf("Pool is empty: %+v", ueIPPool.ueSubNet)
 		return nil
@@ -939,9 +1033,26 @@ func (upi *UserPlaneInformation) ReleaseUEIP(upf *UPNode, addr net.IP) {
 		return
 	}
 	pool.release(addr)
+	if pool.Retired() && pool.pool.Remain() == pool.pool.Total() {
+		removeUEIPPool(upf, pool)
+	}
+}
+
+// ReserveUEIP allocates the given UE IP address from the pools of the UPF
+func (upi *UserPlaneInformation) ReserveUEIP(upf *UPNode, addr net.IP) bool {
+	pool := findPoolByAddr(upf, addr)
+	if pool == nil {
+		logger.CtxLog.Warnf("Fail to reserve UE IP address: %v of UPF: %s", addr,
+			upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+		return false
+	}
+	return pool.reserve(addr)
 }
 
 func findPoolByAddr(upf *UPNode, addr net.IP) *UeIPPool {
+	ueIPPoolsLock.RLock()
+	defer ueIPPoolsLock.RUnlock()
+
 	for _, snssaiInfo := range upf.UPF.SNssaiInfos {
 		for _, dnnInfo := range snssaiInfo.DnnList {
 			for _, pool := range dnnInfo.UeIPPools {
@@ -949,6 +1060,11 @@ func findPoolByAddr(upf *UPNode, addr net.IP) *UeIPPool {
 					return pool
 				}
 			}
+			for _, pool := range dnnInfo.StaticUeIPPools {
+				if pool.ueSubNet.Contains(addr) {
+					return pool
+				}
+			}
 		}
 	}
 	return nil
@@ -960,9 +1076,20 @@ func (ueIPPool *UeIPPool) release(addr net.IP) {
 	if !res {
 		logger.CtxLog.Warnf("failed to release UE Address: %s", addr)
 	}
+	ueIPPool.checkUsage()
 	logger.CtxLog.Debug(ueIPPool.dump())
 }
 
+func (ueIPPool *UeIPPool) reserve(addr net.IP) bool {
+	addrVal := binary.BigEndian.Uint32(addr.To4())
+	if !ueIPPool.pool.Use(int(addrVal)) {
+		logger.CtxLog.Warnf("UE Address %s is already allocated", addr)
+		return false
+	}
+	ueIPPool.checkUsage()
+	return true
+}
+
 func (ueIPPool *UeIPPool) dump() string {
 	str := "["
 	elements := ueIPPool.pool.Dump()
@@ -1,6 +1,7 @@
 package context_test
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -216,3 +217,77 @@ func TestGenerateDefaultPath(t *testing.T) {
 
 func TestGetDefaultUPFTopoByDNN(t *testing.T) {
 }
+
+func TestSelectUPFAndReserveStaticUEIP(t *testing.T) {
+	staticConfiguration := &factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"GNodeB": {
+				Type:   "AN",
+				NodeID: "192.168.179.100",
+			},
+			"UPF1": {
+				Type:   "UPF",
+				NodeID: "192.168.179.1",
+				SNssaiInfos: []factory.SnssaiUpfInfoItem{
+					{
+						SNssai: &models.Snssai{Sst: 1, Sd: "010203"},
+						DnnUpfInfoList: []factory.DnnUpfInfoItem{
+							{
+								Dnn:         "internet",
+								Pools:       []factory.UEIPPool{{Cidr: "10.60.0.0/24"}},
+								StaticPools: []factory.UEIPPool{{Cidr: "10.61.0.0/24"}},
+							},
+						},
+					},
+				},
+			},
+			"UPF2": {
+				Type:   "UPF",
+				NodeID: "192.168.179.2",
+				SNssaiInfos: []factory.SnssaiUpfInfoItem{
+					{
+						SNssai: &models.Snssai{Sst: 1, Sd: "010203"},
+						DnnUpfInfoList: []factory.DnnUpfInfoItem{
+							{
+								Dnn:         "internet",
+								StaticPools: []factory.UEIPPool{{Cidr: "10.62.0.0/24"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		Links: []factory.UPLink{
+			{A: "GNodeB", B: "UPF1"},
+			{A: "GNodeB", B: "UPF2"},
+		},
+	}
+	upi := context.NewUserPlaneInformation(staticConfiguration)
+	for _, upf := range upi.UPFs {
+		upf.UPF.UPFStatus = context.AssociatedSetUpSuccess
+	}
+	selection := &context.UPFSelectionParams{
+		Dnn:    "internet",
+		SNssai: &context.SNssai{Sst: 1, Sd: "010203"},
+	}
+
+	upf, err := upi.SelectUPFAndReserveStaticUEIP(selection, net.ParseIP("10.62.0.5").To4())
+	require.NoError(t, err)
+	require.Equal(t, upi.UPFs["UPF2"], upf)
+
+	_, err = upi.SelectUPFAndReserveStaticUEIP(selection, net.ParseIP("10.62.0.5").To4())
+	require.Equal(t, context.ErrStaticUEIPInUse, err)
+
+	_, err = upi.SelectUPFAndReserveStaticUEIP(selection, net.ParseIP("10.60.0.5").To4())
+	require.Equal(t, context.ErrStaticUEIPNotFound, err)
+
+	upi.ReleaseUEIP(upf, net.ParseIP("10.62.0.5").To4())
+	_, err = upi.SelectUPFAndReserveStaticUEIP(selection, net.ParseIP("10.62.0.5").To4())
+	require.NoError(t, err)
+
+	// static pools are never used for dynamic allocation
+	upf, ip := upi.SelectUPFAndAllocUEIP(selection)
+	require.Equal(t, upi.UPFs["UPF1"], upf)
+	_, dynamicPool, _ := net.ParseCIDR("10.60.0.0/24")
+	require.True(t, dynamicPool.Contains(ip))
+}
@@ -0,0 +1,215 @@
+package gtpu
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+)
+
+// GTP-U message types, TS 29.281 6.1
+const (
+	MsgTypeEchoRequest  uint8 = 1
+	MsgTypeEchoResponse uint8 = 2
+	MsgTypeGPDU         uint8 = 255
+)
+
+const (
+	headerLength         = 8
+	optionalHeaderLength = 4
+	optionalHeaderFlag   = 0x07
+	ieTypeRecovery       = 14
+	maxGtpuPacketSize    = 65535
+)
+
+// back-off between the reads of the GTP-U endpoint after an error
+const (
+	minReadBackoff = 10 * time.Millisecond
+	maxReadBackoff = time.Second
+)
+
+var Server *net.UDPConn
+
+type sessionBuffer struct {
+	packets  [][]byte
+	notified bool
+}
+
+var (
+	bufferMu sync.Mutex
+	buffers  = make(map[uint32]*sessionBuffer)
+)
+
+// Run starts the GTP-U endpoint on which UPFs forward the downlink data of idle UEs
+// when the SMF buffers it. notify is called once for the first packet buffered for a
+// PDU session, so that the UE can be paged.
+func Run(notify func(*context.SMContext)) {
+	if !context.SMF_Self().SMFBuffering {
+		return
+	}
+
+	conn, err := net.ListenUDP("udp", context.SMF_Self().BufferingGtpuAddr)
+	if err != nil {
+		logger.GtpuLog.Errorf("Failed to listen: %v", err)
+		return
+	}
+	Server = conn
+	logger.GtpuLog.Infof("Listen on %s", Server.LocalAddr().String())
+
+	go func(conn *net.UDPConn) {
+		buf := make([]byte, maxGtpuPacketSize)
+		backoff := time.Duration(0)
+		for {
+			n, remoteAddr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					logger.GtpuLog.Infof("GTP-U endpoint closed")
+					return
+				}
+				// the errors which persist would otherwise spin the loop
+				backoff *= 2
+				if backoff < minReadBackoff {
+					backoff = minReadBackoff
+				} else if backoff > maxReadBackoff {
+					backoff = maxReadBackoff
+				}
+				logger.GtpuLog.Warnf("Read GTP-U error, retry in %s: %v", backoff, err)
+				time.Sleep(backoff)
+				continue
+			}
+			backoff = 0
+			packet := make([]byte, n)
+			copy(packet, buf[:n])
+			handlePacket(packet, remoteAddr, notify)
+		}
+	}(Server)
+}
+
+func handlePacket(packet []byte, remoteAddr *net.UDPAddr, notify func(*context.SMContext)) {
+	msgType, teid, err := parseHeader(packet)
+	if err != nil {
+		logger.GtpuLog.Warnf("Invalid GTP-U packet from %s: %v", remoteAddr, err)
+		return
+	}
+
+	switch msgType {
+	case MsgTypeEchoRequest:
+		if _, err := Server.WriteToUDP(buildEchoResponse(packet), remoteAddr); err != nil {
+			logger.GtpuLog.Warnf("Send GTP-U Echo Response error: %v", err)
+		}
+	case MsgTypeGPDU:
+		smContext := context.GetSMContextByBufferingTEID(teid)
+		if smContext == nil {
+			logger.GtpuLog.Warnf("No PDU session found for buffering TEID[%d], packet dropped", teid)
+			return
+		}
+		if first := bufferPacket(teid, packet); first {
+			go notify(smContext)
+		}
+	default:
+		logger.GtpuLog.Debugf("GTP-U message type[%d] from %s is ignored", msgType, remoteAddr)
+	}
+}
+
+func parseHeader(packet []byte) (uint8, uint32, error) {
+	if len(packet) < headerLength {
+		return 0, 0, errors.New("packet shorter than GTP-U header")
+	}
+	if version := packet[0] >> 5; version != 1 {
+		return 0, 0, errors.New("unsupported GTP version")
+	}
+	// the Length counts the octets after the mandatory part of the header, TS 29.281 5.1
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if packet[0]&optionalHeaderFlag != 0 && length < optionalHeaderLength {
+		return 0, 0, errors.New("optional header fields missing")
+	}
+	if headerLength+length > len(packet) {
+		return 0, 0, errors.New("packet shorter than its GTP-U length")
+	}
+	return packet[1], binary.BigEndian.Uint32(packet[4:8]), nil
+}
+
+func buildEchoResponse(request []byte) []byte {
+	// Sequence Number is mandatory in Echo messages, TS 29.281 7.2.1
+	rsp := []byte{0x32, MsgTypeEchoResponse, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0}
+	if len(request) >= headerLength+optionalHeaderLength && request[0]&optionalHeaderFlag != 0 {
+		copy(rsp[8:10], request[8:10])
+	}
+	return append(rsp, ieTypeRecovery, 0)
+}
+
+// bufferPacket stores a G-PDU for the session and reports whether it is the first one
+func bufferPacket(teid uint32, packet []byte) bool {
+	bufferMu.Lock()
+	defer bufferMu.Unlock()
+
+	buffer, exist := buffers[teid]
+	if !exist {
+		buffer = new(sessionBuffer)
+		buffers[teid] = buffer
+	}
+
+	if len(buffer.packets) >= context.SMF_Self().BufferingMaxPackets {
+		logger.GtpuLog.Debugf("Buffer of TEID[%d] is full, packet dropped", teid)
+	} else {
+		buffer.packets = append(buffer.packets, packet)
+	}
+
+	first := !buffer.notified
+	buffer.notified = true
+	return first
+}
+
+// Flush delivers the downlink data buffered by the SMF to the AN tunnel of the session
+// once the user plane is activated again, and releases the buffer.
+func Flush(smContext *context.SMContext) {
+	packets := take(smContext.BufferingTEID)
+	if len(packets) == 0 {
+		return
+	}
+
+	anInformation := smContext.Tunnel.ANInformation
+	if anInformation.IPAddress == nil {
+		logger.GtpuLog.Warnf("No AN tunnel for UE[%s] PDUSessionID[%d], %d buffered packets dropped",
+			smContext.Supi, smContext.PDUSessionID, len(packets))
+		return
+	}
+
+	anAddr := &net.UDPAddr{IP: anInformation.IPAddress, Port: context.GtpuPort}
+	for _, packet := range packets {
+		binary.BigEndian.PutUint32(packet[4:8], anInformation.TEID)
+		if _, err := Server.WriteToUDP(packet, anAddr); err != nil {
+			logger.GtpuLog.Warnf("Send buffered packet to %s error: %v", anAddr, err)
+		}
+	}
+	logger.GtpuLog.Infof("Delivered %d buffered packets of UE[%s] PDUSessionID[%d]",
+		len(packets), smContext.Supi, smContext.PDUSessionID)
+}
+
+// Discard drops the downlink data buffered by the SMF for the session
+func Discard(smContext *context.SMContext) {
+	if packets := take(smContext.BufferingTEID); len(packets) != 0 {
+		logger.GtpuLog.Infof("Discarded %d buffered packets of UE[%s] PDUSessionID[%d]",
+			len(packets), smContext.Supi, smContext.PDUSessionID)
+	}
+}
+
+func take(teid uint32) [][]byte {
+	if teid == 0 {
+		return nil
+	}
+
+	bufferMu.Lock()
+	defer bufferMu.Unlock()
+
+	buffer, exist := buffers[teid]
+	if !exist {
+		return nil
+	}
+	delete(buffers, teid)
+	return buffer.packets
+}
@@ -0,0 +1,226 @@
+package gtpu
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/smf/internal/context"
+)
+
+func gpdu(teid uint32, payload ...byte) []byte {
+	packet := []byte{0x30, MsgTypeGPDU, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(payload)))
+	binary.BigEndian.PutUint32(packet[4:8], teid)
+	return append(packet, payload...)
+}
+
+func TestParseHeader(t *testing.T) {
+	testCases := []struct {
+		name    string
+		packet  []byte
+		msgType uint8
+		teid    uint32
+		err     string
+	}{
+		{
+			name:    "G-PDU",
+			packet:  gpdu(0x01020304, 0x45, 0, 0, 0),
+			msgType: MsgTypeGPDU,
+			teid:    0x01020304,
+		},
+		{
+			name:    "Echo Request with sequence number",
+			packet:  []byte{0x32, MsgTypeEchoRequest, 0, 4, 0, 0, 0, 0, 0, 7, 0, 0},
+			msgType: MsgTypeEchoRequest,
+		},
+		{
+			name:    "Error Indication",
+			packet:  []byte{0x30, 26, 0, 0, 0, 0, 0, 9},
+			msgType: 26,
+			teid:    9,
+		},
+		{
+			name:   "empty",
+			packet: []byte{},
+			err:    "packet shorter than GTP-U header",
+		},
+		{
+			name:   "short header",
+			packet: []byte{0x30, MsgTypeGPDU, 0, 0, 0, 0, 0},
+			err:    "packet shorter than GTP-U header",
+		},
+		{
+			name:   "GTPv2",
+			packet: []byte{0x48, 1, 0, 0, 0, 0, 0, 0},
+			err:    "unsupported GTP version",
+		},
+		{
+			name:   "GTPv0",
+			packet: []byte{0x10, MsgTypeGPDU, 0, 0, 0, 0, 0, 0},
+			err:    "unsupported GTP version",
+		},
+		{
+			name:   "optional header fields missing",
+			packet: []byte{0x32, MsgTypeEchoRequest, 0, 0, 0, 0, 0, 0},
+			err:    "optional header fields missing",
+		},
+		{
+			name:   "truncated optional header",
+			packet: []byte{0x32, MsgTypeEchoRequest, 0, 4, 0, 0, 0, 0, 0, 7},
+			err:    "packet shorter than its GTP-U length",
+		},
+		{
+			name:   "truncated T-PDU",
+			packet: gpdu(1, 0x45, 0, 0, 0)[:10],
+			err:    "packet shorter than its GTP-U length",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msgType, teid, err := parseHeader(tc.packet)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.msgType, msgType)
+			require.Equal(t, tc.teid, teid)
+		})
+	}
+}
+
+func TestBuildEchoResponse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  []byte
+		response []byte
+	}{
+		{
+			name:     "sequence number copied",
+			request:  []byte{0x32, MsgTypeEchoRequest, 0, 4, 0, 0, 0, 0, 0x12, 0x34, 0, 0},
+			response: []byte{0x32, MsgTypeEchoResponse, 0, 6, 0, 0, 0, 0, 0x12, 0x34, 0, 0, ieTypeRecovery, 0},
+		},
+		{
+			name:     "no sequence number",
+			request:  []byte{0x30, MsgTypeEchoRequest, 0, 0, 0, 0, 0, 0},
+			response: []byte{0x32, MsgTypeEchoResponse, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0, ieTypeRecovery, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.response, buildEchoResponse(tc.request))
+		})
+	}
+}
+
+func TestBufferPacket(t *testing.T) {
+	maxPackets := context.SMF_Self().BufferingMaxPackets
+	context.SMF_Self().BufferingMaxPackets = 2
+	defer func() { context.SMF_Self().BufferingMaxPackets = maxPackets }()
+	defer func() {
+		take(101)
+		take(102)
+	}()
+
+	// only the first packet of a session is reported, whatever the other sessions
+	require.True(t, bufferPacket(101, gpdu(101, 1)))
+	require.False(t, bufferPacket(101, gpdu(101, 2)))
+	require.True(t, bufferPacket(102, gpdu(102, 1)))
+
+	// the packets over the limit of a session are dropped, but not those of the other sessions
+	require.False(t, bufferPacket(101, gpdu(101, 3)))
+	require.False(t, bufferPacket(102, gpdu(102, 2)))
+	require.Equal(t, [][]byte{gpdu(101, 1), gpdu(101, 2)}, take(101))
+	require.Equal(t, [][]byte{gpdu(102, 1), gpdu(102, 2)}, take(102))
+
+	// a new buffer is reported again
+	require.True(t, bufferPacket(101, gpdu(101, 4)))
+}
+
+// newBufferingSMContext returns a session whose downlink data is buffered by the SMF
+func newBufferingSMContext(t *testing.T) *context.SMContext {
+	smfSelf := context.SMF_Self()
+	smfBuffering, gtpuAddr := smfSelf.SMFBuffering, smfSelf.BufferingGtpuAddr
+	smfSelf.SMFBuffering = true
+	smfSelf.BufferingGtpuAddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.15"), Port: context.GtpuPort}
+	t.Cleanup(func() { smfSelf.SMFBuffering, smfSelf.BufferingGtpuAddr = smfBuffering, gtpuAddr })
+
+	smContext := context.NewSMContext("imsi-208930000000051", 1)
+	t.Cleanup(func() { context.RemoveSMContext(smContext.Ref) })
+	smContext.Tunnel = context.NewUPTunnel()
+	bar, err := smContext.BufferDownlinkFAR(nil, &context.FAR{})
+	require.NoError(t, err)
+	require.Nil(t, bar)
+	return smContext
+}
+
+func TestFlush(t *testing.T) {
+	maxPackets := context.SMF_Self().BufferingMaxPackets
+	context.SMF_Self().BufferingMaxPackets = 8
+	defer func() { context.SMF_Self().BufferingMaxPackets = maxPackets }()
+
+	an, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.14"), Port: context.GtpuPort})
+	require.NoError(t, err)
+	defer an.Close()
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.15")})
+	require.NoError(t, err)
+	Server = server
+	defer func() {
+		Server = nil
+		require.NoError(t, server.Close())
+	}()
+
+	smContext := newBufferingSMContext(t)
+	teid := smContext.BufferingTEID
+	notified := make(chan *context.SMContext, 3)
+	notify := func(smContext *context.SMContext) { notified <- smContext }
+
+	// the G-PDUs of unknown sessions and the other messages are not buffered
+	handlePacket(gpdu(teid+1000, 0), nil, notify)
+	handlePacket([]byte{0x30, 26, 0, 0, 0, 0, 0, 0}, nil, notify)
+	for i := byte(1); i <= 3; i++ {
+		handlePacket(gpdu(teid, i), nil, notify)
+	}
+	select {
+	case n := <-notified:
+		require.Same(t, smContext, n)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "buffered data not notified")
+	}
+
+	// the buffered packets go to the AN tunnel in order, and the buffer is released
+	smContext.Tunnel.ANInformation.IPAddress = net.ParseIP("127.0.0.14").To4()
+	smContext.Tunnel.ANInformation.TEID = 0x0a0b0c0d
+	Flush(smContext)
+	buf := make([]byte, maxGtpuPacketSize)
+	require.NoError(t, an.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for i := byte(1); i <= 3; i++ {
+		n, _, err := an.ReadFromUDP(buf)
+		require.NoError(t, err)
+		require.Equal(t, gpdu(0x0a0b0c0d, i), buf[:n])
+	}
+	require.Nil(t, take(teid))
+	require.Empty(t, notified)
+}
+
+func TestDiscard(t *testing.T) {
+	smContext := newBufferingSMContext(t)
+	teid := smContext.BufferingTEID
+	defer take(teid)
+
+	require.True(t, bufferPacket(teid, gpdu(teid, 1)))
+	Discard(smContext)
+
+	// the buffer is freed, and the next packet is reported again
+	bufferMu.Lock()
+	_, exist := buffers[teid]
+	bufferMu.Unlock()
+	require.False(t, exist)
+	require.True(t, bufferPacket(teid, gpdu(teid, 2)))
+}
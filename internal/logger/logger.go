@@ -26,6 +26,7 @@ var (
 	CfgLog      *logrus.Entry
 	GsmLog      *logrus.Entry
 	PfcpLog     *logrus.Entry
+	GtpuLog     *logrus.Entry
 	PduSessLog  *logrus.Entry
 	CtxLog      *logrus.Entry
 	ConsumerLog *logrus.Entry
@@ -48,6 +49,7 @@ func init() {
 	InitLog = log.WithFields(logrus.Fields{"component": "SMF", "category": "Init"})
 	CfgLog = log.WithFields(logrus.Fields{"component": "SMF", "category": "CFG"})
 	PfcpLog = log.WithFields(logrus.Fields{"component": "SMF", "category": "PFCP"})
+	GtpuLog = log.WithFields(logrus.Fields{"component": "SMF", "category": "GTPU"})
 	PduSessLog = log.WithFields(logrus.Fields{"component": "SMF", "category": "PduSess"})
 	GsmLog = log.WithFields(logrus.Fields{"component": "SMF", "category": "GSM"})
 	CtxLog = log.WithFields(logrus.Fields{"component": "SMF", "category": "CTX"})
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "smf"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	procedureAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pdu_session_procedure_attempts_total",
+		Help:      "Number of PDU session establishment, modification and release attempts.",
+	}, []string{"procedure"})
+
+	procedureFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pdu_session_procedure_failures_total",
+		Help:      "Number of failed PDU session procedures by cause.",
+	}, []string{"procedure", "cause"})
+
+	pfcpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pfcp_request_duration_seconds",
+		Help:      "Latency of the answered PFCP requests sent to the UPFs.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 3, 6, 9},
+	}, []string{"upf", "message_type"})
+
+	pfcpRequestTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pfcp_request_timeouts_total",
+		Help:      "Number of PFCP requests not answered after all retransmissions.",
+	}, []string{"upf", "message_type"})
+
+	pfcpRequestRetransmissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pfcp_request_retransmissions_total",
+		Help:      "Number of retransmitted PFCP requests.",
+	}, []string{"upf", "message_type"})
+
+	sbiClientRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sbi_client_request_duration_seconds",
+		Help:      "Latency of the SBI requests sent by the SMF.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "status"})
+
+	sbiServerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sbi_server_request_duration_seconds",
+		Help:      "Latency of the SBI requests served by the SMF.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "method", "status"})
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		procedureAttempts,
+		procedureFailures,
+		pfcpRequestDuration,
+		pfcpRequestTimeouts,
+		pfcpRequestRetransmissions,
+		sbiClientRequestDuration,
+		sbiServerRequestDuration,
+	)
+}
+
+// Register registers collectors which read their values from the SMF context when scraped
+func Register(collectors ...prometheus.Collector) {
+	registry.MustRegister(collectors...)
+}
+
+// NewServer returns the server of the /metrics endpoint at the address. The endpoint is not
+// served on the SBI, where any NF could read it.
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// Procedure names of the PDU session procedures
+const (
+	ProcedureEstablishment = "establishment"
+	ProcedureModification  = "modification"
+	ProcedureRelease       = "release"
+)
+
+// ObserveProcedure counts an attempt of the procedure, and a failure when cause is not empty
+func ObserveProcedure(procedure string, cause string) {
+	procedureAttempts.WithLabelValues(procedure).Inc()
+	if cause != "" {
+		procedureFailures.WithLabelValues(procedure, cause).Inc()
+	}
+}
+
+// ObserveProcedureFailure counts a failure of the procedure detected after its SBI request
+// was answered, e.g. a PDU Session Establishment Reject after a failed PFCP establishment
+func ObserveProcedureFailure(procedure string, cause string) {
+	procedureFailures.WithLabelValues(procedure, cause).Inc()
+}
+
+// NasCause is the cause label of a failure by a 5GSM cause
+func NasCause(cause uint8) string {
+	return "5GSM_CAUSE_" + strconv.Itoa(int(cause))
+}
+
+// ObservePfcpRequest records a PFCP request sent to the UPF, with the number of times it was
+// retransmitted
+func ObservePfcpRequest(upf, messageType string, start time.Time, retransmissions int, answered bool) {
+	if answered {
+		pfcpRequestDuration.WithLabelValues(upf, messageType).Observe(time.Since(start).Seconds())
+	} else {
+		pfcpRequestTimeouts.WithLabelValues(upf, messageType).Inc()
+	}
+	if retransmissions > 0 {
+		pfcpRequestRetransmissions.WithLabelValues(upf, messageType).Add(float64(retransmissions))
+	}
+}
+
+// Services of the other NFs, labels of the SBI client metrics
+const (
+	ServiceNnrfNfm              = "nnrf-nfm"
+	ServiceNnrfDisc             = "nnrf-disc"
+	ServiceNudmSdm              = "nudm-sdm"
+	ServiceNudmUecm             = "nudm-uecm"
+	ServiceNamfComm             = "namf-comm"
+	ServiceNpcfSmPolicyControl  = "npcf-smpolicycontrol"
+	ServiceNsmfPDUSessionNotify = "nsmf-pdusession-notify"
+)
+
+// ObserveSBIClient records an SBI request sent to the service of another NF
+func ObserveSBIClient(service string, start time.Time, rsp *http.Response) {
+	status := "error"
+	if rsp != nil {
+		status = strconv.Itoa(rsp.StatusCode)
+	}
+	sbiClientRequestDuration.WithLabelValues(service, status).Observe(time.Since(start).Seconds())
+}
+
+// GinMiddleware records the latency of the requests served by the SBI server
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		sbiServerRequestDuration.WithLabelValues(serviceOf(c.FullPath()), c.Request.Method,
+			strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// serviceOf returns the service of the route, e.g. nsmf-pdusession for
+// /nsmf-pdusession/v1/sm-contexts
+func serviceOf(path string) string {
+	if path == "" {
+		// no route matched
+		return "unknown"
+	}
+	for i := 1; i < len(path); i++ {
+		if path[i] == '/' {
+			return path[1:i]
+		}
+	}
+	return path[1:]
+}
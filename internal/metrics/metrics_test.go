@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObservePfcpRequest(t *testing.T) {
+	ObservePfcpRequest("upf-a", "SessionEstablishmentRequest", time.Now(), 0, true)
+	ObservePfcpRequest("upf-a", "SessionEstablishmentRequest", time.Now(), 1, true)
+	ObservePfcpRequest("upf-a", "SessionEstablishmentRequest", time.Now(), 2, false)
+
+	require.Equal(t, float64(3),
+		testutil.ToFloat64(pfcpRequestRetransmissions.WithLabelValues("upf-a", "SessionEstablishmentRequest")))
+	require.Equal(t, float64(1),
+		testutil.ToFloat64(pfcpRequestTimeouts.WithLabelValues("upf-a", "SessionEstablishmentRequest")))
+}
+
+func TestNewServer(t *testing.T) {
+	ObserveProcedure(ProcedureEstablishment, "")
+	server := httptest.NewServer(NewServer("127.0.0.1:0").Handler)
+	defer server.Close()
+
+	rsp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(rsp.Body)
+	require.NoError(t, rsp.Body.Close())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	require.Contains(t, string(body), `smf_pdu_session_procedure_attempts_total{procedure="establishment"}`)
+
+	// nothing else is served
+	rsp, err = http.Get(server.URL + "/nsmf-pdusession/v1/sm-contexts")
+	require.NoError(t, err)
+	require.NoError(t, rsp.Body.Close())
+	require.Equal(t, http.StatusNotFound, rsp.StatusCode)
+}
@@ -1,9 +1,11 @@
 package handler
 
 import (
-	"context"
 	"fmt"
+	"net"
+	"time"
 
+	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpType"
@@ -11,6 +13,10 @@ import (
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
 	pfcp_message "github.com/free5gc/smf/internal/pfcp/message"
+	"github.com/free5gc/smf/internal/pfcp/udp"
+	"github.com/free5gc/smf/internal/sbi/consumer"
+	"github.com/free5gc/smf/internal/sbi/producer"
+	"github.com/free5gc/smf/pkg/association"
 )
 
 func HandlePfcpHeartbeatRequest(msg *pfcpUdp.Message) {
@@ -38,17 +44,79 @@ func HandlePfcpAssociationSetupRequest(msg *pfcpUdp.Message) {
 		return
 	}
 
-	upf.UPIPInfo = *req.UserPlaneIPResourceInformation
+	if req.UserPlaneIPResourceInformation != nil {
+		upf.UPIPInfo = *req.UserPlaneIPResourceInformation
+	}
+
+	// an associated UPF setting up the association again with a newer recovery
+	// time stamp has restarted, and lost the PFCP sessions of the SMF
+	restarted := false
+	if req.RecoveryTimeStamp != nil {
+		restarted = upf.UPFStatus == smf_context.AssociatedSetUpSuccess && !upf.RecoveryTimeStamp.IsZero() &&
+			upf.RecoveryTimeStamp.Before(req.RecoveryTimeStamp.RecoveryTimeStamp)
+		upf.RecoveryTimeStamp = req.RecoveryTimeStamp.RecoveryTimeStamp
+	}
 
 	// Response with PFCP Association Setup Response
 	cause := pfcpType.Cause{
 		CauseValue: pfcpType.CauseRequestAccepted,
 	}
 	pfcp_message.SendPfcpAssociationSetupResponse(*nodeID, cause)
+
+	if restarted {
+		logger.PfcpLog.Warnf("UPF[%s] restarted", nodeID.ResolveNodeIdToIp().String())
+		go association.RestoreSessionsOfUPF(upf)
+	}
 }
 
 func HandlePfcpAssociationUpdateRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Association Update Request handling is not implemented")
+	req := msg.PfcpMessage.Body.(udp.PFCPAssociationUpdateRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	var cause pfcpType.Cause
+	if req.NodeID == nil {
+		logger.PfcpLog.Errorln("PFCP Association Update Request needs NodeID")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	nodeIDtoIP := req.NodeID.ResolveNodeIdToIp().String()
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*req.NodeID)
+	if upf == nil || upf.UPFStatus != smf_context.AssociatedSetUpSuccess {
+		logger.PfcpLog.Warnf("PFCP Association Update Request : Not Associated with UPF[%s], Request Rejected",
+			nodeIDtoIP)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	logger.PfcpLog.Infof("Handle PFCP Association Update Request with NodeID[%s]", nodeIDtoIP)
+
+	if req.UPFunctionFeatures != nil {
+		upf.UPFunctionFeatures = req.UPFunctionFeatures.SupportedFeatures
+	}
+	if req.UserPlaneIPResourceInformation != nil {
+		upf.UPIPInfo = *req.UserPlaneIPResourceInformation
+	}
+
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	// TS 29.244 6.2.7.3: the UPF is to be released gracefully, its PDU sessions are moved to
+	// other UPFs within the graceful release period
+	var period time.Duration
+	if req.GracefulReleasePeriod != nil {
+		if p, ok := req.GracefulReleasePeriod.Period(); ok {
+			period = p
+		}
+	}
+	if req.PFCPAssociationReleaseRequest != nil && req.PFCPAssociationReleaseRequest.Sarr {
+		logger.PfcpLog.Infof("UPF[%s] requested to release the PFCP association in %v", nodeIDtoIP, period)
+		go association.DrainUPF(upf, period, true)
+	} else if req.PFCPAUReqFlags != nil && req.PFCPAUReqFlags.Parps {
+		logger.PfcpLog.Infof("UPF[%s] prepares to release the PFCP association", nodeIDtoIP)
+		go association.DrainUPF(upf, period, false)
+	}
 }
 
 func HandlePfcpAssociationReleaseRequest(msg *pfcpUdp.Message) {
@@ -68,11 +136,93 @@ func HandlePfcpAssociationReleaseRequest(msg *pfcpUdp.Message) {
 }
 
 func HandlePfcpNodeReportRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Node Report Request handling is not implemented")
+	req := msg.PfcpMessage.Body.(udp.PFCPNodeReportRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	var cause pfcpType.Cause
+	if req.NodeID == nil || req.NodeReportType == nil {
+		logger.PfcpLog.Errorln("PFCP Node Report Request needs NodeID and NodeReportType")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	nodeIDtoIP := req.NodeID.ResolveNodeIdToIp().String()
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*req.NodeID)
+	if upf == nil || upf.UPFStatus != smf_context.AssociatedSetUpSuccess {
+		logger.PfcpLog.Warnf("PFCP Node Report Request : Not Associated with UPF[%s], Request Rejected", nodeIDtoIP)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	if req.NodeReportType.Upfr && req.UserPlanePathFailureReport != nil {
+		for _, peer := range req.UserPlanePathFailureReport.RemoteGTPUPeer {
+			peerIP := remoteGTPUPeerIP(peer)
+			logger.PfcpLog.Warnf("UPF[%s] reported user plane path failure to [%s]", nodeIDtoIP, peerIP)
+			upf.PathFailed(peerIP, func(peerIP net.IP) {
+				smContexts := upf.SMContextsOfRemoteGTPUPeers([]net.IP{peerIP})
+				logger.PfcpLog.Infof("Release %d PDU sessions using the failed user plane path between UPF[%s] and [%s]",
+					len(smContexts), nodeIDtoIP, peerIP)
+				association.ReleasePDUSessions(smContexts)
+			})
+		}
+	}
+
+	if req.NodeReportType.Uprr && req.UserPlanePathRecoveryReport != nil {
+		for _, peer := range req.UserPlanePathRecoveryReport.RemoteGTPUPeer {
+			peerIP := remoteGTPUPeerIP(peer)
+			if upf.PathRecovered(peerIP) {
+				logger.PfcpLog.Infof("User plane path between UPF[%s] and [%s] recovered, PDU sessions are kept",
+					nodeIDtoIP, peerIP)
+			} else {
+				logger.PfcpLog.Infof("UPF[%s] reported user plane path recovery to [%s]", nodeIDtoIP, peerIP)
+			}
+		}
+	}
+}
+
+func remoteGTPUPeerIP(peer *pfcpType.RemoteGTPUPeer) net.IP {
+	if peer.V4 {
+		return peer.Ipv4Address
+	}
+	return peer.Ipv6Address
 }
 
 func HandlePfcpSessionSetDeletionRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Session Set Deletion Request handling is not implemented")
+	req := msg.PfcpMessage.Body.(udp.PFCPSessionSetDeletionRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	var cause pfcpType.Cause
+	if req.NodeID == nil {
+		logger.PfcpLog.Errorln("PFCP Session Set Deletion Request needs NodeID")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	nodeIDtoIP := req.NodeID.ResolveNodeIdToIp().String()
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*req.NodeID)
+	if upf == nil || upf.UPFStatus != smf_context.AssociatedSetUpSuccess {
+		logger.PfcpLog.Warnf("PFCP Session Set Deletion Request : Not Associated with UPF[%s], Request Rejected",
+			nodeIDtoIP)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	// TS 23.007: the UPF has deleted the PFCP sessions of the sets after a partial failure
+	smContexts := upf.SMContextsOfFQCSIDs(req.FQCSIDs)
+	logger.PfcpLog.Infof("UPF[%s] requested to delete the sets of PFCP sessions, %d PDU sessions are released",
+		nodeIDtoIP, len(smContexts))
+
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	go association.ReleasePDUSessions(smContexts)
 }
 
 func HandlePfcpSessionReportRequest(msg *pfcpUdp.Message) {
@@ -117,63 +267,121 @@ func HandlePfcpSessionReportRequest(msg *pfcpUdp.Message) {
 		return
 	}
 
+	if req.ReportType == nil {
+		logger.PfcpLog.Errorln("PFCP Session Report Request needs ReportType")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpSessionReportResponse(
+			msg.RemoteAddr, cause, seqFromUPF, smContext.PFCPContext[NodeIDtoIPStr].RemoteSEID)
+		return
+	}
+
+	if req.ReportType.Usar && req.UsageReport != nil {
+		smContext.AccountUsage(req.UsageReport.VolumeMeasurement)
+		smContext.SendInterimAccounting()
+	}
+
+	// TS 23.502 4.2.3.3 2b. Send Data Notification Ack, SMF->UPF
+	// The UPF is answered before the paging, which waits for the AMF and may modify the PFCP session
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpSessionReportResponse(
+		msg.RemoteAddr, cause, seqFromUPF, smContext.PFCPContext[NodeIDtoIPStr].RemoteSEID)
+
+	if smContext.UpCnxState == models.UpCnxState_DEACTIVATED && req.ReportType.Dldr {
+		var info *pfcpType.DownlinkDataServiceInformation
+		if req.DownlinkDataReport != nil {
+			info = req.DownlinkDataReport.DownlinkDataServiceInformation
+		}
+		requestUEPaging(smContext, info)
+	}
+}
+
+// HandleBufferedDownlinkData is called when the SMF receives the first downlink packet
+// buffered for a PDU session whose user plane is deactivated
+func HandleBufferedDownlinkData(smContext *smf_context.SMContext) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
 	if smContext.UpCnxState == models.UpCnxState_DEACTIVATED {
-		if req.ReportType.Dldr {
-			downlinkDataReport := req.DownlinkDataReport
+		requestUEPaging(smContext, nil)
+	}
+}
 
-			if downlinkDataReport.DownlinkDataServiceInformation != nil {
-				logger.PfcpLog.Warnf("PFCP Session Report Request DownlinkDataServiceInformation handling is not implemented")
-			}
+func requestUEPaging(smContext *smf_context.SMContext, info *pfcpType.DownlinkDataServiceInformation) {
+	if smContext.PagingInProgress {
+		logger.PfcpLog.Infof("UE[%s] is already being paged, skip N1N2MessageTransfer", smContext.Supi)
+		return
+	}
+	if smContext.DDNBackoffTimer != nil {
+		// TS 23.502 4.2.3.3 3c. no paging for downlink data until the back-off expires
+		logger.PfcpLog.Infof("DDN back-off of UE[%s] is running, skip N1N2MessageTransfer", smContext.Supi)
+		return
+	}
 
-			n1n2Request := models.N1N2MessageTransferRequest{}
+	n1n2Request := models.N1N2MessageTransferRequest{}
 
-			// TS 23.502 4.2.3.3 3a. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
-			if n2SmBuf, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext); err != nil {
-				logger.PduSessLog.Errorln("Build PDUSessionResourceSetupRequestTransfer failed:", err)
-			} else {
-				n1n2Request.BinaryDataN2Information = n2SmBuf
-			}
+	// TS 23.502 4.2.3.3 3a. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
+	if n2SmBuf, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext); err != nil {
+		logger.PduSessLog.Errorln("Build PDUSessionResourceSetupRequestTransfer failed:", err)
+	} else {
+		n1n2Request.BinaryDataN2Information = n2SmBuf
+	}
 
-			n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
+	n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
+		PduSessionId: smContext.PDUSessionID,
+		// TS 23.502 4.2.3.3 5. Namf_Communication_N1N2TransferFailureNotification
+		N1n2FailureTxfNotifURI: fmt.Sprintf("%s://%s:%d/nsmf-callback/sm-n1n2failnotify/%s",
+			smf_context.SMF_Self().URIScheme,
+			smf_context.SMF_Self().RegisterIPv4,
+			smf_context.SMF_Self().SBIPort,
+			smContext.Ref),
+		N2InfoContainer: &models.N2InfoContainer{
+			N2InformationClass: models.N2InformationClass_SM,
+			SmInfo: &models.N2SmInformation{
 				PduSessionId: smContext.PDUSessionID,
-				// Temporarily assign SMF itself, TODO: TS 23.502 4.2.3.3 5. Namf_Communication_N1N2TransferFailureNotification
-				N1n2FailureTxfNotifURI: fmt.Sprintf("%s://%s:%d",
-					smf_context.SMF_Self().URIScheme,
-					smf_context.SMF_Self().RegisterIPv4,
-					smf_context.SMF_Self().SBIPort),
-				N2InfoContainer: &models.N2InfoContainer{
-					N2InformationClass: models.N2InformationClass_SM,
-					SmInfo: &models.N2SmInformation{
-						PduSessionId: smContext.PDUSessionID,
-						N2InfoContent: &models.N2InfoContent{
-							NgapIeType: models.NgapIeType_PDU_RES_SETUP_REQ,
-							NgapData: &models.RefToBinaryData{
-								ContentId: "N2SmInformation",
-							},
-						},
-						SNssai: smContext.Snssai,
+				N2InfoContent: &models.N2InfoContent{
+					NgapIeType: models.NgapIeType_PDU_RES_SETUP_REQ,
+					NgapData: &models.RefToBinaryData{
+						ContentId: "N2SmInformation",
 					},
 				},
-			}
+				SNssai: smContext.Snssai,
+			},
+		},
+	}
+	n1n2Request.JsonData.Ppi, n1n2Request.JsonData.Arp, n1n2Request.JsonData.Var5qi = smContext.PagingPolicy(info)
 
-			rspData, _, err := smContext.CommunicationClient.
-				N1N2MessageCollectionDocumentApi.
-				N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
-			if err != nil {
-				logger.PfcpLog.Warnf("Send N1N2Transfer failed")
-			}
-			if rspData.Cause == models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE {
-				logger.PfcpLog.Infof("Receive %v, AMF is able to page the UE", rspData.Cause)
+	rspData, res, err := consumer.SendN1N2MessageTransfer(smContext, n1n2Request)
+	if res != nil {
+		defer func() {
+			if resCloseErr := res.Body.Close(); resCloseErr != nil {
+				logger.PfcpLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
 			}
-			if rspData.Cause == models.N1N2MessageTransferCause_UE_NOT_RESPONDING {
-				logger.PfcpLog.Warnf("%v", rspData.Cause)
-				// TODO: TS 23.502 4.2.3.3 3c. Failure indication
+		}()
+	}
+	if err != nil {
+		logger.PfcpLog.Warnf("Send N1N2Transfer failed: %v", err)
+		if apiErr, ok := err.(openapi.GenericOpenAPIError); ok {
+			// TS 29.518 5.2.2.3.1 the AMF rejects paging with lower priority than an ongoing one,
+			// and may tell when to retry
+			if transferErr, ok := apiErr.Model().(models.N1N2MessageTransferError); ok &&
+				transferErr.Error != nil && transferErr.Error.Cause == "HIGHER_PRIORITY_REQUEST_ONGOING" {
+				var retryAfter time.Duration
+				if transferErr.ErrInfo != nil {
+					retryAfter = time.Duration(transferErr.ErrInfo.RetryAfter) * time.Second
+				}
+				producer.ThrottleDownlinkDataNotification(smContext, retryAfter)
 			}
 		}
 	}
-
-	// TS 23.502 4.2.3.3 2b. Send Data Notification Ack, SMF->UPF
-	cause.CauseValue = pfcpType.CauseRequestAccepted
-	pfcp_message.SendPfcpSessionReportResponse(
-		msg.RemoteAddr, cause, seqFromUPF, smContext.PFCPContext[NodeIDtoIPStr].RemoteSEID)
+	switch rspData.Cause {
+	case models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE:
+		// the outcome is reported by the failure notification or by the service request
+		logger.PfcpLog.Infof("Receive %v, AMF is able to page the UE", rspData.Cause)
+		smContext.PagingInProgress = true
+	case models.N1N2MessageTransferCause_UE_NOT_RESPONDING,
+		models.N1N2MessageTransferCause_UE_NOT_REACHABLE_FOR_SESSION:
+		// TS 23.502 4.2.3.3 3c. Failure indication
+		logger.PfcpLog.Warnf("%v", rspData.Cause)
+		producer.HandlePagingFailure(smContext)
+	}
 }
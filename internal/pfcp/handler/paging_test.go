@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/Namf_Communication"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+const testAMFURI = "http://127.0.0.16:8000"
+
+// newTestAMF answers the N1N2 message transfers of the session with the status and body, and
+// counts them
+func newTestAMF(t *testing.T, smContext *smf_context.SMContext, status int, body interface{}) chan struct{} {
+	openapi.InterceptH2CClient()
+	t.Cleanup(func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+	})
+	transfers := make(chan struct{}, 8)
+	gock.New(testAMFURI).Persist().
+		Post("/namf-comm/v1/ue-contexts/imsi-208930000000001/n1-n2-messages").
+		AddMatcher(func(*http.Request, *gock.Request) (bool, error) {
+			transfers <- struct{}{}
+			return true, nil
+		}).
+		Reply(status).
+		JSON(body)
+
+	configuration := Namf_Communication.NewConfiguration()
+	configuration.SetBasePath(testAMFURI)
+	smContext.CommunicationClient = Namf_Communication.NewAPIClient(configuration)
+	return transfers
+}
+
+// newPagingSMContext returns an idle SM context with a default path through a UPF which is not
+// associated, so that the PFCP requests of the paging fail at once
+func newPagingSMContext(t *testing.T) *smf_context.SMContext {
+	nodeID := pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: net.ParseIP("10.4.0.41").To4()}
+	upf := smf_context.NewUPF(&nodeID, []factory.InterfaceUpfInfoItem{
+		{InterfaceType: models.UpInterfaceType_N3, Endpoints: []string{"10.4.0.41"}, NetworkInstance: "internet"},
+	})
+	t.Cleanup(func() { smf_context.RemoveUPFNodeByNodeID(nodeID) })
+
+	smContext := smf_context.NewSMContext("imsi-208930000000001", 1)
+	t.Cleanup(func() {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		smf_context.RemoveSMContext(smContext.Ref)
+	})
+	smContext.Supi = "imsi-208930000000001"
+	smContext.Dnn = "internet"
+	smContext.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+	smContext.SelectedPDUSessionType = 1
+	smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+	sessionRule := smf_context.NewSessionRuleFromModel(&models.SessionRule{
+		SessRuleId:   "rule-1",
+		AuthSessAmbr: &models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"},
+		AuthDefQos: &models.AuthorizedDefaultQos{
+			Var5qi: 9,
+			Arp:    &models.Arp{PriorityLevel: 8},
+		},
+	})
+	smf_context.SetSessionRuleActivateState(sessionRule, true)
+	smContext.SessionRules[sessionRule.SessionRuleID] = sessionRule
+
+	node := smf_context.NewDataPathNode()
+	node.UPF = upf
+	node.DownLinkTunnel.PDR = &smf_context.PDR{PDRID: 2, FAR: &smf_context.FAR{FARID: 2}}
+	dataPath := smf_context.NewDataPath()
+	dataPath.IsDefaultPath = true
+	dataPath.Activated = true
+	dataPath.FirstDPNode = node
+	smContext.Tunnel = smf_context.NewUPTunnel()
+	smContext.Tunnel.AddDataPath(dataPath)
+	return smContext
+}
+
+func waitTransfer(t *testing.T, transfers chan struct{}) {
+	select {
+	case <-transfers:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no N1N2 message transfer")
+	}
+}
+
+func TestRequestUEPagingAttemptingToReachUE(t *testing.T) {
+	smContext := newPagingSMContext(t)
+	transfers := newTestAMF(t, smContext, http.StatusOK,
+		models.N1N2MessageTransferRspData{Cause: models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE})
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	requestUEPaging(smContext, nil)
+	waitTransfer(t, transfers)
+	require.True(t, smContext.PagingInProgress)
+	require.Nil(t, smContext.DDNBackoffTimer)
+
+	// the UE is paged once, whatever the downlink data reported meanwhile
+	requestUEPaging(smContext, nil)
+	require.Empty(t, transfers)
+}
+
+func TestRequestUEPagingUENotResponding(t *testing.T) {
+	smContext := newPagingSMContext(t)
+	transfers := newTestAMF(t, smContext, http.StatusOK,
+		models.N1N2MessageTransferRspData{Cause: models.N1N2MessageTransferCause_UE_NOT_RESPONDING})
+	ddnBackoffTimer := smf_context.SMF_Self().DDNBackoffTimer
+	smf_context.SMF_Self().DDNBackoffTimer = time.Hour
+	defer func() { smf_context.SMF_Self().DDNBackoffTimer = ddnBackoffTimer }()
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	requestUEPaging(smContext, nil)
+	waitTransfer(t, transfers)
+	require.False(t, smContext.PagingInProgress)
+	require.NotNil(t, smContext.DDNBackoffTimer)
+	farAction := smContext.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.DownLinkTunnel.PDR.FAR.ApplyAction
+	require.Equal(t, pfcpType.ApplyAction{Drop: true}, farAction)
+
+	// no paging for the downlink data reported during the back-off
+	requestUEPaging(smContext, nil)
+	require.Empty(t, transfers)
+
+	// until it is stopped, e.g. by a service request of the UE
+	smContext.StopDDNBackoff()
+	requestUEPaging(smContext, nil)
+	waitTransfer(t, transfers)
+}
+
+func TestRequestUEPagingHigherPriorityRequestOngoing(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	smfBuffering, delay, packetCount := smfSelf.SMFBuffering, smfSelf.DDNThrottlingDelay, smfSelf.DDNThrottlingPacketCount
+	defer func() {
+		smfSelf.SMFBuffering, smfSelf.DDNThrottlingDelay, smfSelf.DDNThrottlingPacketCount = smfBuffering, delay, packetCount
+	}()
+	smfSelf.SMFBuffering = false
+	smfSelf.DDNThrottlingDelay = time.Second
+	smfSelf.DDNThrottlingPacketCount = 2
+
+	testCases := []struct {
+		name    string
+		errInfo *models.N1N2MsgTxfrErrDetail
+		delay   uint8
+	}{
+		{
+			name:  "configured delay",
+			delay: 20,
+		},
+		{
+			name:    "retry after",
+			errInfo: &models.N1N2MsgTxfrErrDetail{RetryAfter: 5},
+			delay:   100,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := newPagingSMContext(t)
+			transfers := newTestAMF(t, smContext, http.StatusConflict, models.N1N2MessageTransferError{
+				Error: &models.ProblemDetails{
+					Status: http.StatusConflict,
+					Cause:  "HIGHER_PRIORITY_REQUEST_ONGOING",
+				},
+				ErrInfo: tc.errInfo,
+			})
+			bar := &smf_context.BAR{BARID: 1, State: smf_context.RULE_CREATE}
+			smContext.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.DownLinkTunnel.PDR.FAR.BAR = bar
+
+			smContext.SMLock.Lock()
+			defer smContext.SMLock.Unlock()
+			requestUEPaging(smContext, nil)
+			waitTransfer(t, transfers)
+
+			// the DDNs of the UPF are throttled, the session is not paged
+			require.Equal(t, tc.delay, bar.DownlinkDataNotificationDelay.DelayValue)
+			require.Equal(t, uint8(2), bar.SuggestedBufferingPacketsCount.PacketCountValue)
+			require.Equal(t, smf_context.RULE_UPDATE, bar.State)
+			require.False(t, smContext.PagingInProgress)
+			require.Nil(t, smContext.DDNBackoffTimer)
+		})
+	}
+}
@@ -2,6 +2,8 @@ package message
 
 import (
 	"net"
+	"strings"
+	"time"
 
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpType"
@@ -9,8 +11,10 @@ import (
 	"github.com/free5gc/smf/internal/pfcp/udp"
 )
 
-func BuildPfcpAssociationSetupRequest() (pfcp.PFCPAssociationSetupRequest, error) {
-	msg := pfcp.PFCPAssociationSetupRequest{}
+// BuildPfcpAssociationSetupRequest builds the request, asking the UPF to retain the PFCP
+// sessions of the SMF with retainSessions, TS 29.244 6.2.6.2.2
+func BuildPfcpAssociationSetupRequest(retainSessions bool) (udp.PFCPAssociationSetupRequest, error) {
+	msg := udp.PFCPAssociationSetupRequest{}
 
 	msg.NodeID = &context.SMF_Self().CPNodeID
 
@@ -19,7 +23,20 @@ func BuildPfcpAssociationSetupRequest() (pfcp.PFCPAssociationSetupRequest, error
 	}
 
 	msg.CPFunctionFeatures = &pfcpType.CPFunctionFeatures{
-		SupportedFeatures: 0,
+		SupportedFeatures: context.SMF_Self().CPFunctionFeatures(),
+	}
+
+	if retainSessions {
+		msg.PFCPSessionRetentionInformation = &udp.PFCPSessionRetentionInformation{}
+		if cpIP := context.SMF_Self().CPNodeID.ResolveNodeIdToIp(); cpIP.To4() != nil {
+			msg.PFCPSessionRetentionInformation.CPPFCPEntityIPAddress = []*udp.CPPFCPEntityIPAddress{
+				{Ipv4Address: cpIP.To4()},
+			}
+		} else if cpIP != nil {
+			msg.PFCPSessionRetentionInformation.CPPFCPEntityIPAddress = []*udp.CPPFCPEntityIPAddress{
+				{Ipv6Address: cpIP},
+			}
+		}
 	}
 
 	return msg, nil
@@ -37,7 +54,35 @@ func BuildPfcpAssociationSetupResponse(cause pfcpType.Cause) (pfcp.PFCPAssociati
 	}
 
 	msg.CPFunctionFeatures = &pfcpType.CPFunctionFeatures{
-		SupportedFeatures: 0,
+		SupportedFeatures: context.SMF_Self().CPFunctionFeatures(),
+	}
+
+	return msg, nil
+}
+
+// BuildPfcpAssociationUpdateRequest builds the request informing the UPF of the changed features
+// of the SMF, TS 29.244 6.2.7.2
+func BuildPfcpAssociationUpdateRequest() (udp.PFCPAssociationUpdateRequest, error) {
+	msg := udp.PFCPAssociationUpdateRequest{}
+
+	msg.NodeID = &context.SMF_Self().CPNodeID
+
+	msg.CPFunctionFeatures = &pfcpType.CPFunctionFeatures{
+		SupportedFeatures: context.SMF_Self().CPFunctionFeatures(),
+	}
+
+	return msg, nil
+}
+
+func BuildPfcpAssociationUpdateResponse(cause pfcpType.Cause) (pfcp.PFCPAssociationUpdateResponse, error) {
+	msg := pfcp.PFCPAssociationUpdateResponse{}
+
+	msg.NodeID = &context.SMF_Self().CPNodeID
+
+	msg.Cause = &cause
+
+	msg.CPFunctionFeatures = &pfcpType.CPFunctionFeatures{
+		SupportedFeatures: context.SMF_Self().CPFunctionFeatures(),
 	}
 
 	return msg, nil
@@ -61,8 +106,30 @@ func BuildPfcpAssociationReleaseResponse(cause pfcpType.Cause) (pfcp.PFCPAssocia
 	return msg, nil
 }
 
-func pdrToCreatePDR(pdr *context.PDR) *pfcp.CreatePDR {
-	createPDR := new(pfcp.CreatePDR)
+func pdiToPDI(pdi *context.PDI) *udp.PDI {
+	pfcpPDI := &udp.PDI{
+		PDI: pfcp.PDI{
+			SourceInterface: &pdi.SourceInterface,
+			LocalFTEID:      pdi.LocalFTeid,
+			NetworkInstance: pdi.NetworkInstance,
+			UEIPAddress:     pdi.UEIPAddress,
+		},
+	}
+
+	// the downlink packets to the framed routes are detected like the ones to the UE IP address
+	for _, route := range pdi.FramedRoutes {
+		if strings.Contains(route, ":") {
+			pfcpPDI.FramedIPv6Route = append(pfcpPDI.FramedIPv6Route, &udp.FramedIPv6Route{Route: route + " :: 1"})
+		} else {
+			pfcpPDI.FramedRoute = append(pfcpPDI.FramedRoute, &udp.FramedRoute{Route: route + " 0.0.0.0 1"})
+		}
+	}
+
+	return pfcpPDI
+}
+
+func pdrToCreatePDR(pdr *context.PDR) *udp.CreatePDR {
+	createPDR := new(udp.CreatePDR)
 
 	createPDR.PDRID = new(pfcpType.PacketDetectionRuleID)
 	createPDR.PDRID.RuleId = pdr.PDRID
@@ -70,12 +137,7 @@ func pdrToCreatePDR(pdr *context.PDR) *pfcp.CreatePDR {
 	createPDR.Precedence = new(pfcpType.Precedence)
 	createPDR.Precedence.PrecedenceValue = pdr.Precedence
 
-	createPDR.PDI = &pfcp.PDI{
-		SourceInterface: &pdr.PDI.SourceInterface,
-		LocalFTEID:      pdr.PDI.LocalFTeid,
-		NetworkInstance: pdr.PDI.NetworkInstance,
-		UEIPAddress:     pdr.PDI.UEIPAddress,
-	}
+	createPDR.PDI = pdiToPDI(&pdr.PDI)
 
 	if pdr.PDI.ApplicationID != "" {
 		createPDR.PDI.ApplicationID = &pfcpType.ApplicationID{
@@ -101,9 +163,28 @@ func pdrToCreatePDR(pdr *context.PDR) *pfcp.CreatePDR {
 		}
 	}
 
+	if pdr.URR != nil {
+		createPDR.URRID = append(createPDR.URRID, &pfcpType.URRID{
+			UrrIdValue: pdr.URR.URRID,
+		})
+	}
+
 	return createPDR
 }
 
+// urrsOfPDRs returns the URRs of the PDRs, a URR being shared by the PDRs of the session
+func urrsOfPDRs(pdrList []*context.PDR) []*context.URR {
+	var urrs []*context.URR
+	listed := make(map[uint32]bool)
+	for _, pdr := range pdrList {
+		if urr := pdr.URR; urr != nil && !listed[urr.URRID] {
+			listed[urr.URRID] = true
+			urrs = append(urrs, urr)
+		}
+	}
+	return urrs
+}
+
 func farToCreateFAR(far *context.FAR) *pfcp.CreateFAR {
 	createFAR := new(pfcp.CreateFAR)
 
@@ -111,7 +192,10 @@ func farToCreateFAR(far *context.FAR) *pfcp.CreateFAR {
 	createFAR.FARID.FarIdValue = far.FARID
 
 	createFAR.ApplyAction = new(pfcpType.ApplyAction)
-	if far.ForwardingParameters != nil {
+	if far.ApplyAction.Buff || far.ApplyAction.Drop {
+		// e.g. the FAR of an idle UE, created again on a restarted UPF
+		*createFAR.ApplyAction = far.ApplyAction
+	} else if far.ForwardingParameters != nil {
 		createFAR.ApplyAction.Forw = true
 	} else {
 		/*
@@ -152,12 +236,33 @@ func barToCreateBAR(bar *context.BAR) *pfcp.CreateBAR {
 	createBAR.BARID.BarIdValue = bar.BARID
 
 	createBAR.DownlinkDataNotificationDelay = new(pfcpType.DownlinkDataNotificationDelay)
+	*createBAR.DownlinkDataNotificationDelay = bar.DownlinkDataNotificationDelay
 
-	// createBAR.SuggestedBufferingPacketsCount = new(pfcpType.SuggestedBufferingPacketsCount)
+	if bar.SuggestedBufferingPacketsCount.PacketCountValue != 0 {
+		createBAR.SuggestedBufferingPacketsCount = new(pfcpType.SuggestedBufferingPacketsCount)
+		*createBAR.SuggestedBufferingPacketsCount = bar.SuggestedBufferingPacketsCount
+	}
 
 	return createBAR
 }
 
+func barToUpdateBAR(bar *context.BAR) *pfcp.UpdateBARPFCPSessionModificationRequest {
+	updateBAR := new(pfcp.UpdateBARPFCPSessionModificationRequest)
+
+	updateBAR.BARID = new(pfcpType.BARID)
+	updateBAR.BARID.BarIdValue = bar.BARID
+
+	updateBAR.DownlinkDataNotificationDelay = new(pfcpType.DownlinkDataNotificationDelay)
+	*updateBAR.DownlinkDataNotificationDelay = bar.DownlinkDataNotificationDelay
+
+	if bar.SuggestedBufferingPacketsCount.PacketCountValue != 0 {
+		updateBAR.SuggestedBufferingPacketsCount = new(pfcpType.SuggestedBufferingPacketsCount)
+		*updateBAR.SuggestedBufferingPacketsCount = bar.SuggestedBufferingPacketsCount
+	}
+
+	return updateBAR
+}
+
 func qerToCreateQER(qer *context.QER) *pfcp.CreateQER {
 	createQER := new(pfcp.CreateQER)
 
@@ -172,8 +277,45 @@ func qerToCreateQER(qer *context.QER) *pfcp.CreateQER {
 	return createQER
 }
 
-func pdrToUpdatePDR(pdr *context.PDR) *pfcp.UpdatePDR {
-	updatePDR := new(pfcp.UpdatePDR)
+func qerToUpdateQER(qer *context.QER) *pfcp.UpdateQER {
+	updateQER := new(pfcp.UpdateQER)
+
+	updateQER.QERID = new(pfcpType.QERID)
+	updateQER.QERID.QERID = qer.QERID
+	updateQER.GateStatus = qer.GateStatus
+
+	updateQER.QoSFlowIdentifier = &qer.QFI
+	updateQER.MaximumBitrate = qer.MBR
+	updateQER.GuaranteedBitrate = qer.GBR
+
+	return updateQER
+}
+
+// urrToCreateURR measures the volume and the duration of the traffic, reported at the
+// measurement period if any, TS 29.244 7.5.2.4
+func urrToCreateURR(urr *context.URR) *pfcp.CreateURR {
+	createURR := new(pfcp.CreateURR)
+
+	createURR.URRID = &pfcpType.URRID{
+		UrrIdValue: urr.URRID,
+	}
+	createURR.MeasurementMethod = &pfcpType.MeasurementMethod{
+		Volum: true,
+		Durat: true,
+	}
+	createURR.ReportingTriggers = new(pfcpType.ReportingTriggers)
+	if urr.MeasurementPeriod > 0 {
+		createURR.ReportingTriggers.Perio = true
+		createURR.MeasurementPeriod = &pfcpType.MeasurementPeriod{
+			MeasurementPeriod: uint32(urr.MeasurementPeriod / time.Second),
+		}
+	}
+
+	return createURR
+}
+
+func pdrToUpdatePDR(pdr *context.PDR) *udp.UpdatePDR {
+	updatePDR := new(udp.UpdatePDR)
 
 	updatePDR.PDRID = new(pfcpType.PacketDetectionRuleID)
 	updatePDR.PDRID.RuleId = pdr.PDRID
@@ -181,12 +323,7 @@ func pdrToUpdatePDR(pdr *context.PDR) *pfcp.UpdatePDR {
 	updatePDR.Precedence = new(pfcpType.Precedence)
 	updatePDR.Precedence.PrecedenceValue = pdr.Precedence
 
-	updatePDR.PDI = &pfcp.PDI{
-		SourceInterface: &pdr.PDI.SourceInterface,
-		LocalFTEID:      pdr.PDI.LocalFTeid,
-		NetworkInstance: pdr.PDI.NetworkInstance,
-		UEIPAddress:     pdr.PDI.UEIPAddress,
-	}
+	updatePDR.PDI = pdiToPDI(&pdr.PDI)
 
 	if pdr.PDI.ApplicationID != "" {
 		updatePDR.PDI.ApplicationID = &pfcpType.ApplicationID{
@@ -256,8 +393,8 @@ func BuildPfcpSessionEstablishmentRequest(
 	farList []*context.FAR,
 	barList []*context.BAR,
 	qerList []*context.QER,
-) (pfcp.PFCPSessionEstablishmentRequest, error) {
-	msg := pfcp.PFCPSessionEstablishmentRequest{}
+) (udp.PFCPSessionEstablishmentRequest, error) {
+	msg := udp.PFCPSessionEstablishmentRequest{}
 
 	msg.NodeID = &context.SMF_Self().CPNodeID
 
@@ -273,7 +410,7 @@ func BuildPfcpSessionEstablishmentRequest(
 		Ipv4Address: context.SMF_Self().CPNodeID.ResolveNodeIdToIp(),
 	}
 
-	msg.CreatePDR = make([]*pfcp.CreatePDR, 0)
+	msg.CreatePDR = make([]*udp.CreatePDR, 0)
 	msg.CreateFAR = make([]*pfcp.CreateFAR, 0)
 
 	for _, pdr := range pdrList {
@@ -310,6 +447,13 @@ func BuildPfcpSessionEstablishmentRequest(
 		filteredQER.State = context.RULE_CREATE
 	}
 
+	for _, urr := range urrsOfPDRs(pdrList) {
+		if urr.State == context.RULE_INITIAL {
+			msg.CreateURR = append(msg.CreateURR, urrToCreateURR(urr))
+		}
+		urr.State = context.RULE_CREATE
+	}
+
 	msg.PDNType = &pfcpType.PDNType{
 		PdnType: pfcpType.PDNTypeIpv4,
 	}
@@ -366,10 +510,10 @@ func BuildPfcpSessionModificationRequest(
 	farList []*context.FAR,
 	barList []*context.BAR,
 	qerList []*context.QER,
-) (pfcp.PFCPSessionModificationRequest, error) {
-	msg := pfcp.PFCPSessionModificationRequest{}
+) (udp.PFCPSessionModificationRequest, error) {
+	msg := udp.PFCPSessionModificationRequest{}
 
-	msg.UpdatePDR = make([]*pfcp.UpdatePDR, 0, 2)
+	msg.UpdatePDR = make([]*udp.UpdatePDR, 0, 2)
 	msg.UpdateFAR = make([]*pfcp.UpdateFAR, 0, 2)
 
 	nodeIDtoIP := upNodeID.ResolveNodeIdToIp().String()
@@ -419,17 +563,42 @@ func BuildPfcpSessionModificationRequest(
 		switch bar.State {
 		case context.RULE_INITIAL:
 			msg.CreateBAR = append(msg.CreateBAR, barToCreateBAR(bar))
+		case context.RULE_UPDATE:
+			// only one BAR can be updated in a PFCP Session Modification Request
+			msg.UpdateBAR = barToUpdateBAR(bar)
+		case context.RULE_REMOVE:
+			msg.RemoveBAR = append(msg.RemoveBAR, &pfcp.RemoveBAR{
+				BARID: &pfcpType.BARID{
+					BarIdValue: bar.BARID,
+				},
+			})
 		}
+		bar.State = context.RULE_CREATE
 	}
 
 	for _, qer := range qerList {
 		switch qer.State {
 		case context.RULE_INITIAL:
 			msg.CreateQER = append(msg.CreateQER, qerToCreateQER(qer))
+		case context.RULE_UPDATE:
+			msg.UpdateQER = append(msg.UpdateQER, qerToUpdateQER(qer))
+		case context.RULE_REMOVE:
+			msg.RemoveQER = append(msg.RemoveQER, &pfcp.RemoveQER{
+				QERID: &pfcpType.QERID{
+					QERID: qer.QERID,
+				},
+			})
 		}
 		qer.State = context.RULE_CREATE
 	}
 
+	for _, urr := range urrsOfPDRs(pdrList) {
+		if urr.State == context.RULE_INITIAL {
+			msg.CreateURR = append(msg.CreateURR, urrToCreateURR(urr))
+		}
+		urr.State = context.RULE_CREATE
+	}
+
 	return msg, nil
 }
 
@@ -491,6 +660,36 @@ func BuildPfcpSessionReportResponse(cause pfcpType.Cause) (pfcp.PFCPSessionRepor
 	return msg, nil
 }
 
+func BuildPfcpNodeReportResponse(cause pfcpType.Cause) (pfcp.PFCPNodeReportResponse, error) {
+	msg := pfcp.PFCPNodeReportResponse{}
+
+	msg.NodeID = &context.SMF_Self().CPNodeID
+
+	msg.Cause = &cause
+
+	return msg, nil
+}
+
+func BuildPfcpSessionSetDeletionRequest(fqcsids []*context.FQCSID) (udp.PFCPSessionSetDeletionRequest, error) {
+	msg := udp.PFCPSessionSetDeletionRequest{}
+
+	msg.NodeID = &context.SMF_Self().CPNodeID
+
+	msg.FQCSIDs = fqcsids
+
+	return msg, nil
+}
+
+func BuildPfcpSessionSetDeletionResponse(cause pfcpType.Cause) (pfcp.PFCPSessionSetDeletionResponse, error) {
+	msg := pfcp.PFCPSessionSetDeletionResponse{}
+
+	msg.NodeID = &context.SMF_Self().CPNodeID
+
+	msg.Cause = &cause
+
+	return msg, nil
+}
+
 func BuildPfcpHeartbeatRequest() (pfcp.HeartbeatRequest, error) {
 	msg := pfcp.HeartbeatRequest{}
 
@@ -0,0 +1,47 @@
+package message_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/pfcp/message"
+	"github.com/free5gc/smf/internal/pfcp/udp"
+)
+
+func TestBuildFramedRoutes(t *testing.T) {
+	upNodeID := pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: net.ParseIP("10.4.0.1").To4()}
+	smContext := context.NewSMContext("imsi-208930000000040", 1)
+	defer context.RemoveSMContext(smContext.Ref)
+	smContext.PFCPContext[upNodeID.ResolveNodeIdToIp().String()] = &context.PFCPSessionContext{LocalSEID: 1}
+
+	pdr := &context.PDR{
+		PDRID: 2,
+		PDI: context.PDI{
+			SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceSgiLanN6Lan},
+			UEIPAddress:     &pfcpType.UEIPAddress{V4: true, Sd: true, Ipv4Address: net.ParseIP("10.60.0.1").To4()},
+			FramedRoutes:    []string{"10.1.0.0/16", "2001:db8::/48"},
+		},
+		FAR: &context.FAR{FARID: 2},
+	}
+
+	establishment, err := message.BuildPfcpSessionEstablishmentRequest(upNodeID, smContext,
+		[]*context.PDR{pdr}, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, establishment.CreatePDR, 1)
+	pdi := establishment.CreatePDR[0].PDI
+	require.Equal(t, pdr.PDI.UEIPAddress, pdi.UEIPAddress)
+	require.Equal(t, []*udp.FramedRoute{{Route: "10.1.0.0/16 0.0.0.0 1"}}, pdi.FramedRoute)
+	require.Equal(t, []*udp.FramedIPv6Route{{Route: "2001:db8::/48 :: 1"}}, pdi.FramedIPv6Route)
+
+	// the PDI of an updated PDR replaces the former one, the framed routes are kept
+	pdr.State = context.RULE_UPDATE
+	modification, err := message.BuildPfcpSessionModificationRequest(upNodeID, smContext,
+		[]*context.PDR{pdr}, nil, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, modification.UpdatePDR, 1)
+	require.Equal(t, pdi, modification.UpdatePDR[0].PDI)
+}
@@ -19,8 +19,10 @@ func getSeqNumber() uint32 {
 	return atomic.AddUint32(&seq, 1)
 }
 
-func SendPfcpAssociationSetupRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUdp.Message, err error) {
-	pfcpMsg, err := BuildPfcpAssociationSetupRequest()
+func SendPfcpAssociationSetupRequest(upNodeID pfcpType.NodeID, retainSessions bool) (
+	resMsg *pfcpUdp.Message, err error,
+) {
+	pfcpMsg, err := BuildPfcpAssociationSetupRequest(retainSessions)
 	if err != nil {
 		return nil, fmt.Errorf("Build PFCP Association Setup Request failed: %v", err)
 	}
@@ -79,6 +81,61 @@ func SendPfcpAssociationSetupResponse(upNodeID pfcpType.NodeID, cause pfcpType.C
 	udp.SendPfcpResponse(message, addr)
 }
 
+func SendPfcpAssociationUpdateRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUdp.Message, err error) {
+	pfcpMsg, err := BuildPfcpAssociationUpdateRequest()
+	if err != nil {
+		return nil, fmt.Errorf("Build PFCP Association Update Request failed: %v", err)
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST,
+			SequenceNumber: getSeqNumber(),
+		},
+		Body: pfcpMsg,
+	}
+
+	addr := &net.UDPAddr{
+		IP:   upNodeID.ResolveNodeIdToIp(),
+		Port: pfcpUdp.PFCP_PORT,
+	}
+
+	resMsg, err = udp.SendPfcpRequest(message, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if resMsg.MessageType() != pfcp.PFCP_ASSOCIATION_UPDATE_RESPONSE {
+		return resMsg, fmt.Errorf("received unexpected response message")
+	}
+
+	return resMsg, nil
+}
+
+func SendPfcpAssociationUpdateResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpAssociationUpdateResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Association Update Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_ASSOCIATION_UPDATE_RESPONSE,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
 func SendPfcpAssociationReleaseRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUdp.Message, err error) {
 	pfcpMsg, err := BuildPfcpAssociationReleaseRequest()
 	if err != nil {
@@ -156,6 +213,7 @@ func SendPfcpSessionEstablishmentRequest(
 		logger.PfcpLog.Errorf("Build PFCP Session Establishment Request failed: %v", err)
 		return
 	}
+	pfcpMsg.PGWCFQCSID = upf.SMFFQCSID()
 
 	message := &pfcp.Message{
 		Header: pfcp.Header{
@@ -392,6 +450,89 @@ func SendPfcpSessionReportResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqF
 	udp.SendPfcpResponse(message, addr)
 }
 
+func SendPfcpNodeReportResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpNodeReportResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Node Report Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_NODE_REPORT_RESPONSE,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
+func SendPfcpSessionSetDeletionRequest(upf *context.UPF, fqcsids []*context.FQCSID) (
+	resMsg *pfcpUdp.Message, err error,
+) {
+	nodeIDtoIP := upf.NodeID.ResolveNodeIdToIp()
+	if upf.UPFStatus != context.AssociatedSetUpSuccess {
+		return nil, fmt.Errorf("Not Associated with UPF[%s]", nodeIDtoIP.String())
+	}
+
+	pfcpMsg, err := BuildPfcpSessionSetDeletionRequest(fqcsids)
+	if err != nil {
+		return nil, fmt.Errorf("Build PFCP Session Set Deletion Request failed: %v", err)
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_SESSION_SET_DELETION_REQUEST,
+			SequenceNumber: getSeqNumber(),
+		},
+		Body: pfcpMsg,
+	}
+
+	addr := &net.UDPAddr{
+		IP:   nodeIDtoIP,
+		Port: pfcpUdp.PFCP_PORT,
+	}
+
+	resMsg, err = udp.SendPfcpRequest(message, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if resMsg.MessageType() != pfcp.PFCP_SESSION_SET_DELETION_RESPONSE {
+		return resMsg, fmt.Errorf("received unexpected response message")
+	}
+
+	return resMsg, nil
+}
+
+func SendPfcpSessionSetDeletionResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpSessionSetDeletionResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Session Set Deletion Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_SESSION_SET_DELETION_RESPONSE,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
 func SendPfcpHeartbeatRequest(upf *context.UPF) (resMsg *pfcpUdp.Message, err error) {
 	pfcpMsg, err := BuildPfcpHeartbeatRequest()
 	if err != nil {
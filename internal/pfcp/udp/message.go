@@ -0,0 +1,360 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/tlv"
+)
+
+// IE type of FQ-CSID, TS 29.244 8.1.2
+const ieTypeFQCSID = 65
+
+// The pfcp library keeps the FQ-CSID, User Plane Path Failure Report, Graceful Release Period
+// and Framed-Route IEs as raw bytes, which it can neither encode nor decode, and misses some
+// others.
+// The messages carrying them are decoded into the following types instead.
+
+type PFCPSessionEstablishmentRequest struct {
+	pfcp.PFCPSessionEstablishmentRequest `tlv:"0"`
+	CreatePDR                            []*CreatePDR    `tlv:"1"`
+	PGWCFQCSID                           *context.FQCSID `tlv:"65"`
+}
+
+type PFCPSessionModificationRequest struct {
+	pfcp.PFCPSessionModificationRequest `tlv:"0"`
+	CreatePDR                           []*CreatePDR `tlv:"1"`
+	UpdatePDR                           []*UpdatePDR `tlv:"9"`
+}
+
+type CreatePDR struct {
+	pfcp.CreatePDR `tlv:"0"`
+	PDI            *PDI `tlv:"2"`
+}
+
+type UpdatePDR struct {
+	pfcp.UpdatePDR `tlv:"0"`
+	PDI            *PDI `tlv:"2"`
+}
+
+type PDI struct {
+	pfcp.PDI        `tlv:"0"`
+	FramedRoute     []*FramedRoute     `tlv:"153"`
+	FramedIPv6Route []*FramedIPv6Route `tlv:"155"`
+}
+
+type PFCPAssociationSetupRequest struct {
+	pfcp.PFCPAssociationSetupRequest `tlv:"0"`
+	PFCPSessionRetentionInformation  *PFCPSessionRetentionInformation `tlv:"183"`
+}
+
+// PFCPSessionRetentionInformation is the PFCP Session Retention Information IE, asking the UP
+// function to keep the PFCP sessions of the restarted CP function, TS 29.244 7.4.4.1-2
+type PFCPSessionRetentionInformation struct {
+	CPPFCPEntityIPAddress []*CPPFCPEntityIPAddress `tlv:"185"`
+}
+
+type PFCPAssociationUpdateRequest struct {
+	NodeID                         *pfcpType.NodeID                         `tlv:"60"`
+	UPFunctionFeatures             *pfcpType.UPFunctionFeatures             `tlv:"43"`
+	CPFunctionFeatures             *pfcpType.CPFunctionFeatures             `tlv:"89"`
+	PFCPAssociationReleaseRequest  *PFCPAssociationReleaseRequest           `tlv:"111"`
+	GracefulReleasePeriod          *GracefulReleasePeriod                   `tlv:"112"`
+	PFCPAUReqFlags                 *PFCPAUReqFlags                          `tlv:"162"`
+	UserPlaneIPResourceInformation *pfcpType.UserPlaneIPResourceInformation `tlv:"116"`
+}
+
+type PFCPNodeReportRequest struct {
+	NodeID                      *pfcpType.NodeID     `tlv:"60"`
+	NodeReportType              *NodeReportType      `tlv:"101"`
+	UserPlanePathFailureReport  *UserPlanePathReport `tlv:"102"`
+	UserPlanePathRecoveryReport *UserPlanePathReport `tlv:"187"`
+}
+
+type UserPlanePathReport struct {
+	RemoteGTPUPeer []*pfcpType.RemoteGTPUPeer `tlv:"103"`
+}
+
+type PFCPSessionSetDeletionRequest struct {
+	NodeID *pfcpType.NodeID `tlv:"60"`
+	// SGW-C, PGW-C/SMF, SGW-U, PGW-U/UPF, TWAN, ePDG and MME FQ-CSIDs, in this order if present
+	FQCSIDs []*context.FQCSID `tlv:"65"`
+}
+
+// NodeReportType is the Node Report Type IE, TS 29.244 8.2.69
+type NodeReportType struct {
+	Upfr bool
+	Uprr bool
+}
+
+func (n *NodeReportType) MarshalBinary() (data []byte, err error) {
+	var octet uint8
+	if n.Upfr {
+		octet |= 0x01
+	}
+	if n.Uprr {
+		octet |= 0x02
+	}
+	return []byte{octet}, nil
+}
+
+func (n *NodeReportType) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("Inadequate TLV length: %d", len(data))
+	}
+	n.Upfr = data[0]&0x01 != 0
+	n.Uprr = data[0]&0x02 != 0
+	return nil
+}
+
+// FramedRoute is the Framed-Route IE, TS 29.244 8.2.109, the value of the Framed-Route attribute
+// of RFC 2865 5.22, e.g. "10.1.0.0/16 0.0.0.0 1"
+type FramedRoute struct {
+	Route string
+}
+
+func (f *FramedRoute) MarshalBinary() (data []byte, err error) {
+	return []byte(f.Route), nil
+}
+
+func (f *FramedRoute) UnmarshalBinary(data []byte) error {
+	f.Route = string(data)
+	return nil
+}
+
+// FramedIPv6Route is the Framed-IPv6-Route IE, TS 29.244 8.2.111, the value of the
+// Framed-IPv6-Route attribute of RFC 3162 2.5, e.g. "2001:db8::/48 :: 1"
+type FramedIPv6Route struct {
+	Route string
+}
+
+func (f *FramedIPv6Route) MarshalBinary() (data []byte, err error) {
+	return []byte(f.Route), nil
+}
+
+func (f *FramedIPv6Route) UnmarshalBinary(data []byte) error {
+	f.Route = string(data)
+	return nil
+}
+
+// CPPFCPEntityIPAddress is the CP PFCP Entity IP Address IE, TS 29.244 8.2.154
+type CPPFCPEntityIPAddress struct {
+	Ipv4Address net.IP
+	Ipv6Address net.IP
+}
+
+func (c *CPPFCPEntityIPAddress) MarshalBinary() (data []byte, err error) {
+	var octet uint8
+	if c.Ipv4Address != nil {
+		octet |= 0x01
+	}
+	if c.Ipv6Address != nil {
+		octet |= 0x02
+	}
+	data = append(data, octet)
+	if c.Ipv4Address != nil {
+		ipv4 := c.Ipv4Address.To4()
+		if ipv4 == nil {
+			return nil, fmt.Errorf("invalid IPv4 address: %s", c.Ipv4Address)
+		}
+		data = append(data, ipv4...)
+	}
+	if c.Ipv6Address != nil {
+		data = append(data, c.Ipv6Address.To16()...)
+	}
+	return data, nil
+}
+
+func (c *CPPFCPEntityIPAddress) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("Inadequate TLV length: %d", len(data))
+	}
+	octet, data := data[0], data[1:]
+	if octet&0x01 != 0 {
+		if len(data) < net.IPv4len {
+			return fmt.Errorf("Inadequate TLV length: %d", len(data))
+		}
+		c.Ipv4Address, data = net.IP(data[:net.IPv4len]), data[net.IPv4len:]
+	}
+	if octet&0x02 != 0 {
+		if len(data) < net.IPv6len {
+			return fmt.Errorf("Inadequate TLV length: %d", len(data))
+		}
+		c.Ipv6Address = net.IP(data[:net.IPv6len])
+	}
+	return nil
+}
+
+// PFCPAssociationReleaseRequest is the PFCP Association Release Request IE, TS 29.244 8.2.75
+type PFCPAssociationReleaseRequest struct {
+	// PFCP association release requested by the UP function
+	Sarr bool
+	// non-zero usage reports sent for the affected sessions
+	Urss bool
+}
+
+func (p *PFCPAssociationReleaseRequest) MarshalBinary() (data []byte, err error) {
+	var octet uint8
+	if p.Sarr {
+		octet |= 0x01
+	}
+	if p.Urss {
+		octet |= 0x02
+	}
+	return []byte{octet}, nil
+}
+
+func (p *PFCPAssociationReleaseRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("Inadequate TLV length: %d", len(data))
+	}
+	p.Sarr = data[0]&0x01 != 0
+	p.Urss = data[0]&0x02 != 0
+	return nil
+}
+
+// GracefulReleasePeriod is the Graceful Release Period IE, TS 29.244 8.2.76, encoded as
+// the GPRS Timer of TS 24.008 10.5.7.3
+type GracefulReleasePeriod struct {
+	TimerUnit  uint8
+	TimerValue uint8
+}
+
+const gracefulReleasePeriodInfinite uint8 = 7
+
+func (g *GracefulReleasePeriod) MarshalBinary() (data []byte, err error) {
+	return []byte{g.TimerUnit<<5 | g.TimerValue&0x1f}, nil
+}
+
+func (g *GracefulReleasePeriod) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("Inadequate TLV length: %d", len(data))
+	}
+	g.TimerUnit = data[0] >> 5
+	g.TimerValue = data[0] & 0x1f
+	return nil
+}
+
+// Period returns the graceful release period, and false if it is infinite
+func (g *GracefulReleasePeriod) Period() (time.Duration, bool) {
+	value := time.Duration(g.TimerValue)
+	switch g.TimerUnit {
+	case 0:
+		return value * 2 * time.Second, true
+	case 2:
+		return value * 10 * time.Minute, true
+	case 3:
+		return value * time.Hour, true
+	case 4:
+		return value * 10 * time.Hour, true
+	case gracefulReleasePeriodInfinite:
+		return 0, false
+	default:
+		// other values are interpreted as multiples of 1 minute
+		return value * time.Minute, true
+	}
+}
+
+// PFCPAUReqFlags is the PFCPAUReq Flags IE, TS 29.244 8.2.142
+type PFCPAUReqFlags struct {
+	// PFCP association release preparation start
+	Parps bool
+}
+
+func (p *PFCPAUReqFlags) MarshalBinary() (data []byte, err error) {
+	var octet uint8
+	if p.Parps {
+		octet |= 0x01
+	}
+	return []byte{octet}, nil
+}
+
+func (p *PFCPAUReqFlags) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("Inadequate TLV length: %d", len(data))
+	}
+	p.Parps = data[0]&0x01 != 0
+	return nil
+}
+
+// unmarshal decodes a PFCP message, including the IEs the pfcp library cannot decode
+func unmarshal(m *pfcp.Message, data []byte) error {
+	if err := m.Header.UnmarshalBinary(data); err != nil {
+		return fmt.Errorf("pfcp: unmarshal msg failed: %s", err)
+	}
+	if int(m.Header.MessageLength) != len(data)-4 {
+		return fmt.Errorf("Incorrect Message Length: Expected %d, got %d", m.Header.MessageLength, len(data)-4)
+	}
+
+	switch m.Header.MessageType {
+	case pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST:
+		body := PFCPAssociationUpdateRequest{}
+		if err := tlv.Unmarshal(data[m.Header.Len():], &body); err != nil {
+			return err
+		}
+		m.Body = body
+	case pfcp.PFCP_NODE_REPORT_REQUEST:
+		body := PFCPNodeReportRequest{}
+		if err := tlv.Unmarshal(data[m.Header.Len():], &body); err != nil {
+			return err
+		}
+		m.Body = body
+	case pfcp.PFCP_SESSION_SET_DELETION_REQUEST:
+		body := PFCPSessionSetDeletionRequest{}
+		if err := tlv.Unmarshal(data[m.Header.Len():], &body); err != nil {
+			return err
+		}
+		m.Body = body
+	case pfcp.PFCP_SESSION_ESTABLISHMENT_RESPONSE:
+		// the UPF includes its FQ-CSID if it supports partial failure handling
+		ies, fqcsids, err := extractIEs(data[m.Header.Len():], ieTypeFQCSID)
+		if err != nil {
+			return err
+		}
+		body := pfcp.PFCPSessionEstablishmentResponse{}
+		if err := tlv.Unmarshal(ies, &body); err != nil {
+			return err
+		}
+		// SGW-U FQ-CSID, then PGW-U/UPF FQ-CSID
+		switch len(fqcsids) {
+		case 0:
+		case 1:
+			body.PGWUFQCSID = &pfcpType.FQCSID{FQCSIDdata: fqcsids[0]}
+		default:
+			body.SGWUFQCSID = &pfcpType.FQCSID{FQCSIDdata: fqcsids[0]}
+			body.PGWUFQCSID = &pfcpType.FQCSID{FQCSIDdata: fqcsids[1]}
+		}
+		m.Body = body
+	default:
+		return m.Unmarshal(data)
+	}
+	return nil
+}
+
+// extractIEs removes the IEs of the type from the message body, and returns their values
+func extractIEs(body []byte, ieType uint16) ([]byte, [][]byte, error) {
+	rest := new(bytes.Buffer)
+	values := make([][]byte, 0)
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, nil, fmt.Errorf("Inadequate IE length: %d", len(body))
+		}
+		length := int(binary.BigEndian.Uint16(body[2:4]))
+		if len(body) < 4+length {
+			return nil, nil, fmt.Errorf("Inadequate IE length: %d", len(body))
+		}
+		if binary.BigEndian.Uint16(body[0:2]) == ieType {
+			values = append(values, body[4:4+length])
+		} else {
+			rest.Write(body[:4+length])
+		}
+		body = body[4+length:]
+	}
+	return rest.Bytes(), values, nil
+}
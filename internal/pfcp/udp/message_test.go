@@ -0,0 +1,159 @@
+package udp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/tlv"
+)
+
+func TestUnmarshal(t *testing.T) {
+	nodeID := &pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("10.4.0.11").To4(),
+	}
+
+	testCases := []struct {
+		name string
+		msg  pfcp.Message
+	}{
+		{
+			name: "Node Report Request",
+			msg: pfcp.Message{
+				Header: pfcp.Header{
+					Version:        pfcp.PfcpVersion,
+					MessageType:    pfcp.PFCP_NODE_REPORT_REQUEST,
+					SequenceNumber: 1,
+				},
+				Body: PFCPNodeReportRequest{
+					NodeID:         nodeID,
+					NodeReportType: &NodeReportType{Upfr: true},
+					UserPlanePathFailureReport: &UserPlanePathReport{
+						RemoteGTPUPeer: []*pfcpType.RemoteGTPUPeer{
+							{V4: true, Ipv4Address: net.ParseIP("10.200.200.1").To4()},
+							{V4: true, Ipv4Address: net.ParseIP("10.200.200.2").To4()},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Association Update Request",
+			msg: pfcp.Message{
+				Header: pfcp.Header{
+					Version:        pfcp.PfcpVersion,
+					MessageType:    pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST,
+					SequenceNumber: 3,
+				},
+				Body: PFCPAssociationUpdateRequest{
+					NodeID:                        nodeID,
+					PFCPAssociationReleaseRequest: &PFCPAssociationReleaseRequest{Sarr: true},
+					GracefulReleasePeriod:         &GracefulReleasePeriod{TimerUnit: 1, TimerValue: 5},
+				},
+			},
+		},
+		{
+			name: "Session Set Deletion Request",
+			msg: pfcp.Message{
+				Header: pfcp.Header{
+					Version:        pfcp.PfcpVersion,
+					MessageType:    pfcp.PFCP_SESSION_SET_DELETION_REQUEST,
+					SequenceNumber: 2,
+				},
+				Body: PFCPSessionSetDeletionRequest{
+					NodeID: nodeID,
+					FQCSIDs: []*context.FQCSID{
+						context.NewFQCSID(net.ParseIP("10.4.0.11"), 1, 2),
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := tc.msg.Marshal()
+			require.NoError(t, err)
+
+			msg := &pfcp.Message{}
+			err = unmarshal(msg, buf)
+			require.NoError(t, err)
+			require.Equal(t, tc.msg.Body, msg.Body)
+		})
+	}
+}
+
+func TestMarshalPFCPSessionRetentionInformation(t *testing.T) {
+	msg := pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MessageType:    pfcp.PFCP_ASSOCIATION_SETUP_REQUEST,
+			SequenceNumber: 1,
+		},
+		Body: PFCPAssociationSetupRequest{
+			PFCPAssociationSetupRequest: pfcp.PFCPAssociationSetupRequest{
+				NodeID: &pfcpType.NodeID{
+					NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+					IP:         net.ParseIP("10.4.0.1").To4(),
+				},
+			},
+			PFCPSessionRetentionInformation: &PFCPSessionRetentionInformation{
+				CPPFCPEntityIPAddress: []*CPPFCPEntityIPAddress{{Ipv4Address: net.ParseIP("10.4.0.1").To4()}},
+			},
+		},
+	}
+	buf, err := msg.Marshal()
+	require.NoError(t, err)
+
+	// a UPF built on the pfcp library, which has no such IE, skips it
+	decoded := &pfcp.Message{}
+	require.NoError(t, decoded.Unmarshal(buf))
+	require.Equal(t, msg.Body.(PFCPAssociationSetupRequest).NodeID,
+		decoded.Body.(pfcp.PFCPAssociationSetupRequest).NodeID)
+
+	_, ies, err := extractIEs(buf[msg.Header.Len():], 183)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0, 185, 0, 5, 0x01, 10, 4, 0, 1}}, ies)
+}
+
+func TestMarshalFramedRoute(t *testing.T) {
+	body := PFCPSessionModificationRequest{
+		CreatePDR: []*CreatePDR{{
+			CreatePDR: pfcp.CreatePDR{
+				PDRID: &pfcpType.PacketDetectionRuleID{RuleId: 2},
+				FARID: &pfcpType.FARID{FarIdValue: 2},
+			},
+			PDI: &PDI{
+				PDI: pfcp.PDI{
+					SourceInterface: &pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceSgiLanN6Lan},
+				},
+				FramedRoute:     []*FramedRoute{{Route: "10.1.0.0/16 0.0.0.0 1"}},
+				FramedIPv6Route: []*FramedIPv6Route{{Route: "2001:db8::/48 :: 1"}},
+			},
+		}},
+	}
+	buf, err := tlv.Marshal(body)
+	require.NoError(t, err)
+
+	// the Create PDR holds the IEs of the pfcp library and the PDI with the framed routes
+	_, createPDRs, err := extractIEs(buf, 1)
+	require.NoError(t, err)
+	require.Len(t, createPDRs, 1)
+	rest, pdis, err := extractIEs(createPDRs[0], 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 56, 0, 2, 0, 2, 0, 108, 0, 4, 0, 0, 0, 2}, rest)
+	require.Len(t, pdis, 1)
+
+	rest, framedRoutes, err := extractIEs(pdis[0], 153)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("10.1.0.0/16 0.0.0.0 1")}, framedRoutes)
+	rest, framedIPv6Routes, err := extractIEs(rest, 155)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("2001:db8::/48 :: 1")}, framedIPv6Routes)
+	require.Equal(t, []byte{0, 20, 0, 1, pfcpType.SourceInterfaceSgiLanN6Lan}, rest)
+}
@@ -0,0 +1,89 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
+)
+
+func TestWriteRequestToRetransmissions(t *testing.T) {
+	defer func(timeout time.Duration) { requestTimeout = timeout }(requestTimeout)
+	requestTimeout = 50 * time.Millisecond
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	p := &pfcpUdp.PfcpServer{Conn: conn}
+	defer func() { _ = conn.Close() }()
+	go func() {
+		for {
+			if _, err := readFrom(p); errors.Is(err, net.ErrClosed) {
+				return
+			}
+		}
+	}()
+
+	// the UPF misses the first transmission of the request and answers the retransmission
+	upf, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer func() { _ = upf.Close() }()
+	go func() {
+		buf := make([]byte, pfcpUdp.PFCP_MAX_UDP_LEN)
+		for received := 1; ; received++ {
+			n, addr, err := upf.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if received == 1 {
+				continue
+			}
+			request := &pfcp.Message{}
+			if err := request.Unmarshal(buf[:n]); err != nil {
+				continue
+			}
+			response := &pfcp.Message{
+				Header: pfcp.Header{
+					Version:        pfcp.PfcpVersion,
+					MessageType:    pfcp.PFCP_HEARTBEAT_RESPONSE,
+					SequenceNumber: request.Header.SequenceNumber,
+				},
+				Body: pfcp.HeartbeatResponse{
+					RecoveryTimeStamp: &pfcpType.RecoveryTimeStamp{RecoveryTimeStamp: time.Now()},
+				},
+			}
+			raw, err := response.Marshal()
+			if err != nil {
+				continue
+			}
+			_, _ = upf.WriteToUDP(raw, addr)
+		}
+	}()
+
+	request := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MessageType:    pfcp.PFCP_HEARTBEAT_REQUEST,
+			SequenceNumber: 7,
+		},
+		Body: pfcp.HeartbeatRequest{
+			RecoveryTimeStamp: &pfcpType.RecoveryTimeStamp{RecoveryTimeStamp: time.Now()},
+		},
+	}
+	rsp, transmissions, err := writeRequestTo(p, request, upf.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	require.Equal(t, pfcp.PFCP_HEARTBEAT_RESPONSE, rsp.MessageType())
+	require.Equal(t, 2, transmissions)
+
+	// no answer at all
+	require.NoError(t, upf.Close())
+	request.Header.SequenceNumber = 8
+	_, transmissions, err = writeRequestTo(p, request, upf.LocalAddr().(*net.UDPAddr))
+	require.Error(t, err)
+	require.Equal(t, pfcp.NumOfResend, transmissions)
+}
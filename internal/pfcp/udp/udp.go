@@ -2,13 +2,16 @@ package udp
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpUdp"
 	"github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 )
 
 const MaxPfcpUdpDataSize = 1024
@@ -29,7 +32,7 @@ func Run(Dispatch func(*pfcpUdp.Message)) {
 
 	go func(p *pfcpUdp.PfcpServer) {
 		for {
-			msg, err := p.ReadFrom()
+			msg, err := readFrom(p)
 			if err != nil {
 				if err == pfcpUdp.ErrReceivedResentRequest {
 					logger.PfcpLog.Infoln(err)
@@ -49,6 +52,50 @@ func Run(Dispatch func(*pfcpUdp.Message)) {
 	ServerStartTime = time.Now()
 }
 
+// readFrom is pfcpUdp.PfcpServer.ReadFrom, with the messages decoded by unmarshal
+func readFrom(p *pfcpUdp.PfcpServer) (*pfcpUdp.Message, error) {
+	buf := make([]byte, pfcpUdp.PFCP_MAX_UDP_LEN)
+	n, addr, err := p.Conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	pfcpMsg := &pfcp.Message{}
+	msg := pfcpUdp.NewMessage(addr, pfcpMsg)
+
+	if err = unmarshal(pfcpMsg, buf[:n]); err != nil {
+		return msg, err
+	}
+
+	if pfcpMsg.IsRequest() {
+		tx, err := p.FindTransaction(pfcpMsg, addr)
+		if err != nil {
+			return msg, err
+		}
+		if tx != nil {
+			// already replied, resend the response
+			tx.EventChannel <- pfcp.ReceiveEvent{
+				Type:       pfcp.ReceiveEventTypeResendRequest,
+				RemoteAddr: addr,
+				RcvMsg:     pfcpMsg,
+			}
+			return msg, pfcpUdp.ErrReceivedResentRequest
+		}
+	} else if pfcpMsg.IsResponse() {
+		tx, err := p.FindTransaction(pfcpMsg, p.Conn.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			return msg, err
+		}
+		tx.EventChannel <- pfcp.ReceiveEvent{
+			Type:       pfcp.ReceiveEventTypeValidResponse,
+			RemoteAddr: addr,
+			RcvMsg:     pfcpMsg,
+		}
+	}
+
+	return msg, nil
+}
+
 func SendPfcpResponse(sndMsg *pfcp.Message, addr *net.UDPAddr) {
 	Server.WriteResponseTo(sndMsg, addr)
 }
@@ -57,5 +104,78 @@ func SendPfcpRequest(sndMsg *pfcp.Message, addr *net.UDPAddr) (rsvMsg *pfcpUdp.M
 	if addr.IP.Equal(net.IPv4zero) {
 		return nil, errors.New("no destination IP address is specified")
 	}
-	return Server.WriteRequestTo(sndMsg, addr)
+	start := time.Now()
+	rsvMsg, transmissions, err := writeRequestTo(Server, sndMsg, addr)
+	metrics.ObservePfcpRequest(upfName(addr.IP), requestName(sndMsg.Header.MessageType), start,
+		transmissions-1, err == nil)
+	return rsvMsg, err
+}
+
+// requestTimeout is the time after which an unanswered request is retransmitted
+var requestTimeout = pfcp.ResendRequestTimeOutPeriod * time.Second
+
+// writeRequestTo is pfcpUdp.PfcpServer.WriteRequestTo, which also returns the number of times
+// the request was transmitted
+func writeRequestTo(p *pfcpUdp.PfcpServer, reqMsg *pfcp.Message, addr *net.UDPAddr) (
+	*pfcpUdp.Message, int, error,
+) {
+	buf, err := reqMsg.Marshal()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tx := pfcp.NewTransaction(reqMsg, buf, p.Conn, addr)
+	if err = p.PutTransaction(tx); err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if rmErr := p.RemoveTransaction(tx); rmErr != nil {
+			logger.PfcpLog.Warnf("RemoveTransaction error: %+v", rmErr)
+		}
+	}()
+
+	for transmissions := 1; transmissions <= pfcp.NumOfResend; transmissions++ {
+		if _, err = tx.Conn.WriteToUDP(tx.SendMsg, tx.DestAddr); err != nil {
+			return nil, transmissions, fmt.Errorf("Request Transaction [%d]: %s", tx.SequenceNumber, err)
+		}
+		select {
+		case event := <-tx.EventChannel:
+			if event.Type == pfcp.ReceiveEventTypeValidResponse {
+				return pfcpUdp.NewMessage(event.RemoteAddr, event.RcvMsg), transmissions, nil
+			}
+		case <-time.After(requestTimeout):
+		}
+	}
+	return nil, pfcp.NumOfResend, fmt.Errorf("Request Transaction [%d]: retry-out", tx.SequenceNumber)
+}
+
+// upfName returns the configured name of the UPF, or its address if it is unknown
+func upfName(ip net.IP) string {
+	if upi := context.GetUserPlaneInformation(); upi != nil {
+		if name := upi.GetUPFNameByIp(ip.String()); name != "" {
+			return name
+		}
+	}
+	return ip.String()
+}
+
+var requestNames = map[pfcp.MessageType]string{
+	pfcp.PFCP_HEARTBEAT_REQUEST:             "HeartbeatRequest",
+	pfcp.PFCP_PFD_MANAGEMENT_REQUEST:        "PFDManagementRequest",
+	pfcp.PFCP_ASSOCIATION_SETUP_REQUEST:     "AssociationSetupRequest",
+	pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST:    "AssociationUpdateRequest",
+	pfcp.PFCP_ASSOCIATION_RELEASE_REQUEST:   "AssociationReleaseRequest",
+	pfcp.PFCP_NODE_REPORT_REQUEST:           "NodeReportRequest",
+	pfcp.PFCP_SESSION_SET_DELETION_REQUEST:  "SessionSetDeletionRequest",
+	pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST: "SessionEstablishmentRequest",
+	pfcp.PFCP_SESSION_MODIFICATION_REQUEST:  "SessionModificationRequest",
+	pfcp.PFCP_SESSION_DELETION_REQUEST:      "SessionDeletionRequest",
+	pfcp.PFCP_SESSION_REPORT_REQUEST:        "SessionReportRequest",
+}
+
+func requestName(messageType pfcp.MessageType) string {
+	if name, ok := requestNames[messageType]; ok {
+		return name
+	}
+	return strconv.Itoa(int(messageType))
 }
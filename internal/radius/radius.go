@@ -0,0 +1,376 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// RADIUS codes, RFC 2865 3 and RFC 2866 3
+const (
+	CodeAccessRequest      uint8 = 1
+	CodeAccessAccept       uint8 = 2
+	CodeAccessReject       uint8 = 3
+	CodeAccountingRequest  uint8 = 4
+	CodeAccountingResponse uint8 = 5
+	CodeAccessChallenge    uint8 = 11
+)
+
+// RADIUS attribute types, RFC 2865 5, RFC 2866 5, RFC 2869 5 and RFC 3579 3
+const (
+	AttrUserName             uint8 = 1
+	AttrNASIPAddress         uint8 = 4
+	AttrServiceType          uint8 = 6
+	AttrFramedProtocol       uint8 = 7
+	AttrFramedIPAddress      uint8 = 8
+	AttrFramedRoute          uint8 = 22
+	AttrState                uint8 = 24
+	AttrClass                uint8 = 25
+	AttrVendorSpecific       uint8 = 26
+	AttrSessionTimeout       uint8 = 27
+	AttrCalledStationID      uint8 = 30
+	AttrCallingStationID     uint8 = 31
+	AttrNASIdentifier        uint8 = 32
+	AttrAcctStatusType       uint8 = 40
+	AttrAcctInputOctets      uint8 = 42
+	AttrAcctOutputOctets     uint8 = 43
+	AttrAcctSessionID        uint8 = 44
+	AttrAcctSessionTime      uint8 = 46
+	AttrAcctInputPackets     uint8 = 47
+	AttrAcctOutputPackets    uint8 = 48
+	AttrAcctTerminateCause   uint8 = 49
+	AttrAcctInputGigawords   uint8 = 52
+	AttrAcctOutputGigawords  uint8 = 53
+	AttrEAPMessage           uint8 = 79
+	AttrMessageAuthenticator uint8 = 80
+	AttrNASPortID            uint8 = 87
+)
+
+// Acct-Status-Type values, RFC 2866 5.1
+const (
+	AcctStatusStart         uint32 = 1
+	AcctStatusStop          uint32 = 2
+	AcctStatusInterimUpdate uint32 = 3
+)
+
+const (
+	headerLength          = 20
+	maxPacketLength       = 4096
+	maxAttributeLength    = 253
+	authenticatorLength   = 16
+	DefaultTimeout        = 3 * time.Second
+	DefaultRetries        = 3
+	DefaultAuthPort       = "1812"
+	DefaultAccountingPort = "1813"
+)
+
+var (
+	ErrTimeout       = errors.New("radius: no response from server")
+	ErrAuthenticator = errors.New("radius: invalid response authenticator")
+)
+
+type Attribute struct {
+	Type  uint8
+	Value []byte
+}
+
+// Packet is a RADIUS packet. The Authenticator of a response is the one of its request until
+// the response is encoded.
+type Packet struct {
+	Code          uint8
+	Identifier    uint8
+	Authenticator [authenticatorLength]byte
+	Attributes    []Attribute
+}
+
+var identifier uint32
+
+// NewRequest returns a request with the next identifier, Access-Requests get a random
+// authenticator
+func NewRequest(code uint8) (*Packet, error) {
+	p := &Packet{
+		Code:       code,
+		Identifier: uint8(atomic.AddUint32(&identifier, 1)),
+	}
+	if code == CodeAccessRequest {
+		if _, err := rand.Read(p.Authenticator[:]); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// NewResponse returns a response of code to the request
+func NewResponse(request *Packet, code uint8) *Packet {
+	return &Packet{
+		Code:          code,
+		Identifier:    request.Identifier,
+		Authenticator: request.Authenticator,
+	}
+}
+
+func (p *Packet) Add(typ uint8, value []byte) {
+	p.Attributes = append(p.Attributes, Attribute{Type: typ, Value: value})
+}
+
+func (p *Packet) AddString(typ uint8, value string) {
+	p.Add(typ, []byte(value))
+}
+
+func (p *Packet) AddUint32(typ uint8, value uint32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+	p.Add(typ, b)
+}
+
+func (p *Packet) AddIPv4(typ uint8, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		p.Add(typ, []byte(ip4))
+	}
+}
+
+// AddVendorSpecific adds a Vendor-Specific attribute carrying a single sub-attribute,
+// RFC 2865 5.26
+func (p *Packet) AddVendorSpecific(vendorID uint32, typ uint8, value []byte) {
+	b := make([]byte, 6, 6+len(value))
+	binary.BigEndian.PutUint32(b, vendorID)
+	b[4] = typ
+	b[5] = uint8(2 + len(value))
+	p.Add(AttrVendorSpecific, append(b, value...))
+}
+
+// Get returns the value of the first attribute of the type, or nil
+func (p *Packet) Get(typ uint8) []byte {
+	for _, attr := range p.Attributes {
+		if attr.Type == typ {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+// GetAll returns the values of all the attributes of the type in order
+func (p *Packet) GetAll(typ uint8) [][]byte {
+	var values [][]byte
+	for _, attr := range p.Attributes {
+		if attr.Type == typ {
+			values = append(values, attr.Value)
+		}
+	}
+	return values
+}
+
+func (p *Packet) GetUint32(typ uint8) (uint32, bool) {
+	if value := p.Get(typ); len(value) == 4 {
+		return binary.BigEndian.Uint32(value), true
+	}
+	return 0, false
+}
+
+func (p *Packet) GetIPv4(typ uint8) net.IP {
+	if value := p.Get(typ); len(value) == net.IPv4len {
+		return net.IP(value)
+	}
+	return nil
+}
+
+// SetEAPMessage splits the EAP packet into EAP-Message attributes, RFC 3579 3.1
+func (p *Packet) SetEAPMessage(eap []byte) {
+	for len(eap) > maxAttributeLength {
+		p.Add(AttrEAPMessage, eap[:maxAttributeLength])
+		eap = eap[maxAttributeLength:]
+	}
+	p.Add(AttrEAPMessage, eap)
+}
+
+// EAPMessage returns the EAP packet of the EAP-Message attributes, or nil
+func (p *Packet) EAPMessage() []byte {
+	return bytes.Join(p.GetAll(AttrEAPMessage), nil)
+}
+
+// Encode returns the packet on the wire. A Message-Authenticator is added to packets carrying
+// EAP, and the Authenticator of accounting requests and of responses is computed.
+func (p *Packet) Encode(secret []byte) ([]byte, error) {
+	attrs := p.Attributes
+	withMessageAuthenticator := p.Get(AttrEAPMessage) != nil && p.Get(AttrMessageAuthenticator) == nil
+	if withMessageAuthenticator {
+		attrs = append(attrs[:len(attrs):len(attrs)], Attribute{
+			Type:  AttrMessageAuthenticator,
+			Value: make([]byte, md5.Size),
+		})
+	}
+
+	length := headerLength
+	for _, attr := range attrs {
+		if len(attr.Value) > maxAttributeLength {
+			return nil, fmt.Errorf("radius: attribute %d too long", attr.Type)
+		}
+		length += 2 + len(attr.Value)
+	}
+	if length > maxPacketLength {
+		return nil, errors.New("radius: packet too long")
+	}
+
+	b := make([]byte, headerLength, length)
+	b[0] = p.Code
+	b[1] = p.Identifier
+	binary.BigEndian.PutUint16(b[2:4], uint16(length))
+	if p.Code != CodeAccountingRequest {
+		copy(b[4:headerLength], p.Authenticator[:])
+	}
+	for _, attr := range attrs {
+		b = append(b, attr.Type, uint8(2+len(attr.Value)))
+		b = append(b, attr.Value...)
+	}
+
+	if withMessageAuthenticator {
+		mac := hmac.New(md5.New, secret)
+		mac.Write(b)
+		copy(b[length-md5.Size:], mac.Sum(nil))
+	}
+
+	if p.Code != CodeAccessRequest {
+		// Request Authenticator of accounting requests (RFC 2866 3) or Response Authenticator
+		hash := md5.New()
+		hash.Write(b)
+		hash.Write(secret)
+		copy(b[4:headerLength], hash.Sum(nil))
+		copy(p.Authenticator[:], b[4:headerLength])
+	}
+	return b, nil
+}
+
+// Decode parses the packet, the authenticators are not verified
+func Decode(b []byte) (*Packet, error) {
+	if len(b) < headerLength {
+		return nil, errors.New("radius: packet too short")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < headerLength || length > len(b) || length > maxPacketLength {
+		return nil, fmt.Errorf("radius: invalid packet length %d", length)
+	}
+
+	p := &Packet{
+		Code:       b[0],
+		Identifier: b[1],
+	}
+	copy(p.Authenticator[:], b[4:headerLength])
+	for attrs := b[headerLength:length]; len(attrs) > 0; {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return nil, errors.New("radius: invalid attribute length")
+		}
+		value := make([]byte, attrs[1]-2)
+		copy(value, attrs[2:attrs[1]])
+		p.Add(attrs[0], value)
+		attrs = attrs[attrs[1]:]
+	}
+	return p, nil
+}
+
+// verifyResponse checks the Response Authenticator and the Message-Authenticator, if any, of
+// the raw response to the request
+func verifyResponse(raw []byte, request *Packet, secret []byte) error {
+	length := int(binary.BigEndian.Uint16(raw[2:4]))
+	b := make([]byte, length)
+	copy(b, raw[:length])
+	copy(b[4:headerLength], request.Authenticator[:])
+
+	hash := md5.New()
+	hash.Write(b)
+	hash.Write(secret)
+	if !hmac.Equal(hash.Sum(nil), raw[4:headerLength]) {
+		return ErrAuthenticator
+	}
+
+	// Message-Authenticator is computed with the Request Authenticator, RFC 3579 3.2
+	for offset := headerLength; offset+2 <= length; offset += int(b[offset+1]) {
+		if b[offset+1] < 2 {
+			break
+		}
+		if b[offset] != AttrMessageAuthenticator || b[offset+1] != 2+md5.Size {
+			continue
+		}
+		received := make([]byte, md5.Size)
+		copy(received, b[offset+2:offset+2+md5.Size])
+		for i := 0; i < md5.Size; i++ {
+			b[offset+2+i] = 0
+		}
+		mac := hmac.New(md5.New, secret)
+		mac.Write(b)
+		if !hmac.Equal(mac.Sum(nil), received) {
+			return errors.New("radius: invalid Message-Authenticator")
+		}
+		break
+	}
+	return nil
+}
+
+// Client exchanges requests with a RADIUS server over UDP
+type Client struct {
+	Addr    string
+	Secret  []byte
+	Timeout time.Duration
+	Retries int
+}
+
+// Exchange sends the request and returns the verified response, the request is retransmitted
+// Retries times when no response is received in Timeout
+func (c *Client) Exchange(request *Packet) (*Packet, error) {
+	raw, err := request.Encode(c.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("udp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	timeout, retries := c.Timeout, c.Retries
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if retries <= 0 {
+		retries = DefaultRetries
+	}
+
+	buf := make([]byte, maxPacketLength)
+	for attempt := 0; attempt < retries; attempt++ {
+		if _, err = conn.Write(raw); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		if err = conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, readErr := conn.Read(buf)
+			if readErr != nil {
+				if netErr, ok := readErr.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return nil, readErr
+			}
+			response, decodeErr := Decode(buf[:n])
+			if decodeErr != nil || response.Identifier != request.Identifier {
+				// not the response of the request, keep waiting
+				continue
+			}
+			if err := verifyResponse(buf[:n], request, c.Secret); err != nil {
+				return nil, err
+			}
+			return response, nil
+		}
+	}
+	return nil, ErrTimeout
+}
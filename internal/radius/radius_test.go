@@ -0,0 +1,128 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("testing123")
+
+// runDNAAA runs a DN-AAA stand-in which challenges the first Access-Request of the EAP exchange
+// and accepts the second one
+func runDNAAA(t *testing.T, secret []byte) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, maxPacketLength)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			request, err := Decode(buf[:n])
+			if err != nil || !validMessageAuthenticator(buf[:n]) {
+				continue
+			}
+
+			var response *Packet
+			switch {
+			case request.Get(AttrState) == nil:
+				response = NewResponse(request, CodeAccessChallenge)
+				response.Add(AttrState, []byte("round-1"))
+				response.SetEAPMessage([]byte{1, 2, 0, 6, 4, 16})
+			case bytes.Equal(request.Get(AttrState), []byte("round-1")):
+				response = NewResponse(request, CodeAccessAccept)
+				response.AddIPv4(AttrFramedIPAddress, net.IPv4(10, 70, 0, 5))
+				response.AddString(AttrFramedRoute, "192.168.10.0/24 0.0.0.0 1")
+				response.AddUint32(AttrSessionTimeout, 3600)
+				response.SetEAPMessage([]byte{3, 2, 0, 4})
+			default:
+				response = NewResponse(request, CodeAccessReject)
+			}
+			raw, err := response.Encode(secret)
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteToUDP(raw, addr)
+		}
+	}()
+	return conn
+}
+
+func validMessageAuthenticator(raw []byte) bool {
+	b := make([]byte, len(raw))
+	copy(b, raw)
+	for offset := headerLength; offset+2 <= len(b); offset += int(b[offset+1]) {
+		if b[offset] != AttrMessageAuthenticator {
+			continue
+		}
+		received := append([]byte(nil), b[offset+2:offset+2+md5.Size]...)
+		copy(b[offset+2:offset+2+md5.Size], make([]byte, md5.Size))
+		mac := hmac.New(md5.New, testSecret)
+		mac.Write(b)
+		return hmac.Equal(mac.Sum(nil), received)
+	}
+	return false
+}
+
+func TestExchangeEAP(t *testing.T) {
+	server := runDNAAA(t, testSecret)
+	defer server.Close()
+	client := &Client{Addr: server.LocalAddr().String(), Secret: testSecret}
+
+	// EAP-Response/Identity
+	request, err := NewRequest(CodeAccessRequest)
+	require.NoError(t, err)
+	request.AddString(AttrUserName, "user@enterprise")
+	request.SetEAPMessage(append([]byte{2, 1, 0, 20, 1}, "user@enterprise"...))
+	response, err := client.Exchange(request)
+	require.NoError(t, err)
+	require.Equal(t, CodeAccessChallenge, response.Code)
+	require.Equal(t, []byte{1, 2, 0, 6, 4, 16}, response.EAPMessage())
+
+	request, err = NewRequest(CodeAccessRequest)
+	require.NoError(t, err)
+	request.Add(AttrState, response.Get(AttrState))
+	request.SetEAPMessage(bytes.Repeat([]byte{2}, 300))
+	require.Len(t, request.GetAll(AttrEAPMessage), 2)
+	response, err = client.Exchange(request)
+	require.NoError(t, err)
+	require.Equal(t, CodeAccessAccept, response.Code)
+	require.Equal(t, net.IPv4(10, 70, 0, 5).To4(), response.GetIPv4(AttrFramedIPAddress))
+	require.Equal(t, [][]byte{[]byte("192.168.10.0/24 0.0.0.0 1")}, response.GetAll(AttrFramedRoute))
+	timeout, ok := response.GetUint32(AttrSessionTimeout)
+	require.True(t, ok)
+	require.Equal(t, uint32(3600), timeout)
+	require.Equal(t, []byte{3, 2, 0, 4}, response.EAPMessage())
+}
+
+func TestExchangeErrors(t *testing.T) {
+	server := runDNAAA(t, []byte("other secret"))
+	defer server.Close()
+
+	request, err := NewRequest(CodeAccessRequest)
+	require.NoError(t, err)
+	request.SetEAPMessage([]byte{2, 1, 0, 5, 1})
+	client := &Client{Addr: server.LocalAddr().String(), Secret: testSecret}
+	_, err = client.Exchange(request)
+	require.Equal(t, ErrAuthenticator, err)
+
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer silent.Close()
+	client = &Client{
+		Addr:    silent.LocalAddr().String(),
+		Secret:  testSecret,
+		Timeout: 50 * time.Millisecond,
+		Retries: 2,
+	}
+	_, err = client.Exchange(request)
+	require.Equal(t, ErrTimeout, err)
+}
@@ -60,3 +60,72 @@ func HTTPSmPolicyUpdateNotification(c *gin.Context) {
 func SmPolicyControlTerminationRequestNotification(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+// HTTPN1N2MessageTransferFailureNotification - Namf_Communication N1N2 Transfer Failure Notification
+func HTTPN1N2MessageTransferFailureNotification(c *gin.Context) {
+	var request models.N1N2MsgTxfrFailureNotification
+	if !deserializeNotification(c, &request) {
+		return
+	}
+
+	reqWrapper := httpwrapper.NewRequest(c.Request, request)
+	reqWrapper.Params["smContextRef"] = c.Params.ByName("smContextRef")
+
+	smContextRef := reqWrapper.Params["smContextRef"]
+	HTTPResponse := producer.HandleN1N2MessageTransferFailureNotification(
+		smContextRef, reqWrapper.Body.(models.N1N2MsgTxfrFailureNotification))
+
+	c.Status(HTTPResponse.Status)
+}
+
+// HTTPSdmChangeNotification - Nudm_SubscriberDataManagement Data Change Notification
+func HTTPSdmChangeNotification(c *gin.Context) {
+	var request models.ModificationNotification
+	if !deserializeNotification(c, &request) {
+		return
+	}
+
+	reqWrapper := httpwrapper.NewRequest(c.Request, request)
+	reqWrapper.Params["smContextRef"] = c.Params.ByName("smContextRef")
+
+	smContextRef := reqWrapper.Params["smContextRef"]
+	HTTPResponse := producer.HandleSDMChangeNotification(
+		smContextRef, reqWrapper.Body.(models.ModificationNotification))
+
+	c.Status(HTTPResponse.Status)
+}
+
+// HTTPNfStatusNotification - Nnrf_NFManagement NF Status Notification
+func HTTPNfStatusNotification(c *gin.Context) {
+	var request models.NotificationData
+	if !deserializeNotification(c, &request) {
+		return
+	}
+
+	HTTPResponse := producer.HandleNfStatusNotification(request)
+	if HTTPResponse.Body != nil {
+		c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+		return
+	}
+	c.Status(HTTPResponse.Status)
+}
+
+// deserializeNotification reads the body of a notification into request. A body which cannot be
+// read is answered with 400 Bad Request, and false is returned.
+func deserializeNotification(c *gin.Context, request interface{}) bool {
+	reqBody, err := c.GetRawData()
+	if err == nil {
+		err = openapi.Deserialize(request, reqBody, c.ContentType())
+	}
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package callback
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func serveNotification(handler gin.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/:smContextRef", handler)
+
+	rsp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rsp, req)
+	return rsp
+}
+
+func TestHTTPSdmChangeNotification(t *testing.T) {
+	rsp := serveNotification(HTTPSdmChangeNotification, "/urn:uuid:unknown", "{")
+	require.Equal(t, http.StatusBadRequest, rsp.Code)
+	require.Contains(t, rsp.Body.String(), "Malformed request syntax")
+
+	rsp = serveNotification(HTTPSdmChangeNotification, "/urn:uuid:unknown",
+		`{"notifyItems":[{"resourceId":"imsi-208930000000001/sm-data"}]}`)
+	require.Equal(t, http.StatusNotFound, rsp.Code)
+}
+
+func TestHTTPNfStatusNotification(t *testing.T) {
+	rsp := serveNotification(HTTPNfStatusNotification, "/nf-status-notify", "{")
+	require.Equal(t, http.StatusBadRequest, rsp.Code)
+	require.Contains(t, rsp.Body.String(), "Malformed request syntax")
+
+	rsp = serveNotification(HTTPNfStatusNotification, "/nf-status-notify",
+		`{"event":"NF_DEREGISTERED","nfInstanceUri":"http://nrf:8000/nnrf-nfm/v1/nf-instances/"}`)
+	require.Equal(t, http.StatusBadRequest, rsp.Code)
+
+	rsp = serveNotification(HTTPNfStatusNotification, "/nf-status-notify",
+		`{"event":"NF_DEREGISTERED","nfInstanceUri":"http://nrf:8000/nnrf-nfm/v1/nf-instances/unknown"}`)
+	require.Equal(t, http.StatusNoContent, rsp.Code)
+}
+
+func TestHTTPN1N2MessageTransferFailureNotification(t *testing.T) {
+	rsp := serveNotification(HTTPN1N2MessageTransferFailureNotification, "/urn:uuid:unknown", "{")
+	require.Equal(t, http.StatusBadRequest, rsp.Code)
+	require.Contains(t, rsp.Body.String(), "Malformed request syntax")
+
+	rsp = serveNotification(HTTPN1N2MessageTransferFailureNotification, "/urn:uuid:unknown",
+		`{"cause":"UE_NOT_RESPONDING","n1n2MsgDataUri":"http://amf:8000/namf-comm/v1/ue-contexts/1"}`)
+	require.Equal(t, http.StatusNotFound, rsp.Code)
+}
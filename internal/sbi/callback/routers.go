@@ -10,9 +10,16 @@
 package callback
 
 import (
+	"net"
+	"net/http"
+	"net/url"
+
 	"github.com/gin-gonic/gin"
 
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/util"
 	logger_util "github.com/free5gc/util/logger"
 )
 
@@ -41,6 +48,27 @@ func NewRouter() *gin.Engine {
 func AddService(engine *gin.Engine) *gin.RouterGroup {
 	group := engine.Group("/nsmf-callback")
 
+	// the notifications are not requests to a service of the SMF, so their access tokens need not
+	// grant a scope
+	routerAuthorizationCheck := util.NewRouterAuthorizationCheck("")
+	group.Use(func(c *gin.Context) {
+		smfSelf := smf_context.SMF_Self()
+		// without access tokens, only the NRF, or the SCP relaying it, may notify the SMF that an
+		// NF deregistered
+		if c.FullPath() == "/nsmf-callback/nf-status-notify" && !smfSelf.OAuth2Required {
+			if !fromNrf(c.Request, smfSelf) {
+				c.JSON(http.StatusForbidden, models.ProblemDetails{
+					Title:  "Forbidden",
+					Status: http.StatusForbidden,
+					Detail: "NF status notifications are only accepted from the NRF",
+				})
+				c.Abort()
+			}
+			return
+		}
+		routerAuthorizationCheck.Check(c, smfSelf)
+	})
+
 	for _, route := range routes {
 		switch route.Method {
 		case "GET":
@@ -70,4 +98,52 @@ var routes = Routes{
 		"/sm-policies/:smContextRef/terminate",
 		SmPolicyControlTerminationRequestNotification,
 	},
+	{
+		"N1N2MessageTransferFailureNotification",
+		"POST",
+		"/sm-n1n2failnotify/:smContextRef",
+		HTTPN1N2MessageTransferFailureNotification,
+	},
+	{
+		"SdmChangeNotification",
+		"POST",
+		"/sdm-notify/:smContextRef",
+		HTTPSdmChangeNotification,
+	},
+	{
+		"NfStatusNotification",
+		"POST",
+		"/nf-status-notify",
+		HTTPNfStatusNotification,
+	},
+}
+
+// fromNrf reports whether the request comes from an address of the NRF or the SCP. The
+// forwarding headers are not trusted, as anyone may set them.
+func fromNrf(req *http.Request, smfSelf *smf_context.SMFContext) bool {
+	source, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	sourceIP := net.ParseIP(source)
+	for _, uri := range []string{smfSelf.NrfUri, smfSelf.ScpUri} {
+		if uri == "" {
+			continue
+		}
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+		addrs, err := net.LookupHost(parsed.Hostname())
+		if err != nil {
+			logger.PduSessLog.Warnf("Resolve %s failed: %v", parsed.Hostname(), err)
+			continue
+		}
+		for _, addr := range addrs {
+			if net.ParseIP(addr).Equal(sourceIP) {
+				return true
+			}
+		}
+	}
+	return false
 }
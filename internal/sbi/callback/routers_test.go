@@ -0,0 +1,38 @@
+package callback
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestNfStatusNotifySource(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	nrfUri, scpUri := smfSelf.NrfUri, smfSelf.ScpUri
+	defer func() { smfSelf.NrfUri, smfSelf.ScpUri = nrfUri, scpUri }()
+	smfSelf.NrfUri, smfSelf.ScpUri = "http://127.0.0.10:8000", "http://127.0.0.11:8000"
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	AddService(router)
+	notify := func(remoteAddr string) int {
+		rsp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/nsmf-callback/nf-status-notify", strings.NewReader(
+			`{"event":"NF_PROFILE_CHANGED","nfInstanceUri":"http://127.0.0.10:8000/nnrf-nfm/v1/nf-instances/udm-1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", "127.0.0.10")
+		req.RemoteAddr = remoteAddr
+		router.ServeHTTP(rsp, req)
+		return rsp.Code
+	}
+
+	require.Equal(t, http.StatusForbidden, notify("10.0.0.1:41000"))
+	require.Equal(t, http.StatusNoContent, notify("127.0.0.10:41000"))
+	require.Equal(t, http.StatusNoContent, notify("127.0.0.11:41000"))
+}
@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/metrics"
+)
+
+// SendN1N2MessageTransfer transfers the N1 and N2 messages of the session to the serving AMF,
+// TS 29.518 5.2.2.3.1. The request fails at once while the circuit breaker of the AMF is open.
+func SendN1N2MessageTransfer(smContext *smf_context.SMContext, request models.N1N2MessageTransferRequest) (
+	models.N1N2MessageTransferRspData, *http.Response, error,
+) {
+	var rspData models.N1N2MessageTransferRspData
+	if smContext.CommunicationClient == nil {
+		if err := selectServingAMF(smContext); err != nil {
+			return rspData, nil, err
+		}
+	}
+	amfID := smContext.AMFProfile.NfInstanceId
+	if err := smf_context.CheckNFInstance(amfID); err != nil {
+		return rspData, nil, err
+	}
+	ctx, cancel, err := smf_context.SMF_Self().GetSBICtx(models.ServiceName_NAMF_COMM, models.NfType_AMF)
+	if err != nil {
+		return rspData, nil, err
+	}
+	defer cancel()
+
+	start := time.Now()
+	rspData, rsp, err := smContext.
+		CommunicationClient.
+		N1N2MessageCollectionDocumentApi.
+		N1N2MessageTransfer(ctx, smContext.Supi, request)
+	metrics.ObserveSBIClient(metrics.ServiceNamfComm, start, rsp)
+	if amfID != "" {
+		if nfFailed(rsp, err) {
+			smf_context.NFInstanceFailed(amfID)
+		} else {
+			smf_context.NFInstanceAnswered(amfID)
+		}
+	}
+	return rspData, rsp, err
+}
+
+// selectServingAMF selects the serving AMF of the session again, after its client was dropped
+// because the AMF deregistered
+func selectServingAMF(smContext *smf_context.SMContext) error {
+	if !smf_context.DelegatedDiscovery() {
+		if smContext.ServingNfId == "" {
+			return fmt.Errorf("UE[%s] has no serving AMF", smContext.Supi)
+		}
+		if problemDetails, err := SendNFDiscoveryServingAMF(smContext); err != nil {
+			return fmt.Errorf("UE[%s] serving AMF discovery failed: %v", smContext.Supi, err)
+		} else if problemDetails != nil {
+			return fmt.Errorf("UE[%s] serving AMF discovery failed: %s", smContext.Supi, problemDetails.Cause)
+		}
+	}
+	smContext.SetCommunicationClient()
+	if smContext.CommunicationClient == nil {
+		return fmt.Errorf("UE[%s] has no serving AMF", smContext.Supi)
+	}
+	return nil
+}
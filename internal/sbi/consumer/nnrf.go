@@ -2,8 +2,11 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strings"
 	"time"
 
@@ -13,18 +16,21 @@ import (
 	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/Nnrf_NFDiscovery"
 	"github.com/free5gc/openapi/Nudm_SubscriberDataManagement"
+	"github.com/free5gc/openapi/Nudm_UEContextManagement"
 	"github.com/free5gc/openapi/models"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 )
 
-func SendNFRegistration() error {
-	smfProfile := smf_context.NFProfile
+// ErrNFNotRegistered is returned by the heartbeat when the NRF no longer knows the SMF
+var ErrNFNotRegistered = errors.New("SMF not registered to the NRF")
 
-	sNssais := []models.Snssai{}
-	for _, snssaiSmfInfo := range *smfProfile.SMFInfo.SNssaiSmfInfoList {
-		sNssais = append(sNssais, *snssaiSmfInfo.SNssai)
-	}
+// DefaultNFHeartBeatTimer is the heartbeat period when the NRF does not ask for one
+const DefaultNFHeartBeatTimer = 60 * time.Second
+
+func buildNFProfile() models.NfProfile {
+	smfProfile := smf_context.NFProfile
 
 	// set nfProfile
 	profile := models.NfProfile{
@@ -34,22 +40,40 @@ func SendNFRegistration() error {
 		Ipv4Addresses: []string{smf_context.SMF_Self().RegisterIPv4},
 		NfServices:    smfProfile.NFServices,
 		SmfInfo:       smfProfile.SMFInfo,
-		SNssais:       &sNssais,
+		SNssais:       sNssaisOf(smfProfile.SMFInfo),
 		PlmnList:      smfProfile.PLMNList,
+		Load:          smf_context.NFLoad(),
 	}
 	if smf_context.SMF_Self().Locality != "" {
 		profile.Locality = smf_context.SMF_Self().Locality
 	}
+	return profile
+}
+
+func sNssaisOf(smfInfo *models.SmfInfo) *[]models.Snssai {
+	sNssais := []models.Snssai{}
+	for _, snssaiSmfInfo := range *smfInfo.SNssaiSmfInfoList {
+		sNssais = append(sNssais, *snssaiSmfInfo.SNssai)
+	}
+	return &sNssais
+}
+
+func SendNFRegistration() error {
+	profile := buildNFProfile()
 	var rep models.NfProfile
 	var res *http.Response
 	var err error
 
 	// Check data (Use RESTful PUT)
 	for {
+		ctx, cancel := context.WithTimeout(context.Background(), smf_context.SMF_Self().SBITimeoutOf(models.NfType_NRF))
+		start := time.Now()
 		rep, res, err = smf_context.SMF_Self().
 			NFManagementClient.
 			NFInstanceIDDocumentApi.
-			RegisterNFInstance(context.TODO(), smf_context.SMF_Self().NfInstanceID, profile)
+			RegisterNFInstance(ctx, smf_context.SMF_Self().NfInstanceID, profile)
+		cancel()
+		metrics.ObserveSBIClient(metrics.ServiceNnrfNfm, start, res)
 		if err != nil || res == nil {
 			logger.ConsumerLog.Infof("SMF register to NRF Error[%s]", err.Error())
 			time.Sleep(2 * time.Second)
@@ -77,10 +101,230 @@ func SendNFRegistration() error {
 		}
 	}
 
+	smf_context.SMF_Self().NrfReportedLoad = profile.Load
+	smf_context.SMF_Self().NrfHeartBeatTimer = DefaultNFHeartBeatTimer
+	if rep.HeartBeatTimer > 0 {
+		smf_context.SMF_Self().NrfHeartBeatTimer = time.Duration(rep.HeartBeatTimer) * time.Second
+	}
 	logger.InitLog.Infof("SMF Registration to NRF %v", rep)
 	return nil
 }
 
+// SendNFHeartbeat sends the heartbeat of the SMF to the NRF, with the changes of its S-NSSAIs,
+// DNNs and load since they were last sent, TS 29.510 5.2.2.3.2
+func SendNFHeartbeat() error {
+	smfSelf := smf_context.SMF_Self()
+	patchItems := []models.PatchItem{
+		{
+			Op:    models.PatchOperation_REPLACE,
+			Path:  "/nfStatus",
+			Value: models.NfStatus_REGISTERED,
+		},
+	}
+	load := smf_context.NFLoad()
+	if load != smfSelf.NrfReportedLoad {
+		patchItems = append(patchItems, models.PatchItem{
+			Op:    models.PatchOperation_REPLACE,
+			Path:  "/load",
+			Value: load,
+		})
+	}
+	smfInfo := &models.SmfInfo{SNssaiSmfInfoList: smf_context.SNssaiSmfInfo()}
+	profileChanged := !reflect.DeepEqual(smfInfo, smf_context.NFProfile.SMFInfo)
+	if profileChanged {
+		patchItems = append(patchItems,
+			models.PatchItem{
+				Op:    models.PatchOperation_REPLACE,
+				Path:  "/smfInfo",
+				Value: smfInfo,
+			},
+			models.PatchItem{
+				Op:    models.PatchOperation_REPLACE,
+				Path:  "/sNssais",
+				Value: sNssaisOf(smfInfo),
+			})
+	}
+
+	ctx, cancel, err := smf_context.SMF_Self().GetSBICtx(models.ServiceName_NNRF_NFM, models.NfType_NRF)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	start := time.Now()
+	rep, res, err := smfSelf.
+		NFManagementClient.
+		NFInstanceIDDocumentApi.
+		UpdateNFInstance(ctx, smfSelf.NfInstanceID, patchItems)
+	metrics.ObserveSBIClient(metrics.ServiceNnrfNfm, start, res)
+	if res != nil {
+		defer func() {
+			if resCloseErr := res.Body.Close(); resCloseErr != nil {
+				logger.ConsumerLog.Errorf("UpdateNFInstance response body cannot close: %+v", resCloseErr)
+			}
+		}()
+	}
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return ErrNFNotRegistered
+		}
+		return fmt.Errorf("NF heartbeat failed: %v", err)
+	}
+
+	smfSelf.NrfReportedLoad = load
+	if profileChanged {
+		logger.ConsumerLog.Infof("SMF profile updated in the NRF")
+		smf_context.NFProfile.SMFInfo = smfInfo
+	}
+	if rep.HeartBeatTimer > 0 {
+		smfSelf.NrfHeartBeatTimer = time.Duration(rep.HeartBeatTimer) * time.Second
+	}
+	return nil
+}
+
+// RunNFHeartbeat sends the heartbeats of the SMF at the period asked by the NRF until ctx is
+// done. The SMF registers again and renews its NF status subscriptions when the NRF lost it.
+func RunNFHeartbeat(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(smf_context.SMF_Self().NrfHeartBeatTimer):
+		}
+
+		err := SendNFHeartbeat()
+		switch {
+		case errors.Is(err, ErrNFNotRegistered):
+			logger.ConsumerLog.Warnln("SMF not found in the NRF, register again")
+			if err = SendNFRegistration(); err != nil {
+				logger.ConsumerLog.Errorf("SMF register to NRF Error[%v]", err)
+				continue
+			}
+			SendNFStatusSubscriptions()
+		case err != nil:
+			logger.ConsumerLog.Warnln(err)
+		default:
+			renewNFStatusSubscriptions()
+		}
+	}
+}
+
+// nfStatusSubscribedTypes are the types of the NFs whose clients the SMF keeps
+var nfStatusSubscribedTypes = []models.NfType{models.NfType_AMF, models.NfType_PCF, models.NfType_UDM}
+
+// SendNFStatusSubscriptions subscribes to the deregistration of the AMF, PCF and UDM instances,
+// TS 29.510 5.2.2.5.2
+func SendNFStatusSubscriptions() {
+	for _, nfType := range nfStatusSubscribedTypes {
+		if err := SendNFStatusSubscribe(nfType); err != nil {
+			logger.ConsumerLog.Warnf("NF status subscription to %s failed: %v", nfType, err)
+		}
+	}
+}
+
+// renewNFStatusSubscriptions subscribes again before the subscriptions expire
+func renewNFStatusSubscriptions() {
+	smfSelf := smf_context.SMF_Self()
+	for nfType, subscription := range nfStatusSubscriptions() {
+		if subscription.ValidityTime == nil ||
+			time.Until(*subscription.ValidityTime) > 2*smfSelf.NrfHeartBeatTimer {
+			continue
+		}
+		if err := SendNFStatusSubscribe(nfType); err != nil {
+			logger.ConsumerLog.Warnf("NF status subscription to %s failed: %v", nfType, err)
+		}
+	}
+}
+
+func SendNFStatusSubscribe(nfType models.NfType) error {
+	smfSelf := smf_context.SMF_Self()
+	subscription := models.NrfSubscriptionData{
+		NfStatusNotificationUri: fmt.Sprintf("%s://%s:%d/nsmf-callback/nf-status-notify",
+			smfSelf.URIScheme,
+			smfSelf.RegisterIPv4,
+			smfSelf.SBIPort,
+		),
+		SubscrCond:     models.NfTypeCond{NfType: nfType},
+		ReqNotifEvents: []models.NotificationEventType{models.NotificationEventType_DEREGISTERED},
+		ReqNfType:      models.NfType_SMF,
+	}
+
+	ctx, cancel, err := smf_context.SMF_Self().GetSBICtx(models.ServiceName_NNRF_NFM, models.NfType_NRF)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	start := time.Now()
+	rep, res, err := smfSelf.
+		NFManagementClient.
+		SubscriptionsCollectionApi.
+		CreateSubscription(ctx, subscription)
+	metrics.ObserveSBIClient(metrics.ServiceNnrfNfm, start, res)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if resCloseErr := res.Body.Close(); resCloseErr != nil {
+			logger.ConsumerLog.Errorf("CreateSubscription response body cannot close: %+v", resCloseErr)
+		}
+	}()
+	smfSelf.NfStatusSubscriptionsLock.Lock()
+	smfSelf.NfStatusSubscriptions[nfType] = rep
+	smfSelf.NfStatusSubscriptionsLock.Unlock()
+	logger.ConsumerLog.Infof("NF status subscription[%s] to %s created", rep.SubscriptionId, nfType)
+	return nil
+}
+
+// SendRemoveNFStatusSubscriptions removes the NF status subscriptions of the SMF from the NRF
+func SendRemoveNFStatusSubscriptions() {
+	smfSelf := smf_context.SMF_Self()
+	for nfType, subscription := range nfStatusSubscriptions() {
+		if err := sendRemoveNFStatusSubscription(subscription.SubscriptionId); err != nil {
+			logger.ConsumerLog.Warnf("Remove NF status subscription[%s] failed: %v", subscription.SubscriptionId, err)
+		}
+		smfSelf.NfStatusSubscriptionsLock.Lock()
+		delete(smfSelf.NfStatusSubscriptions, nfType)
+		smfSelf.NfStatusSubscriptionsLock.Unlock()
+	}
+}
+
+// sendRemoveNFStatusSubscription removes the NF status subscription from the NRF, each removal
+// with its own timeout
+func sendRemoveNFStatusSubscription(subscriptionID string) error {
+	smfSelf := smf_context.SMF_Self()
+	ctx, cancel, err := smfSelf.GetSBICtx(models.ServiceName_NNRF_NFM, models.NfType_NRF)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	start := time.Now()
+	res, err := smfSelf.
+		NFManagementClient.
+		SubscriptionIDDocumentApi.
+		RemoveSubscription(ctx, subscriptionID)
+	metrics.ObserveSBIClient(metrics.ServiceNnrfNfm, start, res)
+	if res != nil {
+		if resCloseErr := res.Body.Close(); resCloseErr != nil {
+			logger.ConsumerLog.Errorf("RemoveSubscription response body cannot close: %+v", resCloseErr)
+		}
+	}
+	return err
+}
+
+// nfStatusSubscriptions returns a copy of the NF status subscriptions of the SMF
+func nfStatusSubscriptions() map[models.NfType]models.NrfSubscriptionData {
+	smfSelf := smf_context.SMF_Self()
+	smfSelf.NfStatusSubscriptionsLock.Lock()
+	defer smfSelf.NfStatusSubscriptionsLock.Unlock()
+	subscriptions := make(map[models.NfType]models.NrfSubscriptionData, len(smfSelf.NfStatusSubscriptions))
+	for nfType, subscription := range smfSelf.NfStatusSubscriptions {
+		subscriptions[nfType] = subscription
+	}
+	return subscriptions
+}
+
 func RetrySendNFRegistration(MaxRetry int) error {
 	retryCount := 0
 	for retryCount < MaxRetry {
@@ -96,11 +340,19 @@ func RetrySendNFRegistration(MaxRetry int) error {
 }
 
 func SendNFDeregistration() error {
+	ctx, cancel, err := smf_context.SMF_Self().GetSBICtx(models.ServiceName_NNRF_NFM, models.NfType_NRF)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
 	// Check data (Use RESTful DELETE)
+	start := time.Now()
 	res, localErr := smf_context.SMF_Self().
 		NFManagementClient.
 		NFInstanceIDDocumentApi.
-		DeregisterNFInstance(context.TODO(), smf_context.SMF_Self().NfInstanceID)
+		DeregisterNFInstance(ctx, smf_context.SMF_Self().NfInstanceID)
+	metrics.ObserveSBIClient(metrics.ServiceNnrfNfm, start, res)
 	if localErr != nil {
 		logger.ConsumerLog.Warnln(localErr)
 		return localErr
@@ -119,121 +371,125 @@ func SendNFDeregistration() error {
 	return nil
 }
 
-func SendNFDiscoveryUDM() (*models.ProblemDetails, error) {
-	localVarOptionals := Nnrf_NFDiscovery.SearchNFInstancesParamOpts{}
+// nfFailed reports whether the NF instance did not answer the request, so that the next
+// discovered instance is tried
+func nfFailed(rsp *http.Response, err error) bool {
+	return err != nil && (rsp == nil || rsp.StatusCode >= http.StatusInternalServerError)
+}
 
-	// Check data
-	result, httpResp, localErr := smf_context.SMF_Self().
-		NFDiscoveryClient.
-		NFInstancesStoreApi.
-		SearchNFInstances(context.TODO(), models.NfType_UDM, models.NfType_SMF, &localVarOptionals)
+// NFUnavailable reports whether the request failed because the NF did not answer or its circuit
+// breaker is open, rather than because of the answer of the NF
+func NFUnavailable(err error) bool {
+	var urlErr *url.Error
+	return errors.Is(err, smf_context.ErrNFUnavailable) || errors.As(err, &urlErr)
+}
 
-	if localErr == nil {
-		smf_context.SMF_Self().UDMProfile = result.NfInstances[0]
+// NFUnavailableOrFailed reports whether the request failed because the NF is unavailable or
+// answered with a server error, rather than because the NF refused the request
+func NFUnavailableOrFailed(err error) bool {
+	var apiErr openapi.GenericOpenAPIError
+	return NFUnavailable(err) || (errors.As(err, &apiErr) && strings.HasPrefix(apiErr.ErrorStatus, "5"))
+}
 
-		for _, service := range *smf_context.SMF_Self().UDMProfile.NfServices {
-			if service.ServiceName == models.ServiceName_NUDM_SDM {
-				SDMConf := Nudm_SubscriberDataManagement.NewConfiguration()
-				SDMConf.SetBasePath(service.ApiPrefix)
-				smf_context.SMF_Self().SubscriberDataManagementClient = Nudm_SubscriberDataManagement.NewAPIClient(SDMConf)
-			}
+// discoveryProblem returns the problem details of a failed NF discovery, if the NRF sent them
+func discoveryProblem(err error) (*models.ProblemDetails, error) {
+	if apiErr, ok := err.(openapi.GenericOpenAPIError); ok {
+		if problem, ok := apiErr.Model().(models.ProblemDetails); ok {
+			return &problem, nil
 		}
+	}
+	return nil, err
+}
 
-		if smf_context.SMF_Self().SubscriberDataManagementClient == nil {
-			logger.ConsumerLog.Warnln("sdm client failed")
+// SendNFDiscoveryUDM selects the UDM of the SMF, the first of the discovered UDM instances
+func SendNFDiscoveryUDM() (*models.ProblemDetails, error) {
+	// the SCP selects the UDM of the requests with delegated discovery
+	if smf_context.DelegatedDiscovery() {
+		setDelegatedUDMClients()
+		return nil, nil
+	}
+
+	localVarOptionals := Nnrf_NFDiscovery.SearchNFInstancesParamOpts{}
+
+	instances, err := smf_context.DiscoverNFInstances(models.NfType_UDM, &localVarOptionals)
+	if err != nil {
+		logger.ConsumerLog.Warnln("NF discovery of UDM failed:", err)
+		return discoveryProblem(err)
+	}
+	if len(instances) == 0 {
+		return nil, openapi.ReportError("no UDM discovered")
+	}
+
+	smfSelf := smf_context.SMF_Self()
+	udm := instances[0]
+	if selected, sdmClient, _ := smfSelf.UDM(); selected.NfInstanceId == udm.NfInstanceId && sdmClient != nil {
+		return nil, nil
+	}
+	if udm.NfServices == nil {
+		return nil, openapi.ReportError("UDM[%s] has no services", udm.NfInstanceId)
+	}
+
+	// the requests in flight keep the clients of the former UDM, the new ones are swapped in
+	var SDMConf *Nudm_SubscriberDataManagement.Configuration
+	var UECMConf *Nudm_UEContextManagement.Configuration
+	for _, service := range *udm.NfServices {
+		if service.ServiceName == models.ServiceName_NUDM_SDM {
+			SDMConf = Nudm_SubscriberDataManagement.NewConfiguration()
+			smf_context.SetSBIApiRoot(SDMConf, service.ApiPrefix)
 		}
-	} else if httpResp != nil {
-		defer func() {
-			if resCloseErr := httpResp.Body.Close(); resCloseErr != nil {
-				logger.ConsumerLog.Errorf("SearchNFInstances response body cannot close: %+v", resCloseErr)
-			}
-		}()
-		logger.ConsumerLog.Warnln("handler returned wrong status code ", httpResp.Status)
-		if httpResp.Status != localErr.Error() {
-			return nil, localErr
+		if service.ServiceName == models.ServiceName_NUDM_UECM {
+			UECMConf = Nudm_UEContextManagement.NewConfiguration()
+			smf_context.SetSBIApiRoot(UECMConf, service.ApiPrefix)
 		}
-		problem := localErr.(openapi.GenericOpenAPIError).Model().(models.ProblemDetails)
-		return &problem, nil
-	} else {
-		return nil, openapi.ReportError("server no response")
 	}
+	if SDMConf == nil {
+		logger.ConsumerLog.Warnln("sdm client failed")
+	}
+	smfSelf.SetUDM(udm, SDMConf, UECMConf)
 	return nil, nil
 }
 
+// setDelegatedUDMClients creates the clients to the UDM the SCP selects
+func setDelegatedUDMClients() {
+	smfSelf := smf_context.SMF_Self()
+	if _, sdmClient, uecmClient := smfSelf.UDM(); sdmClient != nil && uecmClient != nil {
+		return
+	}
+
+	SDMConf := Nudm_SubscriberDataManagement.NewConfiguration()
+	smf_context.SetSBIDelegatedDiscovery(SDMConf, models.NfType_UDM, models.ServiceName_NUDM_SDM, nil)
+	UECMConf := Nudm_UEContextManagement.NewConfiguration()
+	smf_context.SetSBIDelegatedDiscovery(UECMConf, models.NfType_UDM, models.ServiceName_NUDM_UECM, nil)
+	smfSelf.SetUDM(models.NfProfile{}, SDMConf, UECMConf)
+}
+
 func SendNFDiscoveryPCF() (problemDetails *models.ProblemDetails, err error) {
-	// Set targetNfType
-	targetNfType := models.NfType_PCF
-	// Set requestNfType
-	requesterNfType := models.NfType_SMF
 	localVarOptionals := Nnrf_NFDiscovery.SearchNFInstancesParamOpts{}
 
-	// Check data
-	result, httpResp, localErr := smf_context.SMF_Self().
-		NFDiscoveryClient.
-		NFInstancesStoreApi.
-		SearchNFInstances(context.TODO(), targetNfType, requesterNfType, &localVarOptionals)
-
-	if localErr == nil {
-		logger.ConsumerLog.Traceln(result.NfInstances)
-	} else if httpResp != nil {
-		defer func() {
-			if resCloseErr := httpResp.Body.Close(); resCloseErr != nil {
-				logger.ConsumerLog.Errorf("SearchNFInstances response body cannot close: %+v", resCloseErr)
-			}
-		}()
-		logger.ConsumerLog.Warnln("handler returned wrong status code ", httpResp.Status)
-		if httpResp.Status != localErr.Error() {
-			err = localErr
-			return
-		}
-		problem := localErr.(openapi.GenericOpenAPIError).Model().(models.ProblemDetails)
-		problemDetails = &problem
-	} else {
-		err = openapi.ReportError("server no response")
+	instances, err := smf_context.DiscoverNFInstances(models.NfType_PCF, &localVarOptionals)
+	if err != nil {
+		logger.ConsumerLog.Warnln("NF discovery of PCF failed:", err)
+		return discoveryProblem(err)
 	}
-
-	return problemDetails, err
+	logger.ConsumerLog.Traceln(instances)
+	return nil, nil
 }
 
 func SendNFDiscoveryServingAMF(smContext *smf_context.SMContext) (*models.ProblemDetails, error) {
-	targetNfType := models.NfType_AMF
-	requesterNfType := models.NfType_SMF
-
 	localVarOptionals := Nnrf_NFDiscovery.SearchNFInstancesParamOpts{}
 
 	localVarOptionals.TargetNfInstanceId = optional.NewInterface(smContext.ServingNfId)
 
-	// Check data
-	result, httpResp, localErr := smf_context.SMF_Self().
-		NFDiscoveryClient.
-		NFInstancesStoreApi.
-		SearchNFInstances(context.TODO(), targetNfType, requesterNfType, &localVarOptionals)
-
-	if localErr == nil {
-		if result.NfInstances == nil {
-			if status := httpResp.StatusCode; status != http.StatusOK {
-				logger.ConsumerLog.Warnln("handler returned wrong status code", status)
-			}
-			logger.ConsumerLog.Warnln("NfInstances is nil")
-			return nil, openapi.ReportError("NfInstances is nil")
-		}
-		logger.ConsumerLog.Info("SendNFDiscoveryServingAMF ok")
-		smContext.AMFProfile = deepcopy.Copy(result.NfInstances[0]).(models.NfProfile)
-	} else if httpResp != nil {
-		defer func() {
-			if resCloseErr := httpResp; resCloseErr != nil {
-				logger.ConsumerLog.Errorf("SearchNFInstances response body cannot close: %+v", resCloseErr)
-			}
-		}()
-		if httpResp.Status != localErr.Error() {
-			return nil, localErr
-		}
-		problem := localErr.(openapi.GenericOpenAPIError).Model().(models.ProblemDetails)
-		return &problem, nil
-	} else {
-		return nil, openapi.ReportError("server no response")
+	instances, err := smf_context.DiscoverNFInstances(models.NfType_AMF, &localVarOptionals)
+	if err != nil {
+		return discoveryProblem(err)
 	}
-
+	if len(instances) == 0 {
+		logger.ConsumerLog.Warnln("NfInstances is nil")
+		return nil, openapi.ReportError("NfInstances is nil")
+	}
+	logger.ConsumerLog.Info("SendNFDiscoveryServingAMF ok")
+	smContext.AMFProfile = deepcopy.Copy(instances[0]).(models.NfProfile)
 	return nil, nil
 }
 
@@ -242,11 +498,18 @@ func SendDeregisterNFInstance() (*models.ProblemDetails, error) {
 
 	smfSelf := smf_context.SMF_Self()
 	// Set client and set url
+	ctx, cancel, err := smf_context.SMF_Self().GetSBICtx(models.ServiceName_NNRF_NFM, models.NfType_NRF)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
 
+	start := time.Now()
 	res, err := smfSelf.
 		NFManagementClient.
 		NFInstanceIDDocumentApi.
-		DeregisterNFInstance(context.Background(), smfSelf.NfInstanceID)
+		DeregisterNFInstance(ctx, smfSelf.NfInstanceID)
+	metrics.ObserveSBIClient(metrics.ServiceNnrfNfm, start, res)
 	if err == nil {
 		return nil, err
 	} else if res != nil {
@@ -0,0 +1,88 @@
+package consumer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/Nnrf_NFManagement"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+const testNRFURI = "http://127.0.0.10:8000"
+
+// newTestNRF sets the NF management client of the SMF to a stub NRF
+func newTestNRF(t *testing.T) {
+	openapi.InterceptH2CClient()
+	t.Cleanup(func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+	})
+
+	smfSelf := smf_context.SMF_Self()
+	client, subscriptions := smfSelf.NFManagementClient, smfSelf.NfStatusSubscriptions
+	t.Cleanup(func() { smfSelf.NFManagementClient, smfSelf.NfStatusSubscriptions = client, subscriptions })
+	configuration := Nnrf_NFManagement.NewConfiguration()
+	configuration.SetBasePath(testNRFURI)
+	smfSelf.NFManagementClient = Nnrf_NFManagement.NewAPIClient(configuration)
+	smfSelf.NfStatusSubscriptions = make(map[models.NfType]models.NrfSubscriptionData)
+}
+
+func TestNFStatusSubscriptions(t *testing.T) {
+	newTestNRF(t)
+	for _, nfType := range nfStatusSubscribedTypes {
+		gock.New(testNRFURI).
+			Post("/nnrf-nfm/v1/subscriptions").
+			BodyString(string(nfType)).
+			Reply(http.StatusCreated).
+			JSON(models.NrfSubscriptionData{SubscriptionId: "subscription-" + string(nfType)})
+		gock.New(testNRFURI).
+			Delete("/nnrf-nfm/v1/subscriptions/subscription-" + string(nfType)).
+			Reply(http.StatusNoContent)
+	}
+
+	SendNFStatusSubscriptions()
+	subscriptions := nfStatusSubscriptions()
+	require.Len(t, subscriptions, len(nfStatusSubscribedTypes))
+	require.Equal(t, "subscription-AMF", subscriptions[models.NfType_AMF].SubscriptionId)
+
+	SendRemoveNFStatusSubscriptions()
+	require.Empty(t, nfStatusSubscriptions())
+	require.True(t, gock.IsDone())
+}
+
+func TestRemoveNFStatusSubscriptionsTimeout(t *testing.T) {
+	newTestNRF(t)
+	smfSelf := smf_context.SMF_Self()
+	defer func(timeout time.Duration) { smfSelf.SBITimeout = timeout }(smfSelf.SBITimeout)
+	smfSelf.SBITimeout = 200 * time.Millisecond
+
+	// each removal has the whole timeout, however long the previous ones took
+	remaining := make(chan time.Duration, len(nfStatusSubscribedTypes))
+	for _, nfType := range nfStatusSubscribedTypes {
+		smfSelf.NfStatusSubscriptions[nfType] = models.NrfSubscriptionData{SubscriptionId: "subscription-" + string(nfType)}
+		gock.New(testNRFURI).
+			Delete("/nnrf-nfm/v1/subscriptions/subscription-" + string(nfType)).
+			AddMatcher(func(req *http.Request, _ *gock.Request) (bool, error) {
+				if deadline, ok := req.Context().Deadline(); ok {
+					remaining <- time.Until(deadline)
+				}
+				return true, nil
+			}).
+			Reply(http.StatusNoContent).
+			Delay(120 * time.Millisecond)
+	}
+
+	SendRemoveNFStatusSubscriptions()
+	require.Empty(t, nfStatusSubscriptions())
+	require.True(t, gock.IsDone())
+	require.Len(t, remaining, len(nfStatusSubscribedTypes))
+	for i := 0; i < len(nfStatusSubscribedTypes); i++ {
+		require.Greater(t, <-remaining, 150*time.Millisecond)
+	}
+}
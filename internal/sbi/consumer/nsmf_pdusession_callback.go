@@ -3,11 +3,14 @@ package consumer
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/Nsmf_PDUSession"
 	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 )
 
 func SendSMContextStatusNotification(uri string) (*models.ProblemDetails, error) {
@@ -17,12 +20,17 @@ func SendSMContextStatusNotification(uri string) (*models.ProblemDetails, error)
 			ResourceStatus: models.ResourceStatus_RELEASED,
 		}
 		configuration := Nsmf_PDUSession.NewConfiguration()
+		uri = smf_context.SBICallbackURI(configuration, uri)
 		client := Nsmf_PDUSession.NewAPIClient(configuration)
 
 		logger.CtxLog.Infoln("[SMF] Send SMContext Status Notification")
+		ctx, cancel := context.WithTimeout(context.Background(), smf_context.SMF_Self().SBITimeoutOf(models.NfType_AMF))
+		defer cancel()
+		start := time.Now()
 		httpResp, localErr := client.
 			IndividualSMContextNotificationApi.
-			SMContextNotification(context.Background(), uri, request)
+			SMContextNotification(ctx, uri, request)
+		metrics.ObserveSBIClient(metrics.ServiceNsmfPDUSessionNotify, start, httpResp)
 
 		if localErr == nil {
 			if httpResp.StatusCode != http.StatusNoContent {
@@ -0,0 +1,275 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
+)
+
+// sendToUDM calls send with the clients of the selected UDM and the context of a request to
+// the service. When the UDM does not answer, or its circuit breaker is open, send is called
+// again with the next discovered UDM instance, up to SBIMaxRetries times.
+func sendToUDM(serviceName models.ServiceName, send func(ctx context.Context) (*http.Response, error)) (
+	*http.Response, error,
+) {
+	tokenCtx, err := smf_context.SMF_Self().GetTokenCtx(serviceName, models.NfType_UDM)
+	if err != nil {
+		return nil, err
+	}
+
+	tried := make(map[string]bool)
+	for {
+		var rsp *http.Response
+		udm, _, _ := smf_context.SMF_Self().UDM()
+		udmID := udm.NfInstanceId
+		if err = smf_context.CheckNFInstance(udmID); err == nil {
+			ctx, cancel := context.WithTimeout(tokenCtx, smf_context.SMF_Self().SBITimeoutOf(models.NfType_UDM))
+			rsp, err = send(ctx)
+			cancel()
+			if !nfFailed(rsp, err) {
+				if udmID != "" {
+					smf_context.NFInstanceAnswered(udmID)
+				}
+				return rsp, err
+			}
+			if udmID != "" {
+				smf_context.NFInstanceFailed(udmID)
+			}
+		}
+		if udmID == "" {
+			return rsp, err
+		}
+		tried[udmID] = true
+		if len(tried) > smf_context.SMF_Self().SBIMaxRetries {
+			return rsp, err
+		}
+		problemDetails, discoveryErr := SendNFDiscoveryUDM()
+		udm, _, _ = smf_context.SMF_Self().UDM()
+		if problemDetails != nil || discoveryErr != nil || tried[udm.NfInstanceId] {
+			return rsp, err
+		}
+		if rsp != nil {
+			if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+				logger.ConsumerLog.Errorf("UDM response body cannot close: %+v", rspCloseErr)
+			}
+		}
+		logger.ConsumerLog.Warnf("UDM[%s] not answering, try UDM[%s]: %v", udmID, udm.NfInstanceId, err)
+	}
+}
+
+// SessionManagementSubscriptionData is the SM subscription data of TS 29.503 6.1.6.2.8 with
+// the framed routes of its DNN configurations, which the models of the openapi module miss
+type SessionManagementSubscriptionData struct {
+	models.SessionManagementSubscriptionData
+	DnnConfigurations map[string]DnnConfiguration `json:"dnnConfigurations,omitempty"`
+}
+
+// DnnConfiguration is the DNN configuration of TS 29.503 6.1.6.2.9 with its framed routes
+type DnnConfiguration struct {
+	models.DnnConfiguration
+	Ipv4FrameRouteList []FrameRouteInfo `json:"ipv4FrameRouteList,omitempty"`
+	Ipv6FrameRouteList []FrameRouteInfo `json:"ipv6FrameRouteList,omitempty"`
+}
+
+// FrameRouteInfo is a framed route of a DNN configuration, TS 29.503 6.1.6.2.64
+type FrameRouteInfo struct {
+	Ipv4Mask    string `json:"ipv4Mask,omitempty"`
+	Ipv6Prefix  string `json:"ipv6Prefix,omitempty"`
+	RouteNumber int32  `json:"routeNumber,omitempty"`
+}
+
+// FramedRoutes returns the IP subnets of the framed routes of the DNN configuration
+func (d *DnnConfiguration) FramedRoutes() []string {
+	var routes []string
+	for _, route := range d.Ipv4FrameRouteList {
+		if route.Ipv4Mask != "" {
+			routes = append(routes, route.Ipv4Mask)
+		}
+	}
+	for _, route := range d.Ipv6FrameRouteList {
+		if route.Ipv6Prefix != "" {
+			routes = append(routes, route.Ipv6Prefix)
+		}
+	}
+	return routes
+}
+
+// SendGetSmData retrieves the SM subscription data of the DNN and S-NSSAI of the session. The
+// request is not sent by the SDM client, whose models drop the framed routes of the DNN.
+func SendGetSmData(smContext *smf_context.SMContext, plmnID *models.PlmnId) (
+	[]SessionManagementSubscriptionData, error,
+) {
+	query := url.Values{}
+	query.Set("dnn", smContext.Dnn)
+	if plmnID != nil {
+		query.Set("plmn-id", openapi.MarshToJsonString(plmnID)[0])
+	}
+	if smContext.Snssai != nil {
+		query.Set("single-nssai", openapi.MarshToJsonString(smContext.Snssai)[0])
+	}
+
+	var sessSubData []SessionManagementSubscriptionData
+	_, err := sendToUDM(models.ServiceName_NUDM_SDM, func(ctx context.Context) (*http.Response, error) {
+		cfg := smf_context.SMF_Self().SubscriberDataManagementConf()
+		if cfg == nil {
+			return nil, errors.Errorf("UDM SDM client not selected")
+		}
+		start := time.Now()
+		rsp, err := sendRequest(ctx, cfg, http.MethodGet, "/"+smContext.Supi+"/sm-data", query, nil, &sessSubData)
+		metrics.ObserveSBIClient(metrics.ServiceNudmSdm, start, rsp)
+		return rsp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessSubData, nil
+}
+
+// SendUECMRegistration registers the SMF serving the session in the UDM,
+// TS 29.503 5.3.2.2.3
+func SendUECMRegistration(smContext *smf_context.SMContext, plmnID *models.PlmnId) error {
+	registration := models.SmfRegistration{
+		SmfInstanceId: smf_context.SMF_Self().NfInstanceID,
+		PduSessionId:  smContext.PDUSessionID,
+		SingleNssai:   smContext.Snssai,
+		Dnn:           smContext.Dnn,
+		PlmnId:        plmnID,
+	}
+
+	rsp, err := sendToUDM(models.ServiceName_NUDM_UECM, func(ctx context.Context) (*http.Response, error) {
+		_, _, client := smf_context.SMF_Self().UDM()
+		if client == nil {
+			return nil, errors.Errorf("UDM UECM client not selected")
+		}
+		start := time.Now()
+		_, rsp, err := client.SMFRegistrationApi.SmfRegistrationsPduSessionId(
+			ctx, smContext.Supi, smContext.PDUSessionID, registration)
+		metrics.ObserveSBIClient(metrics.ServiceNudmUecm, start, rsp)
+		return rsp, err
+	})
+	if err != nil {
+		return fmt.Errorf("UECM registration failed: %v", err)
+	}
+	defer func() {
+		if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+			logger.ConsumerLog.Errorf("SmfRegistrationsPduSessionId response body cannot close: %+v", rspCloseErr)
+		}
+	}()
+	smContext.UECMRegistered = true
+	return nil
+}
+
+// SendUECMDeregistration deregisters the SMF of the session from the UDM,
+// TS 29.503 5.3.2.4.3
+func SendUECMDeregistration(smContext *smf_context.SMContext) error {
+	rsp, err := sendToUDM(models.ServiceName_NUDM_UECM, func(ctx context.Context) (*http.Response, error) {
+		_, _, client := smf_context.SMF_Self().UDM()
+		if client == nil {
+			return nil, errors.Errorf("UDM UECM client not selected")
+		}
+		start := time.Now()
+		rsp, err := client.SMFDeregistrationApi.Deregistration(
+			ctx, smContext.Supi, smContext.PDUSessionID)
+		metrics.ObserveSBIClient(metrics.ServiceNudmUecm, start, rsp)
+		return rsp, err
+	})
+	if rsp != nil {
+		defer func() {
+			if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+				logger.ConsumerLog.Errorf("Deregistration response body cannot close: %+v", rspCloseErr)
+			}
+		}()
+	}
+	// the registration is gone anyway if the UDM does not know it
+	if err != nil && (rsp == nil || rsp.StatusCode != http.StatusNotFound) {
+		return fmt.Errorf("UECM deregistration failed: %v", err)
+	}
+	smContext.UECMRegistered = false
+	return nil
+}
+
+// SendSDMSubscription subscribes to the changes of the SM subscription data of the session,
+// which the UDM notifies to the sdm-notify callback of the SM context, TS 29.503 5.2.2.3.2
+func SendSDMSubscription(smContext *smf_context.SMContext, plmnID *models.PlmnId) error {
+	subscription := models.SdmSubscription{
+		NfInstanceId: smf_context.SMF_Self().NfInstanceID,
+		CallbackReference: fmt.Sprintf("%s://%s:%d/nsmf-callback/sdm-notify/%s",
+			smf_context.SMF_Self().URIScheme,
+			smf_context.SMF_Self().RegisterIPv4,
+			smf_context.SMF_Self().SBIPort,
+			smContext.Ref,
+		),
+		MonitoredResourceUris: []string{fmt.Sprintf("%s/sm-data", smContext.Supi)},
+		SingleNssai:           smContext.Snssai,
+		Dnn:                   smContext.Dnn,
+		PlmnId:                plmnID,
+	}
+
+	var created models.SdmSubscription
+	rsp, err := sendToUDM(models.ServiceName_NUDM_SDM, func(ctx context.Context) (*http.Response, error) {
+		_, client, _ := smf_context.SMF_Self().UDM()
+		if client == nil {
+			return nil, errors.Errorf("UDM SDM client not selected")
+		}
+		start := time.Now()
+		var rsp *http.Response
+		var err error
+		created, rsp, err = client.SubscriptionCreationApi.Subscribe(
+			ctx, smContext.Supi, subscription)
+		metrics.ObserveSBIClient(metrics.ServiceNudmSdm, start, rsp)
+		return rsp, err
+	})
+	if err != nil {
+		return fmt.Errorf("SDM subscription failed: %v", err)
+	}
+	defer func() {
+		if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+			logger.ConsumerLog.Errorf("Subscribe response body cannot close: %+v", rspCloseErr)
+		}
+	}()
+	smContext.SdmSubscriptionID = created.SubscriptionId
+	if smContext.SdmSubscriptionID == "" {
+		// the ID is the last segment of the location of the created subscription
+		loc := rsp.Header.Get("Location")
+		smContext.SdmSubscriptionID = loc[strings.LastIndex(loc, "/")+1:]
+	}
+	return nil
+}
+
+// SendSDMUnsubscription removes the subscription to the changes of the SM subscription data
+func SendSDMUnsubscription(smContext *smf_context.SMContext) error {
+	rsp, err := sendToUDM(models.ServiceName_NUDM_SDM, func(ctx context.Context) (*http.Response, error) {
+		_, client, _ := smf_context.SMF_Self().UDM()
+		if client == nil {
+			return nil, errors.Errorf("UDM SDM client not selected")
+		}
+		start := time.Now()
+		rsp, err := client.SubscriptionDeletionApi.Unsubscribe(
+			ctx, smContext.Supi, smContext.SdmSubscriptionID)
+		metrics.ObserveSBIClient(metrics.ServiceNudmSdm, start, rsp)
+		return rsp, err
+	})
+	if rsp != nil {
+		defer func() {
+			if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+				logger.ConsumerLog.Errorf("Unsubscribe response body cannot close: %+v", rspCloseErr)
+			}
+		}()
+	}
+	if err != nil && (rsp == nil || rsp.StatusCode != http.StatusNotFound) {
+		return fmt.Errorf("SDM unsubscription failed: %v", err)
+	}
+	smContext.SdmSubscriptionID = ""
+	return nil
+}
@@ -0,0 +1,160 @@
+package consumer
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/Nnrf_NFDiscovery"
+	"github.com/free5gc/openapi/Nudm_SubscriberDataManagement"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestSendToUDMFailover(t *testing.T) {
+	newTestNRF(t)
+	smfSelf := smf_context.SMF_Self()
+	discoveryClient, maxRetries := smfSelf.NFDiscoveryClient, smfSelf.SBIMaxRetries
+	defer func() {
+		smfSelf.SetUDM(models.NfProfile{}, nil, nil)
+		smfSelf.NFDiscoveryClient, smfSelf.SBIMaxRetries = discoveryClient, maxRetries
+		smf_context.RemoveNFInstanceFromDiscovery("udm-1")
+		smf_context.RemoveNFInstanceFromDiscovery("udm-2")
+	}()
+	configuration := Nnrf_NFDiscovery.NewConfiguration()
+	configuration.SetBasePath(testNRFURI)
+	smfSelf.NFDiscoveryClient = Nnrf_NFDiscovery.NewAPIClient(configuration)
+	smfSelf.SBIMaxRetries = 2
+
+	// udm-1 is preferred until it fails
+	udm := func(id, apiPrefix string, priority int32) models.NfProfile {
+		return models.NfProfile{
+			NfInstanceId: id,
+			NfType:       models.NfType_UDM,
+			Priority:     priority,
+			NfServices: &[]models.NfService{
+				{ServiceName: models.ServiceName_NUDM_SDM, ApiPrefix: apiPrefix},
+				{ServiceName: models.ServiceName_NUDM_UECM, ApiPrefix: apiPrefix},
+			},
+		}
+	}
+	udm1, udm2 := udm("udm-1", "http://127.0.0.11:8000", 1), udm("udm-2", "http://127.0.0.12:8000", 2)
+	gock.New(testNRFURI).Times(2).
+		Get("/nnrf-disc/v1/nf-instances").
+		MatchParam("target-nf-type", "UDM").
+		Reply(http.StatusOK).
+		JSON(models.SearchResult{NfInstances: []models.NfProfile{udm1, udm2}})
+	gock.New("http://127.0.0.11:8000").
+		Get("/nudm-sdm/v1/imsi-208930000000041/sm-data").
+		Reply(http.StatusServiceUnavailable).
+		JSON(models.ProblemDetails{Status: http.StatusServiceUnavailable})
+	gock.New("http://127.0.0.12:8000").
+		Get("/nudm-sdm/v1/imsi-208930000000041/sm-data").
+		Reply(http.StatusOK).
+		JSON([]models.SessionManagementSubscriptionData{{SingleNssai: &models.Snssai{Sst: 1}}})
+
+	_, err := SendNFDiscoveryUDM()
+	require.NoError(t, err)
+	selected, sdmClient, uecmClient := smfSelf.UDM()
+	require.Equal(t, "udm-1", selected.NfInstanceId)
+	require.NotNil(t, sdmClient)
+	require.NotNil(t, uecmClient)
+
+	smContext := smf_context.NewSMContext("imsi-208930000000041", 1)
+	defer smf_context.RemoveSMContext(smContext.Ref)
+	smContext.Supi = "imsi-208930000000041"
+	smContext.Dnn = "internet"
+	smContext.Snssai = &models.Snssai{Sst: 1}
+	data, err := SendGetSmData(smContext, &models.PlmnId{Mcc: "208", Mnc: "93"})
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+
+	// the UDM which did not answer is replaced by the next one
+	selected, sdm, _ := smfSelf.UDM()
+	require.Equal(t, "udm-2", selected.NfInstanceId)
+	require.NotSame(t, sdmClient, sdm)
+	require.True(t, gock.IsDone())
+}
+
+func TestSendGetSmDataFramedRoutes(t *testing.T) {
+	openapi.InterceptH2CClient()
+	smfSelf := smf_context.SMF_Self()
+	defer func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+		smfSelf.SetUDM(models.NfProfile{}, nil, nil)
+	}()
+	configuration := Nudm_SubscriberDataManagement.NewConfiguration()
+	configuration.SetBasePath("http://127.0.0.11:8000")
+	smfSelf.SetUDM(models.NfProfile{}, configuration, nil)
+
+	gock.New("http://127.0.0.11:8000").
+		Get("/nudm-sdm/v1/imsi-208930000000040/sm-data").
+		MatchParam("dnn", "internet").
+		Reply(http.StatusOK).
+		BodyString(`[{"singleNssai":{"sst":1},"dnnConfigurations":{"internet":{
+			"sessionAmbr":{"uplink":"100 Mbps","downlink":"200 Mbps"},
+			"ipv4FrameRouteList":[{"ipv4Mask":"10.1.0.0/16"}],
+			"ipv6FrameRouteList":[{"ipv6Prefix":"2001:db8::/48"}]}}}]`).
+		SetHeader("Content-Type", "application/json")
+
+	smContext := smf_context.NewSMContext("imsi-208930000000040", 1)
+	defer smf_context.RemoveSMContext(smContext.Ref)
+	smContext.Supi = "imsi-208930000000040"
+	smContext.Dnn = "internet"
+	smContext.Snssai = &models.Snssai{Sst: 1}
+	data, err := SendGetSmData(smContext, &models.PlmnId{Mcc: "208", Mnc: "93"})
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	dnnConfiguration := data[0].DnnConfigurations["internet"]
+	require.Equal(t, "200 Mbps", dnnConfiguration.SessionAmbr.Downlink)
+	require.Equal(t, []string{"10.1.0.0/16", "2001:db8::/48"}, dnnConfiguration.FramedRoutes())
+	require.True(t, gock.IsDone())
+}
+
+func TestSendGetSmDataFailures(t *testing.T) {
+	openapi.InterceptH2CClient()
+	smfSelf := smf_context.SMF_Self()
+	defer func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+		smfSelf.SetUDM(models.NfProfile{}, nil, nil)
+	}()
+	configuration := Nudm_SubscriberDataManagement.NewConfiguration()
+	configuration.SetBasePath("http://127.0.0.11:8000")
+	smfSelf.SetUDM(models.NfProfile{}, configuration, nil)
+
+	smContext := smf_context.NewSMContext("imsi-208930000000041", 1)
+	defer smf_context.RemoveSMContext(smContext.Ref)
+	smContext.Supi = "imsi-208930000000041"
+	smContext.Dnn = "internet"
+
+	// the UDM refuses to send the data
+	gock.New("http://127.0.0.11:8000").
+		Get("/nudm-sdm/v1/imsi-208930000000041/sm-data").
+		Reply(http.StatusNotFound).
+		JSON(models.ProblemDetails{Status: http.StatusNotFound, Cause: "DATA_NOT_FOUND"})
+	_, err := SendGetSmData(smContext, nil)
+	require.Error(t, err)
+	require.False(t, NFUnavailable(err))
+	require.False(t, NFUnavailableOrFailed(err))
+
+	// the UDM cannot send the data
+	gock.New("http://127.0.0.11:8000").
+		Get("/nudm-sdm/v1/imsi-208930000000041/sm-data").
+		Reply(http.StatusInternalServerError).
+		JSON(models.ProblemDetails{Status: http.StatusInternalServerError, Cause: "SYSTEM_FAILURE"})
+	_, err = SendGetSmData(smContext, nil)
+	require.Error(t, err)
+	require.False(t, NFUnavailable(err))
+	require.True(t, NFUnavailableOrFailed(err))
+	require.True(t, gock.IsDone())
+
+	// the UDM does not answer
+	_, err = SendGetSmData(smContext, nil)
+	require.True(t, NFUnavailable(err))
+	require.True(t, NFUnavailableOrFailed(err))
+}
@@ -0,0 +1,60 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+)
+
+// ErrReplicationChangesGone is returned when the active SMF no longer keeps the requested
+// changes, and a snapshot has to be read instead
+var ErrReplicationChangesGone = fmt.Errorf("replication changes are gone")
+
+var replicationClient = &http.Client{Timeout: 5 * time.Second}
+
+func GetReplicationSnapshot(peerUri string) (*smf_context.ReplicationSnapshot, error) {
+	snapshot := new(smf_context.ReplicationSnapshot)
+	if err := getReplication(peerUri+"/nsmf-replication/v1/snapshot", snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func GetReplicationChanges(peerUri string, after uint64) (*smf_context.ReplicationChanges, error) {
+	changes := new(smf_context.ReplicationChanges)
+	uri := fmt.Sprintf("%s/nsmf-replication/v1/changes?after=%d", peerUri, after)
+	if err := getReplication(uri, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func getReplication(uri string, body interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+smf_context.SMF_Self().ReplicationSecret)
+	res, err := replicationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if resCloseErr := res.Body.Close(); resCloseErr != nil {
+			logger.ConsumerLog.Errorf("Replication response body cannot close: %+v", resCloseErr)
+		}
+	}()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(res.Body).Decode(body)
+	case http.StatusGone:
+		return ErrReplicationChangesGone
+	default:
+		return fmt.Errorf("GET %s: unexpected status %d", uri, res.StatusCode)
+	}
+}
@@ -0,0 +1,63 @@
+package consumer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/logger"
+)
+
+// sendRequest sends the request of a service operation whose body or answer has attributes the
+// models of the openapi module miss, so that the generated client cannot send it. Like the
+// generated clients, it decodes the answer into rspBody, and returns the problem details of a
+// failure in an openapi.GenericOpenAPIError.
+func sendRequest(ctx context.Context, cfg openapi.Configuration, method, path string, query url.Values,
+	body, rspBody interface{},
+) (*http.Response, error) {
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Accept":       openapi.SelectHeaderAccept([]string{"application/json", "application/problem+json"}),
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	req, err := openapi.PrepareRequest(ctx, cfg, cfg.BasePath()+path, method, body, headers, query, url.Values{},
+		"", "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := openapi.CallAPI(cfg, req)
+	if err != nil || rsp == nil {
+		return rsp, err
+	}
+	rawBody, err := io.ReadAll(rsp.Body)
+	if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+		logger.ConsumerLog.Errorf("%s %s response body cannot close: %+v", method, path, rspCloseErr)
+	}
+	if err != nil {
+		return rsp, err
+	}
+
+	if rsp.StatusCode >= http.StatusMultipleChoices {
+		apiError := openapi.GenericOpenAPIError{
+			RawBody:     rawBody,
+			ErrorStatus: rsp.Status,
+		}
+		var problem models.ProblemDetails
+		if err := openapi.Deserialize(&problem, rawBody, rsp.Header.Get("Content-Type")); err == nil {
+			apiError.ErrorModel = problem
+		}
+		return rsp, apiError
+	}
+	if rspBody != nil && len(rawBody) > 0 {
+		if err := openapi.Deserialize(rspBody, rawBody, rsp.Header.Get("Content-Type")); err != nil {
+			return rsp, err
+		}
+	}
+	return rsp, nil
+}
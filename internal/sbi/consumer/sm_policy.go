@@ -3,7 +3,10 @@ package consumer
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -11,15 +14,69 @@ import (
 	"github.com/free5gc/openapi/models"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 )
 
-// SendSMPolicyAssociationCreate create the session management association to the PCF
+// SendSMPolicyAssociationCreate create the session management association to the PCF. When the
+// selected PCF does not answer, or its circuit breaker is open, the association is created with
+// the next discovered PCF, up to SBIMaxRetries times.
 func SendSMPolicyAssociationCreate(smContext *smf_context.SMContext) (string, *models.SmPolicyDecision, error) {
-	if smContext.SMPolicyClient == nil {
-		return "", nil, errors.Errorf("smContext not selected PCF")
+	tokenCtx, err := smf_context.SMF_Self().GetTokenCtx(models.ServiceName_NPCF_SMPOLICYCONTROL, models.NfType_PCF)
+	if err != nil {
+		return "", nil, err
 	}
 
-	smPolicyData := models.SmPolicyContextData{}
+	tried := make(map[string]bool)
+	for {
+		pcfID := smContext.SelectedPCFProfile.NfInstanceId
+		if err = smf_context.CheckNFInstance(pcfID); err == nil {
+			smPolicyID, smPolicyDecision, httpRsp, sendErr := sendSMPolicyAssociationCreate(tokenCtx, smContext)
+			if !nfFailed(httpRsp, sendErr) {
+				if pcfID != "" {
+					smf_context.NFInstanceAnswered(pcfID)
+				}
+				return smPolicyID, smPolicyDecision, sendErr
+			}
+			if pcfID != "" {
+				smf_context.NFInstanceFailed(pcfID)
+			}
+			err = sendErr
+		}
+		if pcfID == "" {
+			return "", nil, err
+		}
+		tried[pcfID] = true
+		if len(tried) > smf_context.SMF_Self().SBIMaxRetries {
+			return "", nil, err
+		}
+		if selectErr := smContext.PCFSelection(); selectErr != nil ||
+			tried[smContext.SelectedPCFProfile.NfInstanceId] {
+			return "", nil, err
+		}
+		logger.ConsumerLog.Warnf("PCF[%s] not answering, try PCF[%s]: %v",
+			pcfID, smContext.SelectedPCFProfile.NfInstanceId, err)
+	}
+}
+
+// smPolicyContextData is the SmPolicyContextData of TS 29.512 5.6.2.3 with the framed routes of
+// the UE, which the model of the openapi module misses. The request is not sent by the SM policy
+// control client for this reason.
+type smPolicyContextData struct {
+	models.SmPolicyContextData
+	Ipv4FrameRouteList []string `json:"ipv4FrameRouteList,omitempty"`
+	Ipv6FrameRouteList []string `json:"ipv6FrameRouteList,omitempty"`
+}
+
+func sendSMPolicyAssociationCreate(tokenCtx context.Context, smContext *smf_context.SMContext) (
+	string, *models.SmPolicyDecision, *http.Response, error,
+) {
+	if smContext.SMPolicyConf == nil {
+		return "", nil, nil, errors.Errorf("smContext not selected PCF")
+	}
+	ctx, cancel := context.WithTimeout(tokenCtx, smf_context.SMF_Self().SBITimeoutOf(models.NfType_PCF))
+	defer cancel()
+
+	smPolicyData := smPolicyContextData{}
 
 	smPolicyData.Supi = smContext.Supi
 	smPolicyData.PduSessionId = smContext.PDUSessionID
@@ -34,6 +91,13 @@ func SendSMPolicyAssociationCreate(smContext *smf_context.SMContext) (string, *m
 	smPolicyData.AccessType = smContext.AnType
 	smPolicyData.RatType = smContext.RatType
 	smPolicyData.Ipv4Address = smContext.PDUAddress.To4().String()
+	for _, route := range smContext.FramedRoutes {
+		if strings.Contains(route, ":") {
+			smPolicyData.Ipv6FrameRouteList = append(smPolicyData.Ipv6FrameRouteList, route)
+		} else {
+			smPolicyData.Ipv4FrameRouteList = append(smPolicyData.Ipv4FrameRouteList, route)
+		}
+	}
 	smPolicyData.SubsSessAmbr = smContext.DnnConfiguration.SessionAmbr
 	smPolicyData.SubsDefQos = smContext.DnnConfiguration.Var5gQosProfile
 	smPolicyData.SliceInfo = smContext.Snssai
@@ -45,35 +109,41 @@ func SendSMPolicyAssociationCreate(smContext *smf_context.SMContext) (string, *m
 
 	var smPolicyID string
 	var smPolicyDecision *models.SmPolicyDecision
-	if smPolicyDecisionFromPCF, httpRsp, err := smContext.SMPolicyClient.
-		DefaultApi.SmPoliciesPost(context.Background(), smPolicyData); err != nil {
-		return "", nil, err
+	var smPolicyDecisionFromPCF models.SmPolicyDecision
+	start := time.Now()
+	httpRsp, err := sendRequest(ctx, smContext.SMPolicyConf, http.MethodPost, "/sm-policies", nil,
+		&smPolicyData, &smPolicyDecisionFromPCF)
+	metrics.ObserveSBIClient(metrics.ServiceNpcfSmPolicyControl, start, httpRsp)
+	if err != nil {
+		return "", nil, httpRsp, err
 	} else {
-		defer func() {
-			if rspCloseErr := httpRsp.Body.Close(); rspCloseErr != nil {
-				logger.ConsumerLog.Errorf("SmPoliciesPost response body cannot close: %+v", rspCloseErr)
-			}
-		}()
 		smPolicyDecision = &smPolicyDecisionFromPCF
 
 		loc := httpRsp.Header.Get("Location")
-		if smPolicyID = extractSMPolicyIDFromLocation(loc); len(smPolicyID) == 0 {
-			return "", nil, fmt.Errorf("SMPolicy ID parse failed")
+		var apiRoot string
+		if apiRoot, smPolicyID = parseSMPolicyLocation(loc); len(smPolicyID) == 0 {
+			return "", nil, httpRsp, fmt.Errorf("SMPolicy ID parse failed")
+		}
+		// the updates and the deletion of the association go to the PCF the SCP selected
+		if smf_context.DelegatedDiscovery() {
+			smContext.BindSMPolicyAssociation(apiRoot)
 		}
 	}
 
-	return smPolicyID, smPolicyDecision, nil
+	return smPolicyID, smPolicyDecision, httpRsp, nil
 }
 
-var smPolicyRegexp = regexp.MustCompile(`http[s]?\://.*/npcf-smpolicycontrol/v\d+/sm-policies/(.*)`)
+var smPolicyRegexp = regexp.MustCompile(`(http[s]?\://.*)/npcf-smpolicycontrol/v\d+/sm-policies/(.*)`)
 
-func extractSMPolicyIDFromLocation(location string) string {
+// parseSMPolicyLocation returns the apiRoot of the PCF and the ID of the SM policy association
+// from its Location
+func parseSMPolicyLocation(location string) (string, string) {
 	match := smPolicyRegexp.FindStringSubmatch(location)
-	if len(match) > 1 {
-		return match[1]
+	if len(match) > 2 {
+		return match[1], match[2]
 	}
 	// not match submatch
-	return ""
+	return "", ""
 }
 
 func SendSMPolicyAssociationTermination(smContext *smf_context.SMContext) error {
@@ -81,8 +151,17 @@ func SendSMPolicyAssociationTermination(smContext *smf_context.SMContext) error
 		return errors.Errorf("smContext not selected PCF")
 	}
 
-	if httpRsp, err := smContext.SMPolicyClient.DefaultApi.SmPoliciesSmPolicyIdDeletePost(
-		context.Background(), smContext.SMPolicyID, models.SmPolicyDeleteData{}); err != nil {
+	ctx, cancel, err := smf_context.SMF_Self().GetSBICtx(models.ServiceName_NPCF_SMPOLICYCONTROL, models.NfType_PCF)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	start := time.Now()
+	httpRsp, err := smContext.SMPolicyClient.DefaultApi.SmPoliciesSmPolicyIdDeletePost(
+		ctx, smContext.SMPolicyID, models.SmPolicyDeleteData{})
+	metrics.ObserveSBIClient(metrics.ServiceNpcfSmPolicyControl, start, httpRsp)
+	if err != nil {
 		return fmt.Errorf("SM Policy termination failed: %v", err)
 	} else {
 		defer func() {
@@ -0,0 +1,84 @@
+package consumer
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/Npcf_SMPolicyControl"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestSMPolicyAssociationDelegatedDiscovery(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	defer func(scpUri string, delegatedDiscovery bool) {
+		smfSelf.ScpUri, smfSelf.ScpDelegatedDiscovery = scpUri, delegatedDiscovery
+	}(smfSelf.ScpUri, smfSelf.ScpDelegatedDiscovery)
+	smfSelf.ScpUri, smfSelf.ScpDelegatedDiscovery = "http://127.0.0.9:8000", true
+
+	openapi.InterceptH2CClient()
+	defer func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+	}()
+	gock.New(smfSelf.ScpUri).
+		Post("/npcf-smpolicycontrol/v1/sm-policies").
+		MatchHeader(smf_context.HeaderDiscoveryTargetNfType, string(models.NfType_PCF)).
+		Reply(http.StatusCreated).
+		SetHeader("Location", "http://pcf.example:8000/npcf-smpolicycontrol/v1/sm-policies/policy-1").
+		JSON(models.SmPolicyDecision{})
+	gock.New(smfSelf.ScpUri).
+		Post("/npcf-smpolicycontrol/v1/sm-policies/policy-1/delete").
+		MatchHeader(smf_context.HeaderTargetApiRoot, "http://pcf.example:8000").
+		Reply(http.StatusNoContent)
+
+	smContext := smf_context.NewSMContext("imsi-208930000000031", 1)
+	defer smf_context.RemoveSMContext(smContext.Ref)
+	smContext.Dnn = "internet"
+	smContext.ServingNetwork = &models.PlmnId{Mcc: "208", Mnc: "93"}
+	require.NoError(t, smContext.PCFSelection())
+
+	smPolicyID, _, err := SendSMPolicyAssociationCreate(smContext)
+	require.NoError(t, err)
+	require.Equal(t, "policy-1", smPolicyID)
+	smContext.SMPolicyID = smPolicyID
+
+	require.NoError(t, SendSMPolicyAssociationTermination(smContext))
+	require.True(t, gock.IsDone())
+}
+
+func TestSMPolicyAssociationFramedRoutes(t *testing.T) {
+	openapi.InterceptH2CClient()
+	defer func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+	}()
+	gock.New("http://127.0.0.7:8000").
+		Post("/npcf-smpolicycontrol/v1/sm-policies").
+		BodyString(`"ipv4FrameRouteList":\["10\.1\.0\.0/16"\],"ipv6FrameRouteList":\["2001:db8::/48"\]`).
+		Reply(http.StatusCreated).
+		SetHeader("Location", "http://127.0.0.7:8000/npcf-smpolicycontrol/v1/sm-policies/policy-1").
+		JSON(models.SmPolicyDecision{PolicyCtrlReqTriggers: []models.PolicyControlRequestTrigger{
+			models.PolicyControlRequestTrigger_PLMN_CH,
+		}})
+
+	smContext := smf_context.NewSMContext("imsi-208930000000040", 1)
+	defer smf_context.RemoveSMContext(smContext.Ref)
+	smContext.Dnn = "internet"
+	smContext.ServingNetwork = &models.PlmnId{Mcc: "208", Mnc: "93"}
+	smContext.FramedRoutes = []string{"10.1.0.0/16", "2001:db8::/48"}
+	configuration := Npcf_SMPolicyControl.NewConfiguration()
+	configuration.SetBasePath("http://127.0.0.7:8000")
+	smContext.SetSMPolicyConf(configuration)
+
+	smPolicyID, decision, err := SendSMPolicyAssociationCreate(smContext)
+	require.NoError(t, err)
+	require.Equal(t, "policy-1", smPolicyID)
+	require.Equal(t, []models.PolicyControlRequestTrigger{models.PolicyControlRequestTrigger_PLMN_CH},
+		decision.PolicyCtrlReqTriggers)
+	require.True(t, gock.IsDone())
+}
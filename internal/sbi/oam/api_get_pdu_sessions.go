@@ -0,0 +1,33 @@
+package oam
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/smf/internal/sbi/producer"
+)
+
+func HTTPGetPDUSessions(c *gin.Context) {
+	var query producer.PDUSessionQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, producer.InvalidPDUSessionQuery("[Query] "+err.Error()))
+		return
+	}
+
+	HTTPResponse := producer.HandleOAMGetPDUSessions(&query)
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPQueryPDUSessions(c *gin.Context) {
+	var query producer.PDUSessionQuery
+	if err := c.ShouldBindJSON(&query); err != nil {
+		c.JSON(http.StatusBadRequest, producer.InvalidPDUSessionQuery("[Request Body] "+err.Error()))
+		return
+	}
+
+	HTTPResponse := producer.HandleOAMGetPDUSessions(&query)
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
@@ -0,0 +1,44 @@
+package oam
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+)
+
+func TestPDUSessionQueryErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/pdu-sessions", HTTPGetPDUSessions)
+	router.POST("/pdu-sessions/query", HTTPQueryPDUSessions)
+
+	serve := func(req *http.Request) models.ProblemDetails {
+		rsp := httptest.NewRecorder()
+		router.ServeHTTP(rsp, req)
+		require.Equal(t, http.StatusBadRequest, rsp.Code)
+		var problemDetails models.ProblemDetails
+		require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &problemDetails))
+		return problemDetails
+	}
+
+	getProblem := serve(httptest.NewRequest(http.MethodGet, "/pdu-sessions?limit=many", nil))
+	req := httptest.NewRequest(http.MethodPost, "/pdu-sessions/query", strings.NewReader(`{"limit":"many"}`))
+	req.Header.Set("Content-Type", "application/json")
+	postProblem := serve(req)
+	req = httptest.NewRequest(http.MethodPost, "/pdu-sessions/query", strings.NewReader(`{"snssai":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	matchProblem := serve(req)
+
+	for _, problemDetails := range []models.ProblemDetails{getProblem, postProblem, matchProblem} {
+		require.Equal(t, "Invalid PDU session query", problemDetails.Title)
+		require.Equal(t, "INVALID_QUERY_PARAM", problemDetails.Cause)
+		require.NotEmpty(t, problemDetails.Detail)
+	}
+}
@@ -0,0 +1,13 @@
+package oam
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/smf/internal/sbi/producer"
+)
+
+func HTTPGetUPFRestorations(c *gin.Context) {
+	HTTPResponse := producer.HandleOAMGetUPFRestorations()
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
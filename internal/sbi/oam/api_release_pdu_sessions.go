@@ -0,0 +1,27 @@
+package oam
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/sbi/producer"
+)
+
+func HTTPReleasePDUSessions(c *gin.Context) {
+	var request producer.ReleasePDUSessionsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		problemDetails := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: "[Request Body] " + err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, problemDetails)
+		return
+	}
+
+	HTTPResponse := producer.HandleOAMReleasePDUSessions(&request)
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
@@ -0,0 +1,57 @@
+package oam
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+)
+
+func TestReleasePDUSessionsErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/pdu-sessions/release", HTTPReleasePDUSessions)
+
+	serve := func(body string, status int) models.ProblemDetails {
+		req := httptest.NewRequest(http.MethodPost, "/pdu-sessions/release", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rsp := httptest.NewRecorder()
+		router.ServeHTTP(rsp, req)
+		require.Equal(t, status, rsp.Code, body)
+		var problemDetails models.ProblemDetails
+		require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &problemDetails))
+		return problemDetails
+	}
+
+	problemDetails := serve(`{"supi":1}`, http.StatusBadRequest)
+	require.Equal(t, "Malformed request syntax", problemDetails.Title)
+
+	for _, body := range []string{
+		`{}`,
+		`{"supi":"imsi-208930000000001","dnn":"internet"}`,
+		`{"supi":"imsi-208930000000001","cause":0}`,
+		`{"supi":"imsi-208930000000001","cause":1}`,
+		`{"supi":"imsi-208930000000001","cause":255}`,
+		`{"supi":"imsi-208930000000001","backoffTimer":-1}`,
+		`{"supi":"imsi-208930000000001","backoffTimer":1116001}`,
+	} {
+		problemDetails = serve(body, http.StatusBadRequest)
+		require.Equal(t, "INVALID_MSG_FORMAT", problemDetails.Cause, body)
+		require.NotEmpty(t, problemDetails.Detail, body)
+	}
+
+	// no PDU session to release
+	for _, body := range []string{
+		`{"smContextRef":"urn:uuid:00000000-0000-0000-0000-000000000000"}`,
+		`{"supi":"imsi-208930000000001","cause":36,"backoffTimer":60}`,
+	} {
+		problemDetails = serve(body, http.StatusNotFound)
+		require.Equal(t, "CONTEXT_NOT_FOUND", problemDetails.Cause, body)
+	}
+}
@@ -0,0 +1,97 @@
+package oam
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/sbi/producer"
+	"github.com/free5gc/util/httpwrapper"
+)
+
+func HTTPGetUEIPPools(c *gin.Context) {
+	var query producer.UEIPPoolQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		problemDetails := models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Cause:  "INVALID_QUERY_PARAM",
+			Detail: err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, problemDetails)
+		return
+	}
+
+	HTTPResponse := producer.HandleOAMGetUEIPPools(&query)
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPAddUEIPPool(c *gin.Context) {
+	var request producer.AddUEIPPoolRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		problemDetails := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: "[Request Body] " + err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, problemDetails)
+		return
+	}
+
+	HTTPResponse := producer.HandleOAMAddUEIPPool(&request)
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPRetireUEIPPool(c *gin.Context) {
+	upf, pool := c.Query("upf"), c.Query("pool")
+	if upf == "" || pool == "" {
+		problemDetails := models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Cause:  "MANDATORY_QUERY_PARAM_MISSING",
+			Detail: "upf and pool are required",
+		}
+		c.JSON(http.StatusBadRequest, problemDetails)
+		return
+	}
+
+	HTTPResponse := producer.HandleOAMRetireUEIPPool(upf, pool)
+
+	if HTTPResponse.Body == nil {
+		c.Status(HTTPResponse.Status)
+		return
+	}
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPReserveUEIP(c *gin.Context) {
+	handleUEIPAddressRequest(c, producer.HandleOAMReserveUEIP)
+}
+
+func HTTPReleaseUEIP(c *gin.Context) {
+	handleUEIPAddressRequest(c, producer.HandleOAMReleaseUEIP)
+}
+
+func handleUEIPAddressRequest(c *gin.Context,
+	handler func(*producer.UEIPAddressRequest) *httpwrapper.Response,
+) {
+	var request producer.UEIPAddressRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		problemDetails := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: "[Request Body] " + err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, problemDetails)
+		return
+	}
+
+	HTTPResponse := handler(&request)
+
+	if HTTPResponse.Body == nil {
+		c.Status(HTTPResponse.Status)
+		return
+	}
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
@@ -5,10 +5,16 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/util"
 	logger_util "github.com/free5gc/util/logger"
 )
 
+// serviceNameOAM is the scope of the access tokens for the service, which is not a standard one
+const serviceNameOAM = models.ServiceName("nsmf-oam")
+
 // Route is the information for every URI.
 type Route struct {
 	// Name is the name of this Route.
@@ -34,10 +40,19 @@ func NewRouter() *gin.Engine {
 func AddService(engine *gin.Engine) *gin.RouterGroup {
 	group := engine.Group("/nsmf-oam/v1")
 
+	routerAuthorizationCheck := util.NewRouterAuthorizationCheck(serviceNameOAM)
+	group.Use(func(c *gin.Context) {
+		routerAuthorizationCheck.Check(c, smf_context.SMF_Self())
+	})
+
 	for _, route := range routes {
 		switch route.Method {
 		case "GET":
 			group.GET(route.Pattern, route.HandlerFunc)
+		case "POST":
+			group.POST(route.Pattern, route.HandlerFunc)
+		case "DELETE":
+			group.DELETE(route.Pattern, route.HandlerFunc)
 		}
 	}
 	return group
@@ -61,4 +76,58 @@ var routes = Routes{
 		"/ue-pdu-session-info/:smContextRef",
 		HTTPGetUEPDUSessionInfo,
 	},
+	{
+		"Get PDU Sessions",
+		"GET",
+		"/pdu-sessions",
+		HTTPGetPDUSessions,
+	},
+	{
+		"Query PDU Sessions",
+		"POST",
+		"/pdu-sessions/query",
+		HTTPQueryPDUSessions,
+	},
+	{
+		"Release PDU Sessions",
+		"POST",
+		"/pdu-sessions/release",
+		HTTPReleasePDUSessions,
+	},
+	{
+		"Get UE IP Pools",
+		"GET",
+		"/ue-ip-pools",
+		HTTPGetUEIPPools,
+	},
+	{
+		"Add UE IP Pool",
+		"POST",
+		"/ue-ip-pools",
+		HTTPAddUEIPPool,
+	},
+	{
+		"Retire UE IP Pool",
+		"DELETE",
+		"/ue-ip-pools",
+		HTTPRetireUEIPPool,
+	},
+	{
+		"Reserve UE IP",
+		"POST",
+		"/ue-ip-pools/reserve",
+		HTTPReserveUEIP,
+	},
+	{
+		"Release UE IP",
+		"POST",
+		"/ue-ip-pools/release",
+		HTTPReleaseUEIP,
+	},
+	{
+		"Get UPF Restorations",
+		"GET",
+		"/upf-restorations",
+		HTTPGetUPFRestorations,
+	},
 }
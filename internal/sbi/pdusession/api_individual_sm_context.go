@@ -19,6 +19,7 @@ import (
 	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 	"github.com/free5gc/smf/internal/sbi/producer"
 	"github.com/free5gc/util/httpwrapper"
 )
@@ -48,6 +49,7 @@ func HTTPReleaseSmContext(c *gin.Context) {
 	smContextRef := req.Params["smContextRef"]
 	HTTPResponse := producer.HandlePDUSessionSMContextRelease(
 		smContextRef, req.Body.(models.ReleaseSmContextRequest))
+	observeProcedure(metrics.ProcedureRelease, HTTPResponse)
 
 	if HTTPResponse.Status < 300 {
 		c.Status(http.StatusNoContent)
@@ -86,6 +88,7 @@ func HTTPUpdateSmContext(c *gin.Context) {
 	smContextRef := req.Params["smContextRef"]
 	HTTPResponse := producer.HandlePDUSessionSMContextUpdate(
 		smContextRef, req.Body.(models.UpdateSmContextRequest))
+	observeProcedure(metrics.ProcedureModification, HTTPResponse)
 
 	if HTTPResponse.Status < 300 {
 		c.Render(HTTPResponse.Status, openapi.MultipartRelatedRender{Data: HTTPResponse.Body})
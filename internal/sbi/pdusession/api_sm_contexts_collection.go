@@ -18,6 +18,7 @@ import (
 	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 	"github.com/free5gc/smf/internal/sbi/producer"
 	"github.com/free5gc/util/httpwrapper"
 )
@@ -52,6 +53,7 @@ func HTTPPostSmContexts(c *gin.Context) {
 
 	req := httpwrapper.NewRequest(c.Request, request)
 	HTTPResponse := producer.HandlePDUSessionSMContextCreate(req.Body.(models.PostSmContextsRequest))
+	observeProcedure(metrics.ProcedureEstablishment, HTTPResponse)
 	// Http Response to AMF
 	for key, val := range HTTPResponse.Header {
 		c.Header(key, val[0])
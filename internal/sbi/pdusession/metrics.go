@@ -0,0 +1,37 @@
+package pdusession
+
+import (
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/metrics"
+	"github.com/free5gc/util/httpwrapper"
+)
+
+// observeProcedure counts the procedure, and its failure with the cause of the error response
+func observeProcedure(procedure string, rsp *httpwrapper.Response) {
+	if rsp.Status < 300 {
+		metrics.ObserveProcedure(procedure, "")
+		return
+	}
+
+	var problemDetails *models.ProblemDetails
+	switch body := rsp.Body.(type) {
+	case models.PostSmContextsErrorResponse:
+		if body.JsonData != nil {
+			problemDetails = body.JsonData.Error
+		}
+	case models.UpdateSmContextErrorResponse:
+		if body.JsonData != nil {
+			problemDetails = body.JsonData.Error
+		}
+	case *models.ProblemDetails:
+		problemDetails = body
+	case models.ProblemDetails:
+		problemDetails = &body
+	}
+
+	cause := "UNSPECIFIED"
+	if problemDetails != nil && problemDetails.Cause != "" {
+		cause = problemDetails.Cause
+	}
+	metrics.ObserveProcedure(procedure, cause)
+}
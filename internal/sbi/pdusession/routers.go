@@ -15,7 +15,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/util"
 	logger_util "github.com/free5gc/util/logger"
 )
 
@@ -44,6 +47,11 @@ func NewRouter() *gin.Engine {
 func AddService(engine *gin.Engine) *gin.RouterGroup {
 	group := engine.Group("/nsmf-pdusession/v1")
 
+	routerAuthorizationCheck := util.NewRouterAuthorizationCheck(models.ServiceName_NSMF_PDUSESSION)
+	group.Use(func(c *gin.Context) {
+		routerAuthorizationCheck.Check(c, smf_context.SMF_Self())
+	})
+
 	for _, route := range routes {
 		switch route.Method {
 		case "GET":
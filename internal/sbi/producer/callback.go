@@ -2,6 +2,7 @@ package producer
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/free5gc/openapi/models"
 	smf_context "github.com/free5gc/smf/internal/context"
@@ -40,10 +41,103 @@ func HandleSMPolicyUpdateNotify(smContextRef string, request models.SmPolicyNoti
 		// TODO: Fill the error body
 		httpResponse.Status = http.StatusBadRequest
 	}
+	if changes := enforcePCCRules(smContext); !changes.Empty() {
+		sendPDUSessionModificationCommand(smContext, changes)
+	}
+	smf_context.StoreSMContext(smContext)
 
 	return httpResponse
 }
 
+// HandleN1N2MessageTransferFailureNotification handles the failure of the paging triggered
+// by downlink data, TS 23.502 4.2.3.3 step 5
+func HandleN1N2MessageTransferFailureNotification(smContextRef string,
+	notification models.N1N2MsgTxfrFailureNotification,
+) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandleN1N2MessageTransferFailureNotification")
+	smContext := smf_context.GetSMContextByRef(smContextRef)
+
+	if smContext == nil {
+		logger.PduSessLog.Errorf("SMContext[%s] not found", smContextRef)
+		httpResponse := httpwrapper.NewResponse(http.StatusNotFound, nil, nil)
+		return httpResponse
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	logger.PduSessLog.Warnf("N1N2MessageTransfer to UE[%s] PDUSessionID[%d] failed: %s",
+		smContext.Supi, smContext.PDUSessionID, notification.Cause)
+
+	if smContext.UpCnxState == models.UpCnxState_DEACTIVATED {
+		HandlePagingFailure(smContext)
+	} else {
+		smContext.PagingInProgress = false
+	}
+
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
+// HandleSDMChangeNotification handles the change of the SM subscription data of the session,
+// TS 29.503 5.2.2.3.3
+func HandleSDMChangeNotification(smContextRef string,
+	notification models.ModificationNotification,
+) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandleSDMChangeNotification")
+	smContext := smf_context.GetSMContextByRef(smContextRef)
+
+	if smContext == nil {
+		logger.PduSessLog.Errorf("SMContext[%s] not found", smContextRef)
+		httpResponse := httpwrapper.NewResponse(http.StatusNotFound, nil, nil)
+		return httpResponse
+	}
+
+	logger.PduSessLog.Debugf("UE[%s] PDUSessionID[%d] %d changes of the SM subscription data",
+		smContext.Supi, smContext.PDUSessionID, len(notification.NotifyItems))
+	// the UDM is answered before the SMF reads the changed data back
+	go applySubscriptionDataChange(smContext)
+
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
+// HandleNfStatusNotification drops the clients of the deregistered NF instance, which are
+// discovered again when they are needed, TS 29.510 5.2.2.6
+func HandleNfStatusNotification(notification models.NotificationData) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandleNfStatusNotification")
+	if notification.Event != models.NotificationEventType_DEREGISTERED {
+		return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+	}
+
+	uri := notification.NfInstanceUri
+	nfInstanceID := uri[strings.LastIndex(uri, "/")+1:]
+	if nfInstanceID == "" {
+		problemDetails := &models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: "[Request Body] no NF instance in nfInstanceUri " + uri,
+		}
+		return httpwrapper.NewResponse(http.StatusBadRequest, nil, problemDetails)
+	}
+	logger.PduSessLog.Infof("NF instance[%s] deregistered", nfInstanceID)
+	smf_context.RemoveNFInstanceFromDiscovery(nfInstanceID)
+
+	smf_context.SMF_Self().RemoveUDM(nfInstanceID)
+	for _, smContext := range smf_context.ListSMContexts() {
+		smContext.SMLock.Lock()
+		if smContext.AMFProfile.NfInstanceId == nfInstanceID {
+			smContext.AMFProfile = models.NfProfile{}
+			smContext.CommunicationClient = nil
+		}
+		if smContext.SelectedPCFProfile.NfInstanceId == nfInstanceID {
+			smContext.SelectedPCFProfile = models.NfProfile{}
+			smContext.SetSMPolicyConf(nil)
+		}
+		smContext.SMLock.Unlock()
+	}
+
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
 func handleSessionRule(smContext *smf_context.SMContext, id string, sessionRuleModel *models.SessionRule) {
 	if sessionRuleModel == nil {
 		logger.PduSessLog.Debugf("Delete SessionRule[%s]", id)
@@ -61,6 +155,31 @@ func handleSessionRule(smContext *smf_context.SMContext, id string, sessionRuleM
 	}
 }
 
+func handlePccRule(smContext *smf_context.SMContext, id string, pccRuleModel *models.PccRule,
+	qosDecs map[string]*models.QosData,
+) {
+	if pccRuleModel == nil {
+		logger.PduSessLog.Debugf("Delete PccRule[%s]", id)
+		smContext.RemovePCCRule(id)
+		return
+	}
+
+	logger.PduSessLog.Debugf("Install PccRule[%s]", id)
+	pccRule := smf_context.NewPCCRuleFromModel(pccRuleModel)
+	// TODO: the rule only follows its first QoS data
+	if len(pccRuleModel.RefQosData) > 0 {
+		qosID := pccRuleModel.RefQosData[0]
+		if qosData, exist := qosDecs[qosID]; exist {
+			pccRule.QosData = qosData
+		} else if oldRule, exist := smContext.PCCRules[id]; exist &&
+			oldRule.QosData != nil && oldRule.QosData.QosId == qosID {
+			// the QoS data was decided before
+			pccRule.QosData = oldRule.QosData
+		}
+	}
+	smContext.InstallPCCRule(pccRule)
+}
+
 func ApplySmPolicyFromDecision(smContext *smf_context.SMContext, decision *models.SmPolicyDecision) error {
 	logger.PduSessLog.Traceln("In ApplySmPolicyFromDecision")
 	var err error
@@ -95,6 +214,10 @@ func ApplySmPolicyFromDecision(smContext *smf_context.SMContext, decision *model
 		}
 	}
 
+	for id, pccRuleModel := range decision.PccRules {
+		handlePccRule(smContext, id, pccRuleModel, decision.QosDecs)
+	}
+
 	logger.PduSessLog.Traceln("End of ApplySmPolicyFromDecision")
 	return err
 }
@@ -0,0 +1,44 @@
+package producer
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/Namf_Communication"
+	"github.com/free5gc/openapi/Nudm_SubscriberDataManagement"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestHandleNfStatusNotification(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	defer smfSelf.SetUDM(models.NfProfile{}, nil, nil)
+	smfSelf.SetUDM(models.NfProfile{NfInstanceId: "udm-1"}, Nudm_SubscriberDataManagement.NewConfiguration(), nil)
+
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+	smContext.AMFProfile = models.NfProfile{NfInstanceId: "amf-1"}
+	smContext.CommunicationClient = Namf_Communication.NewAPIClient(Namf_Communication.NewConfiguration())
+
+	deregistered := func(nfInstanceID string) int {
+		return HandleNfStatusNotification(models.NotificationData{
+			Event:         models.NotificationEventType_DEREGISTERED,
+			NfInstanceUri: "http://nrf:8000/nnrf-nfm/v1/nf-instances/" + nfInstanceID,
+		}).Status
+	}
+
+	require.Equal(t, http.StatusNoContent, deregistered("amf-1"))
+	require.Nil(t, smContext.CommunicationClient)
+	require.Empty(t, smContext.AMFProfile.NfInstanceId)
+	udm, sdm, _ := smfSelf.UDM()
+	require.Equal(t, "udm-1", udm.NfInstanceId)
+	require.NotNil(t, sdm)
+
+	require.Equal(t, http.StatusNoContent, deregistered("udm-1"))
+	udm, sdm, _ = smfSelf.UDM()
+	require.Empty(t, udm.NfInstanceId)
+	require.Nil(t, sdm)
+
+	require.Equal(t, http.StatusBadRequest, deregistered(""))
+}
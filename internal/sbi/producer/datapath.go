@@ -1,16 +1,19 @@
 package producer
 
 import (
-	"context"
 	"fmt"
+	"time"
 
 	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpType"
 	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/gtpu"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 	pfcp_message "github.com/free5gc/smf/internal/pfcp/message"
+	"github.com/free5gc/smf/internal/sbi/consumer"
 )
 
 type PFCPState struct {
@@ -72,6 +75,18 @@ func ActivateUPFSessionAndNotifyUE(smContext *smf_context.SMContext) []SendPfcpR
 		}
 	}
 
+	// the PDRs of the PCC rules detect their traffic on the UPFs of the default path
+	for _, rule := range smContext.PCCRules {
+		for _, ruleUPF := range rule.UPFs {
+			if pfcpState := pfcpPool[ruleUPF.UPF.NodeID.ResolveNodeIdToIp().String()]; pfcpState != nil {
+				pfcpState.pdrList = append(pfcpState.pdrList, ruleUPF.PDRs...)
+				if ruleUPF.QER != nil {
+					pfcpState.qerList = append(pfcpState.qerList, ruleUPF.QER)
+				}
+			}
+		}
+	}
+
 	resChan := make(chan SendPfcpResult)
 
 	for ip, pfcp := range pfcpPool {
@@ -112,6 +127,7 @@ func establishPfcpSession(smContext *smf_context.SMContext, state *PFCPState, re
 		NodeIDtoIP := rsp.NodeID.ResolveNodeIdToIp().String()
 		pfcpSessionCtx := smContext.PFCPContext[NodeIDtoIP]
 		pfcpSessionCtx.RemoteSEID = rsp.UPFSEID.Seid
+		setUPFFQCSID(pfcpSessionCtx, &rsp)
 	}
 
 	if rsp.Cause != nil && rsp.Cause.CauseValue == pfcpType.CauseRequestAccepted {
@@ -174,18 +190,20 @@ func establishPfcpSession(smContext *smf_context.SMContext, state *PFCPState, re
 			},
 		}
 
-		rspData, res, err := smContext.
-			CommunicationClient.
-			N1N2MessageCollectionDocumentApi.
-			N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
-		defer func() {
-			if resCloseErr := res.Body.Close(); resCloseErr != nil {
-				logger.ConsumerLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
-			}
-		}()
+		rspData, res, err := consumer.SendN1N2MessageTransfer(smContext, n1n2Request)
+		if res != nil {
+			defer func() {
+				if resCloseErr := res.Body.Close(); resCloseErr != nil {
+					logger.ConsumerLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
+				}
+			}()
+		}
 		smContext.SMContextState = smf_context.Active
+		// the usage is accounted from when the user plane of the session is set up
+		smContext.StartAccounting()
+		smf_context.StoreSMContext(smContext)
 		if err != nil {
-			logger.PfcpLog.Warnf("Send N1N2Transfer failed")
+			logger.PfcpLog.Warnf("Send N1N2Transfer failed: %v", err)
 		}
 		if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
 			logger.PfcpLog.Warnf("%v", rspData.Cause)
@@ -194,9 +212,10 @@ func establishPfcpSession(smContext *smf_context.SMContext, state *PFCPState, re
 }
 
 func sendPDUSessionEstablishmentReject(smContext *smf_context.SMContext, nasErrorCause uint8) {
+	metrics.ObserveProcedureFailure(metrics.ProcedureEstablishment, metrics.NasCause(nasErrorCause))
 	n1n2Request := models.N1N2MessageTransferRequest{}
-	if smNasBuf, err := smf_context.BuildGSMPDUSessionEstablishmentReject(
-		smContext, nasMessage.Cause5GSMNetworkFailure); err != nil {
+	if smNasBuf, err := smf_context.BuildGSMPDUSessionEstablishmentRejectWithBackoffTimer(
+		smContext, nasErrorCause, establishmentRejectBackoffTimer(nasErrorCause)); err != nil {
 		logger.PduSessLog.Errorf("Build GSM PDUSessionEstablishmentReject failed: %s", err)
 	} else {
 		n1n2Request.BinaryDataN1Message = smNasBuf
@@ -208,18 +227,22 @@ func sendPDUSessionEstablishmentReject(smContext *smf_context.SMContext, nasErro
 			N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
 		},
 	}
-	rspData, res, err := smContext.
-		CommunicationClient.
-		N1N2MessageCollectionDocumentApi.
-		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
-	defer func() {
-		if resCloseErr := res.Body.Close(); resCloseErr != nil {
-			logger.ConsumerLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
-		}
-	}()
+	if smContext.CommunicationClient == nil {
+		logger.PduSessLog.Errorf("UE[%s] has no serving AMF", smContext.Supi)
+		smContext.SMContextState = smf_context.InActive
+		return
+	}
+	rspData, res, err := consumer.SendN1N2MessageTransfer(smContext, n1n2Request)
+	if res != nil {
+		defer func() {
+			if resCloseErr := res.Body.Close(); resCloseErr != nil {
+				logger.ConsumerLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
+			}
+		}()
+	}
 	smContext.SMContextState = smf_context.InActive
 	if err != nil {
-		logger.PfcpLog.Warnf("Send N1N2Transfer failed")
+		logger.PfcpLog.Warnf("Send N1N2Transfer failed: %v", err)
 	}
 	if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
 		logger.PfcpLog.Warnf("%v", rspData.Cause)
@@ -294,6 +317,9 @@ func updateAnUpfPfcpSession(smContext *smf_context.SMContext,
 func ReleaseTunnel(smContext *smf_context.SMContext) []SendPfcpResult {
 	resChan := make(chan SendPfcpResult)
 
+	// the rules of the PCC rules go with the PFCP sessions
+	smContext.DeactivatePCCRules()
+
 	deletedPFCPNode := make(map[string]bool)
 	for _, dataPath := range smContext.Tunnel.DataPathPool {
 		var targetNodes []*smf_context.DataPathNode
@@ -339,6 +365,10 @@ func deletePfcpSession(upf *smf_context.UPF, ctx *smf_context.SMContext, resCh c
 	rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPSessionDeletionResponse)
 	if rsp.Cause != nil && rsp.Cause.CauseValue == pfcpType.CauseRequestAccepted {
 		logger.PduSessLog.Info("Received PFCP Session Deletion Accepted Response")
+		// final usage of the session, accounted before the Stop
+		if usageReport := rsp.UsageReport; usageReport != nil {
+			ctx.AccountUsage(usageReport.VolumeMeasurement)
+		}
 		resCh <- SendPfcpResult{
 			Status: smf_context.SessionReleaseSuccess,
 		}
@@ -350,3 +380,289 @@ func deletePfcpSession(upf *smf_context.UPF, ctx *smf_context.SMContext, resCh c
 		}
 	}
 }
+
+// updateAnUpfDownlinkFARs applies update to the downlink FAR of the AN UPF of every
+// activated data path and sends the modified rules to those UPFs
+func updateAnUpfDownlinkFARs(smContext *smf_context.SMContext,
+	update func(upf *smf_context.UPF, far *smf_context.FAR) *smf_context.BAR,
+) []SendPfcpResult {
+	pfcpPool := make(map[string]*PFCPState)
+
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		ANUPF := dataPath.FirstDPNode
+		DLPDR := ANUPF.DownLinkTunnel.PDR
+		if DLPDR == nil {
+			continue
+		}
+
+		bar := update(ANUPF.UPF, DLPDR.FAR)
+		pfcpState := pfcpPool[ANUPF.GetNodeIP()]
+		if pfcpState == nil {
+			pfcpState = &PFCPState{upf: ANUPF.UPF}
+			pfcpPool[ANUPF.GetNodeIP()] = pfcpState
+		}
+		pfcpState.farList = append(pfcpState.farList, DLPDR.FAR)
+		if bar != nil {
+			pfcpState.barList = append(pfcpState.barList, bar)
+		}
+	}
+
+	resChan := make(chan SendPfcpResult)
+	for _, pfcpState := range pfcpPool {
+		go modifyExistingPfcpSession(smContext, pfcpState, resChan)
+	}
+
+	resList := make([]SendPfcpResult, 0, len(pfcpPool))
+	for i := 0; i < len(pfcpPool); i++ {
+		resList = append(resList, <-resChan)
+	}
+
+	return resList
+}
+
+// enforcePCCRules sends the rules of the PCC rules installed and removed since the last enforcement
+// to the UPFs of the default path of the session, and returns the changes to signal to the UE
+func enforcePCCRules(smContext *smf_context.SMContext) *smf_context.PCCRuleChanges {
+	changes := smContext.UpdatePCCRules()
+
+	pfcpPool := make(map[string]*PFCPState)
+	for _, rules := range [][]*smf_context.PCCRule{changes.Installed, changes.Removed} {
+		for _, rule := range rules {
+			for _, ruleUPF := range rule.UPFs {
+				ip := ruleUPF.UPF.NodeID.ResolveNodeIdToIp().String()
+				pfcpState := pfcpPool[ip]
+				if pfcpState == nil {
+					pfcpState = &PFCPState{upf: ruleUPF.UPF}
+					pfcpPool[ip] = pfcpState
+				}
+				pfcpState.pdrList = append(pfcpState.pdrList, ruleUPF.PDRs...)
+				if ruleUPF.QER != nil {
+					pfcpState.qerList = append(pfcpState.qerList, ruleUPF.QER)
+				}
+			}
+		}
+	}
+
+	resChan := make(chan SendPfcpResult)
+	for _, pfcpState := range pfcpPool {
+		go modifyExistingPfcpSession(smContext, pfcpState, resChan)
+	}
+	for i := 0; i < len(pfcpPool); i++ {
+		if res := <-resChan; res.Err != nil {
+			logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] enforcement of the PCC rules failed: %v",
+				smContext.Supi, smContext.PDUSessionID, res.Err)
+		}
+	}
+
+	return changes
+}
+
+// sessionAMBRQERs returns the session AMBR QERs of the activated data paths of the session by
+// UPF. The other QERs of the PDRs and the QERs of the other sessions are left out.
+func sessionAMBRQERs(smContext *smf_context.SMContext) map[string]*PFCPState {
+	pfcpPool := make(map[string]*PFCPState)
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if node.UpLinkTunnel == nil || node.UpLinkTunnel.PDR == nil {
+				continue
+			}
+			for _, qer := range node.UpLinkTunnel.PDR.QER {
+				if !qer.SessionAMBR {
+					continue
+				}
+				pfcpState := pfcpPool[node.GetNodeIP()]
+				if pfcpState == nil {
+					pfcpState = &PFCPState{upf: node.UPF}
+					pfcpPool[node.GetNodeIP()] = pfcpState
+				}
+				pfcpState.qerList = append(pfcpState.qerList, qer)
+			}
+		}
+	}
+	return pfcpPool
+}
+
+// updateSessionQERs applies update to the session AMBR QERs of the activated data paths of the
+// session, which their uplink and downlink PDRs share, and sends the modified rules to the UPFs
+func updateSessionQERs(smContext *smf_context.SMContext, update func(qer *smf_context.QER)) []SendPfcpResult {
+	pfcpPool := sessionAMBRQERs(smContext)
+	for _, pfcpState := range pfcpPool {
+		for _, qer := range pfcpState.qerList {
+			update(qer)
+			qer.State = smf_context.RULE_UPDATE
+		}
+	}
+
+	resChan := make(chan SendPfcpResult)
+	for _, pfcpState := range pfcpPool {
+		go modifyExistingPfcpSession(smContext, pfcpState, resChan)
+	}
+
+	resList := make([]SendPfcpResult, 0, len(pfcpPool))
+	for i := 0; i < len(pfcpPool); i++ {
+		resList = append(resList, <-resChan)
+	}
+
+	return resList
+}
+
+// HandlePagingFailure is called with the SMContext locked when the UE could not be reached
+// for downlink data (TS 23.502 4.2.3.3 step 3c). The buffered data is discarded and the AN
+// UPF drops further downlink packets, so that no DDN is sent until the back-off expires.
+func HandlePagingFailure(smContext *smf_context.SMContext) {
+	logger.PduSessLog.Infof("Paging of UE[%s] PDUSessionID[%d] failed, discard downlink data for %s",
+		smContext.Supi, smContext.PDUSessionID, smf_context.SMF_Self().DDNBackoffTimer)
+
+	smContext.PagingInProgress = false
+	gtpu.Discard(smContext)
+
+	resList := updateAnUpfDownlinkFARs(smContext,
+		func(upf *smf_context.UPF, far *smf_context.FAR) *smf_context.BAR {
+			far.ApplyAction = pfcpType.ApplyAction{Drop: true}
+			far.State = smf_context.RULE_UPDATE
+			return nil
+		})
+	for _, res := range resList {
+		if res.Err != nil {
+			logger.PduSessLog.Warnf("Discard downlink data in UPF failed: %v", res.Err)
+		}
+	}
+
+	smContext.StopDDNBackoff()
+	var timer *time.Timer
+	timer = time.AfterFunc(smf_context.SMF_Self().DDNBackoffTimer, func() {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+
+		// the back-off may have been stopped or restarted meanwhile
+		if smContext.DDNBackoffTimer == timer {
+			smContext.DDNBackoffTimer = nil
+			restartDownlinkBuffering(smContext)
+		}
+	})
+	smContext.DDNBackoffTimer = timer
+}
+
+func restartDownlinkBuffering(smContext *smf_context.SMContext) {
+	if smf_context.GetSMContextByRef(smContext.Ref) == nil ||
+		smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
+		return
+	}
+
+	logger.PduSessLog.Infof("DDN back-off of UE[%s] PDUSessionID[%d] expired, buffer downlink data again",
+		smContext.Supi, smContext.PDUSessionID)
+	resList := updateAnUpfDownlinkFARs(smContext,
+		func(upf *smf_context.UPF, far *smf_context.FAR) *smf_context.BAR {
+			bar, err := smContext.BufferDownlinkFAR(upf, far)
+			if err != nil {
+				logger.PduSessLog.Errorf("Set DL buffering of FAR[%d] failed: %v", far.FARID, err)
+			}
+			return bar
+		})
+	for _, res := range resList {
+		if res.Err != nil {
+			logger.PduSessLog.Warnf("Restart downlink buffering in UPF failed: %v", res.Err)
+		}
+	}
+}
+
+// ThrottleDownlinkDataNotification is called with the SMContext locked when the AMF asks
+// to reduce the DDNs of the session, e.g. while a higher priority paging is ongoing. The
+// DDNs are delayed by retryAfter when the AMF gives it.
+func ThrottleDownlinkDataNotification(smContext *smf_context.SMContext, retryAfter time.Duration) {
+	if smf_context.SMF_Self().SMFBuffering {
+		// DDN is triggered by the SMF itself, the UPF has no BAR to throttle
+		return
+	}
+
+	logger.PduSessLog.Infof("Throttle DDN of UE[%s] PDUSessionID[%d]", smContext.Supi, smContext.PDUSessionID)
+	resList := updateAnUpfDownlinkFARs(smContext,
+		func(upf *smf_context.UPF, far *smf_context.FAR) *smf_context.BAR {
+			return smContext.ThrottleDownlinkDataNotification(far, retryAfter)
+		})
+	for _, res := range resList {
+		if res.Err != nil {
+			logger.PduSessLog.Warnf("Throttle DDN in UPF failed: %v", res.Err)
+		}
+	}
+}
+
+// ReestablishPfcpSession creates the PFCP session of the SMContext again on a restarted UPF,
+// from the PDRs and their FAR/BAR/QER kept in the PFCP session context (TS 23.527 4.3)
+func ReestablishPfcpSession(smContext *smf_context.SMContext, upf *smf_context.UPF) error {
+	pfcpSessionCtx, exist := smContext.PFCPContext[upf.NodeID.ResolveNodeIdToIp().String()]
+	if !exist {
+		return fmt.Errorf("no PFCP session context of UPF[%s]", upf.NodeID.ResolveNodeIdToIp())
+	}
+
+	pdrList := make([]*smf_context.PDR, 0, len(pfcpSessionCtx.PDRs))
+	farList := make([]*smf_context.FAR, 0, len(pfcpSessionCtx.PDRs))
+	barList := make([]*smf_context.BAR, 0)
+	qerMap := make(map[uint32]*smf_context.QER)
+	// the PDRs of the PCC rules share the FARs of the default path
+	listedFARs := make(map[uint32]bool)
+	for _, pdr := range pfcpSessionCtx.PDRs {
+		pdr.State = smf_context.RULE_INITIAL
+		pdrList = append(pdrList, pdr)
+		if far := pdr.FAR; far != nil && !listedFARs[far.FARID] {
+			listedFARs[far.FARID] = true
+			far.State = smf_context.RULE_INITIAL
+			farList = append(farList, far)
+			if bar := far.BAR; bar != nil {
+				bar.State = smf_context.RULE_INITIAL
+				barList = append(barList, bar)
+			}
+		}
+		for _, qer := range pdr.QER {
+			qer.State = smf_context.RULE_INITIAL
+			qerMap[qer.QERID] = qer
+		}
+		// the usage measured by the UPF before it restarted is lost, the measurement starts over
+		if urr := pdr.URR; urr != nil {
+			urr.State = smf_context.RULE_INITIAL
+		}
+	}
+	qerList := make([]*smf_context.QER, 0, len(qerMap))
+	for _, qer := range qerMap {
+		qerList = append(qerList, qer)
+	}
+
+	pfcpSessionCtx.RemoteSEID = 0
+	rcvMsg, err := pfcp_message.SendPfcpSessionEstablishmentRequest(
+		upf, smContext, pdrList, farList, barList, qerList)
+	if err != nil {
+		return err
+	}
+
+	rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPSessionEstablishmentResponse)
+	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		return fmt.Errorf("PFCP Session Establishment not accepted")
+	}
+	if rsp.UPFSEID != nil {
+		pfcpSessionCtx.RemoteSEID = rsp.UPFSEID.Seid
+	}
+	setUPFFQCSID(pfcpSessionCtx, &rsp)
+	smf_context.StoreSMContext(smContext)
+
+	return nil
+}
+
+// setUPFFQCSID keeps the FQ-CSID the UPF assigned to the PFCP session, so that the session
+// can be found when the UPF requests to delete the set of sessions after a partial failure
+func setUPFFQCSID(pfcpSessionCtx *smf_context.PFCPSessionContext, rsp *pfcp.PFCPSessionEstablishmentResponse) {
+	if rsp.PGWUFQCSID == nil {
+		return
+	}
+	fqcsid, err := smf_context.ParseFQCSID(rsp.PGWUFQCSID)
+	if err != nil {
+		logger.PduSessLog.Warnf("Invalid PGW-U/UPF FQ-CSID: %v", err)
+		return
+	}
+	pfcpSessionCtx.UPFFQCSID = fqcsid
+}
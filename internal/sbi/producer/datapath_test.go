@@ -0,0 +1,114 @@
+package producer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+// newPagingSMContext returns an idle SM context whose downlink data is buffered by the SMF, with
+// the DDN back-off set to backoff. Its UPF is not associated, so the FAR updates are not sent.
+func newPagingSMContext(t *testing.T, backoff time.Duration) (*smf_context.SMContext, *smf_context.FAR) {
+	smfSelf := smf_context.SMF_Self()
+	smfBuffering, gtpuAddr, ddnBackoffTimer := smfSelf.SMFBuffering, smfSelf.BufferingGtpuAddr, smfSelf.DDNBackoffTimer
+	t.Cleanup(func() {
+		smfSelf.SMFBuffering, smfSelf.BufferingGtpuAddr, smfSelf.DDNBackoffTimer = smfBuffering, gtpuAddr, ddnBackoffTimer
+	})
+	smfSelf.SMFBuffering = true
+	smfSelf.BufferingGtpuAddr = &net.UDPAddr{IP: net.ParseIP("10.4.0.1"), Port: smf_context.GtpuPort}
+	smfSelf.DDNBackoffTimer = backoff
+
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+	smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+	t.Cleanup(func() {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		smContext.StopDDNBackoff()
+	})
+
+	node := smf_context.NewDataPathNode()
+	node.UPF = &smf_context.UPF{
+		NodeID: pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: net.ParseIP("10.4.0.2").To4()},
+	}
+	far := &smf_context.FAR{FARID: 2}
+	node.DownLinkTunnel.PDR = &smf_context.PDR{PDRID: 2, FAR: far}
+	dataPath := smf_context.NewDataPath()
+	dataPath.Activated = true
+	dataPath.FirstDPNode = node
+	smContext.Tunnel.AddDataPath(dataPath)
+
+	_, err := smContext.BufferDownlinkFAR(node.UPF, far)
+	require.NoError(t, err)
+	return smContext, far
+}
+
+func TestHandlePagingFailure(t *testing.T) {
+	smContext, far := newPagingSMContext(t, 100*time.Millisecond)
+	smContext.PagingInProgress = true
+
+	smContext.SMLock.Lock()
+	HandlePagingFailure(smContext)
+	smContext.SMLock.Unlock()
+
+	// the downlink data is dropped during the back-off
+	require.False(t, smContext.PagingInProgress)
+	require.NotNil(t, smContext.DDNBackoffTimer)
+	require.Equal(t, pfcpType.ApplyAction{Drop: true}, far.ApplyAction)
+	require.Equal(t, smf_context.RULE_UPDATE, far.State)
+
+	// then buffered again
+	require.Eventually(t, func() bool {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		return smContext.DDNBackoffTimer == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	require.Equal(t, pfcpType.ApplyAction{Forw: true}, far.ApplyAction)
+	require.Equal(t, pfcpType.DestinationInterfaceCpFunction,
+		far.ForwardingParameters.DestinationInterface.InterfaceValue)
+	require.Equal(t, smContext.BufferingTEID, far.ForwardingParameters.OuterHeaderCreation.Teid)
+}
+
+func TestDDNBackoffStopped(t *testing.T) {
+	testCases := []struct {
+		name string
+		stop func(smContext *smf_context.SMContext)
+	}{
+		{
+			name: "back-off stopped",
+			stop: func(smContext *smf_context.SMContext) { smContext.StopDDNBackoff() },
+		},
+		{
+			name: "user plane activated",
+			stop: func(smContext *smf_context.SMContext) { smContext.UpCnxState = models.UpCnxState_ACTIVATED },
+		},
+		{
+			name: "session released",
+			stop: func(smContext *smf_context.SMContext) { smf_context.RemoveSMContext(smContext.Ref) },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext, far := newPagingSMContext(t, 50*time.Millisecond)
+
+			smContext.SMLock.Lock()
+			HandlePagingFailure(smContext)
+			tc.stop(smContext)
+			smContext.SMLock.Unlock()
+
+			// the downlink FAR is left to the procedure which stopped the back-off
+			time.Sleep(200 * time.Millisecond)
+			smContext.SMLock.Lock()
+			defer smContext.SMLock.Unlock()
+			require.Equal(t, pfcpType.ApplyAction{Drop: true}, far.ApplyAction)
+		})
+	}
+}
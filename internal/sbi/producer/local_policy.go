@@ -0,0 +1,114 @@
+package producer
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/sbi/consumer"
+)
+
+// localPolicyApplies reports whether the session falls back to the local policy of its DNN after
+// the SM policy association failed, which is when no PCF answered or the PCF itself failed
+func localPolicyApplies(smContext *smf_context.SMContext, problemDetails models.ProblemDetails) bool {
+	if smContext.DNNInfo == nil || smContext.DNNInfo.LocalPolicy == nil {
+		return false
+	}
+	return problemDetails.Cause != "USER_UNKNOWN" &&
+		(problemDetails.Status == 0 || problemDetails.Status >= http.StatusInternalServerError)
+}
+
+// RunSMPolicyReconciliation retries the SM policy associations of the sessions on a local policy
+// every SBIPolicyRetryInterval until ctx is done
+func RunSMPolicyReconciliation(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(smf_context.SMF_Self().SBIPolicyRetryInterval):
+		}
+
+		for _, smContext := range smf_context.ListSMContexts() {
+			if ctx.Err() != nil {
+				return
+			}
+			reconcileSMPolicy(smContext)
+		}
+	}
+}
+
+// reconcileSMPolicy creates the SM policy association of a session on a local policy, and
+// replaces the rules of the local policy with the decision of the PCF once it answers
+func reconcileSMPolicy(smContext *smf_context.SMContext) {
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if !smContext.OnLocalPolicy || smf_context.GetSMContextByRef(smContext.Ref) == nil {
+		return
+	}
+	// sessions being modified or released are tried again at the next interval
+	if smContext.SMContextState != smf_context.Active {
+		return
+	}
+
+	if smContext.SMPolicyClient == nil {
+		if err := smContext.PCFSelection(); err != nil {
+			logger.PduSessLog.Debugf("UE[%s] PDUSessionID[%d] pcf selection error: %v",
+				smContext.Supi, smContext.PDUSessionID, err)
+			return
+		}
+	}
+	smPolicyID, decision, err := consumer.SendSMPolicyAssociationCreate(smContext)
+	if err != nil {
+		logger.PduSessLog.Debugf("UE[%s] PDUSessionID[%d] setup sm policy association failed, "+
+			"keep the local policy: %v", smContext.Supi, smContext.PDUSessionID, err)
+		return
+	}
+	logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] sm policy association created, the PCF replaces the local policy",
+		smContext.Supi, smContext.PDUSessionID)
+	smContext.SMPolicyID = smPolicyID
+	smContext.OnLocalPolicy = false
+
+	var oldAmbr *models.Ambr
+	if sessionRule := smContext.SelectedSessionRule(); sessionRule != nil {
+		oldAmbr = sessionRule.AuthSessAmbr
+	}
+	// the rules of the local policy the PCF did not decide are removed
+	if decision.SessRules == nil {
+		decision.SessRules = make(map[string]*models.SessionRule)
+	}
+	for id := range smContext.SessionRules {
+		if _, exist := decision.SessRules[id]; !exist {
+			decision.SessRules[id] = nil
+		}
+	}
+	if decision.PccRules == nil {
+		decision.PccRules = make(map[string]*models.PccRule)
+	}
+	for id := range smContext.PCCRules {
+		if _, exist := decision.PccRules[id]; !exist {
+			decision.PccRules[id] = nil
+		}
+	}
+	if err := ApplySmPolicyFromDecision(smContext, decision); err != nil {
+		logger.PduSessLog.Errorf("apply sm policy decision error: %+v", err)
+	}
+	changes := enforcePCCRules(smContext)
+	smContext.SMContextState = smf_context.Active
+	logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+
+	if sessionRule := smContext.SelectedSessionRule(); sessionRule != nil && sessionRule.AuthSessAmbr != nil &&
+		(oldAmbr == nil || *sessionRule.AuthSessAmbr != *oldAmbr) {
+		modifySessionAMBR(smContext, *sessionRule.AuthSessAmbr, changes)
+	} else if !changes.Empty() {
+		sendPDUSessionModificationCommand(smContext, changes)
+	}
+	smf_context.StoreSMContext(smContext)
+}
@@ -0,0 +1,102 @@
+package producer
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/Npcf_SMPolicyControl"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+const testPCFURI = "http://127.0.0.7:8000"
+
+// newTestPCF answers the SM policy association requests of the session with the status and body
+func newTestPCF(t *testing.T, smContext *smf_context.SMContext, status int, body interface{}) {
+	openapi.InterceptH2CClient()
+	t.Cleanup(func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+	})
+	gock.New(testPCFURI).Persist().
+		Post("/npcf-smpolicycontrol/v1/sm-policies").
+		Reply(status).
+		SetHeader("Location", testPCFURI+"/npcf-smpolicycontrol/v1/sm-policies/policy-1").
+		JSON(body)
+
+	configuration := Npcf_SMPolicyControl.NewConfiguration()
+	configuration.SetBasePath(testPCFURI)
+	smContext.SetSMPolicyConf(configuration)
+}
+
+// newLocalPolicySMContext returns an active SM context of a DNN with a local policy
+func newLocalPolicySMContext(t *testing.T) *smf_context.SMContext {
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+	smContext.ServingNetwork = &models.PlmnId{Mcc: "208", Mnc: "93"}
+	smContext.DNNInfo = &smf_context.SnssaiSmfDnnInfo{
+		LocalPolicy: smf_context.NewLocalPolicy(&factory.LocalPolicy{
+			Var5qi: 9,
+			Arp: &models.Arp{
+				PriorityLevel: 8,
+				PreemptCap:    models.PreemptionCapability_NOT_PREEMPT,
+				PreemptVuln:   models.PreemptionVulnerability_PREEMPTABLE,
+			},
+			SessionAmbr: &models.Ambr{Uplink: "10 Mbps", Downlink: "20 Mbps"},
+		}),
+	}
+	return smContext
+}
+
+func TestSetupSMPolicyAssociationFallback(t *testing.T) {
+	smContext := newLocalPolicySMContext(t)
+	newTestPCF(t, smContext, http.StatusInternalServerError, models.ProblemDetails{Status: 500})
+
+	decision, _, problemDetails := setupSMPolicyAssociation(smContext)
+	require.Nil(t, problemDetails)
+	require.True(t, smContext.OnLocalPolicy)
+	require.Equal(t, "10 Mbps", decision.SessRules[smf_context.LocalSessionRuleID].AuthSessAmbr.Uplink)
+}
+
+func TestSetupSMPolicyAssociationUserUnknown(t *testing.T) {
+	smContext := newLocalPolicySMContext(t)
+	newTestPCF(t, smContext, http.StatusBadRequest, models.ProblemDetails{Status: 400, Cause: "USER_UNKNOWN"})
+
+	decision, _, problemDetails := setupSMPolicyAssociation(smContext)
+	require.Nil(t, decision)
+	require.NotNil(t, problemDetails)
+	require.False(t, smContext.OnLocalPolicy)
+}
+
+func TestReconcileSMPolicy(t *testing.T) {
+	smContext := newLocalPolicySMContext(t)
+	newTestPCF(t, smContext, http.StatusInternalServerError, models.ProblemDetails{Status: 500})
+	decision, _, _ := setupSMPolicyAssociation(smContext)
+	smContext.SessionRules = make(map[string]*smf_context.SessionRule)
+	require.NoError(t, ApplySmPolicyFromDecision(smContext, decision))
+	smContext.SMContextState = smf_context.Active
+
+	// the PCF still fails, the session keeps the local policy
+	reconcileSMPolicy(smContext)
+	require.True(t, smContext.OnLocalPolicy)
+	require.Equal(t, smf_context.LocalSessionRuleID, smContext.SelectedSessionRule().SessionRuleID)
+
+	pcfAmbr := models.Ambr{Uplink: "200 Mbps", Downlink: "400 Mbps"}
+	gock.Flush()
+	newTestPCF(t, smContext, http.StatusCreated, models.SmPolicyDecision{
+		SessRules: map[string]*models.SessionRule{
+			"pcf-rule": {SessRuleId: "pcf-rule", AuthSessAmbr: &pcfAmbr},
+		},
+	})
+	reconcileSMPolicy(smContext)
+	require.False(t, smContext.OnLocalPolicy)
+	require.Equal(t, "policy-1", smContext.SMPolicyID)
+	require.NotContains(t, smContext.SessionRules, smf_context.LocalSessionRuleID)
+	require.Equal(t, "pcf-rule", smContext.SelectedSessionRule().SessionRuleID)
+	require.Equal(t, pcfAmbr, *smContext.SelectedSessionRule().AuthSessAmbr)
+	require.Equal(t, smf_context.Active, smContext.SMContextState)
+}
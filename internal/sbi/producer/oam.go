@@ -1,56 +1,495 @@
 package producer
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/util/httpwrapper"
 )
 
+const (
+	defaultPDUSessionLimit = 100
+	maxPDUSessionLimit     = 1000
+)
+
 type PDUSessionInfo struct {
+	Ref          string
 	Supi         string
+	Gpsi         string
 	PDUSessionID string
 	Dnn          string
 	Sst          string
 	Sd           string
 	AnType       models.AccessType
 	PDUAddress   string
+	FramedRoutes []string `json:",omitempty"`
+	State        string
 	SessionRule  models.SessionRule
 	UpCnxState   models.UpCnxState
-	Tunnel       context.UPTunnel
+	Tunnel       *TunnelInfo
+}
+
+// TunnelInfo is the user plane of the PDU session
+type TunnelInfo struct {
+	ANIPAddress string
+	ANTEID      uint32
+	DataPaths   []DataPathInfo
+}
+
+type DataPathInfo struct {
+	PathID        int64
+	Activated     bool
+	IsDefaultPath bool
+	Destination   context.Destination
+	Nodes         []DataPathNodeInfo
+}
+
+// DataPathNodeInfo is a UPF of the data path, from the AN to the DN
+type DataPathNodeInfo struct {
+	UPF        string
+	NodeID     string
+	LocalSEID  uint64
+	RemoteSEID uint64
+	UpLink     *GTPTunnelInfo
+	DownLink   *GTPTunnelInfo
+}
+
+// GTPTunnelInfo is the TEID of a tunnel and the IDs of the PFCP rules applied to it
+type GTPTunnelInfo struct {
+	TEID   uint32
+	PDRID  uint16
+	FARID  uint32
+	QERIDs []uint32
+}
+
+// PDUSessionQuery filters the PDU sessions, empty fields match any session. Snssai is the
+// SST, or the SST and SD separated by "-", e.g. "1-010203".
+type PDUSessionQuery struct {
+	Supi   string `form:"supi" json:"supi,omitempty"`
+	Gpsi   string `form:"gpsi" json:"gpsi,omitempty"`
+	Dnn    string `form:"dnn" json:"dnn,omitempty"`
+	Snssai string `form:"snssai" json:"snssai,omitempty"`
+	UeIP   string `form:"ueIp" json:"ueIp,omitempty"`
+	Upf    string `form:"upf" json:"upf,omitempty"`
+	State  string `form:"state" json:"state,omitempty"`
+	AnType string `form:"anType" json:"anType,omitempty"`
+	Offset int    `form:"offset" json:"offset,omitempty"`
+	Limit  int    `form:"limit" json:"limit,omitempty"`
+}
+
+// PDUSessionList is a page of the PDU sessions matching a query
+type PDUSessionList struct {
+	Total       int
+	Offset      int
+	Limit       int
+	PDUSessions []PDUSessionInfo
+}
+
+// InvalidPDUSessionQuery is the problem of a query of the PDU sessions which is malformed or
+// not valid, whether it is in the URI or in the body of the request
+func InvalidPDUSessionQuery(detail string) *models.ProblemDetails {
+	return &models.ProblemDetails{
+		Title:  "Invalid PDU session query",
+		Status: http.StatusBadRequest,
+		Cause:  "INVALID_QUERY_PARAM",
+		Detail: detail,
+	}
 }
 
 func HandleOAMGetUEPDUSessionInfo(smContextRef string) *httpwrapper.Response {
 	smContext := context.GetSMContextByRef(smContextRef)
 	if smContext == nil {
-		httpResponse := &httpwrapper.Response{
-			Header: nil,
+		problemDetails := &models.ProblemDetails{
 			Status: http.StatusNotFound,
-			Body:   nil,
+			Cause:  "CONTEXT_NOT_FOUND",
+		}
+		return httpwrapper.NewResponse(http.StatusNotFound, nil, problemDetails)
+	}
+
+	return httpwrapper.NewResponse(http.StatusOK, nil, pduSessionInfos([]*context.SMContext{smContext})[0])
+}
+
+func HandleOAMGetPDUSessions(query *PDUSessionQuery) *httpwrapper.Response {
+	smContexts, problemDetails := QueryPDUSessions(query)
+	if problemDetails != nil {
+		return httpwrapper.NewResponse(int(problemDetails.Status), nil, problemDetails)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultPDUSessionLimit
+	} else if limit > maxPDUSessionLimit {
+		limit = maxPDUSessionLimit
+	}
+
+	list := PDUSessionList{
+		Total:  len(smContexts),
+		Offset: query.Offset,
+		Limit:  limit,
+	}
+	page := smContexts[:0]
+	if query.Offset < len(smContexts) {
+		page = smContexts[query.Offset:]
+		if len(page) > limit {
+			page = page[:limit]
 		}
+	}
+	list.PDUSessions = pduSessionInfos(page)
+	return httpwrapper.NewResponse(http.StatusOK, nil, list)
+}
 
-		return httpResponse
+// QueryPDUSessions returns the SMContexts matching the query, ordered by SUPI and PDU session ID
+func QueryPDUSessions(query *PDUSessionQuery) ([]*context.SMContext, *models.ProblemDetails) {
+	matcher, problemDetails := newPDUSessionMatcher(query)
+	if problemDetails != nil {
+		return nil, problemDetails
 	}
 
+	smContexts := make([]*context.SMContext, 0)
+	for _, smContext := range context.ListSMContexts() {
+		smContext.SMLock.Lock()
+		matched := matcher.match(smContext)
+		smContext.SMLock.Unlock()
+		if matched {
+			smContexts = append(smContexts, smContext)
+		}
+	}
+	return smContexts, nil
+}
+
+// ReleasePDUSessionsRequest selects the PDU sessions to release by exactly one of
+// SmContextRef, Supi, Dnn or Upf
+type ReleasePDUSessionsRequest struct {
+	SmContextRef string `json:"smContextRef,omitempty"`
+	Supi         string `json:"supi,omitempty"`
+	Dnn          string `json:"dnn,omitempty"`
+	Upf          string `json:"upf,omitempty"`
+	// 5GSM cause of the PDU Session Release Command, regular deactivation by default
+	Cause *uint8 `json:"cause,omitempty"`
+	// back-off timer in seconds, during which the UEs do not re-establish the PDU sessions
+	BackoffTimer *int `json:"backoffTimer,omitempty"`
+}
+
+// ReleasedPDUSessions is the number of PDU sessions whose release was started
+type ReleasedPDUSessions struct {
+	Sessions int `json:"sessions"`
+}
+
+// longest back-off timer encoded by a GPRS timer 3, TS 24.008 10.5.7.4a
+const maxBackoffTimer = 31 * 10 * time.Hour
+
+// the 5GSM cause values of TS 24.501 9.11.4.2, those missing in nasMessage by their value
+var valid5GSMCauses = map[uint8]bool{
+	8: true, // operator determined barring
+	nasMessage.Cause5GSMInsufficientResources:                   true,
+	nasMessage.Cause5GSMMissingOrUnknownDNN:                     true,
+	nasMessage.Cause5GSMUnknownPDUSessionType:                   true,
+	nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed: true,
+	nasMessage.Cause5GSMRequestRejectedUnspecified:              true,
+	32: true, // service option not supported
+	33: true, // requested service option not subscribed
+	nasMessage.Cause5GSMServiceOptionTemporarilyOutOfOrder: true,
+	nasMessage.Cause5GSMPTIAlreadyInUse:                    true,
+	nasMessage.Cause5GSMRegularDeactivation:                true,
+	37:                                                     true, // 5GS QoS not accepted
+	nasMessage.Cause5GSMNetworkFailure:                     true,
+	nasMessage.Cause5GSMReactivationRequested:              true,
+	41: true, // semantic error in the TFT operation
+	42: true, // syntactical error in the TFT operation
+	nasMessage.Cause5GSMInvalidPDUSessionIdentity:      true,
+	nasMessage.Cause5GSMSemanticErrorsInPacketFilter:   true,
+	nasMessage.Cause5GSMSyntacticalErrorInPacketFilter: true,
+	nasMessage.Cause5GSMOutOfLADNServiceArea:           true,
+	nasMessage.Cause5GSMPTIMismatch:                    true,
+	nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed:  true,
+	nasMessage.Cause5GSMPDUSessionTypeIPv6OnlyAllowed:  true,
+	nasMessage.Cause5GSMPDUSessionDoesNotExist:         true,
+	57: true, // PDU session type IPv4v6 only allowed
+	58: true, // PDU session type Unstructured only allowed
+	59: true, // unsupported 5QI value
+	61: true, // PDU session type Ethernet only allowed
+	nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN:                 true,
+	nasMessage.Cause5GSMNotSupportedSSCMode:                                         true,
+	nasMessage.Cause5GSMInsufficientResourcesForSpecificSlice:                       true,
+	nasMessage.Cause5GSMMissingOrUnknownDNNInASlice:                                 true,
+	nasMessage.Cause5GSMInvalidPTIValue:                                             true,
+	nasMessage.Cause5GSMMaximumDataRatePerUEForUserPlaneIntegrityProtectionIsTooLow: true,
+	nasMessage.Cause5GSMSemanticErrorInTheQoSOperation:                              true,
+	nasMessage.Cause5GSMSyntacticalErrorInTheQoSOperation:                           true,
+	nasMessage.Cause5GSMInvalidMappedEPSBearerIdentity:                              true,
+	nasMessage.Cause5GSMSemanticallyIncorrectMessage:                                true,
+	nasMessage.Cause5GSMInvalidMandatoryInformation:                                 true,
+	nasMessage.Cause5GSMMessageTypeNonExistentOrNotImplemented:                      true,
+	nasMessage.Cause5GSMMessageTypeNotCompatibleWithTheProtocolState:                true,
+	nasMessage.Cause5GSMInformationElementNonExistentOrNotImplemented:               true,
+	nasMessage.Cause5GSMConditionalIEError:                                          true,
+	nasMessage.Cause5GSMMessageNotCompatibleWithTheProtocolState:                    true,
+	nasMessage.Cause5GSMProtocolErrorUnspecified:                                    true,
+}
+
+// HandleOAMReleasePDUSessions starts the network-requested release of the selected PDU sessions,
+// with the 5GSM cause and the back-off timer of the request
+func HandleOAMReleasePDUSessions(request *ReleasePDUSessionsRequest) *httpwrapper.Response {
+	invalid := func(detail string) *httpwrapper.Response {
+		problemDetails := &models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Cause:  "INVALID_MSG_FORMAT",
+			Detail: detail,
+		}
+		return httpwrapper.NewResponse(http.StatusBadRequest, nil, problemDetails)
+	}
+	selectors := 0
+	for _, selector := range []string{request.SmContextRef, request.Supi, request.Dnn, request.Upf} {
+		if selector != "" {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return invalid("exactly one of smContextRef, supi, dnn or upf is required")
+	}
+	cause := nasMessage.Cause5GSMRegularDeactivation
+	if request.Cause != nil {
+		if !valid5GSMCauses[*request.Cause] {
+			return invalid(fmt.Sprintf("invalid 5GSM cause %d", *request.Cause))
+		}
+		cause = *request.Cause
+	}
+	var backoffTimer time.Duration
+	if request.BackoffTimer != nil {
+		backoffTimer = time.Duration(*request.BackoffTimer) * time.Second
+		if backoffTimer < 0 || backoffTimer > maxBackoffTimer {
+			return invalid("backoffTimer is out of range")
+		}
+	}
+
+	var smContexts []*context.SMContext
+	if request.SmContextRef != "" {
+		if smContext := context.GetSMContextByRef(request.SmContextRef); smContext != nil {
+			smContexts = append(smContexts, smContext)
+		}
+	} else {
+		var problemDetails *models.ProblemDetails
+		smContexts, problemDetails = QueryPDUSessions(&PDUSessionQuery{
+			Supi: request.Supi,
+			Dnn:  request.Dnn,
+			Upf:  request.Upf,
+		})
+		if problemDetails != nil {
+			return httpwrapper.NewResponse(int(problemDetails.Status), nil, problemDetails)
+		}
+	}
+	if len(smContexts) == 0 {
+		problemDetails := &models.ProblemDetails{
+			Status: http.StatusNotFound,
+			Cause:  "CONTEXT_NOT_FOUND",
+		}
+		return httpwrapper.NewResponse(http.StatusNotFound, nil, problemDetails)
+	}
+
+	logger.PduSessLog.Infof("OAM requested to release %d PDU sessions with cause %d", len(smContexts), cause)
+	go func(release func(*context.SMContext, uint8, time.Duration)) {
+		for _, smContext := range smContexts {
+			release(smContext, cause, backoffTimer)
+		}
+	}(ReleasePDUSessionHandler)
+	return httpwrapper.NewResponse(http.StatusAccepted, nil, ReleasedPDUSessions{Sessions: len(smContexts)})
+}
+
+func HandleOAMGetUPFRestorations() *httpwrapper.Response {
 	httpResponse := &httpwrapper.Response{
 		Header: nil,
 		Status: http.StatusOK,
-		Body: PDUSessionInfo{
-			Supi:         smContext.Supi,
-			PDUSessionID: strconv.Itoa(int(smContext.PDUSessionID)),
-			Dnn:          smContext.Dnn,
-			Sst:          strconv.Itoa(int(smContext.Snssai.Sst)),
-			Sd:           smContext.Snssai.Sd,
-			AnType:       smContext.AnType,
-			PDUAddress:   smContext.PDUAddress.String(),
-			UpCnxState:   smContext.UpCnxState,
-			// Tunnel: context.UPTunnel{
-			// 	//UpfRoot:  smContext.Tunnel.UpfRoot,
-			// 	ULCLRoot: smContext.Tunnel.UpfRoot,
-			// },
-		},
+		Body:   context.GetUPFRestorations(),
 	}
 	return httpResponse
 }
+
+type pduSessionMatcher struct {
+	query  *PDUSessionQuery
+	snssai *models.Snssai
+	ueIP   net.IP
+	upfIP  string
+}
+
+func newPDUSessionMatcher(query *PDUSessionQuery) (*pduSessionMatcher, *models.ProblemDetails) {
+	if query.Offset < 0 {
+		return nil, InvalidPDUSessionQuery("offset must not be negative")
+	}
+	matcher := &pduSessionMatcher{query: query}
+	if query.Snssai != "" {
+		snssai, err := parseSnssai(query.Snssai)
+		if err != nil {
+			return nil, InvalidPDUSessionQuery("invalid snssai " + query.Snssai)
+		}
+		matcher.snssai = snssai
+	}
+	if query.UeIP != "" {
+		if matcher.ueIP = net.ParseIP(query.UeIP); matcher.ueIP == nil {
+			return nil, InvalidPDUSessionQuery("invalid ueIp " + query.UeIP)
+		}
+	}
+	if query.Upf != "" {
+		upi := context.GetUserPlaneInformation()
+		upi.Mu.RLock()
+		upNode, ok := upi.UPFs[query.Upf]
+		upi.Mu.RUnlock()
+		if !ok {
+			return nil, InvalidPDUSessionQuery("unknown upf " + query.Upf)
+		}
+		matcher.upfIP = upNode.NodeID.ResolveNodeIdToIp().String()
+	}
+	return matcher, nil
+}
+
+// parseSnssai parses the SST, or the SST and SD separated by "-"
+func parseSnssai(snssai string) (*models.Snssai, error) {
+	sstSd := strings.SplitN(snssai, "-", 2)
+	sst, err := strconv.Atoi(sstSd[0])
+	if err != nil {
+		return nil, err
+	}
+	parsed := &models.Snssai{Sst: int32(sst)}
+	if len(sstSd) == 2 {
+		parsed.Sd = sstSd[1]
+	}
+	return parsed, nil
+}
+
+// match reports whether the session matches the query, with the SMLock of the session held
+func (m *pduSessionMatcher) match(smContext *context.SMContext) bool {
+	query := m.query
+	switch {
+	case query.Supi != "" && query.Supi != smContext.Supi,
+		query.Gpsi != "" && query.Gpsi != smContext.Gpsi,
+		query.Dnn != "" && query.Dnn != smContext.Dnn,
+		query.State != "" && !strings.EqualFold(query.State, smContext.SMContextState.String()),
+		query.AnType != "" && !strings.EqualFold(query.AnType, string(smContext.AnType)),
+		m.ueIP != nil && !m.ueIP.Equal(smContext.PDUAddress):
+		return false
+	}
+	if m.snssai != nil {
+		if smContext.Snssai == nil || smContext.Snssai.Sst != m.snssai.Sst ||
+			(m.snssai.Sd != "" && !strings.EqualFold(smContext.Snssai.Sd, m.snssai.Sd)) {
+			return false
+		}
+	}
+	if m.upfIP != "" {
+		// the UPF is on the data path of the session, whether it is the anchor or not
+		if _, exist := smContext.PFCPContext[m.upfIP]; !exist {
+			return false
+		}
+	}
+	return true
+}
+
+// pduSessionInfos returns the information of the sessions, each read under its SMLock
+func pduSessionInfos(smContexts []*context.SMContext) []PDUSessionInfo {
+	upi := context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	infos := make([]PDUSessionInfo, 0, len(smContexts))
+	for _, smContext := range smContexts {
+		smContext.SMLock.Lock()
+		infos = append(infos, pduSessionInfo(smContext))
+		smContext.SMLock.Unlock()
+	}
+	return infos
+}
+
+func pduSessionInfo(smContext *context.SMContext) PDUSessionInfo {
+	info := PDUSessionInfo{
+		Ref:          smContext.Ref,
+		Supi:         smContext.Supi,
+		Gpsi:         smContext.Gpsi,
+		PDUSessionID: strconv.Itoa(int(smContext.PDUSessionID)),
+		Dnn:          smContext.Dnn,
+		AnType:       smContext.AnType,
+		PDUAddress:   smContext.PDUAddress.String(),
+		FramedRoutes: smContext.FramedRoutes,
+		State:        smContext.SMContextState.String(),
+		UpCnxState:   smContext.UpCnxState,
+	}
+	if smContext.Snssai != nil {
+		info.Sst = strconv.Itoa(int(smContext.Snssai.Sst))
+		info.Sd = smContext.Snssai.Sd
+	}
+	if sessionRule := smContext.SelectedSessionRule(); sessionRule != nil {
+		info.SessionRule = models.SessionRule{
+			SessRuleId:   sessionRule.SessionRuleID,
+			AuthSessAmbr: sessionRule.AuthSessAmbr,
+			AuthDefQos:   sessionRule.AuthDefQos,
+		}
+	}
+	if smContext.Tunnel != nil {
+		info.Tunnel = tunnelInfo(smContext)
+	}
+	return info
+}
+
+func tunnelInfo(smContext *context.SMContext) *TunnelInfo {
+	tunnel := smContext.Tunnel
+	info := &TunnelInfo{
+		ANTEID:    tunnel.ANInformation.TEID,
+		DataPaths: make([]DataPathInfo, 0, len(tunnel.DataPathPool)),
+	}
+	if tunnel.ANInformation.IPAddress != nil {
+		info.ANIPAddress = tunnel.ANInformation.IPAddress.String()
+	}
+
+	upi := context.GetUserPlaneInformation()
+	for pathID, dataPath := range tunnel.DataPathPool {
+		pathInfo := DataPathInfo{
+			PathID:        pathID,
+			Activated:     dataPath.Activated,
+			IsDefaultPath: dataPath.IsDefaultPath,
+			Destination:   dataPath.Destination,
+			Nodes:         make([]DataPathNodeInfo, 0),
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			nodeIP := node.UPF.NodeID.ResolveNodeIdToIp().String()
+			nodeInfo := DataPathNodeInfo{
+				UPF:      upi.GetUPFNameByIp(nodeIP),
+				NodeID:   nodeIP,
+				UpLink:   gtpTunnelInfo(node.UpLinkTunnel),
+				DownLink: gtpTunnelInfo(node.DownLinkTunnel),
+			}
+			if pfcpSessionContext, exist := smContext.PFCPContext[nodeIP]; exist {
+				nodeInfo.LocalSEID = pfcpSessionContext.LocalSEID
+				nodeInfo.RemoteSEID = pfcpSessionContext.RemoteSEID
+			}
+			pathInfo.Nodes = append(pathInfo.Nodes, nodeInfo)
+		}
+		info.DataPaths = append(info.DataPaths, pathInfo)
+	}
+	sort.Slice(info.DataPaths, func(i, j int) bool {
+		return info.DataPaths[i].PathID < info.DataPaths[j].PathID
+	})
+	return info
+}
+
+func gtpTunnelInfo(tunnel *context.GTPTunnel) *GTPTunnelInfo {
+	if tunnel == nil {
+		return nil
+	}
+	info := &GTPTunnelInfo{TEID: tunnel.TEID}
+	if pdr := tunnel.PDR; pdr != nil {
+		info.PDRID = pdr.PDRID
+		if pdr.FAR != nil {
+			info.FARID = pdr.FAR.FARID
+		}
+		for _, qer := range pdr.QER {
+			info.QERIDs = append(info.QERIDs, qer.QERID)
+		}
+	}
+	return info
+}
@@ -0,0 +1,167 @@
+package producer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestHandleOAMGetPDUSessions(t *testing.T) {
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+	for i := 0; i < 5; i++ {
+		smContext.Tunnel.AddDataPath(smf_context.NewDataPath())
+	}
+
+	rsp := HandleOAMGetPDUSessions(&PDUSessionQuery{Dnn: "internet"})
+	require.Equal(t, http.StatusOK, rsp.Status)
+	list := rsp.Body.(PDUSessionList)
+	require.Equal(t, 1, list.Total)
+	require.Len(t, list.PDUSessions, 1)
+	require.Equal(t, smContext.Ref, list.PDUSessions[0].Ref)
+	pathIDs := make([]int64, 0)
+	for _, dataPath := range list.PDUSessions[0].Tunnel.DataPaths {
+		pathIDs = append(pathIDs, dataPath.PathID)
+	}
+	require.Equal(t, []int64{1, 2, 3, 4, 5}, pathIDs)
+
+	rsp = HandleOAMGetPDUSessions(&PDUSessionQuery{Dnn: "ims"})
+	require.Equal(t, 0, rsp.Body.(PDUSessionList).Total)
+	require.Empty(t, rsp.Body.(PDUSessionList).PDUSessions)
+
+	rsp = HandleOAMGetPDUSessions(&PDUSessionQuery{Supi: smContext.Supi, Offset: 1})
+	require.Equal(t, 1, rsp.Body.(PDUSessionList).Total)
+	require.Empty(t, rsp.Body.(PDUSessionList).PDUSessions)
+
+	rsp = HandleOAMGetPDUSessions(&PDUSessionQuery{Snssai: "x"})
+	require.Equal(t, http.StatusBadRequest, rsp.Status)
+	require.Equal(t, InvalidPDUSessionQuery("invalid snssai x"), rsp.Body)
+}
+
+func TestHandleOAMGetUEPDUSessionInfo(t *testing.T) {
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+
+	rsp := HandleOAMGetUEPDUSessionInfo(smContext.Ref)
+	require.Equal(t, http.StatusOK, rsp.Status)
+	require.Equal(t, "internet", rsp.Body.(PDUSessionInfo).Dnn)
+
+	rsp = HandleOAMGetUEPDUSessionInfo("urn:uuid:unknown")
+	require.Equal(t, http.StatusNotFound, rsp.Status)
+	require.Equal(t, "CONTEXT_NOT_FOUND", rsp.Body.(*models.ProblemDetails).Cause)
+}
+
+func TestHandleOAMGetUEIPPoolsDetail(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	defer func(upi *smf_context.UserPlaneInformation) { smfSelf.UserPlaneInformation = upi }(
+		smfSelf.UserPlaneInformation)
+	smfSelf.UserPlaneInformation = smf_context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"GNodeB": {
+				Type:   "AN",
+				NodeID: "192.168.179.100",
+			},
+			"UPF": {
+				Type:   "UPF",
+				NodeID: "192.168.179.1",
+				SNssaiInfos: []factory.SnssaiUpfInfoItem{
+					{
+						SNssai: &models.Snssai{Sst: 1, Sd: "010203"},
+						DnnUpfInfoList: []factory.DnnUpfInfoItem{
+							{
+								Dnn:   "internet",
+								Pools: []factory.UEIPPool{{Cidr: "10.60.0.0/29"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		Links: []factory.UPLink{{A: "GNodeB", B: "UPF"}},
+	})
+	for _, upf := range smfSelf.UserPlaneInformation.UPFs {
+		upf.UPF.UPFStatus = smf_context.AssociatedSetUpSuccess
+	}
+	for i := 0; i < 3; i++ {
+		_, ip := smfSelf.UserPlaneInformation.SelectUPFAndAllocUEIP(&smf_context.UPFSelectionParams{
+			Dnn:    "internet",
+			SNssai: &smf_context.SNssai{Sst: 1, Sd: "010203"},
+		})
+		require.NotNil(t, ip)
+	}
+
+	rsp := HandleOAMGetUEIPPools(&UEIPPoolQuery{Detail: true, Offset: 1, Limit: 1})
+	require.Equal(t, http.StatusOK, rsp.Status)
+	pools := rsp.Body.([]UEIPPoolInfo)
+	require.Len(t, pools, 1)
+	require.Equal(t, 3, pools[0].Allocated)
+	require.Equal(t, []string{"10.60.0.2"}, pools[0].AllocatedAddresses)
+
+	rsp = HandleOAMGetUEIPPools(&UEIPPoolQuery{Detail: true})
+	require.Equal(t, []string{"10.60.0.1", "10.60.0.2", "10.60.0.3"}, rsp.Body.([]UEIPPoolInfo)[0].AllocatedAddresses)
+
+	rsp = HandleOAMGetUEIPPools(&UEIPPoolQuery{Detail: true, Offset: -1})
+	require.Equal(t, http.StatusBadRequest, rsp.Status)
+}
+
+func TestHandleOAMReleasePDUSessions(t *testing.T) {
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+
+	type release struct {
+		smContext    *smf_context.SMContext
+		cause        uint8
+		backoffTimer time.Duration
+	}
+	released := make(chan release, 1)
+	defer func(handler func(*smf_context.SMContext, uint8, time.Duration)) { ReleasePDUSessionHandler = handler }(
+		ReleasePDUSessionHandler)
+	ReleasePDUSessionHandler = func(releasedContext *smf_context.SMContext, cause uint8, backoffTimer time.Duration) {
+		released <- release{smContext: releasedContext, cause: cause, backoffTimer: backoffTimer}
+	}
+	waitRelease := func() release {
+		select {
+		case r := <-released:
+			return r
+		case <-time.After(time.Second):
+			require.FailNow(t, "session not released")
+		}
+		return release{}
+	}
+
+	rsp := HandleOAMReleasePDUSessions(&ReleasePDUSessionsRequest{Supi: smContext.Supi})
+	require.Equal(t, http.StatusAccepted, rsp.Status)
+	require.Equal(t, ReleasedPDUSessions{Sessions: 1}, rsp.Body)
+	require.Equal(t, release{smContext: smContext, cause: nasMessage.Cause5GSMRegularDeactivation}, waitRelease())
+
+	cause, backoffTimer := nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN, 60
+	rsp = HandleOAMReleasePDUSessions(&ReleasePDUSessionsRequest{
+		SmContextRef: smContext.Ref,
+		Cause:        &cause,
+		BackoffTimer: &backoffTimer,
+	})
+	require.Equal(t, http.StatusAccepted, rsp.Status)
+	require.Equal(t, release{smContext: smContext, cause: cause, backoffTimer: time.Minute}, waitRelease())
+
+	// causes of TS 24.501 9.11.4.2 missing in nasMessage are valid too
+	cause = 37
+	rsp = HandleOAMReleasePDUSessions(&ReleasePDUSessionsRequest{Dnn: "internet", Cause: &cause})
+	require.Equal(t, http.StatusAccepted, rsp.Status)
+	require.Equal(t, release{smContext: smContext, cause: cause}, waitRelease())
+
+	for _, cause := range []uint8{0, 1, 7, 30, 40, 0x6e, 0x70, 255} {
+		cause := cause
+		rsp = HandleOAMReleasePDUSessions(&ReleasePDUSessionsRequest{Supi: smContext.Supi, Cause: &cause})
+		require.Equal(t, http.StatusBadRequest, rsp.Status, cause)
+		require.Equal(t, "INVALID_MSG_FORMAT", rsp.Body.(*models.ProblemDetails).Cause, cause)
+	}
+	require.Empty(t, released)
+
+	rsp = HandleOAMReleasePDUSessions(&ReleasePDUSessionsRequest{Dnn: "ims"})
+	require.Equal(t, http.StatusNotFound, rsp.Status)
+	require.Equal(t, "CONTEXT_NOT_FOUND", rsp.Body.(*models.ProblemDetails).Cause)
+}
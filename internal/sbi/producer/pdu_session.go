@@ -1,21 +1,18 @@
 package producer
 
 import (
-	"context"
 	"net"
 	"net/http"
-
-	"github.com/antihax/optional"
+	"time"
 
 	"github.com/free5gc/nas"
 	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/openapi"
-	"github.com/free5gc/openapi/Namf_Communication"
 	"github.com/free5gc/openapi/Nsmf_PDUSession"
-	"github.com/free5gc/openapi/Nudm_SubscriberDataManagement"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp/pfcpType"
 	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/gtpu"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/internal/sbi/consumer"
 	"github.com/free5gc/util/httpwrapper"
@@ -80,25 +77,154 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 		logger.PduSessLog.Infoln("Send NF Discovery Serving UDM Successfully")
 	}
 
+	smPlmnID := createData.Guami.PlmnId
+
+	// TS 23.502 4.3.2.2.1 4. Nudm_UECM_Registration, then subscription data retrieval and
+	// subscription to its changes
+	if err := consumer.SendUECMRegistration(smContext, smPlmnID); err != nil {
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] %v", smContext.Supi, smContext.PDUSessionID, err)
+	}
+
+	var dnnConfiguration *models.DnnConfiguration
+	var subscribedRoutes []string
+	sessSubData, err := consumer.SendGetSmData(smContext, smPlmnID)
+	if err != nil {
+		logger.PduSessLog.Errorln("Get SessionManagementSubscriptionData error:", err)
+	} else if len(sessSubData) == 0 {
+		logger.PduSessLog.Errorln("SessionManagementSubscriptionData from UDM is nil")
+	} else if config, ok := sessSubData[0].DnnConfigurations[smContext.Dnn]; ok {
+		dnnConfiguration = &config.DnnConfiguration
+		subscribedRoutes = config.FramedRoutes()
+	}
+
+	var defaultSubscription *smf_context.DefaultSubscription
+	if smContext.DNNInfo != nil {
+		defaultSubscription = smContext.DNNInfo.DefaultSubscription
+	}
+	// the defaults stand in for the subscription data the UDM has none of for the DNN or cannot
+	// send, not for the data it refuses to send
+	withoutData := err == nil || consumer.NFUnavailableOrFailed(err)
+	switch {
+	case dnnConfiguration != nil:
+		if defaultSubscription != nil && defaultSubscription.Merge(dnnConfiguration) {
+			logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] subscription data completed with the defaults of DNN[%s]",
+				smContext.Supi, smContext.PDUSessionID, smContext.Dnn)
+		}
+		smContext.DnnConfiguration = *dnnConfiguration
+	case defaultSubscription != nil && !defaultSubscription.Reject && withoutData:
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] no subscription data from the UDM, apply the defaults of DNN[%s]",
+			smContext.Supi, smContext.PDUSessionID, smContext.Dnn)
+		defaultSubscription.Merge(&smContext.DnnConfiguration)
+	case consumer.NFUnavailable(err):
+		smContext.SMContextState = smf_context.InActive
+		logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+		return makeEstRejectResAndReleaseSMContext(smContext, nasMessage.Cause5GSMInsufficientResources,
+			&Nsmf_PDUSession.NetworkFailure)
+	case defaultSubscription != nil && withoutData:
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] no subscription data from the UDM, reject the session",
+			smContext.Supi, smContext.PDUSessionID)
+		smContext.SMContextState = smf_context.InActive
+		logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+		return makeEstRejectResAndReleaseSMContext(smContext, nasMessage.Cause5GSMRequestRejectedUnspecified,
+			&Nsmf_PDUSession.SubscriptionDenied)
+	}
+	// UP Security info present in session management subscription data
+	if smContext.DnnConfiguration.UpSecurity != nil {
+		smContext.UpSecurity = smContext.DnnConfiguration.UpSecurity
+	}
+
+	if err := consumer.SendSDMSubscription(smContext, smPlmnID); err != nil {
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] %v", smContext.Supi, smContext.PDUSessionID, err)
+	}
+	// the framed routes of the subscription add to the ones configured locally
+	for _, route := range append(smf_context.SMF_Self().FramedRoutesOf(smContext.Supi, smContext.Dnn),
+		subscribedRoutes...) {
+		if err := smContext.AddFramedRoute(route); err != nil {
+			logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] invalid framed route %s: %v",
+				smContext.Supi, smContext.PDUSessionID, route, err)
+		}
+	}
+
+	if smContext.DNNInfo != nil && smContext.DNNInfo.SecondaryAuth != nil {
+		// TS 23.502 4.3.2.3, the establishment goes on when the DN-AAA server authenticates the UE
+		smContext.Pti = m.PDUSessionEstablishmentRequest.GetPTI()
+		smContext.SecondaryAuth = &smf_context.SecondaryAuthentication{
+			EstablishmentRequest: m.PDUSessionEstablishmentRequest,
+		}
+		selectServingAMF(smContext)
+		go startSecondaryAuthentication(smContext)
+	} else {
+		if nasErrorCause, problemDetails := establishPDUSession(smContext,
+			m.PDUSessionEstablishmentRequest); problemDetails != nil {
+			return makeEstRejectResAndReleaseSMContext(smContext, nasErrorCause, problemDetails)
+		}
+		selectServingAMF(smContext)
+		go ActivateUPFSessionAndNotifyUE(smContext)
+	}
+
+	response.JsonData = smContext.BuildCreatedData()
+	httpResponse := &httpwrapper.Response{
+		Header: http.Header{
+			"Location": {smContext.Ref},
+		},
+		Status: http.StatusCreated,
+		Body:   response,
+	}
+
+	return httpResponse
+}
+
+// establishPDUSession allocates the UE IP address, creates the SM policy association and selects
+// the data path of the PDU session. On failure, it returns the 5GSM cause and the problem of the
+// PDU Session Establishment Reject.
+func establishPDUSession(smContext *smf_context.SMContext,
+	establishmentRequest *nasMessage.PDUSessionEstablishmentRequest,
+) (uint8, *models.ProblemDetails) {
+	upi := smf_context.GetUserPlaneInformation()
+
 	// IP Allocation
 	upfSelectionParams := &smf_context.UPFSelectionParams{
-		Dnn: createData.Dnn,
+		Dnn: smContext.Dnn,
 		SNssai: &smf_context.SNssai{
-			Sst: createData.SNssai.Sst,
-			Sd:  createData.SNssai.Sd,
+			Sst: smContext.Snssai.Sst,
+			Sd:  smContext.Snssai.Sd,
 		},
 	}
 	var selectedUPF *smf_context.UPNode
 	var ip net.IP
 	selectedUPFName := ""
-	if smf_context.SMF_Self().ULCLSupport && smf_context.CheckUEHasPreConfig(createData.Supi) {
-		groupName := smf_context.GetULCLGroupNameFromSUPI(createData.Supi)
+	if smf_context.SMF_Self().ULCLSupport && smf_context.CheckUEHasPreConfig(smContext.Supi) {
+		groupName := smf_context.GetULCLGroupNameFromSUPI(smContext.Supi)
 		defaultPathPool := smf_context.GetUEDefaultPathPool(groupName)
 		if defaultPathPool != nil {
 			selectedUPFName, ip = defaultPathPool.SelectUPFAndAllocUEIPForULCL(
 				upi, upfSelectionParams)
 			selectedUPF = upi.UPFs[selectedUPFName]
 		}
+		if staticUEIPAddress(smContext) != nil {
+			logger.PduSessLog.Warnf("UE[%s] has pre-config route, static IP address is ignored", smContext.Supi)
+		}
+	} else if staticIP := staticUEIPAddress(smContext); staticIP != nil {
+		var err error
+		if selectedUPF, err = upi.SelectUPFAndReserveStaticUEIP(upfSelectionParams, staticIP); err != nil {
+			logger.PduSessLog.Errorf("UE[%s] PDUSessionID[%d] static IP[%s] not available: %v",
+				smContext.Supi, smContext.PDUSessionID, staticIP, err)
+			smContext.SMContextState = smf_context.InActive
+			logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+			if err == smf_context.ErrStaticUEIPInUse {
+				return nasMessage.Cause5GSMRequestRejectedUnspecified, &models.ProblemDetails{
+					Title:  "UE IP address in use",
+					Status: http.StatusForbidden,
+					Detail: "The static UE IP address " + staticIP.String() + " is used by another PDU session.",
+					Cause:  "UE_IP_IN_USE",
+				}
+			}
+			return nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+				&Nsmf_PDUSession.InsufficientResourceSliceDnn
+		}
+		ip = staticIP
+		logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] static IP[%s]",
+			smContext.Supi, smContext.PDUSessionID, ip.String())
 	} else {
 		selectedUPF, ip = upi.SelectUPFAndAllocUEIP(upfSelectionParams)
 		smContext.PDUAddress = ip
@@ -113,44 +239,11 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 		logger.PduSessLog.Warnf("Data Path not found\n")
 		logger.PduSessLog.Warnln("Selection Parameter: ", upfSelectionParams.String())
 
-		return makeEstRejectResAndReleaseSMContext(smContext, nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
-			&Nsmf_PDUSession.InsufficientResourceSliceDnn)
+		return nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN, &Nsmf_PDUSession.InsufficientResourceSliceDnn
 	}
 	smContext.PDUAddress = ip
 	smContext.SelectedUPF = selectedUPF
 
-	smPlmnID := createData.Guami.PlmnId
-
-	smDataParams := &Nudm_SubscriberDataManagement.GetSmDataParamOpts{
-		Dnn:         optional.NewString(createData.Dnn),
-		PlmnId:      optional.NewInterface(openapi.MarshToJsonString(smPlmnID)),
-		SingleNssai: optional.NewInterface(openapi.MarshToJsonString(smContext.Snssai)),
-	}
-
-	SubscriberDataManagementClient := smf_context.SMF_Self().SubscriberDataManagementClient
-
-	if sessSubData, rsp, err := SubscriberDataManagementClient.
-		SessionManagementSubscriptionDataRetrievalApi.
-		GetSmData(context.Background(), smContext.Supi, smDataParams); err != nil {
-		logger.PduSessLog.Errorln("Get SessionManagementSubscriptionData error:", err)
-	} else {
-		defer func() {
-			if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
-				logger.PduSessLog.Errorf("GetSmData response body cannot close: %+v", rspCloseErr)
-			}
-		}()
-		if len(sessSubData) > 0 {
-			smContext.DnnConfiguration = sessSubData[0].DnnConfigurations[smContext.Dnn]
-			// UP Security info present in session management subscription data
-			if smContext.DnnConfiguration.UpSecurity != nil {
-				smContext.UpSecurity = smContext.DnnConfiguration.UpSecurity
-			}
-		} else {
-			logger.PduSessLog.Errorln("SessionManagementSubscriptionData from UDM is nil")
-		}
-	}
-
-	establishmentRequest := m.PDUSessionEstablishmentRequest
 	smContext.HandlePDUSessionEstablishmentRequest(establishmentRequest)
 
 	logger.PduSessLog.Infof("PCF Selection for SMContext SUPI[%s] PDUSessionID[%d]\n",
@@ -159,24 +252,9 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 		logger.PduSessLog.Errorln("pcf selection error:", err)
 	}
 
-	var smPolicyDecision *models.SmPolicyDecision
-	if smPolicyID, smPolicyDecisionRsp, err := consumer.SendSMPolicyAssociationCreate(smContext); err != nil {
-		openapiError := err.(openapi.GenericOpenAPIError)
-		problemDetails := openapiError.Model().(models.ProblemDetails)
-		logger.PduSessLog.Errorln("setup sm policy association failed:", err, problemDetails)
-		smContext.SMContextState = smf_context.InActive
-		logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
-
-		if problemDetails.Cause == "USER_UNKNOWN" {
-			return makeEstRejectResAndReleaseSMContext(smContext, nasMessage.Cause5GSMRequestRejectedUnspecified,
-				&Nsmf_PDUSession.SubscriptionDenied)
-		} else {
-			return makeEstRejectResAndReleaseSMContext(smContext, nasMessage.Cause5GSMNetworkFailure,
-				&Nsmf_PDUSession.NetworkFailure)
-		}
-	} else {
-		smPolicyDecision = smPolicyDecisionRsp
-		smContext.SMPolicyID = smPolicyID
+	smPolicyDecision, cause, problemDetails := setupSMPolicyAssociation(smContext)
+	if problemDetails != nil {
+		return cause, problemDetails
 	}
 
 	// dataPath selection
@@ -186,18 +264,18 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 	}
 	var defaultPath *smf_context.DataPath
 
-	if smf_context.SMF_Self().ULCLSupport && smf_context.CheckUEHasPreConfig(createData.Supi) {
-		logger.PduSessLog.Infof("SUPI[%s] has pre-config route", createData.Supi)
-		uePreConfigPaths := smf_context.GetUEPreConfigPaths(createData.Supi, selectedUPFName)
+	if smf_context.SMF_Self().ULCLSupport && smf_context.CheckUEHasPreConfig(smContext.Supi) {
+		logger.PduSessLog.Infof("SUPI[%s] has pre-config route", smContext.Supi)
+		uePreConfigPaths := smf_context.GetUEPreConfigPaths(smContext.Supi, selectedUPFName)
 		smContext.Tunnel.DataPathPool = uePreConfigPaths.DataPathPool
 		smContext.Tunnel.PathIDGenerator = uePreConfigPaths.PathIDGenerator
 		defaultPath = smContext.Tunnel.DataPathPool.GetDefaultPath()
 		defaultPath.ActivateTunnelAndPDR(smContext, 255)
-		smContext.BPManager = smf_context.NewBPManager(createData.Supi)
+		smContext.BPManager = smf_context.NewBPManager(smContext.Supi)
 	} else {
 		// UE has no pre-config path.
 		// Use default route
-		logger.PduSessLog.Infof("SUPI[%s] has no pre-config route", createData.Supi)
+		logger.PduSessLog.Infof("SUPI[%s] has no pre-config route", smContext.Supi)
 		defaultUPPath := upi.GetDefaultUserPlanePathByDNNAndUPF(
 			upfSelectionParams, smContext.SelectedUPF)
 		defaultPath = smf_context.GenerateDataPath(defaultUPPath, smContext)
@@ -214,8 +292,58 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 		logger.PduSessLog.Warnf("Data Path not found\n")
 		logger.PduSessLog.Warnln("Selection Parameter: ", upfSelectionParams.String())
 
-		return makeEstRejectResAndReleaseSMContext(smContext, nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
-			&Nsmf_PDUSession.InsufficientResourceSliceDnn)
+		return nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN, &Nsmf_PDUSession.InsufficientResourceSliceDnn
+	}
+	// the PCC rules are sent to the UPFs with the default path
+	smContext.UpdatePCCRules()
+
+	return 0, nil
+}
+
+// setupSMPolicyAssociation creates the SM policy association of the PDU session and returns the
+// decision of the PCF, or the decision of the local policy of the DNN when no PCF answered. On
+// failure, it returns the 5GSM cause and the problem of the PDU Session Establishment Reject.
+func setupSMPolicyAssociation(smContext *smf_context.SMContext) (
+	*models.SmPolicyDecision, uint8, *models.ProblemDetails,
+) {
+	smPolicyID, smPolicyDecision, err := consumer.SendSMPolicyAssociationCreate(smContext)
+	if err == nil {
+		smContext.SMPolicyID = smPolicyID
+		return smPolicyDecision, 0, nil
+	}
+
+	// no problem details when no PCF answered
+	var problemDetails models.ProblemDetails
+	if openapiError, ok := err.(openapi.GenericOpenAPIError); ok {
+		problemDetails, _ = openapiError.Model().(models.ProblemDetails)
+	}
+	if localPolicyApplies(smContext, problemDetails) {
+		// the association is retried in the background until a PCF answers
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] setup sm policy association failed, "+
+			"apply the local policy: %v", smContext.Supi, smContext.PDUSessionID, err)
+		smContext.OnLocalPolicy = true
+		return smContext.DNNInfo.LocalPolicy.SmPolicyDecision(), 0, nil
+	}
+
+	logger.PduSessLog.Errorln("setup sm policy association failed:", err, problemDetails)
+	smContext.SMContextState = smf_context.InActive
+	logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+	switch {
+	case problemDetails.Cause == "USER_UNKNOWN":
+		return nil, nasMessage.Cause5GSMRequestRejectedUnspecified, &Nsmf_PDUSession.SubscriptionDenied
+	case consumer.NFUnavailable(err):
+		return nil, nasMessage.Cause5GSMInsufficientResources, &Nsmf_PDUSession.NetworkFailure
+	default:
+		return nil, nasMessage.Cause5GSMNetworkFailure, &Nsmf_PDUSession.NetworkFailure
+	}
+}
+
+// selectServingAMF discovers the AMF serving the UE to send it the N1 and N2 messages
+func selectServingAMF(smContext *smf_context.SMContext) {
+	// the SCP selects the serving AMF of the requests with delegated discovery
+	if smf_context.DelegatedDiscovery() {
+		smContext.SetCommunicationClient()
+		return
 	}
 
 	if problemDetails, err := consumer.SendNFDiscoveryServingAMF(smContext); err != nil {
@@ -226,26 +354,7 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 		logger.PduSessLog.Traceln("Send NF Discovery Serving AMF successfully")
 	}
 
-	for _, service := range *smContext.AMFProfile.NfServices {
-		if service.ServiceName == models.ServiceName_NAMF_COMM {
-			communicationConf := Namf_Communication.NewConfiguration()
-			communicationConf.SetBasePath(service.ApiPrefix)
-			smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
-		}
-	}
-	go ActivateUPFSessionAndNotifyUE(smContext)
-
-	response.JsonData = smContext.BuildCreatedData()
-	httpResponse := &httpwrapper.Response{
-		Header: http.Header{
-			"Location": {smContext.Ref},
-		},
-		Status: http.StatusCreated,
-		Body:   response,
-	}
-
-	return httpResponse
-	// TODO: UECM registration
+	smContext.SetCommunicationClient()
 }
 
 func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmContextRequest) *httpwrapper.Response {
@@ -281,14 +390,23 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
+	defer smf_context.StoreSMContext(smContext)
 
 	var sendPFCPModification bool
+	var flushDLBuffer bool
 	var pfcpResponseStatus smf_context.PFCPSessionResponseStatus
 	var response models.UpdateSmContextResponse
 	response.JsonData = new(models.SmContextUpdatedData)
 
 	smContextUpdateData := body.JsonData
 
+	// another AMF took over the UE, or the client of the serving AMF was dropped when it deregistered
+	if servingNfId := smContextUpdateData.ServingNfId; servingNfId != "" &&
+		(servingNfId != smContext.ServingNfId || smContext.CommunicationClient == nil) {
+		smContext.ServingNfId = servingNfId
+		selectServingAMF(smContext)
+	}
+
 	if body.BinaryDataN1SmMessage != nil {
 		logger.PduSessLog.Traceln("Binary Data N1 SmMessage isn't nil!")
 		m := nas.NewMessage()
@@ -307,6 +425,41 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			return httpResponse
 		}
 		switch m.GsmHeader.GetMessageType() {
+		case nas.MsgTypePDUSessionAuthenticationComplete:
+			auth := smContext.SecondaryAuth
+			if auth == nil || auth.Authenticated || smContext.SMContextState != smf_context.ActivePending {
+				logger.PduSessLog.Warnln("The SMContext should be waiting for the secondary authentication")
+				logger.PduSessLog.Warnln("SMContext state: ", smContext.SMContextState.String())
+				return &httpwrapper.Response{
+					Status: http.StatusForbidden,
+					Body: models.UpdateSmContextErrorResponse{
+						JsonData: &models.SmContextUpdateError{
+							Error: &Nsmf_PDUSession.N1SmError,
+						},
+					},
+				}
+			}
+
+			smContext.StopEAPTimer()
+			request, err := smContext.NewAccessRequest(
+				m.PDUSessionAuthenticationComplete.EAPMessage.GetEAPMessage())
+			if err != nil {
+				logger.PduSessLog.Errorf("Build Access-Request failed: %+v", err)
+				return &httpwrapper.Response{
+					Status: http.StatusInternalServerError,
+					Body: models.UpdateSmContextErrorResponse{
+						JsonData: &models.SmContextUpdateError{
+							Error: &Nsmf_PDUSession.NetworkFailure,
+						},
+					},
+				}
+			}
+			// the answer of the DN-AAA server is handled once the update is over
+			go authenticateByDNAAA(smContext, request)
+			return &httpwrapper.Response{
+				Status: http.StatusOK,
+				Body:   response,
+			}
 		case nas.MsgTypePDUSessionReleaseRequest:
 			state := smContext.SMContextState
 			if !(state == smf_context.Active || state == smf_context.InActivePending) {
@@ -393,6 +546,17 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			if smContext.Tunnel.ANInformation.IPAddress == nil {
 				RemoveSMContextFromAllNF(smContext, true)
 			}
+		case nas.MsgTypePDUSessionModificationComplete:
+			logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] PDU Session Modification Complete",
+				smContext.Supi, smContext.PDUSessionID)
+			smContext.SMContextState = smf_context.ModificationPending
+			logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+		case nas.MsgTypePDUSessionModificationCommandReject:
+			// the rules are already applied in the UPF, the UE keeps the session AMBR it had
+			logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] PDU Session Modification Command rejected",
+				smContext.Supi, smContext.PDUSessionID)
+			smContext.SMContextState = smf_context.ModificationPending
+			logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
 		}
 	} else {
 		logger.PduSessLog.Traceln("[SMF] Binary Data N1 SmMessage is nil!")
@@ -465,8 +629,7 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 		response.JsonData.UpCnxState = models.UpCnxState_DEACTIVATED
 		smContext.UpCnxState = body.JsonData.UpCnxState
 		smContext.UeLocation = body.JsonData.UeLocation
-		// TODO: Deactivate N2 downlink tunnel
-		// Set FAR and An, N3 Release Info
+		// Deactivate N3 downlink tunnel: buffer DL data in the AN UPF (anchor or I-UPF) or in the SMF
 		farList = []*smf_context.FAR{}
 		smContext.PendingUPF = make(smf_context.PendingUPF)
 		for _, dataPath := range smContext.Tunnel.DataPathPool {
@@ -475,10 +638,13 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			if DLPDR == nil {
 				logger.PduSessLog.Errorf("AN Release Error")
 			} else {
-				DLPDR.FAR.State = smf_context.RULE_UPDATE
-				DLPDR.FAR.ApplyAction.Forw = false
-				DLPDR.FAR.ApplyAction.Buff = true
-				DLPDR.FAR.ApplyAction.Nocp = true
+				bar, err := smContext.BufferDownlinkFAR(ANUPF.UPF, DLPDR.FAR)
+				if err != nil {
+					logger.PduSessLog.Errorf("Set DL buffering of FAR[%d] failed: %v", DLPDR.FAR.FARID, err)
+				}
+				if bar != nil {
+					barList = append(barList, bar)
+				}
 				smContext.PendingUPF[ANUPF.GetNodeIP()] = true
 				farList = append(farList, DLPDR.FAR)
 				sendPFCPModification = true
@@ -540,7 +706,10 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			HandlePDUSessionResourceSetupResponseTransfer(body.BinaryDataN2SmInformation, smContext); err != nil {
 			logger.PduSessLog.Errorf("Handle PDUSessionResourceSetupResponseTransfer failed: %+v", err)
 		}
+		smContext.PagingInProgress = false
+		smContext.StopDDNBackoff()
 		sendPFCPModification = true
+		flushDLBuffer = true
 		smContext.SMContextState = smf_context.PFCPModification
 		logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
 	case models.N2SmInfoType_PDU_RES_SETUP_FAIL:
@@ -548,6 +717,15 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			HandlePDUSessionResourceSetupResponseTransfer(body.BinaryDataN2SmInformation, smContext); err != nil {
 			logger.PduSessLog.Errorf("Handle PDUSessionResourceSetupResponseTransfer failed: %+v", err)
 		}
+	case models.N2SmInfoType_PDU_RES_MOD_RSP:
+		logger.PduSessLog.Infoln("[SMF] N2 PDU Session Resource Modify Response")
+		smContext.SMContextState = smf_context.ModificationPending
+		logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+	case models.N2SmInfoType_PDU_RES_MOD_FAIL:
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] PDU Session Resource Modify failed",
+			smContext.Supi, smContext.PDUSessionID)
+		smContext.SMContextState = smf_context.ModificationPending
+		logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
 	case models.N2SmInfoType_PDU_RES_REL_RSP:
 		logger.PduSessLog.Infoln("[SMF] N2 PDU Session Resource Release Response")
 		smContext.Tunnel.ANInformation = struct {
@@ -780,6 +958,9 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			logger.CtxLog.Traceln("In case SessionUpdateSuccess")
 			smContext.SMContextState = smf_context.Active
 			logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+			if flushDLBuffer {
+				gtpu.Flush(smContext)
+			}
 			httpResponse = &httpwrapper.Response{
 				Status: http.StatusOK,
 				Body:   response,
@@ -788,6 +969,10 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			logger.CtxLog.Traceln("In case SessionUpdateFailed")
 			smContext.SMContextState = smf_context.Active
 			logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+			if flushDLBuffer {
+				// the user plane is not activated, the UE will be paged again for new data
+				gtpu.Discard(smContext)
+			}
 			// It is just a template
 			httpResponse = &httpwrapper.Response{
 				Status: http.StatusForbidden,
@@ -1063,9 +1248,10 @@ func makeEstRejectResAndReleaseSMContext(smContext *smf_context.SMContext, nasEr
 	var httpResponse *httpwrapper.Response
 
 	if buf, err := smf_context.
-		BuildGSMPDUSessionEstablishmentReject(
+		BuildGSMPDUSessionEstablishmentRejectWithBackoffTimer(
 			smContext,
-			nasErrorCause); err != nil {
+			nasErrorCause,
+			establishmentRejectBackoffTimer(nasErrorCause)); err != nil {
 		httpResponse = &httpwrapper.Response{
 			Header: nil,
 			Status: int(sbiError.Status),
@@ -1091,3 +1277,30 @@ func makeEstRejectResAndReleaseSMContext(smContext *smf_context.SMContext, nasEr
 	RemoveSMContextFromAllNF(smContext, false)
 	return httpResponse
 }
+
+// establishmentRejectBackoffTimer returns the back-off timer of a PDU Session Establishment
+// Reject, set when an NF is unavailable so that the UE does not retry at once, TS 24.501 6.4.1.4.1
+func establishmentRejectBackoffTimer(nasErrorCause uint8) time.Duration {
+	if nasErrorCause == nasMessage.Cause5GSMInsufficientResources {
+		return smf_context.SMF_Self().SBIRejectBackoffTimer
+	}
+	return 0
+}
+
+// staticUEIPAddress returns the IPv4 address authorized by the DN-AAA server or the static IPv4
+// address of the subscription data of the DNN, if any
+func staticUEIPAddress(smContext *smf_context.SMContext) net.IP {
+	if auth := smContext.SecondaryAuth; auth != nil && auth.FramedIPAddress != nil {
+		return auth.FramedIPAddress
+	}
+	for _, ipAddress := range smContext.DnnConfiguration.StaticIpAddress {
+		if ipAddress.Ipv4Addr == "" {
+			continue
+		}
+		if ip := net.ParseIP(ipAddress.Ipv4Addr).To4(); ip != nil {
+			return ip
+		}
+		logger.PduSessLog.Warnf("UE[%s] invalid static IPv4 address %s", smContext.Supi, ipAddress.Ipv4Addr)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package producer
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestEstablishPDUSessionStaticUEIPInUse(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	defer func(upi *smf_context.UserPlaneInformation) { smfSelf.UserPlaneInformation = upi }(
+		smfSelf.UserPlaneInformation)
+	smfSelf.UserPlaneInformation = smf_context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]factory.UPNode{
+			"GNodeB": {
+				Type:   "AN",
+				NodeID: "192.168.179.100",
+			},
+			"UPF": {
+				Type:   "UPF",
+				NodeID: "192.168.179.1",
+				SNssaiInfos: []factory.SnssaiUpfInfoItem{
+					{
+						SNssai: &models.Snssai{Sst: 1, Sd: "010203"},
+						DnnUpfInfoList: []factory.DnnUpfInfoItem{
+							{
+								Dnn:         "internet",
+								StaticPools: []factory.UEIPPool{{Cidr: "10.61.0.0/24"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		Links: []factory.UPLink{{A: "GNodeB", B: "UPF"}},
+	})
+	for _, upf := range smfSelf.UserPlaneInformation.UPFs {
+		upf.UPF.UPFStatus = smf_context.AssociatedSetUpSuccess
+	}
+	staticIP := net.ParseIP("10.61.0.5").To4()
+	_, err := smfSelf.UserPlaneInformation.SelectUPFAndReserveStaticUEIP(&smf_context.UPFSelectionParams{
+		Dnn:    "internet",
+		SNssai: &smf_context.SNssai{Sst: 1, Sd: "010203"},
+	}, staticIP)
+	require.NoError(t, err)
+
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+	smContext.DnnConfiguration.StaticIpAddress = []models.IpAddress{{Ipv4Addr: staticIP.String()}}
+
+	cause, problemDetails := establishPDUSession(smContext, nasMessage.NewPDUSessionEstablishmentRequest(0))
+	require.Equal(t, nasMessage.Cause5GSMRequestRejectedUnspecified, cause)
+	require.NotNil(t, problemDetails)
+	require.Equal(t, int32(http.StatusForbidden), problemDetails.Status)
+	require.Equal(t, "UE_IP_IN_USE", problemDetails.Cause)
+	require.Equal(t, smf_context.InActive, smContext.SMContextState)
+}
@@ -0,0 +1,59 @@
+package producer
+
+import (
+	"net/http"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/util/httpwrapper"
+)
+
+func replicatedSessionStore() (*context.ReplicatedSessionStore, *httpwrapper.Response) {
+	store, ok := context.GetSessionStore().(*context.ReplicatedSessionStore)
+	if !ok {
+		problemDetails := &models.ProblemDetails{
+			Status: http.StatusNotFound,
+			Cause:  "REPLICATION_NOT_CONFIGURED",
+		}
+		return nil, httpwrapper.NewResponse(http.StatusNotFound, nil, problemDetails)
+	}
+	return store, nil
+}
+
+func HandleGetReplicationSnapshot() *httpwrapper.Response {
+	store, errResponse := replicatedSessionStore()
+	if errResponse != nil {
+		return errResponse
+	}
+
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		logger.CtxLog.Errorf("Read replication snapshot failed: %v", err)
+		problemDetails := &models.ProblemDetails{
+			Status: http.StatusInternalServerError,
+			Cause:  "SYSTEM_FAILURE",
+			Detail: err.Error(),
+		}
+		return httpwrapper.NewResponse(http.StatusInternalServerError, nil, problemDetails)
+	}
+	return httpwrapper.NewResponse(http.StatusOK, nil, snapshot)
+}
+
+func HandleGetReplicationChanges(after uint64) *httpwrapper.Response {
+	store, errResponse := replicatedSessionStore()
+	if errResponse != nil {
+		return errResponse
+	}
+
+	changes, ok := store.ChangesAfter(after)
+	if !ok {
+		// the standby has to read a snapshot
+		problemDetails := &models.ProblemDetails{
+			Status: http.StatusGone,
+			Cause:  "CHANGES_NOT_AVAILABLE",
+		}
+		return httpwrapper.NewResponse(http.StatusGone, nil, problemDetails)
+	}
+	return httpwrapper.NewResponse(http.StatusOK, nil, changes)
+}
@@ -0,0 +1,209 @@
+package producer
+
+import (
+	"strings"
+	"time"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/radius"
+	"github.com/free5gc/smf/internal/sbi/consumer"
+)
+
+// startSecondaryAuthentication asks the UE for its identity in the DN, TS 23.502 4.3.2.3
+func startSecondaryAuthentication(smContext *smf_context.SMContext) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	auth := smContext.SecondaryAuth
+	auth.EAPIdentifier = 1
+	logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] secondary authentication by the DN-AAA server",
+		smContext.Supi, smContext.PDUSessionID)
+	if !sendPDUSessionAuthenticationCommand(smContext, smf_context.NewEAPRequestIdentity(auth.EAPIdentifier)) {
+		RemoveSMContextFromAllNF(smContext, true)
+	}
+}
+
+// authenticateByDNAAA relays the EAP response of the UE to the DN-AAA server, then goes on with
+// the authentication or with the establishment of the PDU session according to the answer
+func authenticateByDNAAA(smContext *smf_context.SMContext, request *radius.Packet) {
+	response, err := smContext.DNNInfo.SecondaryAuth.Client.Exchange(request)
+
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if smContext.SMContextState != smf_context.ActivePending {
+		// released during the exchange
+		return
+	}
+
+	auth := smContext.SecondaryAuth
+	if err != nil {
+		logger.PduSessLog.Errorf("UE[%s] PDUSessionID[%d] DN-AAA server error: %v",
+			smContext.Supi, smContext.PDUSessionID, err)
+		auth.EAPResult = smf_context.NewEAPFailure(auth.EAPIdentifier)
+		sendPDUSessionEstablishmentReject(smContext, nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed)
+		return
+	}
+
+	eap := response.EAPMessage()
+	switch response.Code {
+	case radius.CodeAccessChallenge:
+		if len(eap) < 4 {
+			logger.PduSessLog.Errorf("UE[%s] PDUSessionID[%d] Access-Challenge without EAP-Message",
+				smContext.Supi, smContext.PDUSessionID)
+			auth.EAPResult = smf_context.NewEAPFailure(auth.EAPIdentifier)
+			sendPDUSessionEstablishmentReject(smContext, nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed)
+			return
+		}
+		auth.RadiusState = response.Get(radius.AttrState)
+		auth.EAPIdentifier = eap[1]
+		if !sendPDUSessionAuthenticationCommand(smContext, eap) {
+			RemoveSMContextFromAllNF(smContext, true)
+		}
+	case radius.CodeAccessAccept:
+		logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] authenticated by the DN-AAA server",
+			smContext.Supi, smContext.PDUSessionID)
+		auth.Authenticated = true
+		auth.RadiusState = nil
+		auth.RadiusClass = response.Get(radius.AttrClass)
+		auth.EAPResult = eap
+		applyDNAAAAuthorization(smContext, response)
+
+		if nasErrorCause, problemDetails := establishPDUSession(smContext,
+			auth.EstablishmentRequest); problemDetails != nil {
+			logger.PduSessLog.Errorf("UE[%s] PDUSessionID[%d] establishment failed: %s",
+				smContext.Supi, smContext.PDUSessionID, problemDetails.Detail)
+			auth.EAPResult = nil
+			sendPDUSessionEstablishmentReject(smContext, nasErrorCause)
+			return
+		}
+		smContext.StartSessionTimeout(func() { sessionTimeoutExpired(smContext) })
+		go ActivateUPFSessionAndNotifyUE(smContext)
+	default:
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] rejected by the DN-AAA server",
+			smContext.Supi, smContext.PDUSessionID)
+		auth.EAPResult = eap
+		if len(auth.EAPResult) == 0 {
+			auth.EAPResult = smf_context.NewEAPFailure(auth.EAPIdentifier)
+		}
+		sendPDUSessionEstablishmentReject(smContext, nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed)
+	}
+}
+
+// sessionTimeoutExpired releases the session once the session timeout of the DN-AAA server
+// expires
+func sessionTimeoutExpired(smContext *smf_context.SMContext) {
+	logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] session timeout of the DN-AAA server",
+		smContext.Supi, smContext.PDUSessionID)
+	if ReleasePDUSessionHandler != nil {
+		ReleasePDUSessionHandler(smContext, nasMessage.Cause5GSMRegularDeactivation, 0)
+	}
+}
+
+// ResumeSessionTimeouts starts the session timeouts of the DN-AAA server of the sessions
+// restored after the SMF restarted
+func ResumeSessionTimeouts() {
+	for _, smContext := range smf_context.ListSMContexts() {
+		smContext.SMLock.Lock()
+		smContext.StartSessionTimeout(func() { sessionTimeoutExpired(smContext) })
+		smContext.SMLock.Unlock()
+	}
+}
+
+// applyDNAAAAuthorization takes the UE IP address, the framed routes and the session timeout
+// authorized by the DN-AAA server, TS 29.561 16.4
+func applyDNAAAAuthorization(smContext *smf_context.SMContext, response *radius.Packet) {
+	auth := smContext.SecondaryAuth
+
+	// 255.255.255.254 and 255.255.255.255 leave the allocation to the SMF, RFC 2865 5.8
+	if ip := response.GetIPv4(radius.AttrFramedIPAddress); ip != nil && ip[0] != 255 {
+		auth.FramedIPAddress = ip
+	}
+
+	for _, value := range response.GetAll(radius.AttrFramedRoute) {
+		// "<prefix>[/<length>] <gateway> <metrics>", RFC 2865 5.22
+		fields := strings.Fields(string(value))
+		if len(fields) == 0 {
+			continue
+		}
+		route := fields[0]
+		if !strings.Contains(route, "/") {
+			route += "/32"
+		}
+		if err := smContext.AddFramedRoute(route); err != nil {
+			logger.PduSessLog.Warnf("UE[%s] invalid Framed-Route %q: %v", smContext.Supi, string(value), err)
+		}
+	}
+
+	if timeout, ok := response.GetUint32(radius.AttrSessionTimeout); ok && timeout > 0 {
+		auth.SessionTimeout = time.Duration(timeout) * time.Second
+	}
+}
+
+// sendPDUSessionAuthenticationCommand sends the EAP request to the UE, it returns false if the
+// AMF could not transfer it
+func sendPDUSessionAuthenticationCommand(smContext *smf_context.SMContext, eap []byte) bool {
+	smNasBuf, err := smf_context.BuildGSMPDUSessionAuthenticationCommand(smContext, eap)
+	if err != nil {
+		logger.PduSessLog.Errorf("Build GSM PDUSessionAuthenticationCommand failed: %s", err)
+		return false
+	}
+	n1n2Request := models.N1N2MessageTransferRequest{
+		BinaryDataN1Message: smNasBuf,
+		JsonData: &models.N1N2MessageTransferReqData{
+			PduSessionId: smContext.PDUSessionID,
+			N1MessageContainer: &models.N1MessageContainer{
+				N1MessageClass:   "SM",
+				N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
+			},
+		},
+	}
+	rspData, res, err := consumer.SendN1N2MessageTransfer(smContext, n1n2Request)
+	if res != nil {
+		defer func() {
+			if resCloseErr := res.Body.Close(); resCloseErr != nil {
+				logger.ConsumerLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
+			}
+		}()
+	}
+	if err != nil {
+		logger.PduSessLog.Warnf("Send N1N2Transfer failed: %v", err)
+		return false
+	}
+	if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
+		logger.PduSessLog.Warnf("%v", rspData.Cause)
+		return false
+	}
+	startEAPTimer(smContext)
+	return true
+}
+
+// startEAPTimer rejects the establishment of the PDU session if the UE does not answer the EAP
+// request within the EAPTimeout
+func startEAPTimer(smContext *smf_context.SMContext) {
+	auth := smContext.SecondaryAuth
+	smContext.StopEAPTimer()
+	var timer *time.Timer
+	timer = time.AfterFunc(smf_context.EAPTimeout, func() {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+
+		// the UE may have answered or the session been released meanwhile
+		if auth.EAPTimer != timer || smContext.SMContextState != smf_context.ActivePending {
+			return
+		}
+		auth.EAPTimer = nil
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] did not answer the EAP request in %s",
+			smContext.Supi, smContext.PDUSessionID, smf_context.EAPTimeout)
+		auth.EAPResult = smf_context.NewEAPFailure(auth.EAPIdentifier)
+		sendPDUSessionEstablishmentReject(smContext, nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed)
+	})
+	auth.EAPTimer = timer
+}
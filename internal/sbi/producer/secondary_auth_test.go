@@ -0,0 +1,181 @@
+package producer
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/Namf_Communication"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/radius"
+)
+
+const testAMFURI = "http://127.0.0.13:8000"
+
+var testRadiusSecret = []byte("testing123")
+
+// newTestAMF counts the N1N2 message transfers of the session and sets its communication client
+// to the stub AMF
+func newTestAMF(t *testing.T, smContext *smf_context.SMContext) chan struct{} {
+	openapi.InterceptH2CClient()
+	t.Cleanup(func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+	})
+	transfers := make(chan struct{}, 8)
+	gock.New(testAMFURI).Persist().
+		Post("/namf-comm/v1/ue-contexts/imsi-208930000000001/n1-n2-messages").
+		AddMatcher(func(*http.Request, *gock.Request) (bool, error) {
+			transfers <- struct{}{}
+			return true, nil
+		}).
+		Reply(http.StatusOK).
+		JSON(models.N1N2MessageTransferRspData{Cause: models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED})
+
+	configuration := Namf_Communication.NewConfiguration()
+	configuration.SetBasePath(testAMFURI)
+	smContext.CommunicationClient = Namf_Communication.NewAPIClient(configuration)
+	return transfers
+}
+
+// runTestDNAAA runs a DN-AAA stand-in which challenges the EAP-Response/Identity and rejects the
+// answer to the challenge
+func runTestDNAAA(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			request, err := radius.Decode(buf[:n])
+			if err != nil {
+				continue
+			}
+			response := radius.NewResponse(request, radius.CodeAccessReject)
+			if request.Get(radius.AttrState) == nil {
+				response = radius.NewResponse(request, radius.CodeAccessChallenge)
+				response.Add(radius.AttrState, []byte("round-1"))
+				response.SetEAPMessage([]byte{1, 2, 0, 6, 4, 16})
+			}
+			raw, err := response.Encode(testRadiusSecret)
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteToUDP(raw, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// newSecondaryAuthSMContext returns an SM context waiting for its authentication by the DN-AAA
+// server
+func newSecondaryAuthSMContext(t *testing.T) *smf_context.SMContext {
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+	smContext.SMContextState = smf_context.ActivePending
+	smContext.SecondaryAuth = &smf_context.SecondaryAuthentication{
+		EstablishmentRequest: nasMessage.NewPDUSessionEstablishmentRequest(0),
+	}
+	smContext.DNNInfo = &smf_context.SnssaiSmfDnnInfo{
+		SecondaryAuth: &smf_context.DNAAAServer{
+			Client: &radius.Client{
+				Addr:    runTestDNAAA(t),
+				Secret:  testRadiusSecret,
+				Timeout: time.Second,
+			},
+			NasIdentifier: "smf",
+		},
+	}
+	return smContext
+}
+
+func waitTransfer(t *testing.T, transfers chan struct{}) {
+	select {
+	case <-transfers:
+	case <-time.After(time.Second):
+		t.Fatal("no N1N2 message transfer")
+	}
+}
+
+func TestSecondaryAuthenticationRejected(t *testing.T) {
+	smContext := newSecondaryAuthSMContext(t)
+	transfers := newTestAMF(t, smContext)
+	auth := smContext.SecondaryAuth
+
+	// EAP-Request/Identity
+	startSecondaryAuthentication(smContext)
+	waitTransfer(t, transfers)
+	require.NotNil(t, auth.EAPTimer)
+
+	// the challenge of the DN-AAA server is relayed to the UE
+	smContext.StopEAPTimer()
+	request, err := smContext.NewAccessRequest([]byte{2, 1, 0, 9, 1, 'u', 's', 'e', 'r'})
+	require.NoError(t, err)
+	authenticateByDNAAA(smContext, request)
+	waitTransfer(t, transfers)
+	require.Equal(t, "user", auth.UserName)
+	require.Equal(t, uint8(2), auth.EAPIdentifier)
+	require.NotNil(t, auth.EAPTimer)
+
+	// the DN-AAA server rejects the answer to the challenge
+	smContext.StopEAPTimer()
+	request, err = smContext.NewAccessRequest([]byte{2, 2, 0, 6, 4, 16})
+	require.NoError(t, err)
+	authenticateByDNAAA(smContext, request)
+	waitTransfer(t, transfers)
+	require.Equal(t, smf_context.NewEAPFailure(2), auth.EAPResult)
+	require.Eventually(t, func() bool { return smf_context.GetSMContextByRef(smContext.Ref) == nil },
+		time.Second, 10*time.Millisecond)
+	require.Nil(t, auth.EAPTimer)
+}
+
+func TestEAPTimerExpired(t *testing.T) {
+	defer func(timeout time.Duration) { smf_context.EAPTimeout = timeout }(smf_context.EAPTimeout)
+	smf_context.EAPTimeout = 50 * time.Millisecond
+
+	smContext := newSecondaryAuthSMContext(t)
+	transfers := newTestAMF(t, smContext)
+
+	startSecondaryAuthentication(smContext)
+	waitTransfer(t, transfers)
+
+	// the UE does not answer, the establishment is rejected
+	waitTransfer(t, transfers)
+	require.Eventually(t, func() bool { return smf_context.GetSMContextByRef(smContext.Ref) == nil },
+		time.Second, 10*time.Millisecond)
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	require.Equal(t, smf_context.InActive, smContext.SMContextState)
+	require.Equal(t, smf_context.NewEAPFailure(1), smContext.SecondaryAuth.EAPResult)
+}
+
+func TestEAPTimerStopped(t *testing.T) {
+	defer func(timeout time.Duration) { smf_context.EAPTimeout = timeout }(smf_context.EAPTimeout)
+	smf_context.EAPTimeout = 50 * time.Millisecond
+
+	smContext := newSecondaryAuthSMContext(t)
+	transfers := newTestAMF(t, smContext)
+
+	startSecondaryAuthentication(smContext)
+	waitTransfer(t, transfers)
+	smContext.StopEAPTimer()
+
+	select {
+	case <-transfers:
+		t.Fatal("establishment rejected although the UE answered")
+	case <-time.After(4 * smf_context.EAPTimeout):
+	}
+	require.NotNil(t, smf_context.GetSMContextByRef(smContext.Ref))
+	require.Equal(t, smf_context.ActivePending, smContext.SMContextState)
+}
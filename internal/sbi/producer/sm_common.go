@@ -1,11 +1,19 @@
 package producer
 
 import (
+	"time"
+
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/internal/sbi/consumer"
 )
 
+// ReleasePDUSessionHandler releases the PDU session by the network with the 5GSM cause and the
+// back-off timer, if positive, when the session timeout of the DN-AAA server expires, the
+// subscription no longer allows it or the OAM requests it. It is set when the SMF starts, the
+// network-requested release being in the association package.
+var ReleasePDUSessionHandler func(smContext *smf_context.SMContext, cause uint8, backoffTimer time.Duration)
+
 func RemoveSMContextFromAllNF(smContext *smf_context.SMContext, sendNotification bool) {
 	smContext.SMContextState = smf_context.InActive
 	logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
@@ -43,5 +51,27 @@ func sendSMContextStatusNotificationAndRemoveSMContext(smContext *smf_context.SM
 		}
 	}
 
+	deregisterFromUDM(smContext)
 	smf_context.RemoveSMContext(smContext.Ref)
 }
+
+// deregisterFromUDM removes the SDM subscription and the UECM registration of the session. The
+// registration is kept if a new SM context of the same PDU session already took it over.
+func deregisterFromUDM(smContext *smf_context.SMContext) {
+	if smContext.SdmSubscriptionID != "" {
+		if err := consumer.SendSDMUnsubscription(smContext); err != nil {
+			logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] %v", smContext.Supi, smContext.PDUSessionID, err)
+		}
+	}
+
+	if !smContext.UECMRegistered {
+		return
+	}
+	if current := smf_context.GetSMContextById(smContext.Supi, smContext.PDUSessionID); current != nil &&
+		current != smContext {
+		return
+	}
+	if err := consumer.SendUECMDeregistration(smContext); err != nil {
+		logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] %v", smContext.Supi, smContext.PDUSessionID, err)
+	}
+}
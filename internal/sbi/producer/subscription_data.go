@@ -0,0 +1,167 @@
+package producer
+
+import (
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/sbi/consumer"
+	"github.com/free5gc/smf/internal/util"
+)
+
+// applySubscriptionDataChange reads the SM subscription data of the session again after the UDM
+// notified a change of it. The session is released if the subscription no longer allows it,
+// and its session AMBR is modified if the subscribed one changed.
+func applySubscriptionDataChange(smContext *smf_context.SMContext) {
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if smf_context.GetSMContextByRef(smContext.Ref) == nil {
+		return
+	}
+	if smContext.SMContextState != smf_context.Active {
+		logger.PduSessLog.Warnf("SMContext[%s-%02d] should be Active, but actual %s",
+			smContext.Supi, smContext.PDUSessionID, smContext.SMContextState.String())
+		return
+	}
+
+	sessSubData, err := consumer.SendGetSmData(smContext, smContext.ServingNetwork)
+	if err != nil {
+		logger.PduSessLog.Errorf("UE[%s] PDUSessionID[%d] get SessionManagementSubscriptionData error: %+v",
+			smContext.Supi, smContext.PDUSessionID, err)
+		return
+	}
+
+	var dnnConfiguration *models.DnnConfiguration
+	if len(sessSubData) > 0 {
+		if config, ok := sessSubData[0].DnnConfigurations[smContext.Dnn]; ok {
+			dnnConfiguration = &config.DnnConfiguration
+		}
+	}
+
+	switch {
+	case dnnConfiguration == nil:
+		logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] DNN[%s] is no longer subscribed, release the session",
+			smContext.Supi, smContext.PDUSessionID, smContext.Dnn)
+		go ReleasePDUSessionHandler(smContext, nasMessage.Cause5GSMMissingOrUnknownDNN, 0)
+		return
+	case !sscMode1Allowed(dnnConfiguration.SscModes):
+		logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] SSC mode 1 is no longer allowed, release the session",
+			smContext.Supi, smContext.PDUSessionID)
+		go ReleasePDUSessionHandler(smContext, nasMessage.Cause5GSMNotSupportedSSCMode, 0)
+		return
+	}
+
+	if smContext.DNNInfo != nil && smContext.DNNInfo.DefaultSubscription != nil {
+		smContext.DNNInfo.DefaultSubscription.Merge(dnnConfiguration)
+	}
+	oldAmbr := smContext.DnnConfiguration.SessionAmbr
+	smContext.DnnConfiguration = *dnnConfiguration
+	if ambr := dnnConfiguration.SessionAmbr; ambr != nil && (oldAmbr == nil || *ambr != *oldAmbr) {
+		modifySessionAMBR(smContext, *ambr, nil)
+	}
+	smf_context.StoreSMContext(smContext)
+}
+
+// sscMode1Allowed reports whether the subscription allows SSC mode 1, the only mode of the sessions
+// of the SMF
+func sscMode1Allowed(sscModes *models.SscModes) bool {
+	if sscModes == nil || sscModes.DefaultSscMode == models.SscMode__1 {
+		return true
+	}
+	for _, mode := range sscModes.AllowedSscModes {
+		if mode == models.SscMode__1 {
+			return true
+		}
+	}
+	return false
+}
+
+// modifySessionAMBR enforces the new session AMBR in the UPFs and sends it to the UE and the AN with
+// the changed PCC rules, network requested PDU session modification, TS 23.502 4.3.3.2
+func modifySessionAMBR(smContext *smf_context.SMContext, ambr models.Ambr, changes *smf_context.PCCRuleChanges) {
+	sessionRule := smContext.SelectedSessionRule()
+	if sessionRule == nil {
+		return
+	}
+	logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] session AMBR changed to UL[%s] DL[%s]",
+		smContext.Supi, smContext.PDUSessionID, ambr.Uplink, ambr.Downlink)
+	sessionRule.AuthSessAmbr = &ambr
+
+	for _, res := range updateSessionQERs(smContext, func(qer *smf_context.QER) {
+		qer.MBR = &pfcpType.MBR{
+			ULMBR: util.BitRateTokbps(ambr.Uplink),
+			DLMBR: util.BitRateTokbps(ambr.Downlink),
+		}
+	}) {
+		if res.Err != nil {
+			logger.PduSessLog.Warnf("UE[%s] PDUSessionID[%d] update of the session AMBR failed: %v",
+				smContext.Supi, smContext.PDUSessionID, res.Err)
+		}
+	}
+
+	sendPDUSessionModificationCommand(smContext, changes)
+}
+
+// sendPDUSessionModificationCommand sends the PDU Session Modification Command to the UE, and the
+// PDU Session Resource Modify Request to the AN when the user plane of the session is active
+func sendPDUSessionModificationCommand(smContext *smf_context.SMContext, changes *smf_context.PCCRuleChanges) {
+	smNasBuf, err := smf_context.BuildGSMPDUSessionModificationCommand(smContext, changes)
+	if err != nil {
+		logger.PduSessLog.Errorf("Build GSM PDUSessionModificationCommand failed: %s", err)
+		return
+	}
+
+	n1n2Request := models.N1N2MessageTransferRequest{
+		BinaryDataN1Message: smNasBuf,
+		JsonData: &models.N1N2MessageTransferReqData{
+			PduSessionId: smContext.PDUSessionID,
+			N1MessageContainer: &models.N1MessageContainer{
+				N1MessageClass:   "SM",
+				N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
+			},
+		},
+	}
+	if smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
+		n2Pdu, err := smf_context.BuildPDUSessionResourceModifyRequestTransfer(smContext, changes)
+		if err != nil {
+			logger.PduSessLog.Errorf("Build PDUSessionResourceModifyRequestTransfer failed: %s", err)
+		} else {
+			n1n2Request.BinaryDataN2Information = n2Pdu
+			n1n2Request.JsonData.N2InfoContainer = &models.N2InfoContainer{
+				N2InformationClass: models.N2InformationClass_SM,
+				SmInfo: &models.N2SmInformation{
+					PduSessionId: smContext.PDUSessionID,
+					N2InfoContent: &models.N2InfoContent{
+						NgapIeType: models.NgapIeType_PDU_RES_MOD_REQ,
+						NgapData: &models.RefToBinaryData{
+							ContentId: "N2SmInformation",
+						},
+					},
+					SNssai: smContext.Snssai,
+				},
+			}
+		}
+	}
+
+	rspData, res, err := consumer.SendN1N2MessageTransfer(smContext, n1n2Request)
+	if res != nil {
+		defer func() {
+			if resCloseErr := res.Body.Close(); resCloseErr != nil {
+				logger.ConsumerLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
+			}
+		}()
+	}
+	if err != nil {
+		logger.PduSessLog.Warnf("Send N1N2Transfer failed: %v", err)
+		return
+	}
+	if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
+		logger.PduSessLog.Warnf("%v", rspData.Cause)
+	}
+}
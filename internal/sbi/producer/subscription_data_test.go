@@ -0,0 +1,129 @@
+package producer
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/Nudm_SubscriberDataManagement"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+const testUDMURI = "http://127.0.0.3:8000"
+
+// newTestUDM answers the SM subscription data requests with the DNNs and sets the SDM client of
+// the SMF to the stub UDM
+func newTestUDM(t *testing.T, dnnConfigurations map[string]models.DnnConfiguration) {
+	openapi.InterceptH2CClient()
+	t.Cleanup(func() {
+		gock.Off()
+		openapi.RestoreH2CClient()
+	})
+	gock.New(testUDMURI).Persist().
+		Get("/nudm-sdm/v1/imsi-208930000000001/sm-data").
+		Reply(http.StatusOK).
+		JSON([]models.SessionManagementSubscriptionData{{
+			SingleNssai:       &models.Snssai{Sst: 1, Sd: "010203"},
+			DnnConfigurations: dnnConfigurations,
+		}})
+
+	smfSelf := smf_context.SMF_Self()
+	t.Cleanup(func() { smfSelf.SetUDM(models.NfProfile{}, nil, nil) })
+	configuration := Nudm_SubscriberDataManagement.NewConfiguration()
+	configuration.SetBasePath(testUDMURI)
+	smfSelf.SetUDM(models.NfProfile{}, configuration, nil)
+}
+
+// newTestSMContext returns an active SM context of the DNN with the session AMBR
+func newTestSMContext(t *testing.T, dnn string, ambr models.Ambr) *smf_context.SMContext {
+	if smf_context.GetUserPlaneInformation() == nil {
+		smf_context.SMF_Self().UserPlaneInformation = &smf_context.UserPlaneInformation{}
+	}
+
+	smContext := smf_context.NewSMContext("imsi-208930000000001", 1)
+	t.Cleanup(func() { smf_context.RemoveSMContext(smContext.Ref) })
+	smContext.Supi = "imsi-208930000000001"
+	smContext.Dnn = dnn
+	smContext.Snssai = &models.Snssai{Sst: 1, Sd: "010203"}
+	smContext.SMContextState = smf_context.Active
+	smContext.Tunnel = smf_context.NewUPTunnel()
+	smContext.DnnConfiguration.SessionAmbr = &ambr
+	sessionRule := &smf_context.SessionRule{SessionRuleID: "rule-1", AuthSessAmbr: &ambr}
+	smf_context.SetSessionRuleActivateState(sessionRule, true)
+	smContext.SessionRules[sessionRule.SessionRuleID] = sessionRule
+	return smContext
+}
+
+func TestSessionAMBRQERs(t *testing.T) {
+	upf := &smf_context.UPF{
+		NodeID: pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: net.ParseIP("10.4.0.1").To4()},
+	}
+	newDataPath := func(qers ...*smf_context.QER) *smf_context.DataPath {
+		node := smf_context.NewDataPathNode()
+		node.UPF = upf
+		node.UpLinkTunnel.PDR = &smf_context.PDR{QER: qers}
+		dataPath := smf_context.NewDataPath()
+		dataPath.Activated = true
+		dataPath.FirstDPNode = node
+		return dataPath
+	}
+
+	sessionQER := &smf_context.QER{QERID: 2, SessionAMBR: true}
+	flowQER := &smf_context.QER{QERID: 3}
+	smContext := &smf_context.SMContext{Tunnel: smf_context.NewUPTunnel()}
+	smContext.Tunnel.AddDataPath(newDataPath(sessionQER, flowQER))
+	otherSession := &smf_context.SMContext{Tunnel: smf_context.NewUPTunnel()}
+	otherSession.Tunnel.AddDataPath(newDataPath(&smf_context.QER{QERID: 4, SessionAMBR: true}))
+
+	pfcpPool := sessionAMBRQERs(smContext)
+	require.Len(t, pfcpPool, 1)
+	require.Equal(t, []*smf_context.QER{sessionQER}, pfcpPool["10.4.0.1"].qerList)
+}
+
+func TestSDMChangeSessionAMBR(t *testing.T) {
+	newAmbr := models.Ambr{Uplink: "200 Mbps", Downlink: "400 Mbps"}
+	newTestUDM(t, map[string]models.DnnConfiguration{
+		"internet": {SessionAmbr: &newAmbr},
+	})
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+
+	applySubscriptionDataChange(smContext)
+	require.Equal(t, newAmbr, *smContext.DnnConfiguration.SessionAmbr)
+	require.Equal(t, newAmbr, *smContext.SelectedSessionRule().AuthSessAmbr)
+}
+
+func TestSDMChangeRelease(t *testing.T) {
+	newTestUDM(t, map[string]models.DnnConfiguration{
+		"ims": {SessionAmbr: &models.Ambr{Uplink: "1 Mbps", Downlink: "1 Mbps"}},
+	})
+	smContext := newTestSMContext(t, "internet", models.Ambr{Uplink: "100 Mbps", Downlink: "100 Mbps"})
+
+	released := make(chan uint8, 1)
+	defer func(handler func(*smf_context.SMContext, uint8, time.Duration)) { ReleasePDUSessionHandler = handler }(
+		ReleasePDUSessionHandler)
+	ReleasePDUSessionHandler = func(releasedContext *smf_context.SMContext, cause uint8, _ time.Duration) {
+		require.Equal(t, smContext, releasedContext)
+		released <- cause
+	}
+
+	applySubscriptionDataChange(smContext)
+	select {
+	case cause := <-released:
+		require.Equal(t, nasMessage.Cause5GSMMissingOrUnknownDNN, cause)
+	case <-time.After(time.Second):
+		t.Fatal("session not released")
+	}
+}
+
+func TestHandleSDMChangeNotification(t *testing.T) {
+	rsp := HandleSDMChangeNotification("urn:uuid:unknown", models.ModificationNotification{})
+	require.Equal(t, http.StatusNotFound, rsp.Status)
+}
@@ -0,0 +1,278 @@
+package producer
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/util/httpwrapper"
+)
+
+const (
+	defaultUEIPAddressLimit = 256
+	maxUEIPAddressLimit     = 4096
+)
+
+// UEIPPoolQuery filters the UE IP pools, empty fields match any pool. Detail adds the allocated
+// addresses and the free ranges of the pools, with at most Limit of the allocated addresses of
+// each pool after the first Offset ones.
+type UEIPPoolQuery struct {
+	Upf    string `form:"upf"`
+	Dnn    string `form:"dnn"`
+	Snssai string `form:"snssai"`
+	Detail bool   `form:"detail"`
+	Offset int    `form:"offset"`
+	Limit  int    `form:"limit"`
+}
+
+type UEIPPoolInfo struct {
+	Upf                string
+	Sst                string
+	Sd                 string
+	Dnn                string
+	Pool               string
+	Total              int
+	Free               int
+	Allocated          int
+	Fragments          int
+	Retired            bool
+	Static             bool
+	Alarm              string
+	AllocatedAddresses []string        `json:",omitempty"`
+	FreeRanges         []UEIPRangeInfo `json:",omitempty"`
+}
+
+type UEIPRangeInfo struct {
+	First string
+	Last  string
+}
+
+// AddUEIPPoolRequest adds the pool Cidr to the S-NSSAI and DNN served by the UPF
+type AddUEIPPoolRequest struct {
+	Upf  string `json:"upf" binding:"required"`
+	Sst  int32  `json:"sst" binding:"required"`
+	Sd   string `json:"sd,omitempty"`
+	Dnn  string `json:"dnn" binding:"required"`
+	Cidr string `json:"cidr" binding:"required"`
+}
+
+// UEIPAddressRequest is a UE IP address of the pools of the UPF
+type UEIPAddressRequest struct {
+	Upf     string `json:"upf" binding:"required"`
+	Address string `json:"address" binding:"required"`
+}
+
+func HandleOAMGetUEIPPools(query *UEIPPoolQuery) *httpwrapper.Response {
+	if query.Offset < 0 {
+		problemDetails := &models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Cause:  "INVALID_QUERY_PARAM",
+			Detail: "offset must not be negative",
+		}
+		return httpwrapper.NewResponse(http.StatusBadRequest, nil, problemDetails)
+	}
+	var snssai *models.Snssai
+	if query.Snssai != "" {
+		var err error
+		if snssai, err = parseSnssai(query.Snssai); err != nil {
+			problemDetails := &models.ProblemDetails{
+				Status: http.StatusBadRequest,
+				Cause:  "INVALID_QUERY_PARAM",
+				Detail: "invalid snssai " + query.Snssai,
+			}
+			return httpwrapper.NewResponse(http.StatusBadRequest, nil, problemDetails)
+		}
+	}
+
+	pools := make([]UEIPPoolInfo, 0)
+	for _, entry := range context.GetUserPlaneInformation().UEIPPools() {
+		switch {
+		case query.Upf != "" && query.Upf != entry.UPF,
+			query.Dnn != "" && query.Dnn != entry.Dnn,
+			snssai != nil && snssai.Sst != entry.SNssai.Sst,
+			snssai != nil && snssai.Sd != "" && snssai.Sd != entry.SNssai.Sd:
+			continue
+		}
+		info := ueIPPoolInfo(entry)
+		if query.Detail {
+			addUEIPPoolDetail(&info, entry.Pool, query)
+		}
+		pools = append(pools, info)
+	}
+	return httpwrapper.NewResponse(http.StatusOK, nil, pools)
+}
+
+func HandleOAMAddUEIPPool(request *AddUEIPPoolRequest) *httpwrapper.Response {
+	snssai := &context.SNssai{Sst: request.Sst, Sd: request.Sd}
+	upi := context.GetUserPlaneInformation()
+	if err := upi.AddUEIPPool(request.Upf, snssai, request.Dnn, request.Cidr); err != nil {
+		logger.AppLog.Warnf("Add UE IP pool %s to UPF[%s] failed: %v", request.Cidr, request.Upf, err)
+		problemDetails := ueIPPoolProblem(err)
+		return httpwrapper.NewResponse(int(problemDetails.Status), nil, problemDetails)
+	}
+
+	_, ipNet, _ := net.ParseCIDR(request.Cidr)
+	for _, entry := range upi.UEIPPools() {
+		if entry.UPF == request.Upf && entry.Pool.Subnet().String() == ipNet.String() {
+			return httpwrapper.NewResponse(http.StatusCreated, nil, ueIPPoolInfo(entry))
+		}
+	}
+	return httpwrapper.NewResponse(http.StatusCreated, nil, nil)
+}
+
+// HandleOAMRetireUEIPPool retires the pool, which is removed at once if none of its addresses is
+// allocated (204) or when the last one is released (202)
+func HandleOAMRetireUEIPPool(upf, pool string) *httpwrapper.Response {
+	removed, err := context.GetUserPlaneInformation().RetireUEIPPool(upf, pool)
+	if err != nil {
+		problemDetails := ueIPPoolProblem(err)
+		return httpwrapper.NewResponse(int(problemDetails.Status), nil, problemDetails)
+	}
+	if removed {
+		return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+	}
+	return httpwrapper.NewResponse(http.StatusAccepted, nil, nil)
+}
+
+// HandleOAMReserveUEIP allocates the address, which is then not assigned to any UE until it is
+// released
+func HandleOAMReserveUEIP(request *UEIPAddressRequest) *httpwrapper.Response {
+	upNode, addr, problemDetails := ueIPOfPools(request)
+	if problemDetails != nil {
+		return httpwrapper.NewResponse(int(problemDetails.Status), nil, problemDetails)
+	}
+
+	if !context.GetUserPlaneInformation().ReserveUEIP(upNode, addr) {
+		problemDetails = &models.ProblemDetails{
+			Status: http.StatusConflict,
+			Cause:  "UE_IP_ALREADY_ALLOCATED",
+			Detail: "UE IP address " + request.Address + " is already allocated",
+		}
+		return httpwrapper.NewResponse(http.StatusConflict, nil, problemDetails)
+	}
+	logger.AppLog.Infof("OAM reserved UE IP address %s of UPF[%s]", request.Address, request.Upf)
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
+// HandleOAMReleaseUEIP releases the address, unless it is assigned to a PDU session
+func HandleOAMReleaseUEIP(request *UEIPAddressRequest) *httpwrapper.Response {
+	upNode, addr, problemDetails := ueIPOfPools(request)
+	if problemDetails != nil {
+		return httpwrapper.NewResponse(int(problemDetails.Status), nil, problemDetails)
+	}
+
+	for _, smContext := range context.ListSMContexts() {
+		if smContext.SelectedUPF == upNode && addr.Equal(smContext.PDUAddress) {
+			problemDetails = &models.ProblemDetails{
+				Status: http.StatusConflict,
+				Cause:  "UE_IP_IN_USE",
+				Detail: "UE IP address " + request.Address + " is assigned to " + smContext.Ref,
+			}
+			return httpwrapper.NewResponse(http.StatusConflict, nil, problemDetails)
+		}
+	}
+	upi := context.GetUserPlaneInformation()
+	for _, entry := range upi.UEIPPools() {
+		if entry.UPF == request.Upf && entry.Pool.Subnet().Contains(addr) && !entry.Pool.IsAllocated(addr) {
+			problemDetails = &models.ProblemDetails{
+				Status: http.StatusNotFound,
+				Cause:  "UE_IP_NOT_ALLOCATED",
+				Detail: "UE IP address " + request.Address + " is not allocated",
+			}
+			return httpwrapper.NewResponse(http.StatusNotFound, nil, problemDetails)
+		}
+	}
+
+	upi.ReleaseUEIP(upNode, addr)
+	logger.AppLog.Infof("OAM released UE IP address %s of UPF[%s]", request.Address, request.Upf)
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
+// ueIPOfPools returns the UPF of the request and its address, which is in one of the UE IP pools
+// of the UPF
+func ueIPOfPools(request *UEIPAddressRequest) (*context.UPNode, net.IP, *models.ProblemDetails) {
+	addr := net.ParseIP(request.Address).To4()
+	if addr == nil {
+		return nil, nil, &models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Cause:  "INVALID_MSG_FORMAT",
+			Detail: "invalid IPv4 address " + request.Address,
+		}
+	}
+
+	upi := context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	upNode, ok := upi.UPFs[request.Upf]
+	upi.Mu.RUnlock()
+	if !ok {
+		return nil, nil, ueIPPoolProblem(context.ErrUPFNotFound)
+	}
+	for _, entry := range upi.UEIPPools() {
+		if entry.UPF == request.Upf && entry.Pool.Subnet().Contains(addr) {
+			return upNode, addr, nil
+		}
+	}
+	return nil, nil, ueIPPoolProblem(context.ErrUEIPPoolNotFound)
+}
+
+func ueIPPoolProblem(err error) *models.ProblemDetails {
+	problemDetails := &models.ProblemDetails{Detail: err.Error()}
+	switch {
+	case errors.Is(err, context.ErrUPFNotFound),
+		errors.Is(err, context.ErrUEIPPoolNotFound),
+		errors.Is(err, context.ErrSnssaiDnnNotServed):
+		problemDetails.Status = http.StatusNotFound
+		problemDetails.Cause = "RESOURCE_NOT_FOUND"
+	case errors.Is(err, context.ErrUEIPPoolOverlap):
+		problemDetails.Status = http.StatusConflict
+		problemDetails.Cause = "UE_IP_POOL_OVERLAP"
+	default:
+		problemDetails.Status = http.StatusBadRequest
+		problemDetails.Cause = "INVALID_MSG_FORMAT"
+	}
+	return problemDetails
+}
+
+func ueIPPoolInfo(entry context.UEIPPoolEntry) UEIPPoolInfo {
+	pool := entry.Pool
+	info := UEIPPoolInfo{
+		Upf:       entry.UPF,
+		Sst:       strconv.Itoa(int(entry.SNssai.Sst)),
+		Sd:        entry.SNssai.Sd,
+		Dnn:       entry.Dnn,
+		Pool:      pool.Subnet().String(),
+		Total:     pool.Total(),
+		Free:      pool.Free(),
+		Allocated: pool.Total() - pool.Free(),
+		Fragments: pool.Fragments(),
+		Retired:   pool.Retired(),
+		Static:    entry.Static,
+		Alarm:     pool.AlarmLevel().String(),
+	}
+	return info
+}
+
+// addUEIPPoolDetail adds the page of the allocated addresses of the query and the free ranges
+// of the pool to its info
+func addUEIPPoolDetail(info *UEIPPoolInfo, pool *context.UeIPPool, query *UEIPPoolQuery) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultUEIPAddressLimit
+	} else if limit > maxUEIPAddressLimit {
+		limit = maxUEIPAddressLimit
+	}
+	info.AllocatedAddresses = make([]string, 0)
+	for _, addr := range pool.AllocatedAddresses(query.Offset, limit) {
+		info.AllocatedAddresses = append(info.AllocatedAddresses, addr.String())
+	}
+	for _, freeRange := range pool.FreeRanges() {
+		info.FreeRanges = append(info.FreeRanges, UEIPRangeInfo{
+			First: freeRange.First.String(),
+			Last:  freeRange.Last.String(),
+		})
+	}
+}
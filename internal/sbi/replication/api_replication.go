@@ -0,0 +1,34 @@
+package replication
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/sbi/producer"
+)
+
+func HTTPGetReplicationSnapshot(c *gin.Context) {
+	HTTPResponse := producer.HandleGetReplicationSnapshot()
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPGetReplicationChanges(c *gin.Context) {
+	after, err := strconv.ParseUint(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil {
+		problemDetails := models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Cause:  "INVALID_QUERY_PARAM",
+			Detail: err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, problemDetails)
+		return
+	}
+
+	HTTPResponse := producer.HandleGetReplicationChanges(after)
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
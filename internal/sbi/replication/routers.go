@@ -0,0 +1,93 @@
+package replication
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	logger_util "github.com/free5gc/util/logger"
+)
+
+// Route is the information for every URI.
+type Route struct {
+	// Name is the name of this Route.
+	Name string
+	// Method is the string for the HTTP method. ex) GET, POST etc..
+	Method string
+	// Pattern is the pattern of the URI.
+	Pattern string
+	// HandlerFunc is the handler function of this route.
+	HandlerFunc gin.HandlerFunc
+}
+
+// Routes is the list of the generated Route.
+type Routes []Route
+
+// NewRouter returns a new router.
+func NewRouter() *gin.Engine {
+	router := logger_util.NewGinWithLogrus(logger.GinLog)
+	AddService(router)
+	return router
+}
+
+func AddService(engine *gin.Engine) *gin.RouterGroup {
+	group := engine.Group("/nsmf-replication/v1")
+	group.Use(checkReplicationSecret)
+
+	for _, route := range routes {
+		switch route.Method {
+		case "GET":
+			group.GET(route.Pattern, route.HandlerFunc)
+		}
+	}
+	return group
+}
+
+// checkReplicationSecret lets only the standby SMF, presenting the shared secret of the pair as
+// a bearer token, read the sessions
+func checkReplicationSecret(c *gin.Context) {
+	secret := smf_context.SMF_Self().ReplicationSecret
+	auth := c.Request.Header.Get("Authorization")
+	if secret != "" && strings.HasPrefix(auth, "Bearer ") &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(secret)) == 1 {
+		return
+	}
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	c.JSON(http.StatusUnauthorized, models.ProblemDetails{
+		Title:  "Unauthorized",
+		Status: http.StatusUnauthorized,
+		Detail: "the replication secret is missing or wrong",
+	})
+	c.Abort()
+}
+
+// Index is the index handler.
+func Index(c *gin.Context) {
+	c.String(http.StatusOK, "Hello World!")
+}
+
+var routes = Routes{
+	{
+		"Index",
+		"GET",
+		"/",
+		Index,
+	},
+	{
+		"Get Replication Snapshot",
+		"GET",
+		"/snapshot",
+		HTTPGetReplicationSnapshot,
+	},
+	{
+		"Get Replication Changes",
+		"GET",
+		"/changes",
+		HTTPGetReplicationChanges,
+	},
+}
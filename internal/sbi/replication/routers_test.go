@@ -0,0 +1,40 @@
+package replication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestReplicationSecret(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	defer func(secret string) { smfSelf.ReplicationSecret = secret }(smfSelf.ReplicationSecret)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	AddService(router)
+	getSnapshot := func(auth string) int {
+		rsp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/nsmf-replication/v1/snapshot", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		router.ServeHTTP(rsp, req)
+		return rsp.Code
+	}
+
+	// no secret configured
+	smfSelf.ReplicationSecret = ""
+	require.Equal(t, http.StatusUnauthorized, getSnapshot("Bearer "))
+
+	smfSelf.ReplicationSecret = "secret"
+	require.Equal(t, http.StatusUnauthorized, getSnapshot(""))
+	require.Equal(t, http.StatusUnauthorized, getSnapshot("Bearer wrong"))
+	// the secret is accepted, no replicated store is set
+	require.Equal(t, http.StatusNotFound, getSnapshot("Bearer secret"))
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/free5gc/pfcp/pfcpType"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/pkg/association"
 	"github.com/free5gc/smf/pkg/factory"
@@ -80,3 +81,121 @@ func DeleteUpNodeLink(c *gin.Context) {
 		}
 	}
 }
+
+// MaintenanceRequest is the body of a request to put a UPF into maintenance mode
+type MaintenanceRequest struct {
+	// PDU sessions released per second, the configured rate is used if absent
+	ReleaseRate *int `json:"releaseRate,omitempty"`
+}
+
+type MaintenanceStatus struct {
+	Maintenance bool `json:"maintenance"`
+	Draining    bool `json:"draining"`
+	Associated  bool `json:"associated"`
+	// active PDU sessions left on the UPF
+	Sessions int `json:"sessions"`
+}
+
+func upfOfUpNodeRef(c *gin.Context) *smf_context.UPF {
+	upi := smf_context.SMF_Self().UserPlaneInformation
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	upNode, ok := upi.UPNodes[c.Params.ByName("upNodeRef")]
+	if !ok || upNode.Type != smf_context.UPNODE_UPF {
+		return nil
+	}
+	return upNode.UPF
+}
+
+func GetUpNodeMaintenance(c *gin.Context) {
+	upf := upfOfUpNodeRef(c)
+	if upf == nil {
+		c.JSON(http.StatusNotFound, gin.H{})
+		return
+	}
+
+	smContexts, pending := upf.SMContextsToDrain()
+	c.JSON(http.StatusOK, &MaintenanceStatus{
+		Maintenance: upf.InMaintenance(),
+		Draining:    upf.Draining(),
+		Associated:  upf.UPFStatus == smf_context.AssociatedSetUpSuccess,
+		Sessions:    len(smContexts) + pending,
+	})
+}
+
+func PostUpNodeMaintenance(c *gin.Context) {
+	upf := upfOfUpNodeRef(c)
+	if upf == nil {
+		c.JSON(http.StatusNotFound, gin.H{})
+		return
+	}
+
+	var json MaintenanceRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&json); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	rate := smf_context.SMF_Self().MaintenanceReleaseRate
+	if json.ReleaseRate != nil {
+		if *json.ReleaseRate < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "releaseRate must not be negative"})
+			return
+		}
+		rate = *json.ReleaseRate
+	}
+
+	if !association.StartMaintenance(upf, rate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "UPF is already in maintenance mode"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": "OK"})
+}
+
+func DeleteUpNodeMaintenance(c *gin.Context) {
+	upf := upfOfUpNodeRef(c)
+	if upf == nil {
+		c.JSON(http.StatusNotFound, gin.H{})
+		return
+	}
+
+	association.StopMaintenance(upf)
+	c.JSON(http.StatusOK, gin.H{"status": "OK"})
+}
+
+// CPFunctionFeatures is the body of the features of the SMF advertised to the UPFs
+type CPFunctionFeatures struct {
+	// "LOAD" and "OVRL", TS 29.244 8.2.58
+	Features []string `json:"features"`
+}
+
+func GetCPFunctionFeatures(c *gin.Context) {
+	supportedFeatures := smf_context.SMF_Self().CPFunctionFeatures()
+	features := []string{}
+	if supportedFeatures&pfcpType.CpFunctionFeaturesLoad != 0 {
+		features = append(features, factory.CPFunctionFeatureLoad)
+	}
+	if supportedFeatures&pfcpType.CpFunctionFeaturesOvrl != 0 {
+		features = append(features, factory.CPFunctionFeatureOvrl)
+	}
+	c.JSON(http.StatusOK, &CPFunctionFeatures{Features: features})
+}
+
+func PutCPFunctionFeatures(c *gin.Context) {
+	var json CPFunctionFeatures
+	if err := c.ShouldBindJSON(&json); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, feature := range json.Features {
+		if feature != factory.CPFunctionFeatureLoad && feature != factory.CPFunctionFeatureOvrl {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "features must be LOAD or OVRL"})
+			return
+		}
+	}
+
+	association.UpdateCPFunctionFeatures(smf_context.CPFunctionFeaturesOf(json.Features))
+	c.JSON(http.StatusOK, gin.H{"status": "OK"})
+}
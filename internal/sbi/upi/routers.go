@@ -41,6 +41,8 @@ func AddService(engine *gin.Engine) *gin.RouterGroup {
 			group.GET(route.Pattern, route.HandlerFunc)
 		case "POST":
 			group.POST(route.Pattern, route.HandlerFunc)
+		case "PUT":
+			group.PUT(route.Pattern, route.HandlerFunc)
 		case "DELETE":
 			group.DELETE(route.Pattern, route.HandlerFunc)
 		}
@@ -78,4 +80,34 @@ var routes = Routes{
 		"/upNodesLinks/:upNodeRef",
 		DeleteUpNodeLink,
 	},
+	{
+		"GetUpNodeMaintenance",
+		strings.ToUpper("Get"),
+		"/upNodesLinks/:upNodeRef/maintenance",
+		GetUpNodeMaintenance,
+	},
+	{
+		"StartUpNodeMaintenance",
+		strings.ToUpper("Post"),
+		"/upNodesLinks/:upNodeRef/maintenance",
+		PostUpNodeMaintenance,
+	},
+	{
+		"StopUpNodeMaintenance",
+		strings.ToUpper("Delete"),
+		"/upNodesLinks/:upNodeRef/maintenance",
+		DeleteUpNodeMaintenance,
+	},
+	{
+		"GetCPFunctionFeatures",
+		strings.ToUpper("Get"),
+		"/cpFunctionFeatures",
+		GetCPFunctionFeatures,
+	},
+	{
+		"UpdateCPFunctionFeatures",
+		strings.ToUpper("Put"),
+		"/cpFunctionFeatures",
+		PutCPFunctionFeatures,
+	},
 }
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi/models"
+)
+
+// ErrInsufficientScope is wrapped by the NFContext when the access token does not grant the
+// service, to answer 403 rather than 401
+var ErrInsufficientScope = errors.New("insufficient scope")
+
+// NFContext verifies the access tokens of the requests to the services of the NF
+type NFContext interface {
+	AuthorizationCheck(token string, serviceName models.ServiceName) error
+}
+
+// RouterAuthorizationCheck checks the OAuth2 access token of the requests to a service,
+// RFC 6750
+type RouterAuthorizationCheck struct {
+	serviceName models.ServiceName
+}
+
+func NewRouterAuthorizationCheck(serviceName models.ServiceName) *RouterAuthorizationCheck {
+	return &RouterAuthorizationCheck{
+		serviceName: serviceName,
+	}
+}
+
+func (rac *RouterAuthorizationCheck) Check(c *gin.Context, nfContext NFContext) {
+	token := ""
+	if auth := c.Request.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	err := nfContext.AuthorizationCheck(token, rac.serviceName)
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, ErrInsufficientScope):
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, rac.serviceName))
+		c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+	default:
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+	}
+	c.Abort()
+}
@@ -2,6 +2,7 @@ package association
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -15,8 +16,18 @@ import (
 	"github.com/free5gc/smf/internal/pfcp/message"
 	"github.com/free5gc/smf/internal/sbi/consumer"
 	"github.com/free5gc/smf/internal/sbi/producer"
+	"github.com/free5gc/smf/pkg/factory"
 )
 
+var errUPFRestarted = errors.New("UPF restarted")
+
+// interval at which a draining UPF is checked for PDU sessions being established
+const drainPendingInterval = time.Second
+
+// time given to the PDU sessions of a draining UPF to be released after the planned end of the
+// drain, before the remaining ones are removed
+const drainGracePeriod = 30 * time.Second
+
 func ToBeAssociatedWithUPF(ctx context.Context, upf *smf_context.UPF) {
 	var upfStr string
 	if upf.NodeID.NodeIdType == pfcpType.NodeIdTypeFqdn {
@@ -25,25 +36,43 @@ func ToBeAssociatedWithUPF(ctx context.Context, upf *smf_context.UPF) {
 		upfStr = fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
 	}
 
+	restarted := false
+	staleCSIDs := make([]uint16, 0)
 	for {
 		ensureSetupPfcpAssociation(ctx, upf, upfStr)
 		if isDone(ctx, upf) {
 			break
 		}
 
+		if len(staleCSIDs) != 0 {
+			deleteSessionSetsOfUPF(upf, upfStr, staleCSIDs)
+			staleCSIDs = staleCSIDs[:0]
+		}
+
+		if restarted {
+			restoreAllSessionsOfUPF(upf, upfStr)
+			if isDone(ctx, upf) {
+				break
+			}
+		}
+
 		if smf_context.SMF_Self().PfcpHeartbeatInterval == 0 {
 			return
 		}
 
-		keepHeartbeatTo(ctx, upf, upfStr)
-		// return when UPF heartbeat lost is detected or association is canceled
+		// return when UPF heartbeat lost or UPF restart is detected or association is canceled
+		restarted = keepHeartbeatTo(ctx, upf, upfStr)
 		if isDone(ctx, upf) {
 			break
 		}
 
-		releaseAllResourcesOfUPF(upf, upfStr)
-		if isDone(ctx, upf) {
-			break
+		if !restarted {
+			// the UPF may still hold the PFCP sessions, delete them once it is associated again
+			staleCSIDs = append(staleCSIDs, upf.RenewSMFCSID())
+			releaseAllResourcesOfUPF(upf, upfStr)
+			if isDone(ctx, upf) {
+				break
+			}
 		}
 	}
 }
@@ -75,6 +104,17 @@ func ensureSetupPfcpAssociation(ctx context.Context, upf *smf_context.UPF, upfSt
 	retryInterval := smf_context.SMF_Self().AssociationSetupFailedRetryInterval
 	for {
 		timer := time.After(retryInterval)
+		if upf.InMaintenance() {
+			logger.AppLog.Debugf("UPF%s is in maintenance mode, wait %+v until next attempt", upfStr, retryInterval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-upf.Ctx.Done():
+				return
+			case <-timer:
+				continue
+			}
+		}
 		err := setupPfcpAssociation(upf, upfStr)
 		if err == nil {
 			return
@@ -103,7 +143,10 @@ func ensureSetupPfcpAssociation(ctx context.Context, upf *smf_context.UPF, upfSt
 func setupPfcpAssociation(upf *smf_context.UPF, upfStr string) error {
 	logger.AppLog.Infof("Sending PFCP Association Request to UPF%s", upfStr)
 
-	resMsg, err := message.SendPfcpAssociationSetupRequest(upf.NodeID)
+	// the UPF keeps the PFCP sessions the SMF still has on it, restored after the SMF restarted
+	retainSessions := false
+	upf.ProcEachSMContextWithPFCPSession(func(*smf_context.SMContext) { retainSessions = true })
+	resMsg, err := message.SendPfcpAssociationSetupRequest(upf.NodeID, retainSessions)
 	if err != nil {
 		return err
 	}
@@ -122,6 +165,16 @@ func setupPfcpAssociation(upf *smf_context.UPF, upfStr string) error {
 	logger.AppLog.Infof("Received PFCP Association Setup Accepted Response from UPF%s", upfStr)
 
 	upf.UPFStatus = smf_context.AssociatedSetUpSuccess
+	// a gracefully released UPF is available again once it is associated again
+	upf.StopDrain()
+
+	if rsp.UPFunctionFeatures != nil {
+		upf.UPFunctionFeatures = rsp.UPFunctionFeatures.SupportedFeatures
+	}
+	// a newer time stamp in a later Heartbeat or Association Setup Request means a restart
+	if rsp.RecoveryTimeStamp != nil {
+		upf.RecoveryTimeStamp = rsp.RecoveryTimeStamp.RecoveryTimeStamp
+	}
 
 	if rsp.UserPlaneIPResourceInformation != nil {
 		upf.UPIPInfo = *rsp.UserPlaneIPResourceInformation
@@ -133,22 +186,23 @@ func setupPfcpAssociation(upf *smf_context.UPF, upfStr string) error {
 	return nil
 }
 
-func keepHeartbeatTo(ctx context.Context, upf *smf_context.UPF, upfStr string) {
+// keepHeartbeatTo returns true if it stopped because the UPF has restarted
+func keepHeartbeatTo(ctx context.Context, upf *smf_context.UPF, upfStr string) bool {
 	for {
 		err := doPfcpHeartbeat(upf, upfStr)
 		if err != nil {
 			logger.AppLog.Errorf("PFCP Heartbeat error: %v", err)
-			return
+			return errors.Is(err, errUPFRestarted)
 		}
 
 		timer := time.After(smf_context.SMF_Self().PfcpHeartbeatInterval)
 		select {
 		case <-ctx.Done():
 			logger.AppLog.Infof("Canceled Heartbeat with UPF%s", upfStr)
-			return
+			return false
 		case <-upf.Ctx.Done():
 			logger.AppLog.Infof("Canceled Heartbeat to this UPF%s only", upfStr)
-			return
+			return false
 		case <-timer:
 			continue
 		}
@@ -183,7 +237,7 @@ func doPfcpHeartbeat(upf *smf_context.UPF, upfStr string) error {
 		// received a newer recovery timestamp
 		upf.UPFStatus = smf_context.NotAssociated
 		upf.RecoveryTimeStamp = time.Time{}
-		return fmt.Errorf("received PFCP Heartbeat Response RecoveryTimeStamp has been updated")
+		return fmt.Errorf("received PFCP Heartbeat Response RecoveryTimeStamp has been updated: %w", errUPFRestarted)
 	}
 	return nil
 }
@@ -196,28 +250,344 @@ func releaseAllResourcesOfUPF(upf *smf_context.UPF, upfStr string) {
 		defer smContext.SMLock.Unlock()
 		switch smContext.SMContextState {
 		case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+			releaseSMContext(smContext, nasMessage.Cause5GSMNetworkFailure, 0)
+		}
+	})
+}
+
+// RestoreSessionsOfUPF restores the PDU sessions of an associated UPF which restarted, once
+// it set up the association again
+func RestoreSessionsOfUPF(upf *smf_context.UPF) {
+	var upfStr string
+	if upf.NodeID.NodeIdType == pfcpType.NodeIdTypeFqdn {
+		upfStr = fmt.Sprintf("[%s](%s)", upf.NodeID.FQDN, upf.NodeID.ResolveNodeIdToIp().String())
+	} else {
+		upfStr = fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
+	}
+	restoreAllSessionsOfUPF(upf, upfStr)
+}
+
+// restoreAllSessionsOfUPF handles the PDU sessions of a restarted UPF once it is associated
+// again: depending on the restoration policy of their DNN, the PFCP sessions are created
+// again on the UPF or the PDU sessions are released
+func restoreAllSessionsOfUPF(upf *smf_context.UPF, upfStr string) {
+	smContexts := make([]*smf_context.SMContext, 0)
+	upf.ProcEachSMContextWithPFCPSession(func(smContext *smf_context.SMContext) {
+		smContexts = append(smContexts, smContext)
+	})
+
+	logger.AppLog.Infof("Restore %d PDU sessions of restarted UPF%s", len(smContexts), upfStr)
+	restoration := smf_context.NewUPFRestoration(upf, len(smContexts))
+	for _, smContext := range smContexts {
+		restoreSessionOfUPF(smContext, upf, restoration)
+	}
+	restoration.Complete()
+	logger.AppLog.Infof("Restoration of UPF%s completed: %d reestablished, %d released, %d failed",
+		upfStr, restoration.Reestablished, restoration.Released, restoration.Failed)
+}
+
+func restoreSessionOfUPF(smContext *smf_context.SMContext, upf *smf_context.UPF,
+	restoration *smf_context.UPFRestoration,
+) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	switch smContext.SMContextState {
+	case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+	default:
+		// the session is being established or released
+		restoration.AddFailed()
+		return
+	}
+
+	if smContext.DNNInfo != nil && smContext.DNNInfo.UPFRestoration == factory.UPFRestorationReestablish {
+		err := producer.ReestablishPfcpSession(smContext, upf)
+		if err == nil {
+			restoration.AddReestablished()
+			return
+		}
+		logger.AppLog.Warnf("Reestablish PFCP session of UE[%s] PDUSessionID[%d] failed, release it: %v",
+			smContext.Supi, smContext.PDUSessionID, err)
+	}
+
+	releaseSMContext(smContext, nasMessage.Cause5GSMNetworkFailure, 0)
+	restoration.AddReleased()
+}
+
+// deleteSessionSetsOfUPF requests the UPF to delete the PFCP sessions of the sets identified
+// by the CSIDs of the SMF, after the SMF released the PDU sessions without deleting them
+func deleteSessionSetsOfUPF(upf *smf_context.UPF, upfStr string, csids []uint16) {
+	logger.AppLog.Infof("Sending PFCP Session Set Deletion Request to UPF%s, CSIDs %v", upfStr, csids)
+
+	fqcsid := smf_context.NewFQCSID(smf_context.SMF_Self().CPNodeID.ResolveNodeIdToIp(), csids...)
+	resMsg, err := message.SendPfcpSessionSetDeletionRequest(upf, []*smf_context.FQCSID{fqcsid})
+	if err != nil {
+		logger.AppLog.Warnf("Send PFCP Session Set Deletion Request to UPF%s failed: %v", upfStr, err)
+		return
+	}
+
+	rsp := resMsg.PfcpMessage.Body.(pfcp.PFCPSessionSetDeletionResponse)
+	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		logger.AppLog.Warnf("Received PFCP Session Set Deletion Not Accepted Response from UPF%s", upfStr)
+		return
+	}
+	logger.AppLog.Infof("Received PFCP Session Set Deletion Accepted Response from UPF%s", upfStr)
+}
+
+// ReleasePDUSessions releases the PDU sessions affected by a partial failure of the user
+// plane, TS 23.007. Their PFCP sessions are deleted first, as the UPFs may still hold them.
+func ReleasePDUSessions(smContexts []*smf_context.SMContext) {
+	for _, smContext := range smContexts {
+		releasePDUSession(smContext, nasMessage.Cause5GSMNetworkFailure, 0)
+	}
+}
+
+// ReleasePDUSessionsWithCause releases the PDU sessions by the network, TS 23.502 4.3.4.2,
+// with the 5GSM cause and the back-off timer, if positive, in the PDU Session Release Command
+func ReleasePDUSessionsWithCause(smContexts []*smf_context.SMContext, cause uint8, backoffTimer time.Duration) {
+	for _, smContext := range smContexts {
+		releasePDUSession(smContext, cause, backoffTimer)
+	}
+}
+
+// DrainUPF stops placing new PDU sessions on the UPF and releases its PDU sessions, spread
+// over the period, asking the UEs to reactivate them so that they move to other UPFs. With
+// releaseAssociation, the PFCP association with the UPF is released once it is drained.
+func DrainUPF(upf *smf_context.UPF, period time.Duration, releaseAssociation bool) {
+	upfStr := fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
+	if !upf.StartDrain(releaseAssociation) {
+		logger.AppLog.Infof("UPF%s is already draining", upfStr)
+		return
+	}
+
+	var interval time.Duration
+	if smContexts, _ := upf.SMContextsToDrain(); len(smContexts) != 0 {
+		interval = period / time.Duration(len(smContexts))
+	}
+	logger.AppLog.Infof("Drain PDU sessions of UPF%s in %v", upfStr, period)
+	drainUPF(upf, upfStr, interval, time.Now().Add(period+drainGracePeriod))
+}
+
+// StartMaintenance puts the UPF into maintenance mode, and releases its PDU sessions at the
+// rate, in sessions per second, before releasing its PFCP association. The UPF is associated
+// again once StopMaintenance is called.
+func StartMaintenance(upf *smf_context.UPF, rate int) bool {
+	upfStr := fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
+	if !upf.StartMaintenance() {
+		return false
+	}
+
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Second / time.Duration(rate)
+	}
+	smContexts, _ := upf.SMContextsToDrain()
+	deadline := time.Now().Add(time.Duration(len(smContexts))*interval + drainGracePeriod)
+	logger.AppLog.Infof("UPF%s enters maintenance mode, release %d PDU sessions per second", upfStr, rate)
+	go drainUPF(upf, upfStr, interval, deadline)
+	return true
+}
+
+func StopMaintenance(upf *smf_context.UPF) {
+	logger.AppLog.Infof("UPF[%s] leaves maintenance mode", upf.NodeID.ResolveNodeIdToIp().String())
+	upf.StopMaintenance()
+}
+
+// drainUPF releases the PDU sessions of the UPF one per interval, until none is left. The
+// sessions still left at the deadline, e.g. stuck in their establishment, are removed.
+func drainUPF(upf *smf_context.UPF, upfStr string, interval time.Duration, deadline time.Time) {
+	first := true
+	for {
+		smContexts, pending := upf.SMContextsToDrain()
+		if len(smContexts) == 0 && pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			removeDrainedPDUSessions(upf, upfStr)
+			break
+		}
+		if len(smContexts) == 0 {
+			// wait for the PDU sessions being established, to release them once active
+			if !waitDrain(upf, upfStr, drainPendingInterval) {
+				return
+			}
+			continue
+		}
 
-			needToSendNotify, removeContext := requestAMFToReleasePDUResources(smContext)
-			if needToSendNotify {
-				sendReleaseNotification(smContext)
+		logger.AppLog.Infof("Release %d PDU sessions of draining UPF%s", len(smContexts), upfStr)
+		for _, smContext := range smContexts {
+			if !first && interval != 0 && !waitDrain(upf, upfStr, interval) {
+				return
 			}
-			if removeContext {
-				// Notification has already been sent, if it is needed
-				producer.RemoveSMContextFromAllNF(smContext, false)
+			first = false
+			if !upf.Draining() {
+				logger.AppLog.Infof("Drain of UPF%s stopped", upfStr)
+				return
 			}
+			releasePDUSession(smContext, nasMessage.Cause5GSMReactivationRequested, 0)
 		}
+	}
+	logger.AppLog.Infof("UPF%s drained", upfStr)
+
+	if upf.ReleaseAssociationAfterDrain() {
+		releasePfcpAssociation(upf, upfStr)
+	}
+}
+
+// removeDrainedPDUSessions removes the PDU sessions left on a UPF whose drain is over, whatever
+// their state, and notifies the AMFs
+func removeDrainedPDUSessions(upf *smf_context.UPF, upfStr string) {
+	smContexts := make([]*smf_context.SMContext, 0)
+	upf.ProcEachSMContextWithPFCPSession(func(smContext *smf_context.SMContext) {
+		smContexts = append(smContexts, smContext)
 	})
+	logger.AppLog.Warnf("Drain of UPF%s timed out, remove its %d remaining PDU sessions", upfStr, len(smContexts))
+
+	for _, smContext := range smContexts {
+		smContext.SMLock.Lock()
+		if smContext.SMContextState != smf_context.InActive &&
+			smf_context.GetSMContextByRef(smContext.Ref) != nil {
+			logger.AppLog.Infof("Remove PDU session of UE[%s] PDUSessionID[%d]", smContext.Supi, smContext.PDUSessionID)
+			producer.ReleaseTunnel(smContext)
+			producer.RemoveSMContextFromAllNF(smContext, true)
+		}
+		smContext.SMLock.Unlock()
+	}
 }
 
-func requestAMFToReleasePDUResources(smContext *smf_context.SMContext) (sendNotify bool, releaseContext bool) {
+// waitDrain returns false if the drain of the UPF has been stopped or canceled meanwhile
+func waitDrain(upf *smf_context.UPF, upfStr string, d time.Duration) bool {
+	select {
+	case <-upf.Ctx.Done():
+		logger.AppLog.Infof("Canceled drain of UPF%s", upfStr)
+		return false
+	case <-time.After(d):
+	}
+	if !upf.Draining() {
+		logger.AppLog.Infof("Drain of UPF%s stopped", upfStr)
+		return false
+	}
+	return true
+}
+
+func releasePfcpAssociation(upf *smf_context.UPF, upfStr string) {
+	logger.AppLog.Infof("Sending PFCP Association Release Request to UPF%s", upfStr)
+
+	// the association is set up again once the UPF accepts it
+	upf.UPFStatus = smf_context.NotAssociated
+	upf.RecoveryTimeStamp = time.Time{}
+
+	resMsg, err := message.SendPfcpAssociationReleaseRequest(upf.NodeID)
+	if err != nil {
+		logger.AppLog.Warnf("Send PFCP Association Release Request to UPF%s failed: %v", upfStr, err)
+		return
+	}
+
+	rsp := resMsg.PfcpMessage.Body.(pfcp.PFCPAssociationReleaseResponse)
+	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		logger.AppLog.Warnf("Received PFCP Association Release Not Accepted Response from UPF%s", upfStr)
+		return
+	}
+	logger.AppLog.Infof("Received PFCP Association Release Accepted Response from UPF%s", upfStr)
+}
+
+// UpdateCPFunctionFeatures changes the features supported by the SMF, and informs the
+// associated UPFs with a PFCP Association Update
+func UpdateCPFunctionFeatures(features uint8) {
+	smfSelf := smf_context.SMF_Self()
+	if !smfSelf.SetCPFunctionFeatures(features) {
+		return
+	}
+
+	upfs := make([]*smf_context.UPF, 0)
+	upi := smfSelf.UserPlaneInformation
+	upi.Mu.RLock()
+	for _, upNode := range upi.UPFs {
+		if upNode.UPF != nil && upNode.UPF.UPFStatus == smf_context.AssociatedSetUpSuccess {
+			upfs = append(upfs, upNode.UPF)
+		}
+	}
+	upi.Mu.RUnlock()
+
+	for _, upf := range upfs {
+		go updatePfcpAssociation(upf)
+	}
+}
+
+func updatePfcpAssociation(upf *smf_context.UPF) {
+	upfStr := fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
+	logger.AppLog.Infof("Sending PFCP Association Update Request to UPF%s", upfStr)
+
+	resMsg, err := message.SendPfcpAssociationUpdateRequest(upf.NodeID)
+	if err != nil {
+		logger.AppLog.Warnf("Send PFCP Association Update Request to UPF%s failed: %v", upfStr, err)
+		return
+	}
+
+	rsp := resMsg.PfcpMessage.Body.(pfcp.PFCPAssociationUpdateResponse)
+	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		logger.AppLog.Warnf("Received PFCP Association Update Not Accepted Response from UPF%s", upfStr)
+		return
+	}
+	if rsp.UPFunctionFeatures != nil {
+		upf.UPFunctionFeatures = rsp.UPFunctionFeatures.SupportedFeatures
+	}
+	logger.AppLog.Infof("Received PFCP Association Update Accepted Response from UPF%s", upfStr)
+}
+
+func releasePDUSession(smContext *smf_context.SMContext, cause uint8, backoffTimer time.Duration) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	switch smContext.SMContextState {
+	case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+	default:
+		// the session is being established or released
+		return
+	}
+
+	logger.AppLog.Infof("Release PDU session of UE[%s] PDUSessionID[%d]", smContext.Supi, smContext.PDUSessionID)
+	for _, res := range producer.ReleaseTunnel(smContext) {
+		if res.Status != smf_context.SessionReleaseSuccess {
+			logger.AppLog.Debugf("Delete PFCP session of UE[%s] PDUSessionID[%d] failed: %v",
+				smContext.Supi, smContext.PDUSessionID, res.Err)
+		}
+	}
+	releaseSMContext(smContext, cause, backoffTimer)
+}
+
+func releaseSMContext(smContext *smf_context.SMContext, cause uint8, backoffTimer time.Duration) {
+	needToSendNotify, removeContext := requestAMFToReleasePDUResources(smContext, cause, backoffTimer)
+	if needToSendNotify {
+		sendReleaseNotification(smContext)
+	}
+	if removeContext {
+		// Notification has already been sent, if it is needed
+		producer.RemoveSMContextFromAllNF(smContext, false)
+	}
+}
+
+func requestAMFToReleasePDUResources(smContext *smf_context.SMContext, cause uint8,
+	backoffTimer time.Duration,
+) (
+	sendNotify bool, releaseContext bool,
+) {
+	if smContext.CommunicationClient == nil {
+		// the serving AMF deregistered, the SM Context is removed in SMF only
+		logger.AppLog.Warnf("UE[%s] has no serving AMF", smContext.Supi)
+		smContext.SMContextState = smf_context.InActive
+		logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+		return false, true
+	}
+
 	n1n2Request := models.N1N2MessageTransferRequest{}
 	// TS 23.502 4.3.4.2 3b. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
 	n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
 		PduSessionId: smContext.PDUSessionID,
 		SkipInd:      true,
 	}
-	cause := nasMessage.Cause5GSMNetworkFailure
-	if buf, err := smf_context.BuildGSMPDUSessionReleaseCommand(smContext, cause, false); err != nil {
+	if buf, err := smf_context.BuildGSMPDUSessionReleaseCommandWithBackoffTimer(
+		smContext, cause, false, backoffTimer); err != nil {
 		logger.AppLog.Errorf("Build GSM PDUSessionReleaseCommand failed: %+v", err)
 	} else {
 		n1n2Request.BinaryDataN1Message = buf
@@ -247,12 +617,16 @@ func requestAMFToReleasePDUResources(smContext *smf_context.SMContext) (sendNoti
 		}
 	}
 
-	rspData, res, err := smContext.CommunicationClient.
-		N1N2MessageCollectionDocumentApi.
-		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+	rspData, res, err := consumer.SendN1N2MessageTransfer(smContext, n1n2Request)
 	if err != nil {
 		logger.AppLog.Warnf("Send N1N2Transfer failed: %+v", err)
 	}
+	if res == nil {
+		// the AMF is not reachable, keep SM Context to avoid inconsistency with AMF
+		smContext.SMContextState = smf_context.InActive
+		logger.CtxLog.Traceln("SMContextState Change State: ", smContext.SMContextState.String())
+		return false, false
+	}
 	defer func() {
 		if resCloseErr := res.Body.Close(); resCloseErr != nil {
 			logger.PduSessLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
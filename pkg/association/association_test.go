@@ -0,0 +1,554 @@
+package association
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/pfcp/udp"
+	"github.com/free5gc/smf/internal/sbi/producer"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestMain(m *testing.M) {
+	smf_context.SMF_Self().CPNodeID = pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("127.0.0.31").To4(),
+	}
+	udp.Run(func(*pfcpUdp.Message) {})
+
+	os.Exit(m.Run())
+}
+
+// fakeUPF answers the PFCP requests of the SMF, and records them
+type fakeUPF struct {
+	nodeID   pfcpType.NodeID
+	conn     *net.UDPConn
+	requests chan *pfcp.Message
+	// when set, the PFCP Session Establishment Requests are answered once it is closed
+	hold chan struct{}
+
+	mu                sync.Mutex
+	recoveryTimeStamp time.Time
+}
+
+func newFakeUPF(t *testing.T, ip string) *fakeUPF {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip), Port: pfcpUdp.PFCP_PORT})
+	require.NoError(t, err)
+
+	f := &fakeUPF{
+		nodeID: pfcpType.NodeID{
+			NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+			IP:         net.ParseIP(ip).To4(),
+		},
+		conn:              conn,
+		requests:          make(chan *pfcp.Message, 100),
+		recoveryTimeStamp: time.Now(),
+	}
+	t.Cleanup(func() {
+		require.NoError(t, conn.Close())
+	})
+	go f.serve()
+	return f
+}
+
+func (f *fakeUPF) serve() {
+	buf := make([]byte, pfcpUdp.PFCP_MAX_UDP_LEN)
+	for {
+		n, addr, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req := &pfcp.Message{}
+		if err = req.Unmarshal(buf[:n]); err != nil {
+			// the IEs the pfcp library cannot decode do not matter to the fake UPF
+			if err = req.Header.UnmarshalBinary(buf[:n]); err != nil {
+				continue
+			}
+		}
+		f.requests <- req
+		if f.hold != nil && req.Header.MessageType == pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST {
+			<-f.hold
+		}
+
+		rsp := f.response(req, buf[:n])
+		if rsp == nil {
+			continue
+		}
+		data, err := rsp.Marshal()
+		if err != nil {
+			continue
+		}
+		_, _ = f.conn.WriteToUDP(data, addr)
+	}
+}
+
+func (f *fakeUPF) response(req *pfcp.Message, data []byte) *pfcp.Message {
+	accepted := &pfcpType.Cause{CauseValue: pfcpType.CauseRequestAccepted}
+	rsp := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			S:              pfcp.SEID_NOT_PRESENT,
+			SequenceNumber: req.Header.SequenceNumber,
+		},
+	}
+	recoveryTimeStamp := &pfcpType.RecoveryTimeStamp{RecoveryTimeStamp: f.recoveredAt()}
+	switch req.Header.MessageType {
+	case pfcp.PFCP_HEARTBEAT_REQUEST:
+		rsp.Header.MessageType = pfcp.PFCP_HEARTBEAT_RESPONSE
+		rsp.Body = pfcp.HeartbeatResponse{RecoveryTimeStamp: recoveryTimeStamp}
+	case pfcp.PFCP_ASSOCIATION_SETUP_REQUEST:
+		rsp.Header.MessageType = pfcp.PFCP_ASSOCIATION_SETUP_RESPONSE
+		rsp.Body = pfcp.PFCPAssociationSetupResponse{
+			NodeID:            &f.nodeID,
+			Cause:             accepted,
+			RecoveryTimeStamp: recoveryTimeStamp,
+		}
+	case pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST:
+		seid, ok := cpSEID(data)
+		if !ok {
+			return nil
+		}
+		rsp.Header.MessageType = pfcp.PFCP_SESSION_ESTABLISHMENT_RESPONSE
+		rsp.Header.S = pfcp.SEID_PRESENT
+		rsp.Header.SEID = seid
+		rsp.Body = pfcp.PFCPSessionEstablishmentResponse{
+			NodeID: &f.nodeID,
+			Cause:  accepted,
+			UPFSEID: &pfcpType.FSEID{
+				V4:          true,
+				Seid:        seid + 1000,
+				Ipv4Address: f.nodeID.IP,
+			},
+		}
+	case pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST:
+		rsp.Header.MessageType = pfcp.PFCP_ASSOCIATION_UPDATE_RESPONSE
+		rsp.Body = pfcp.PFCPAssociationUpdateResponse{
+			NodeID:             &f.nodeID,
+			Cause:              accepted,
+			UPFunctionFeatures: &pfcpType.UPFunctionFeatures{SupportedFeatures: pfcpType.UpFunctionFeaturesFtup},
+		}
+	case pfcp.PFCP_ASSOCIATION_RELEASE_REQUEST:
+		rsp.Header.MessageType = pfcp.PFCP_ASSOCIATION_RELEASE_RESPONSE
+		rsp.Body = pfcp.PFCPAssociationReleaseResponse{
+			NodeID: &f.nodeID,
+			Cause:  accepted,
+		}
+	default:
+		return nil
+	}
+	return rsp
+}
+
+// cpSEID returns the SEID of the CP F-SEID IE of a PFCP session message, read from its encoding
+// as the pfcp library cannot decode the Create PDR IEs of the SMF
+func cpSEID(data []byte) (uint64, bool) {
+	const headerLength, ieHeaderLength = 16, 4
+	const ieTypeFSEID, fseidLength = 57, 9
+	for offset := headerLength; offset+ieHeaderLength <= len(data); {
+		ieType := binary.BigEndian.Uint16(data[offset:])
+		ieLength := int(binary.BigEndian.Uint16(data[offset+2:]))
+		value := data[offset+ieHeaderLength:]
+		if ieType == ieTypeFSEID && ieLength >= fseidLength && len(value) >= fseidLength {
+			return binary.BigEndian.Uint64(value[1:]), true
+		}
+		offset += ieHeaderLength + ieLength
+	}
+	return 0, false
+}
+
+func (f *fakeUPF) recoveredAt() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.recoveryTimeStamp
+}
+
+// restart makes the fake UPF answer with a newer recovery time stamp, as a UPF which restarted
+func (f *fakeUPF) restart() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recoveryTimeStamp = f.recoveryTimeStamp.Add(time.Minute)
+}
+
+// request waits for the next PFCP request received by the fake UPF
+func (f *fakeUPF) request(t *testing.T) *pfcp.Message {
+	select {
+	case req := <-f.requests:
+		return req
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no PFCP request received")
+		return nil
+	}
+}
+
+// newTestUPI sets up the user plane of the SMF with a UPF for each node ID, associated unless listed
+func newTestUPI(t *testing.T, nodeIDs map[string]string, unassociated ...string) map[string]*smf_context.UPF {
+	upNodes := map[string]factory.UPNode{
+		"GNodeB": {
+			Type:   "AN",
+			NodeID: "192.168.179.100",
+		},
+	}
+	links := make([]factory.UPLink, 0)
+	pool := 0
+	for name, nodeID := range nodeIDs {
+		pool++
+		upNodes[name] = factory.UPNode{
+			Type:   "UPF",
+			NodeID: nodeID,
+			SNssaiInfos: []factory.SnssaiUpfInfoItem{
+				{
+					SNssai: &models.Snssai{Sst: 1, Sd: "010203"},
+					DnnUpfInfoList: []factory.DnnUpfInfoItem{
+						{
+							Dnn:   "internet",
+							Pools: []factory.UEIPPool{{Cidr: fmt.Sprintf("10.60.%d.0/24", pool)}},
+						},
+					},
+				},
+			},
+		}
+		links = append(links, factory.UPLink{A: "GNodeB", B: name})
+	}
+
+	smfSelf := smf_context.SMF_Self()
+	smfSelf.UserPlaneInformation = smf_context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: upNodes,
+		Links:   links,
+	})
+
+	upfs := make(map[string]*smf_context.UPF)
+	for name, upNode := range smfSelf.UserPlaneInformation.UPFs {
+		upNode.UPF.Ctx, upNode.UPF.CancelFunc = context.WithCancel(context.Background())
+		t.Cleanup(upNode.UPF.CancelFunc)
+		upNode.UPF.UPFStatus = smf_context.AssociatedSetUpSuccess
+		for _, u := range unassociated {
+			if u == name {
+				upNode.UPF.UPFStatus = smf_context.NotAssociated
+			}
+		}
+		upfs[name] = upNode.UPF
+	}
+	return upfs
+}
+
+func TestUpdateCPFunctionFeatures(t *testing.T) {
+	upf1 := newFakeUPF(t, "127.0.0.32")
+	upf2 := newFakeUPF(t, "127.0.0.33")
+	upf3 := newFakeUPF(t, "127.0.0.34")
+	upfs := newTestUPI(t, map[string]string{
+		"UPF1": "127.0.0.32",
+		"UPF2": "127.0.0.33",
+		"UPF3": "127.0.0.34",
+	}, "UPF3")
+	features := smf_context.SMF_Self().CPFunctionFeatures()
+	defer smf_context.SMF_Self().SetCPFunctionFeatures(features)
+
+	UpdateCPFunctionFeatures(pfcpType.CpFunctionFeaturesLoad)
+	require.Equal(t, pfcpType.CpFunctionFeaturesLoad, smf_context.SMF_Self().CPFunctionFeatures())
+
+	for _, upf := range []*fakeUPF{upf1, upf2} {
+		req := upf.request(t)
+		require.Equal(t, pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST, req.Header.MessageType)
+		body := req.Body.(pfcp.PFCPAssociationUpdateRequest)
+		require.NotNil(t, body.CPFunctionFeatures)
+		require.Equal(t, pfcpType.CpFunctionFeaturesLoad, body.CPFunctionFeatures.SupportedFeatures)
+	}
+	require.Eventually(t, func() bool {
+		return upfs["UPF1"].UPFunctionFeatures == pfcpType.UpFunctionFeaturesFtup &&
+			upfs["UPF2"].UPFunctionFeatures == pfcpType.UpFunctionFeaturesFtup
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// features unchanged, nothing to tell
+	UpdateCPFunctionFeatures(pfcpType.CpFunctionFeaturesLoad)
+	time.Sleep(300 * time.Millisecond)
+	for _, upf := range []*fakeUPF{upf1, upf2, upf3} {
+		require.Empty(t, upf.requests)
+	}
+}
+
+// newTestSession returns a PDU session of the UE with a PFCP session on the UPF. It has no
+// serving AMF, so that it is removed at once when released.
+func newTestSession(t *testing.T, upf *smf_context.UPF, supi string, state smf_context.SMContextState,
+) *smf_context.SMContext {
+	smContext := smf_context.NewSMContext(supi, 1)
+	t.Cleanup(func() { smf_context.RemoveSMContext(smContext.Ref) })
+	smContext.Supi = supi
+	smContext.SMContextState = state
+	smContext.Tunnel = smf_context.NewUPTunnel()
+	smContext.PFCPContext[upf.NodeID.ResolveNodeIdToIp().String()] = &smf_context.PFCPSessionContext{
+		PDRs:   make(map[uint16]*smf_context.PDR),
+		NodeID: upf.NodeID,
+	}
+	return smContext
+}
+
+// remaining returns the PDU sessions not removed yet
+func remaining(smContexts []*smf_context.SMContext) int {
+	n := 0
+	for _, smContext := range smContexts {
+		if smf_context.GetSMContextByRef(smContext.Ref) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStartMaintenance(t *testing.T) {
+	fake := newFakeUPF(t, "127.0.0.32")
+	upf := newTestUPI(t, map[string]string{"UPF1": "127.0.0.32"})["UPF1"]
+	defer StopMaintenance(upf)
+	smContexts := []*smf_context.SMContext{
+		newTestSession(t, upf, "imsi-208930000000061", smf_context.Active),
+		newTestSession(t, upf, "imsi-208930000000062", smf_context.Active),
+		newTestSession(t, upf, "imsi-208930000000063", smf_context.Active),
+	}
+
+	// one PDU session released every 200 ms
+	start := time.Now()
+	require.True(t, StartMaintenance(upf, 5))
+	require.False(t, StartMaintenance(upf, 5))
+	require.True(t, upf.Draining())
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 2, remaining(smContexts))
+	require.Eventually(t, func() bool { return remaining(smContexts) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(400*time.Millisecond))
+
+	// then the association is released
+	req := fake.request(t)
+	require.Equal(t, pfcp.PFCP_ASSOCIATION_RELEASE_REQUEST, req.Header.MessageType)
+	require.Equal(t, smf_context.NotAssociated, upf.UPFStatus)
+	require.True(t, upf.InMaintenance())
+}
+
+func TestDrainUPFWithoutSessions(t *testing.T) {
+	fake := newFakeUPF(t, "127.0.0.32")
+	upf := newTestUPI(t, map[string]string{"UPF1": "127.0.0.32"})["UPF1"]
+	defer upf.StopDrain()
+
+	// the association is kept unless asked
+	DrainUPF(upf, time.Second, false)
+	require.True(t, upf.Draining())
+	require.Equal(t, smf_context.AssociatedSetUpSuccess, upf.UPFStatus)
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, fake.requests)
+	upf.StopDrain()
+
+	// and released at once, with no PDU session to wait for
+	DrainUPF(upf, time.Second, true)
+	req := fake.request(t)
+	require.Equal(t, pfcp.PFCP_ASSOCIATION_RELEASE_REQUEST, req.Header.MessageType)
+	require.Equal(t, smf_context.NotAssociated, upf.UPFStatus)
+}
+
+func TestDrainUPFDeadline(t *testing.T) {
+	fake := newFakeUPF(t, "127.0.0.32")
+	upf := newTestUPI(t, map[string]string{"UPF1": "127.0.0.32"})["UPF1"]
+	defer upf.StopDrain()
+	active := newTestSession(t, upf, "imsi-208930000000064", smf_context.Active)
+	establishing := newTestSession(t, upf, "imsi-208930000000065", smf_context.ActivePending)
+
+	// the active PDU session is released, the one stuck in its establishment is removed at the
+	// deadline
+	require.True(t, upf.StartDrain(true))
+	done := make(chan struct{})
+	go func() {
+		drainUPF(upf, "[127.0.0.32]", 0, time.Now().Add(500*time.Millisecond))
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return remaining([]*smf_context.SMContext{active}) == 0 },
+		5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, remaining([]*smf_context.SMContext{establishing}))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "drain not over at the deadline")
+	}
+	require.Eventually(t, func() bool { return remaining([]*smf_context.SMContext{establishing}) == 0 },
+		5*time.Second, 10*time.Millisecond)
+	req := fake.request(t)
+	require.Equal(t, pfcp.PFCP_ASSOCIATION_RELEASE_REQUEST, req.Header.MessageType)
+}
+
+func TestSelectUPFSkipsDrainingUPF(t *testing.T) {
+	upfs := newTestUPI(t, map[string]string{
+		"UPF1": "127.0.0.32",
+		"UPF2": "127.0.0.33",
+	})
+	upi := smf_context.GetUserPlaneInformation()
+	selection := &smf_context.UPFSelectionParams{
+		Dnn:    "internet",
+		SNssai: &smf_context.SNssai{Sst: 1, Sd: "010203"},
+	}
+
+	require.True(t, upfs["UPF1"].StartDrain(false))
+	for i := 0; i < 3; i++ {
+		upNode, ip := upi.SelectUPFAndAllocUEIP(selection)
+		require.NotNil(t, ip)
+		require.Same(t, upfs["UPF2"], upNode.UPF)
+	}
+
+	// no UPF left to place the PDU session on
+	require.True(t, upfs["UPF2"].StartDrain(false))
+	upNode, ip := upi.SelectUPFAndAllocUEIP(selection)
+	require.Nil(t, upNode)
+	require.Nil(t, ip)
+
+	// the UPF takes new PDU sessions again once its drain is stopped
+	upfs["UPF1"].StopDrain()
+	upNode, _ = upi.SelectUPFAndAllocUEIP(selection)
+	require.Same(t, upfs["UPF1"], upNode.UPF)
+}
+
+// newRestoredSession returns a PDU session of the DNN with a PFCP session to restore on the UPF.
+// The PFCP sessions of the internet DNN are reestablished, the PDU sessions of the others released.
+func newRestoredSession(t *testing.T, upf *smf_context.UPF, supi, dnn string, state smf_context.SMContextState,
+) *smf_context.SMContext {
+	smContext := newTestSession(t, upf, supi, state)
+	smContext.Dnn = dnn
+	smContext.DNNInfo = &smf_context.SnssaiSmfDnnInfo{UPFRestoration: factory.UPFRestorationRelease}
+	if dnn == "internet" {
+		smContext.DNNInfo.UPFRestoration = factory.UPFRestorationReestablish
+	}
+	pfcpSessionCtx := smContext.PFCPContext[upf.NodeID.ResolveNodeIdToIp().String()]
+	pfcpSessionCtx.LocalSEID = smContext.LocalSEID
+	pfcpSessionCtx.RemoteSEID = 1
+	pfcpSessionCtx.PDRs[1] = &smf_context.PDR{
+		PDRID: 1,
+		State: smf_context.RULE_CREATE,
+		FAR:   &smf_context.FAR{FARID: 1, State: smf_context.RULE_CREATE},
+	}
+	return smContext
+}
+
+// restorationOf returns the restoration progress of the UPF reported over OAM
+func restorationOf(t *testing.T, upf *smf_context.UPF) *smf_context.UPFRestoration {
+	rsp := producer.HandleOAMGetUPFRestorations()
+	require.Equal(t, http.StatusOK, rsp.Status)
+	for _, restoration := range rsp.Body.([]*smf_context.UPFRestoration) {
+		if restoration.UPF == upf.NodeID.ResolveNodeIdToIp().String() {
+			return restoration
+		}
+	}
+	return nil
+}
+
+func TestRestoreSessionsOfUPF(t *testing.T) {
+	fake := newFakeUPF(t, "127.0.0.32")
+	fake.hold = make(chan struct{})
+	upf := newTestUPI(t, map[string]string{"UPF1": "127.0.0.32"})["UPF1"]
+	reestablished := newRestoredSession(t, upf, "imsi-208930000000066", "internet", smf_context.Active)
+	released := newRestoredSession(t, upf, "imsi-208930000000067", "ims", smf_context.Active)
+	establishing := newRestoredSession(t, upf, "imsi-208930000000068", "internet", smf_context.ActivePending)
+
+	done := make(chan struct{})
+	go func() {
+		RestoreSessionsOfUPF(upf)
+		close(done)
+	}()
+
+	// the progress is reported while the PFCP session is created again on the UPF
+	req := fake.request(t)
+	require.Equal(t, pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST, req.Header.MessageType)
+	restoration := restorationOf(t, upf)
+	require.NotNil(t, restoration)
+	require.Equal(t, smf_context.UPFRestorationInProgress, restoration.State)
+	require.Equal(t, 3, restoration.Total)
+	require.Nil(t, restoration.EndTime)
+	close(fake.hold)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "restoration not completed")
+	}
+	restoration = restorationOf(t, upf)
+	require.Equal(t, smf_context.UPFRestorationCompleted, restoration.State)
+	require.NotNil(t, restoration.EndTime)
+	require.Equal(t, 3, restoration.Total)
+	require.Equal(t, 1, restoration.Reestablished)
+	require.Equal(t, 1, restoration.Released)
+	require.Equal(t, 1, restoration.Failed)
+
+	// the PDU session of the DNN with the reestablish policy is kept with its new PFCP session,
+	// the one of the DNN with the release policy is released, the one being established is left
+	// to its procedure
+	pfcpSessionCtx := reestablished.PFCPContext["127.0.0.32"]
+	require.Equal(t, pfcpSessionCtx.LocalSEID+1000, pfcpSessionCtx.RemoteSEID)
+	require.Equal(t, smf_context.Active, reestablished.SMContextState)
+	require.Eventually(t, func() bool { return remaining([]*smf_context.SMContext{released}) == 0 },
+		5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, remaining([]*smf_context.SMContext{reestablished, establishing}))
+	require.Empty(t, fake.requests)
+}
+
+func TestRestartBetweenHeartbeats(t *testing.T) {
+	heartbeatInterval := smf_context.SMF_Self().PfcpHeartbeatInterval
+	smf_context.SMF_Self().PfcpHeartbeatInterval = 100 * time.Millisecond
+	defer func() { smf_context.SMF_Self().PfcpHeartbeatInterval = heartbeatInterval }()
+
+	fake := newFakeUPF(t, "127.0.0.33")
+	upf := newTestUPI(t, map[string]string{"UPF2": "127.0.0.33"})["UPF2"]
+	reestablished := newRestoredSession(t, upf, "imsi-208930000000069", "internet", smf_context.Active)
+	released := newRestoredSession(t, upf, "imsi-208930000000070", "ims", smf_context.Active)
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ToBeAssociatedWithUPF(ctx, upf)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "association not canceled")
+		}
+	}()
+
+	// the PFCP sessions are kept while the UPF answers the heartbeats with the same time stamp
+	req := fake.request(t)
+	require.Equal(t, pfcp.PFCP_ASSOCIATION_SETUP_REQUEST, req.Header.MessageType)
+	for i := 0; i < 2; i++ {
+		req = fake.request(t)
+		require.Equal(t, pfcp.PFCP_HEARTBEAT_REQUEST, req.Header.MessageType)
+	}
+	if restoration := restorationOf(t, upf); restoration != nil {
+		require.True(t, restoration.StartTime.Before(start))
+	}
+
+	// the UPF restarts, the association is set up again and the PDU sessions restored
+	restartTime := time.Now()
+	fake.restart()
+	require.Eventually(t, func() bool {
+		restoration := restorationOf(t, upf)
+		return restoration != nil && restoration.StartTime.After(restartTime) &&
+			restoration.State == smf_context.UPFRestorationCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+	restoration := restorationOf(t, upf)
+	require.Equal(t, 2, restoration.Total)
+	require.Equal(t, 1, restoration.Reestablished)
+	require.Equal(t, 1, restoration.Released)
+	require.Zero(t, restoration.Failed)
+
+	require.Eventually(t, func() bool { return remaining([]*smf_context.SMContext{released}) == 0 },
+		5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, remaining([]*smf_context.SMContext{reestablished}))
+	require.True(t, upf.RecoveryTimeStamp.Equal(fake.recoveredAt().Truncate(time.Second)))
+}
@@ -7,12 +7,15 @@ package factory
 import (
 	"errors"
 	"fmt"
+	"net"
+	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/asaskevich/govalidator"
 
 	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/util/flowdesc"
 	logger_util "github.com/free5gc/util/logger"
 )
 
@@ -21,6 +24,7 @@ const (
 	UE_ROUTING_EXPECTED_CONFIG_VERSION = "1.0.1"
 	SMF_DEFAULT_IPV4                   = "127.0.0.2"
 	SMF_DEFAULT_PORT                   = 8000
+	SMF_DEFAULT_METRICS_PORT           = 9091
 )
 
 type Config struct {
@@ -73,6 +77,13 @@ type Configuration struct {
 	ULCL                 bool                 `yaml:"ulcl,omitempty" valid:"type(bool),optional"`
 	PLMNList             []PlmnID             `yaml:"plmnList,omitempty"  valid:"optional"`
 	Locality             string               `yaml:"locality,omitempty" valid:"type(string),optional"`
+	DLBuffering          *DLBuffering         `yaml:"dlBuffering,omitempty" valid:"optional"`
+	SessionStore         *SessionStore        `yaml:"sessionStore,omitempty" valid:"optional"`
+	Redundancy           *Redundancy          `yaml:"redundancy,omitempty" valid:"optional"`
+	UEIPPoolAlarm        *UEIPPoolAlarm       `yaml:"ueIPPoolAlarm,omitempty" valid:"optional"`
+	FramedRoutes         []FramedRoutes       `yaml:"framedRoutes,omitempty" valid:"optional"`
+	Scp                  *Scp                 `yaml:"scp,omitempty" valid:"optional"`
+	Metrics              *Metrics             `yaml:"metrics,omitempty" valid:"optional"`
 }
 
 func (c *Configuration) validate() (bool, error) {
@@ -88,6 +99,53 @@ func (c *Configuration) validate() (bool, error) {
 		}
 	}
 
+	if dlBuffering := c.DLBuffering; dlBuffering != nil {
+		if result, err := dlBuffering.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if sessionStore := c.SessionStore; sessionStore != nil {
+		if result, err := sessionStore.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if redundancy := c.Redundancy; redundancy != nil {
+		if c.SessionStore == nil {
+			return false, errors.New("sessionStore is required for redundancy")
+		}
+		if result, err := redundancy.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if ueIPPoolAlarm := c.UEIPPoolAlarm; ueIPPoolAlarm != nil {
+		if result, err := ueIPPoolAlarm.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	for _, framedRoutes := range c.FramedRoutes {
+		if result, err := framedRoutes.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if scp := c.Scp; scp != nil {
+		if result, err := scp.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	metrics := c.Metrics
+	if metrics == nil {
+		metrics = &Metrics{}
+	}
+	if result, err := metrics.validate(c.Sbi); err != nil {
+		return result, err
+	}
+
 	if userPlaneInformation := &c.UserPlaneInformation; userPlaneInformation != nil {
 		if result, err := userPlaneInformation.validate(); err != nil {
 			return result, err
@@ -156,8 +214,23 @@ type SnssaiDnnInfoItem struct {
 	Dnn   string `yaml:"dnn" valid:"type(string),minstringlength(1),required"`
 	DNS   *DNS   `yaml:"dns" valid:"required"`
 	PCSCF *PCSCF `yaml:"pcscf,omitempty" valid:"optional"`
+	// what to do with the PDU sessions of a restarted UPF: "release" (default) or "reestablish"
+	UPFRestoration string `yaml:"upfRestoration,omitempty" valid:"in(release|reestablish),optional"`
+	// DN-AAA server authenticating the PDU sessions of the DNN, TS 29.561 11
+	SecondaryAuth *SecondaryAuth `yaml:"secondaryAuth,omitempty" valid:"optional"`
+	// RADIUS accounting of the PDU sessions of the DNN, TS 29.561 16.5
+	Accounting *Accounting `yaml:"accounting,omitempty" valid:"optional"`
+	// policy of the PDU sessions of the DNN while no PCF answers
+	LocalPolicy *LocalPolicy `yaml:"localPolicy,omitempty" valid:"optional"`
+	// SM subscription data of the PDU sessions of the DNN the UDM has none for
+	DefaultSubscription *DefaultSubscription `yaml:"defaultSubscription,omitempty" valid:"optional"`
 }
 
+const (
+	UPFRestorationRelease     = "release"
+	UPFRestorationReestablish = "reestablish"
+)
+
 func (s *SnssaiDnnInfoItem) validate() (bool, error) {
 	if dns := s.DNS; dns != nil {
 		if result, err := dns.validate(); err != nil {
@@ -171,10 +244,266 @@ func (s *SnssaiDnnInfoItem) validate() (bool, error) {
 		}
 	}
 
+	if secondaryAuth := s.SecondaryAuth; secondaryAuth != nil {
+		if result, err := secondaryAuth.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if accounting := s.Accounting; accounting != nil {
+		if result, err := accounting.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if localPolicy := s.LocalPolicy; localPolicy != nil {
+		if result, err := localPolicy.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if defaultSubscription := s.DefaultSubscription; defaultSubscription != nil {
+		if result, err := defaultSubscription.validate(); err != nil {
+			return result, err
+		}
+	}
+
 	result, err := govalidator.ValidateStruct(s)
 	return result, appendInvalid(err)
 }
 
+type SecondaryAuth struct {
+	Radius *RadiusServer `yaml:"radius" valid:"required"`
+}
+
+func (s *SecondaryAuth) validate() (bool, error) {
+	if radius := s.Radius; radius != nil {
+		if result, err := radius.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	result, err := govalidator.ValidateStruct(s)
+	return result, appendInvalid(err)
+}
+
+type Accounting struct {
+	Radius *RadiusServer `yaml:"radius" valid:"required"`
+	// period of the Interim-Updates, none if zero
+	InterimInterval time.Duration `yaml:"interimInterval,omitempty" valid:"type(time.Duration),optional"`
+}
+
+func (a *Accounting) validate() (bool, error) {
+	if radius := a.Radius; radius != nil {
+		if result, err := radius.validate(); err != nil {
+			return result, err
+		}
+	}
+	if a.InterimInterval != 0 && a.InterimInterval < time.Second {
+		return false, fmt.Errorf("Invalid accounting interimInterval: %s, should be at least 1s.", a.InterimInterval)
+	}
+
+	result, err := govalidator.ValidateStruct(a)
+	return result, appendInvalid(err)
+}
+
+// DefaultSubscription is the SM subscription data of the PDU sessions of a DNN when the UDM has
+// none for the DNN or does not answer, TS 29.503 6.1.6.2.8. The data the UDM sends without some
+// of these fields is completed with them.
+type DefaultSubscription struct {
+	// "apply" (default) establishes the sessions without subscription data with the data below,
+	// "reject" rejects them
+	Mode            string                  `yaml:"mode,omitempty" valid:"in(apply|reject),optional"`
+	PduSessionTypes *models.PduSessionTypes `yaml:"pduSessionTypes,omitempty" valid:"optional"`
+	// SSC mode 1 only if unset, the only mode of the SMF
+	SscModes *models.SscModes `yaml:"sscModes,omitempty" valid:"optional"`
+	// session AMBR, e.g. "100 Mbps"
+	SessionAmbr *models.Ambr `yaml:"sessionAmbr,omitempty" valid:"optional"`
+	// default QoS of the sessions
+	Var5qi     int32              `yaml:"5qi,omitempty" valid:"range(0|255),optional"`
+	Arp        *models.Arp        `yaml:"arp,omitempty" valid:"optional"`
+	UpSecurity *models.UpSecurity `yaml:"upSecurity,omitempty" valid:"optional"`
+}
+
+const (
+	DefaultSubscriptionApply  = "apply"
+	DefaultSubscriptionReject = "reject"
+)
+
+func (d *DefaultSubscription) validate() (bool, error) {
+	if d.Mode != DefaultSubscriptionReject {
+		if d.PduSessionTypes == nil || d.SessionAmbr == nil || d.Var5qi == 0 || d.Arp == nil {
+			return false, errors.New("Invalid defaultSubscription: pduSessionTypes, sessionAmbr, 5qi and arp " +
+				"are required to apply it.")
+		}
+	}
+	if types := d.PduSessionTypes; types != nil {
+		for _, sessionType := range append([]models.PduSessionType{types.DefaultSessionType},
+			types.AllowedSessionTypes...) {
+			switch sessionType {
+			case models.PduSessionType_IPV4, models.PduSessionType_IPV6, models.PduSessionType_IPV4_V6,
+				models.PduSessionType_UNSTRUCTURED, models.PduSessionType_ETHERNET:
+			default:
+				return false, errors.New("Invalid defaultSubscription pduSessionType: " + string(sessionType) +
+					", should be IPV4, IPV6, IPV4V6, UNSTRUCTURED or ETHERNET.")
+			}
+		}
+	}
+	if modes := d.SscModes; modes != nil {
+		for _, mode := range append([]models.SscMode{modes.DefaultSscMode}, modes.AllowedSscModes...) {
+			switch mode {
+			case models.SscMode__1, models.SscMode__2, models.SscMode__3:
+			default:
+				return false, errors.New("Invalid defaultSubscription sscMode: " + string(mode) +
+					", should be SSC_MODE_1, SSC_MODE_2 or SSC_MODE_3.")
+			}
+		}
+	}
+	if err := validateArp("defaultSubscription", d.Arp); err != nil {
+		return false, err
+	}
+	if err := validateAmbr("defaultSubscription", d.SessionAmbr); err != nil {
+		return false, err
+	}
+
+	result, err := govalidator.ValidateStruct(d)
+	return result, appendInvalid(err)
+}
+
+// LocalPolicy is the SM policy decision the SMF makes for the PDU sessions of a DNN when the
+// SM policy association with the PCF cannot be created, TS 23.503 6.3
+type LocalPolicy struct {
+	// default QoS of the sessions
+	Var5qi int32       `yaml:"5qi" valid:"range(1|255),required"`
+	Arp    *models.Arp `yaml:"arp" valid:"required"`
+	// session AMBR, e.g. "100 Mbps"
+	SessionAmbr *models.Ambr `yaml:"sessionAmbr" valid:"required"`
+	// predefined PCC rules of the sessions, enforced on the UPFs and signalled to the UEs
+	PccRules []LocalPccRule `yaml:"pccRules,omitempty" valid:"optional"`
+}
+
+func (p *LocalPolicy) validate() (bool, error) {
+	if err := validateArp("localPolicy", p.Arp); err != nil {
+		return false, err
+	}
+	if err := validateAmbr("localPolicy", p.SessionAmbr); err != nil {
+		return false, err
+	}
+	ids := make(map[string]bool)
+	for i := range p.PccRules {
+		rule := &p.PccRules[i]
+		if ids[rule.PccRuleId] {
+			return false, errors.New("Invalid localPolicy pccRules: duplicate pccRuleId " + rule.PccRuleId)
+		}
+		ids[rule.PccRuleId] = true
+		if result, err := rule.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	result, err := govalidator.ValidateStruct(p)
+	return result, appendInvalid(err)
+}
+
+// bitRateRegexp matches the bit rates of the SMF, TS 29.571 5.5.2
+var bitRateRegexp = regexp.MustCompile(`^[0-9]+ (bps|Kbps|Mbps|Gbps|Tbps)$`)
+
+func validateArp(name string, arp *models.Arp) error {
+	if arp == nil {
+		return nil
+	}
+	if arp.PriorityLevel < 1 || arp.PriorityLevel > 15 {
+		return fmt.Errorf("Invalid %s arp priorityLevel: %d, should be in range 1~15.", name, arp.PriorityLevel)
+	}
+	switch arp.PreemptCap {
+	case models.PreemptionCapability_MAY_PREEMPT, models.PreemptionCapability_NOT_PREEMPT:
+	default:
+		return errors.New("Invalid " + name + " arp preemptCap: " + string(arp.PreemptCap) +
+			", should be MAY_PREEMPT or NOT_PREEMPT.")
+	}
+	switch arp.PreemptVuln {
+	case models.PreemptionVulnerability_PREEMPTABLE, models.PreemptionVulnerability_NOT_PREEMPTABLE:
+	default:
+		return errors.New("Invalid " + name + " arp preemptVuln: " + string(arp.PreemptVuln) +
+			", should be PREEMPTABLE or NOT_PREEMPTABLE.")
+	}
+	return nil
+}
+
+func validateAmbr(name string, ambr *models.Ambr) error {
+	if ambr == nil {
+		return nil
+	}
+	if !bitRateRegexp.MatchString(ambr.Uplink) || !bitRateRegexp.MatchString(ambr.Downlink) {
+		return errors.New("Invalid " + name + " sessionAmbr: " + ambr.Uplink + ", " + ambr.Downlink +
+			", should be bit rates like \"100 Mbps\".")
+	}
+	return nil
+}
+
+// LocalPccRule is a predefined PCC rule of the local policy, TS 29.512 5.6.2.6
+type LocalPccRule struct {
+	PccRuleId string `yaml:"pccRuleId" valid:"type(string),minstringlength(1),required"`
+	// precedence of the rule over the default QoS rule, whose precedence is 255
+	Precedence int32 `yaml:"precedence,omitempty" valid:"range(0|254),optional"`
+	// IPFilterRules of the traffic of the rule, TS 29.214 5.3.8, e.g.
+	// "permit out 17 from 10.10.0.0/16 5060 to assigned"
+	FlowDescriptions []string `yaml:"flowDescriptions" valid:"required"`
+	// QoS of the traffic of the rule, the default QoS of the session if 5qi is unset
+	Var5qi  int32  `yaml:"5qi,omitempty" valid:"range(0|255),optional"`
+	MaxbrUl string `yaml:"maxbrUl,omitempty" valid:"type(string),optional"`
+	MaxbrDl string `yaml:"maxbrDl,omitempty" valid:"type(string),optional"`
+}
+
+func (r *LocalPccRule) validate() (bool, error) {
+	for _, flowDescription := range r.FlowDescriptions {
+		if err := validateFlowDescription(flowDescription); err != nil {
+			return false, errors.New("Invalid localPolicy pccRule " + r.PccRuleId + " flowDescription: " +
+				flowDescription + ", " + err.Error())
+		}
+	}
+	for _, bitRate := range []string{r.MaxbrUl, r.MaxbrDl} {
+		if bitRate != "" && !bitRateRegexp.MatchString(bitRate) {
+			return false, errors.New("Invalid localPolicy pccRule " + r.PccRuleId + " bit rate: " + bitRate +
+				", should be like \"100 Mbps\".")
+		}
+	}
+
+	result, err := govalidator.ValidateStruct(r)
+	return result, appendInvalid(err)
+}
+
+// validateFlowDescription checks that the IPFilterRule can be decoded
+func validateFlowDescription(flowDescription string) (err error) {
+	// flowdesc.Decode does not check the number of fields of the rule before reading them
+	defer func() {
+		if recover() != nil {
+			err = errors.New("missing fields")
+		}
+	}()
+	_, err = flowdesc.Decode(flowDescription)
+	return err
+}
+
+type RadiusServer struct {
+	// host:port of the server
+	Addr   string `yaml:"addr" valid:"dialstring,required"`
+	Secret string `yaml:"secret" valid:"type(string),minstringlength(1),required"`
+	// NAS-Identifier of the requests, the NF instance ID of the SMF by default
+	NasIdentifier string        `yaml:"nasIdentifier,omitempty" valid:"type(string),optional"`
+	Timeout       time.Duration `yaml:"timeout,omitempty" valid:"type(time.Duration),optional"`
+	Retries       int           `yaml:"retries,omitempty" valid:"optional"`
+}
+
+func (r *RadiusServer) validate() (bool, error) {
+	if r.Retries < 0 {
+		return false, fmt.Errorf("Invalid radius retries: %d, should not be negative.", r.Retries)
+	}
+
+	result, err := govalidator.ValidateStruct(r)
+	return result, appendInvalid(err)
+}
+
 type Sbi struct {
 	Scheme       string `yaml:"scheme" valid:"scheme,required"`
 	Tls          *Tls   `yaml:"tls" valid:"optional"`
@@ -182,6 +511,20 @@ type Sbi struct {
 	// IPv6Addr string `yaml:"ipv6Addr,omitempty"`
 	BindingIPv4 string `yaml:"bindingIPv4,omitempty" valid:"host,required"` // IP used to run the server in the node.
 	Port        int    `yaml:"port,omitempty" valid:"port,optional"`
+	// OAuth2 requires NRF-issued access tokens on the SBI, TS 33.501 13.4.1. The tokens of the
+	// requests are verified with the public key of the certificate of the NRF.
+	OAuth2     bool   `yaml:"oauth2,omitempty" valid:"optional"`
+	NrfCertPem string `yaml:"nrfCertPem,omitempty" valid:"type(string),optional"`
+	// timeouts, retries and circuit breakers of the requests to the other NFs
+	Resilience *SbiResilience `yaml:"resilience,omitempty" valid:"optional"`
+}
+
+// ListenPort returns the port the SBI is served on
+func (s *Sbi) ListenPort() int {
+	if s.Port == 0 {
+		return SMF_DEFAULT_PORT
+	}
+	return s.Port
 }
 
 func (s *Sbi) validate() (bool, error) {
@@ -195,10 +538,98 @@ func (s *Sbi) validate() (bool, error) {
 		}
 	}
 
+	if s.OAuth2 && s.NrfCertPem == "" {
+		return false, errors.New("sbi.nrfCertPem is required for oauth2")
+	}
+
+	if resilience := s.Resilience; resilience != nil {
+		if result, err := resilience.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	result, err := govalidator.ValidateStruct(s)
+	return result, appendInvalid(err)
+}
+
+// Scp routes the SBI requests of the SMF through a Service Communication Proxy, TS 29.500 6.10
+type Scp struct {
+	Uri string `yaml:"uri,omitempty" valid:"url,required"`
+	// the SCP discovers and selects the PCF, UDM and serving AMF of the requests, model D,
+	// rather than the SMF, model C
+	DelegatedDiscovery bool `yaml:"delegatedDiscovery,omitempty" valid:"type(bool),optional"`
+}
+
+func (s *Scp) validate() (bool, error) {
 	result, err := govalidator.ValidateStruct(s)
 	return result, appendInvalid(err)
 }
 
+// Metrics serves the Prometheus metrics of the SMF at /metrics, on a listener of its own rather
+// than on the SBI. They are served by default, on the SBI binding address and port 9091.
+type Metrics struct {
+	// the metrics are not served
+	Disable     bool   `yaml:"disable,omitempty" valid:"optional"`
+	BindingIPv4 string `yaml:"bindingIPv4,omitempty" valid:"host,optional"`
+	Port        int    `yaml:"port,omitempty" valid:"port,optional"`
+}
+
+func (m *Metrics) validate(sbi *Sbi) (bool, error) {
+	if !m.Disable && sbi != nil && m.ListenPort() == sbi.ListenPort() &&
+		(m.BindingIPv4 == "" || m.BindingIPv4 == sbi.BindingIPv4) {
+		return false, errors.New("metrics.port must differ from sbi.port on the same address")
+	}
+	result, err := govalidator.ValidateStruct(m)
+	return result, appendInvalid(err)
+}
+
+// ListenPort returns the port the metrics are served on
+func (m *Metrics) ListenPort() int {
+	if m.Port == 0 {
+		return SMF_DEFAULT_METRICS_PORT
+	}
+	return m.Port
+}
+
+// SbiResilience bounds the time the requests to the other NFs take, the unset values keeping
+// their defaults
+type SbiResilience struct {
+	// timeout of a request, and of the requests to the AMF, PCF, UDM or NRF by NF type
+	Timeout      time.Duration            `yaml:"timeout,omitempty" valid:"type(time.Duration),optional"`
+	PeerTimeouts map[string]time.Duration `yaml:"peerTimeouts,omitempty" valid:"optional"`
+	// number of other PCF or UDM instances a request is sent to when the selected one fails
+	MaxRetries *int `yaml:"maxRetries,omitempty" valid:"optional"`
+	// consecutive failures of an NF instance after which its requests fail at once for the
+	// breakerOpenTime, before one request tries it again
+	BreakerFailures int           `yaml:"breakerFailures,omitempty" valid:"type(int),optional"`
+	BreakerOpenTime time.Duration `yaml:"breakerOpenTime,omitempty" valid:"type(time.Duration),optional"`
+	// back-off timer of the PDU Session Establishment Rejects sent when an NF is unavailable
+	RejectBackoffTimer time.Duration `yaml:"rejectBackoffTimer,omitempty" valid:"type(time.Duration),optional"`
+	// interval of the retries of the SM policy associations of the sessions on a local policy
+	PolicyRetryInterval time.Duration `yaml:"policyRetryInterval,omitempty" valid:"type(time.Duration),optional"`
+}
+
+func (r *SbiResilience) validate() (bool, error) {
+	for nfType, timeout := range r.PeerTimeouts {
+		switch nfType {
+		case "AMF", "PCF", "UDM", "NRF":
+		default:
+			return false, errors.New("Invalid sbi.resilience.peerTimeouts: " + nfType +
+				", should be AMF, PCF, UDM or NRF.")
+		}
+		if timeout < 0 {
+			return false, errors.New("Invalid sbi.resilience.peerTimeouts[" + nfType + "]: negative timeout")
+		}
+	}
+	if r.Timeout < 0 || r.BreakerFailures < 0 || r.BreakerOpenTime < 0 || r.RejectBackoffTimer < 0 ||
+		r.PolicyRetryInterval < 0 || (r.MaxRetries != nil && *r.MaxRetries < 0) {
+		return false, errors.New("Invalid sbi.resilience: negative value")
+	}
+
+	result, err := govalidator.ValidateStruct(r)
+	return result, appendInvalid(err)
+}
+
 type Tls struct {
 	Pem string `yaml:"pem,omitempty" valid:"type(string),minstringlength(1),required"`
 	Key string `yaml:"key,omitempty" valid:"type(string),minstringlength(1),required"`
@@ -216,6 +647,14 @@ type PFCP struct {
 	AlertInterval time.Duration `yaml:"associationSetupFailedAlertInterval,omitempty" valid:"type(time.Duration),optional"`
 	RetryInterval time.Duration `yaml:"associationSetupFailedRetryInterval,omitempty" valid:"type(time.Duration),optional"`
 	Heartbeat     PfcpHeartbeat `yaml:"heartbeat,omitempty" valid:"optional"`
+	// time to wait for the recovery of a user plane path reported as failed by a UPF
+	// before the PDU sessions using it are released, 0 releases them at once
+	PathFailureReleaseDelay time.Duration `yaml:"pathFailureReleaseDelay,omitempty" valid:"type(time.Duration),optional"`
+	// number of PDU sessions released per second when a UPF is put into maintenance mode,
+	// 0 releases them at once
+	MaintenanceReleaseRate int `yaml:"maintenanceReleaseRate,omitempty" valid:"type(int),optional"`
+	// features of the SMF advertised to the UPFs, "LOAD" and "OVRL", TS 29.244 8.2.58
+	CPFunctionFeatures []string `yaml:"cpFunctionFeatures,omitempty" valid:"optional"`
 }
 
 type PfcpHeartbeat struct {
@@ -223,10 +662,157 @@ type PfcpHeartbeat struct {
 }
 
 func (p *PFCP) validate() (bool, error) {
+	for _, feature := range p.CPFunctionFeatures {
+		if feature != CPFunctionFeatureLoad && feature != CPFunctionFeatureOvrl {
+			return false, fmt.Errorf("invalid cpFunctionFeatures: %s, should be LOAD or OVRL", feature)
+		}
+	}
+
 	result, err := govalidator.ValidateStruct(p)
 	return result, appendInvalid(err)
 }
 
+// CP function features of the PFCP configuration
+const (
+	CPFunctionFeatureLoad = "LOAD"
+	CPFunctionFeatureOvrl = "OVRL"
+)
+
+const (
+	DLBufferingModeUPF = "upf"
+	DLBufferingModeSMF = "smf"
+)
+
+// SessionStore persists the PDU sessions, so that they are resumed after the SMF restarts.
+// Sessions with UL CL are not persisted: they are lost when the SMF restarts, and their PFCP
+// sessions are left on the UPFs.
+type SessionStore struct {
+	// directory of the embedded store
+	Path string `yaml:"path,omitempty" valid:"type(string),required"`
+}
+
+func (s *SessionStore) validate() (bool, error) {
+	result, err := govalidator.ValidateStruct(s)
+	return result, appendInvalid(err)
+}
+
+const (
+	RedundancyRoleActive  = "active"
+	RedundancyRoleStandby = "standby"
+)
+
+// Redundancy configures an active/standby pair of SMFs. The standby serves neither the SBI nor
+// the PFCP until the active one fails, then takes over the SBI and PFCP addresses of the pair:
+// it binds its listeners to TakeoverAddress, and/or runs TakeoverCommand to move them.
+type Redundancy struct {
+	Role string `yaml:"role,omitempty" valid:"in(active|standby),required"`
+	// SBI URI of the active SMF, whose session store the standby tails
+	PeerUri string `yaml:"peerUri,omitempty" valid:"url,optional"`
+	// interval at which the standby polls the changes of the active SMF, 1s by default
+	PollInterval time.Duration `yaml:"pollInterval,omitempty" valid:"type(time.Duration),optional"`
+	// number of consecutive failed polls after which the standby takes over, 3 by default
+	FailureThreshold int `yaml:"failureThreshold,omitempty" valid:"optional"`
+	// shell command run by the standby to fence the failed active SMF, e.g. by powering it off,
+	// before it takes over; the standby retries it until it succeeds. SMF_PEER_URI is set to
+	// PeerUri in its environment.
+	FencingCommand string `yaml:"fencingCommand,omitempty" valid:"type(string),optional"`
+	// shell command run by the standby to move the SBI and PFCP addresses to itself
+	TakeoverCommand string `yaml:"takeoverCommand,omitempty" valid:"type(string),optional"`
+	// IPv4 address the active SMF serves the SBI and PFCP on. Once it takes over, the standby uses it
+	// as PFCP node ID and registers it at the NRF, and binds its listeners to it as soon as it
+	// is assigned to the host, in place of its own sbi and pfcp addresses.
+	TakeoverAddress string `yaml:"takeoverAddress,omitempty" valid:"ipv4,optional"`
+	// shared secret of the pair, which the standby presents to read the sessions of the active SMF
+	Secret string `yaml:"secret,omitempty" valid:"type(string),required"`
+}
+
+func (r *Redundancy) validate() (bool, error) {
+	if r.Role == RedundancyRoleStandby && r.PeerUri == "" {
+		return false, errors.New("redundancy.peerUri is required for the standby")
+	}
+	if r.Role == RedundancyRoleStandby && r.TakeoverAddress == "" && r.TakeoverCommand == "" {
+		return false, errors.New("redundancy.takeoverAddress or takeoverCommand is required for the standby")
+	}
+	if (r.TakeoverAddress != "" || r.TakeoverCommand != "") && r.FencingCommand == "" {
+		return false, errors.New("redundancy.fencingCommand is required to take over")
+	}
+	result, err := govalidator.ValidateStruct(r)
+	return result, appendInvalid(err)
+}
+
+// UEIPPoolAlarm configures the usage of a UE IP pool, in percent of its addresses, above which
+// an alarm is raised. The thresholds are 80 and 95 by default.
+type UEIPPoolAlarm struct {
+	WarningThreshold  int `yaml:"warningThreshold,omitempty" valid:"range(0|100),optional"`
+	CriticalThreshold int `yaml:"criticalThreshold,omitempty" valid:"range(0|100),optional"`
+}
+
+func (u *UEIPPoolAlarm) validate() (bool, error) {
+	if u.WarningThreshold > 0 && u.CriticalThreshold > 0 && u.WarningThreshold > u.CriticalThreshold {
+		return false, errors.New("ueIPPoolAlarm.warningThreshold must not exceed criticalThreshold")
+	}
+	result, err := govalidator.ValidateStruct(u)
+	return result, appendInvalid(err)
+}
+
+// FramedRoutes are the IP subnets behind a UE, e.g. of a CPE, which are routed to the UE
+// through its PDU sessions of the DNN, or of all DNNs if Dnn is empty
+type FramedRoutes struct {
+	Supi   string   `yaml:"supi" valid:"required"`
+	Dnn    string   `yaml:"dnn,omitempty" valid:"type(string),optional"`
+	Routes []string `yaml:"routes" valid:"required"`
+}
+
+func (f *FramedRoutes) validate() (bool, error) {
+	for _, route := range f.Routes {
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			return false, fmt.Errorf("Invalid framedRoutes route %s of %s: %v", route, f.Supi, err)
+		}
+	}
+	result, err := govalidator.ValidateStruct(f)
+	return result, appendInvalid(err)
+}
+
+// DLBuffering configures how downlink data of idle UEs is buffered, see TS 23.501 5.8.3
+type DLBuffering struct {
+	// "upf" (default) keeps the packets in the UPF; "smf" forwards them to the GTP-U endpoint of the SMF
+	Mode     string `yaml:"mode,omitempty" valid:"in(upf|smf),optional"`
+	GtpuAddr string `yaml:"gtpuAddr,omitempty" valid:"host,optional"`
+	// Suggested Buffering Packets Count sent to the UPF in the BAR
+	SuggestedPacketCount uint8 `yaml:"suggestedPacketCount,omitempty" valid:"optional"`
+	// maximum number of packets buffered by the SMF per PDU session
+	MaxPackets int `yaml:"maxPackets,omitempty" valid:"optional"`
+	// period during which buffered data is discarded and no DDN is sent after paging failed
+	DDNBackoffTimer time.Duration `yaml:"ddnBackoffTimer,omitempty" valid:"type(time.Duration),optional"`
+	// applied when the AMF rejects paging because a higher priority request is ongoing
+	DDNThrottling *DDNThrottling `yaml:"ddnThrottling,omitempty" valid:"optional"`
+}
+
+type DDNThrottling struct {
+	// Downlink Data Notification Delay of the BAR, in steps of 50 ms
+	Delay time.Duration `yaml:"delay,omitempty" valid:"type(time.Duration),optional"`
+	// Suggested Buffering Packets Count of the BAR while throttled
+	SuggestedPacketCount uint8 `yaml:"suggestedPacketCount,omitempty" valid:"optional"`
+}
+
+func (d *DLBuffering) validate() (bool, error) {
+	if d.Mode == DLBufferingModeSMF && d.GtpuAddr == "" {
+		return false, errors.New("dlBuffering.gtpuAddr is required when the mode is smf")
+	}
+	if ddnThrottling := d.DDNThrottling; ddnThrottling != nil {
+		if result, err := ddnThrottling.validate(); err != nil {
+			return result, err
+		}
+	}
+	result, err := govalidator.ValidateStruct(d)
+	return result, appendInvalid(err)
+}
+
+func (d *DDNThrottling) validate() (bool, error) {
+	result, err := govalidator.ValidateStruct(d)
+	return result, appendInvalid(err)
+}
+
 type DNS struct {
 	IPv4Addr string `yaml:"ipv4,omitempty" valid:"ipv4,required"`
 	IPv6Addr string `yaml:"ipv6,omitempty" valid:"ipv6,optional"`
@@ -501,6 +1087,8 @@ type DnnUpfInfoItem struct {
 	DnaiList        []string                `json:"dnaiList" yaml:"dnaiList" valid:"optional"`
 	PduSessionTypes []models.PduSessionType `json:"pduSessionTypes" yaml:"pduSessionTypes" valid:"optional"`
 	Pools           []UEIPPool              `json:"pools" yaml:"pools" valid:"optional"`
+	// addresses assigned to the UEs by their subscription data, never allocated dynamically
+	StaticPools []UEIPPool `json:"staticPools,omitempty" yaml:"staticPools,omitempty" valid:"optional"`
 }
 
 func (d *DnnUpfInfoItem) validate() (bool, error) {
@@ -515,6 +1103,12 @@ func (d *DnnUpfInfoItem) validate() (bool, error) {
 		}
 	}
 
+	for _, pool := range d.StaticPools {
+		if result, err := pool.validate(); err != nil {
+			return result, err
+		}
+	}
+
 	result, err := govalidator.ValidateStruct(d)
 	return result, appendInvalid(err)
 }
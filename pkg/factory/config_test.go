@@ -0,0 +1,150 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedundancyValidate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		redundancy Redundancy
+		err        string
+	}{
+		{
+			name:       "active",
+			redundancy: Redundancy{Role: RedundancyRoleActive, Secret: "secret"},
+		},
+		{
+			name: "standby taking over the address",
+			redundancy: Redundancy{
+				Role:            RedundancyRoleStandby,
+				PeerUri:         "http://10.0.0.1:8000",
+				FencingCommand:  "fence",
+				TakeoverAddress: "10.0.0.100",
+				Secret:          "secret",
+			},
+		},
+		{
+			name: "standby running the takeover command",
+			redundancy: Redundancy{
+				Role:            RedundancyRoleStandby,
+				PeerUri:         "http://10.0.0.1:8000",
+				FencingCommand:  "fence",
+				TakeoverCommand: "takeover",
+				Secret:          "secret",
+			},
+		},
+		{
+			name: "standby without peer",
+			redundancy: Redundancy{
+				Role:            RedundancyRoleStandby,
+				FencingCommand:  "fence",
+				TakeoverAddress: "10.0.0.100",
+				Secret:          "secret",
+			},
+			err: "redundancy.peerUri is required for the standby",
+		},
+		{
+			name: "standby without takeover",
+			redundancy: Redundancy{
+				Role:           RedundancyRoleStandby,
+				PeerUri:        "http://10.0.0.1:8000",
+				FencingCommand: "fence",
+				Secret:         "secret",
+			},
+			err: "redundancy.takeoverAddress or takeoverCommand is required for the standby",
+		},
+		{
+			name: "standby without fencing",
+			redundancy: Redundancy{
+				Role:            RedundancyRoleStandby,
+				PeerUri:         "http://10.0.0.1:8000",
+				TakeoverAddress: "10.0.0.100",
+				Secret:          "secret",
+			},
+			err: "redundancy.fencingCommand is required to take over",
+		},
+		{
+			name: "invalid takeover address",
+			redundancy: Redundancy{
+				Role:            RedundancyRoleStandby,
+				PeerUri:         "http://10.0.0.1:8000",
+				FencingCommand:  "fence",
+				TakeoverAddress: "smf.example.com",
+				Secret:          "secret",
+			},
+			err: "invalid TakeoverAddress: smf.example.com does not validate as ipv4",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tc.redundancy.validate()
+			if tc.err != "" {
+				require.False(t, result)
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.True(t, result)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestMetricsValidate(t *testing.T) {
+	sbi := &Sbi{Scheme: "http", BindingIPv4: "10.0.0.1", Port: 9091}
+	testCases := []struct {
+		name    string
+		metrics Metrics
+		sbi     *Sbi
+		err     string
+	}{
+		{
+			name:    "default",
+			metrics: Metrics{},
+			sbi:     &Sbi{Scheme: "http", BindingIPv4: "10.0.0.1"},
+		},
+		{
+			name:    "default port on the SBI port",
+			metrics: Metrics{},
+			sbi:     sbi,
+			err:     "metrics.port must differ from sbi.port on the same address",
+		},
+		{
+			name:    "SBI port on the SBI address",
+			metrics: Metrics{BindingIPv4: "10.0.0.1", Port: 9091},
+			sbi:     sbi,
+			err:     "metrics.port must differ from sbi.port on the same address",
+		},
+		{
+			name:    "SBI port on another address",
+			metrics: Metrics{BindingIPv4: "10.0.0.2", Port: 9091},
+			sbi:     sbi,
+		},
+		{
+			name:    "other port",
+			metrics: Metrics{Port: 9092},
+			sbi:     sbi,
+		},
+		{
+			name:    "disabled",
+			metrics: Metrics{Disable: true},
+			sbi:     sbi,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tc.metrics.validate(tc.sbi)
+			if tc.err != "" {
+				require.False(t, result)
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.True(t, result)
+			require.NoError(t, err)
+		})
+	}
+}
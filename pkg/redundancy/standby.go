@@ -0,0 +1,164 @@
+package redundancy
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/sbi/consumer"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+const (
+	defaultPollInterval     = time.Second
+	defaultFailureThreshold = 3
+)
+
+// RunStandby tails the session store of the active SMF until it fails, then fences it, takes over
+// its addresses and returns, so that the SMF starts with the replicated sessions
+func RunStandby(config *factory.Redundancy) {
+	interval := config.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	store, ok := smf_context.GetSessionStore().(*smf_context.ReplicatedSessionStore)
+	if !ok {
+		logger.InitLog.Errorln("Standby SMF without session store, taking over immediately")
+		fence(config, interval)
+		takeover(config, interval)
+		return
+	}
+
+	threshold := config.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	logger.InitLog.Infof("Standby SMF tailing the active SMF %s", config.PeerUri)
+	var epoch string
+	var seq uint64
+	synced := false
+	failures := 0
+	for failures < threshold {
+		if !synced {
+			snapshot, err := consumer.GetReplicationSnapshot(config.PeerUri)
+			if err == nil {
+				err = store.ApplySnapshot(snapshot)
+			}
+			if err != nil {
+				failures++
+				logger.InitLog.Warnf("Read snapshot of the active SMF failed (%d/%d): %v", failures, threshold, err)
+				time.Sleep(interval)
+				continue
+			}
+			epoch, seq, synced = snapshot.Epoch, snapshot.Seq, true
+			failures = 0
+			logger.InitLog.Infof("Standby SMF synchronized %d sessions", len(snapshot.Records))
+		}
+
+		time.Sleep(interval)
+		changes, err := consumer.GetReplicationChanges(config.PeerUri, seq)
+		switch {
+		case err == consumer.ErrReplicationChangesGone:
+			synced = false
+			continue
+		case err != nil:
+			failures++
+			logger.InitLog.Warnf("Read changes of the active SMF failed (%d/%d): %v", failures, threshold, err)
+			continue
+		case changes.Epoch != epoch:
+			// the active SMF restarted
+			synced = false
+			failures = 0
+			continue
+		}
+		failures = 0
+		if err := store.ApplyChanges(changes.Changes); err != nil {
+			logger.InitLog.Errorf("Apply changes of the active SMF failed: %v", err)
+			synced = false
+			continue
+		}
+		if n := len(changes.Changes); n > 0 {
+			seq = changes.Changes[n-1].Seq
+		}
+	}
+
+	logger.InitLog.Warnf("Active SMF %s failed, taking over", config.PeerUri)
+	fence(config, interval)
+	takeover(config, interval)
+}
+
+// fence runs the fencing command until it succeeds, so that the failed active SMF, which may
+// only be unreachable, no longer uses the addresses and the PFCP sessions taken over
+func fence(config *factory.Redundancy, interval time.Duration) {
+	if config.FencingCommand == "" {
+		return
+	}
+	for {
+		cmd := exec.Command("sh", "-c", config.FencingCommand)
+		cmd.Env = append(os.Environ(), "SMF_PEER_URI="+config.PeerUri)
+		output, err := cmd.CombinedOutput()
+		if err == nil {
+			logger.InitLog.Infof("Fencing command succeeded: %s", output)
+			return
+		}
+		logger.InitLog.Errorf("Fencing command failed, retry in %s: %v: %s", interval, err, output)
+		time.Sleep(interval)
+	}
+}
+
+// takeover runs the takeover command, then waits for the takeover address, so that the SMF
+// starts on the addresses of the failed active SMF
+func takeover(config *factory.Redundancy, interval time.Duration) {
+	if config.TakeoverCommand != "" {
+		output, err := exec.Command("sh", "-c", config.TakeoverCommand).CombinedOutput()
+		if err != nil {
+			logger.InitLog.Errorf("Takeover command failed: %v: %s", err, output)
+		} else {
+			logger.InitLog.Infof("Takeover command succeeded: %s", output)
+		}
+	}
+	if config.TakeoverAddress != "" {
+		takeOverAddress(net.ParseIP(config.TakeoverAddress), interval)
+	}
+}
+
+// takeOverAddress waits until the address is assigned to the host, then moves the SBI and PFCP
+// of the SMF to it. The SMF never serves on its own addresses meanwhile.
+func takeOverAddress(ip net.IP, interval time.Duration) {
+	smfSelf := smf_context.SMF_Self()
+	for {
+		err := bindable(ip, smfSelf.SBIPort)
+		if err == nil {
+			break
+		}
+		logger.InitLog.Warnf("Takeover address %s not available, retry in %s: %v", ip, interval, err)
+		time.Sleep(interval)
+	}
+	smfSelf.TakeOverAddress(ip)
+	logger.InitLog.Infof("Standby SMF took over the SBI and PFCP address %s", ip)
+}
+
+// bindable checks that the PFCP and SBI listeners of the SMF can be bound to the address
+func bindable(ip net.IP, sbiPort int) error {
+	pfcpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: pfcpUdp.PFCP_PORT})
+	if err != nil {
+		return err
+	}
+	sbiListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: sbiPort})
+	if err != nil {
+		if closeErr := pfcpConn.Close(); closeErr != nil {
+			logger.InitLog.Warnf("Close PFCP probe failed: %v", closeErr)
+		}
+		return err
+	}
+	if err = sbiListener.Close(); err != nil {
+		logger.InitLog.Warnf("Close SBI probe failed: %v", err)
+	}
+	return pfcpConn.Close()
+}
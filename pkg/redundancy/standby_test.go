@@ -0,0 +1,122 @@
+package redundancy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestRunStandby(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	defer func(secret, nfInstanceID string) {
+		smfSelf.ReplicationSecret, smfSelf.NfInstanceID = secret, nfInstanceID
+		smf_context.SetSessionStore(nil)
+	}(smfSelf.ReplicationSecret, smfSelf.NfInstanceID)
+	smfSelf.ReplicationSecret = "secret"
+
+	dir := t.TempDir()
+	fileStore, err := smf_context.NewFileSessionStore(filepath.Join(dir, "store"))
+	require.NoError(t, err)
+	smf_context.SetSessionStore(smf_context.NewReplicatedSessionStore(fileStore))
+
+	// the active SMF answers the snapshot, then fails
+	snapshots := 0
+	active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/nsmf-replication/v1/snapshot" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		snapshots++
+		require.NoError(t, json.NewEncoder(w).Encode(&smf_context.ReplicationSnapshot{
+			Epoch:        "epoch-1",
+			NfInstanceID: "smf-active",
+		}))
+	}))
+	defer active.Close()
+
+	log := filepath.Join(dir, "log")
+	RunStandby(&factory.Redundancy{
+		Role:             factory.RedundancyRoleStandby,
+		PeerUri:          active.URL,
+		PollInterval:     10 * time.Millisecond,
+		FailureThreshold: 2,
+		// the fencing fails once, and the takeover waits until it succeeds
+		FencingCommand: `[ -f "` + dir + `/fenced" ] || { touch "` + dir + `/fenced"; exit 1; }; ` +
+			`echo "fence $SMF_PEER_URI" >> ` + log,
+		TakeoverCommand: "echo takeover >> " + log,
+	})
+
+	require.Equal(t, 1, snapshots)
+	require.Equal(t, "smf-active", smfSelf.NfInstanceID)
+	content, err := ioutil.ReadFile(log)
+	require.NoError(t, err)
+	require.Equal(t, "fence "+active.URL+"\ntakeover\n", string(content))
+}
+
+func TestRunStandbyTakeoverAddress(t *testing.T) {
+	smfSelf := smf_context.SMF_Self()
+	defer func(bindingIPv4, registerIPv4 string, cpNodeID pfcpType.NodeID, sbiPort int) {
+		smfSelf.BindingIPv4, smfSelf.RegisterIPv4, smfSelf.CPNodeID, smfSelf.SBIPort =
+			bindingIPv4, registerIPv4, cpNodeID, sbiPort
+	}(smfSelf.BindingIPv4, smfSelf.RegisterIPv4, smfSelf.CPNodeID, smfSelf.SBIPort)
+	smfSelf.BindingIPv4, smfSelf.RegisterIPv4 = "127.0.0.36", "127.0.0.36"
+	smfSelf.SBIPort = 0
+
+	// the address is still held, e.g. by the active SMF before it is fenced
+	held, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.35"), Port: pfcpUdp.PFCP_PORT})
+	require.NoError(t, err)
+
+	log := filepath.Join(t.TempDir(), "log")
+	done := make(chan struct{})
+	go func() {
+		// without session store, the standby takes over at once
+		RunStandby(&factory.Redundancy{
+			Role:            factory.RedundancyRoleStandby,
+			PeerUri:         "http://127.0.0.35:8000",
+			PollInterval:    10 * time.Millisecond,
+			FencingCommand:  "echo fence >> " + log,
+			TakeoverAddress: "127.0.0.35",
+		})
+		close(done)
+	}()
+
+	// the SMF does not start until it can bind its listeners to the address
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		require.FailNow(t, "took over an address not available")
+	default:
+	}
+	require.NoError(t, held.Close())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "address not taken over")
+	}
+
+	content, err := ioutil.ReadFile(log)
+	require.NoError(t, err)
+	require.Equal(t, "fence\n", string(content))
+	require.Equal(t, "127.0.0.35", smfSelf.BindingIPv4)
+	require.Equal(t, "127.0.0.35", smfSelf.RegisterIPv4)
+	require.Equal(t, pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("127.0.0.35").To4(),
+	}, smfSelf.CPNodeID)
+}
@@ -18,18 +18,24 @@ import (
 	"github.com/free5gc/openapi/models"
 	pfcpLogger "github.com/free5gc/pfcp/logger"
 	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/gtpu"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 	"github.com/free5gc/smf/internal/pfcp"
+	"github.com/free5gc/smf/internal/pfcp/handler"
 	"github.com/free5gc/smf/internal/pfcp/udp"
 	"github.com/free5gc/smf/internal/sbi/callback"
 	"github.com/free5gc/smf/internal/sbi/consumer"
 	"github.com/free5gc/smf/internal/sbi/eventexposure"
 	"github.com/free5gc/smf/internal/sbi/oam"
 	"github.com/free5gc/smf/internal/sbi/pdusession"
+	"github.com/free5gc/smf/internal/sbi/producer"
+	"github.com/free5gc/smf/internal/sbi/replication"
 	"github.com/free5gc/smf/internal/sbi/upi"
 	"github.com/free5gc/smf/internal/util"
 	"github.com/free5gc/smf/pkg/association"
 	"github.com/free5gc/smf/pkg/factory"
+	"github.com/free5gc/smf/pkg/redundancy"
 	"github.com/free5gc/util/httpwrapper"
 	logger_util "github.com/free5gc/util/logger"
 )
@@ -228,9 +234,23 @@ func (smf *SMF) Start() {
 	// allocate id for each upf
 	smf_context.AllocateUPFID()
 	smf_context.InitSMFUERouting(&factory.UERoutingConfig)
+	redundancyConfig := factory.SmfConfig.Configuration.Redundancy
+	takeover := false
+	if redundancyConfig != nil && redundancyConfig.Role == factory.RedundancyRoleStandby {
+		redundancy.RunStandby(redundancyConfig)
+		// registered at the NRF with the NF instance ID and the address taken over
+		smf_context.SetupNFProfile(&factory.SmfConfig)
+		takeover = true
+	}
+	restored := smf_context.RestoreSMContexts()
 
 	logger.InitLog.Infoln("Server started")
 	router := logger_util.NewGinWithLogrus(logger.GinLog)
+	router.Use(metrics.GinMiddleware())
+	metrics.Register(smf_context.NewCollector())
+	if metricsAddr := smf_context.SMF_Self().MetricsAddr; metricsAddr != "" {
+		go serveMetrics(metricsAddr)
+	}
 
 	err := consumer.SendNFRegistration()
 	if err != nil {
@@ -240,6 +260,7 @@ func (smf *SMF) Start() {
 			return
 		}
 	}
+	consumer.SendNFStatusSubscriptions()
 
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
@@ -259,6 +280,9 @@ func (smf *SMF) Start() {
 	oam.AddService(router)
 	callback.AddService(router)
 	upi.AddService(router)
+	if redundancyConfig != nil {
+		replication.AddService(router)
+	}
 	for _, serviceName := range factory.SmfConfig.Configuration.ServiceNameList {
 		switch models.ServiceName(serviceName) {
 		case models.ServiceName_NSMF_PDUSESSION:
@@ -268,10 +292,20 @@ func (smf *SMF) Start() {
 		}
 	}
 	udp.Run(pfcp.Dispatch)
+	keepRecoveryTimeStamp(restored > 0 || takeover)
+	gtpu.Run(handler.HandleBufferedDownlinkData)
+	producer.ReleasePDUSessionHandler = func(smContext *smf_context.SMContext, cause uint8,
+		backoffTimer time.Duration,
+	) {
+		association.ReleasePDUSessionsWithCause([]*smf_context.SMContext{smContext}, cause, backoffTimer)
+	}
+	producer.ResumeSessionTimeouts()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	smf_context.SMF_Self().Ctx = ctx
 	smf_context.SMF_Self().PFCPCancelFunc = cancel
+	go consumer.RunNFHeartbeat(ctx)
+	go producer.RunSMPolicyReconciliation(ctx)
 	for _, upNode := range smf_context.SMF_Self().UserPlaneInformation.UPFs {
 		upNode.UPF.Ctx, upNode.UPF.CancelFunc = context.WithCancel(context.Background())
 		go association.ToBeAssociatedWithUPF(ctx, upNode.UPF)
@@ -303,8 +337,38 @@ func (smf *SMF) Start() {
 	}
 }
 
+// keepRecoveryTimeStamp sends the PFCP Recovery Time Stamp of the previous run to the UPFs
+// when sessions were restored or taken over, so that the UPFs keep their PFCP sessions
+func keepRecoveryTimeStamp(restore bool) {
+	store := smf_context.GetSessionStore()
+	if store == nil {
+		return
+	}
+	if restore {
+		timeStamp, err := store.LoadRecoveryTimeStamp()
+		if err != nil {
+			logger.InitLog.Warnf("Load PFCP Recovery Time Stamp failed: %v", err)
+		} else if !timeStamp.IsZero() {
+			udp.ServerStartTime = timeStamp
+			return
+		}
+	}
+	if err := store.PutRecoveryTimeStamp(udp.ServerStartTime); err != nil {
+		logger.InitLog.Warnf("Persist PFCP Recovery Time Stamp failed: %v", err)
+	}
+}
+
+// serveMetrics serves the Prometheus metrics at the address
+func serveMetrics(addr string) {
+	logger.InitLog.Infof("Serve the metrics on %s", addr)
+	if err := metrics.NewServer(addr).ListenAndServe(); err != nil {
+		logger.InitLog.Errorf("Metrics server failed: %v", err)
+	}
+}
+
 func (smf *SMF) Terminate() {
 	logger.InitLog.Infof("Terminating SMF...")
+	consumer.SendRemoveNFStatusSubscriptions()
 	// deregister with NRF
 	problemDetails, err := consumer.SendDeregisterNFInstance()
 	if problemDetails != nil {